@@ -4,8 +4,10 @@
 package main
 
 import (
+	"context"
 	"log"
 	"os"
+	"path/filepath"
 
 	"gioui.org/font/gofont"
 	"gioui.org/layout"
@@ -17,6 +19,9 @@ import (
 	"gioui.org/app/system"
 
 	appState "github.com/goBookMarker/internal/app"
+	"github.com/goBookMarker/internal/attachment"
+	"github.com/goBookMarker/internal/auth"
+	"github.com/goBookMarker/internal/share"
 	"github.com/goBookMarker/internal/storage"
 	"github.com/goBookMarker/internal/ui"
 )
@@ -48,8 +53,40 @@ func run(w *app.Window) error {
 	}
 	defer db.Close()
 
+	// Queue incoming shares and process them in the background
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	shares := share.NewShareHandler(db)
+
+	// Remove attachment files no bookmark uses any more, and keep
+	// sign-ins across launches
+	if dataDir, err := app.DataDir(); err == nil {
+		auth.SetDefaultTokenStore(auth.DefaultTokenStore(dataDir))
+		attachments := attachment.NewStore(filepath.Join(dataDir, "attachments"), db)
+		shares.Attachments = attachments
+		go func() {
+			if _, err := attachments.CollectGarbage(); err != nil {
+				log.Printf("failed to collect attachments: %v", err)
+			}
+		}()
+	}
+
 	// Initialize application state
-	state := appState.NewAppState()
+	state := appState.NewAppState(db, db.TagStore(), db)
+	state.SetShareHandler(shares)
+
+	go shares.Run(ctx)
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-shares.Changes():
+				state.SharesChanged()
+				w.Invalidate()
+			}
+		}
+	}()
 
 	// Initialize UI
 	ui := ui.NewUI(th, state)
@@ -77,7 +114,13 @@ func run(w *app.Window) error {
 		case system.StageEvent:
 			if e.Stage >= system.StageRunning {
 				// App is visible, trigger initial data load
-				state.LoadInitialData()
+				if err := state.LoadInitialData(); err != nil {
+					log.Printf("failed to load data: %v", err)
+				}
+				// The network may be back; retry waiting shares now
+				if err := shares.Resume(); err != nil {
+					log.Printf("failed to resume shares: %v", err)
+				}
 			}
 		}
 	}
@@ -6,8 +6,12 @@ require (
 	gioui.org v0.7.1
 	gioui.org/x v0.7.1
 	github.com/google/uuid v1.3.0
+	golang.org/x/crypto v0.25.0
 	golang.org/x/exp/shiny v0.0.0-20240707233637-46b078467d37
+	golang.org/x/image v0.18.0
+	golang.org/x/net v0.21.0
 	golang.org/x/oauth2 v0.17.0
+	golang.org/x/sys v0.22.0
 	modernc.org/sqlite v1.29.2
 )
 
@@ -24,9 +28,6 @@ require (
 	github.com/ncruces/go-strftime v0.1.9 // indirect
 	github.com/remyoudompheng/bigfft v0.0.0-20230129092748-24d4a6f8daec // indirect
 	golang.org/x/exp v0.0.0-20240707233637-46b078467d37 // indirect
-	golang.org/x/image v0.18.0 // indirect
-	golang.org/x/net v0.21.0 // indirect
-	golang.org/x/sys v0.22.0 // indirect
 	golang.org/x/text v0.16.0 // indirect
 	google.golang.org/appengine v1.6.7 // indirect
 	google.golang.org/protobuf v1.31.0 // indirect
@@ -0,0 +1,139 @@
+package app
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/goBookMarker/internal/models"
+	"github.com/goBookMarker/internal/storage"
+)
+
+// LinkedAccounts returns the provider accounts linked to the current user.
+func (s *AppState) LinkedAccounts() []models.LinkedAccount {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]models.LinkedAccount(nil), s.accounts...)
+}
+
+// SyncAccount returns the linked account that backs sync, or nil if none
+// was chosen.
+func (s *AppState) SyncAccount() *models.LinkedAccount {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, a := range s.accounts {
+		if a.IsSyncAccount {
+			account := a
+			return &account
+		}
+	}
+	return nil
+}
+
+// LinkAccount links a provider account to the current user, replacing the
+// account previously linked at the same provider. Without a current user,
+// a profile is created for the account. The first account linked backs
+// sync.
+func (s *AppState) LinkAccount(account models.LinkedAccount) error {
+	repo, ok := s.userRepo.(storage.AccountRepository)
+	if !ok {
+		return fmt.Errorf("linked accounts are not supported by the user repository")
+	}
+	if account.Provider == "" || account.SubjectID == "" {
+		return fmt.Errorf("account has no provider or subject ID")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.currentUser == nil {
+		user := &models.User{
+			ID:          uuid.New().String(),
+			Email:       account.Email,
+			Name:        account.Name,
+			Provider:    account.Provider,
+			SubjectID:   account.SubjectID,
+			NavPosition: "bottom",
+			Theme:       "system",
+		}
+		if err := s.userRepo.SaveUser(user); err != nil {
+			return fmt.Errorf("failed to save user: %w", err)
+		}
+		s.currentUser = user
+	}
+
+	account.UserID = s.currentUser.ID
+	account.IsSyncAccount = len(s.accounts) == 0
+	if account.LinkedAt.IsZero() {
+		account.LinkedAt = time.Now()
+	}
+	if err := repo.LinkAccount(account); err != nil {
+		return err
+	}
+	return s.reloadAccountsLocked()
+}
+
+// UnlinkAccount disconnects the current user's account at provider. The
+// library is kept; if the account backed sync, no account does until
+// another is chosen with SetSyncAccount. Callers should also sign the
+// provider out so its token is forgotten.
+func (s *AppState) UnlinkAccount(provider string) error {
+	repo, ok := s.userRepo.(storage.AccountRepository)
+	if !ok {
+		return fmt.Errorf("linked accounts are not supported by the user repository")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.currentUser == nil {
+		return nil
+	}
+	if err := repo.UnlinkAccount(s.currentUser.ID, provider); err != nil {
+		return err
+	}
+	return s.reloadAccountsLocked()
+}
+
+// SetSyncAccount makes the current user's account at provider the one that
+// backs sync.
+func (s *AppState) SetSyncAccount(provider string) error {
+	repo, ok := s.userRepo.(storage.AccountRepository)
+	if !ok {
+		return fmt.Errorf("linked accounts are not supported by the user repository")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.currentUser == nil {
+		return fmt.Errorf("no current user")
+	}
+	linked := false
+	for _, a := range s.accounts {
+		if a.Provider == provider {
+			linked = true
+		}
+	}
+	if !linked {
+		return fmt.Errorf("no %s account is linked", provider)
+	}
+	if err := repo.SetSyncAccount(s.currentUser.ID, provider); err != nil {
+		return err
+	}
+	return s.reloadAccountsLocked()
+}
+
+// reloadAccountsLocked replaces the in-memory linked accounts with the
+// repository contents. The caller must hold s.mu.
+func (s *AppState) reloadAccountsLocked() error {
+	s.accounts = nil
+	repo, ok := s.userRepo.(storage.AccountRepository)
+	if !ok || s.currentUser == nil {
+		return nil
+	}
+	accounts, err := repo.GetLinkedAccounts(s.currentUser.ID)
+	if err != nil {
+		return fmt.Errorf("failed to load linked accounts: %w", err)
+	}
+	s.accounts = accounts
+	return nil
+}
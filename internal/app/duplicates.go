@@ -0,0 +1,151 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/goBookMarker/internal/models"
+	"github.com/goBookMarker/internal/storage"
+	"github.com/goBookMarker/internal/urlnorm"
+)
+
+// FindBookmarkByURL returns the bookmark with the same URL as rawURL once
+// both are normalized, or nil if there is none.
+func (s *AppState) FindBookmarkByURL(rawURL string) *models.Bookmark {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if b := s.findBookmarkByURLLocked(rawURL); b != nil {
+		found := *b
+		return &found
+	}
+	return nil
+}
+
+func (s *AppState) findBookmarkByURLLocked(rawURL string) *models.Bookmark {
+	key := urlnorm.Normalize(rawURL)
+	for i, b := range s.bookmarks {
+		if urlnorm.Normalize(b.URL) == key {
+			return &s.bookmarks[i]
+		}
+	}
+	return nil
+}
+
+// SaveSharedBookmark saves a bookmark created from shared content. If the
+// URL is already bookmarked, bookmark is merged into the existing one
+// instead: its title, description and images fill in what the existing
+// bookmark lacks and its tags are added. It returns the bookmark as saved
+// and whether it was merged.
+func (s *AppState) SaveSharedBookmark(bookmark *models.Bookmark) (*models.Bookmark, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	existing := s.findBookmarkByURLLocked(bookmark.URL)
+	if existing == nil {
+		if bookmark.ID == "" {
+			bookmark.ID = generateID()
+		}
+		if bookmark.UserID == "" && s.currentUser != nil {
+			bookmark.UserID = s.currentUser.ID
+		}
+		err := s.saveBookmarkLocked(bookmark)
+		var dup *storage.DuplicateError
+		if !errors.As(err, &dup) {
+			return bookmark, false, err
+		}
+		// Saved elsewhere since the library was loaded.
+		if err := s.reloadLibraryLocked(); err != nil {
+			return nil, false, err
+		}
+		if existing = s.findBookmarkByURLLocked(bookmark.URL); existing == nil {
+			return nil, false, err
+		}
+	}
+
+	merged := mergeBookmarks(*existing, *bookmark)
+	if err := s.saveBookmarkLocked(&merged); err != nil {
+		return nil, false, err
+	}
+	return &merged, true, nil
+}
+
+// mergeBookmarks returns into with the fields it lacks taken from from and
+// from's tags added. into keeps its ID, URL and creation time.
+func mergeBookmarks(into, from models.Bookmark) models.Bookmark {
+	if into.Title == "" || into.Title == into.URL {
+		into.Title = from.Title
+	}
+	if into.Description == "" {
+		into.Description = from.Description
+	}
+	if into.ImageURL == "" {
+		into.ImageURL = from.ImageURL
+	}
+	if into.FaviconURL == "" {
+		into.FaviconURL = from.FaviconURL
+	}
+	into.IsFavorite = into.IsFavorite || from.IsFavorite
+	into.Tags = append([]string(nil), into.Tags...)
+	for _, tag := range from.Tags {
+		if !containsFold(into.Tags, tag) {
+			into.Tags = append(into.Tags, tag)
+		}
+	}
+	into.TagIDs = append([]string(nil), into.TagIDs...)
+	for _, id := range from.TagIDs {
+		if !containsFold(into.TagIDs, id) {
+			into.TagIDs = append(into.TagIDs, id)
+		}
+	}
+	return into
+}
+
+// resolveSyncedDuplicateLocked handles a synced bookmark whose URL is
+// already used by a local one, which happens when two devices save the
+// same page before syncing. Every device keeps the one created first, or
+// with the lower ID if they were created at the same time, and merges the
+// other into it, so they all end up with the same bookmark. The caller
+// must hold s.mu.
+func (s *AppState) resolveSyncedDuplicateLocked(synced, existing models.Bookmark) (models.Bookmark, error) {
+	keep, drop := existing, synced
+	if synced.CreatedAt.Before(existing.CreatedAt) ||
+		(synced.CreatedAt.Equal(existing.CreatedAt) && synced.ID < existing.ID) {
+		keep, drop = synced, existing
+	}
+
+	merged := mergeBookmarks(keep, drop)
+	if drop.UpdatedAt.After(merged.UpdatedAt) {
+		merged.UpdatedAt = drop.UpdatedAt
+	}
+	if drop.ID == existing.ID {
+		if err := s.moveAttachmentsLocked(drop.ID, keep.ID); err != nil {
+			return models.Bookmark{}, err
+		}
+		if err := s.bookmarkRepo.DeleteBookmark(drop.ID); err != nil {
+			return models.Bookmark{}, fmt.Errorf("failed to delete duplicate bookmark: %w", err)
+		}
+	}
+	if err := s.bookmarkRepo.SaveBookmark(merged); err != nil {
+		return models.Bookmark{}, fmt.Errorf("failed to save merged bookmark: %w", err)
+	}
+	return merged, nil
+}
+
+// moveAttachmentsLocked links the attachments of bookmark from to bookmark
+// to, before from is deleted. The caller must hold s.mu.
+func (s *AppState) moveAttachmentsLocked(from, to string) error {
+	repo, ok := s.bookmarkRepo.(storage.AttachmentRepository)
+	if !ok {
+		return nil
+	}
+	attachments, err := repo.GetBookmarkAttachments(from)
+	if err != nil {
+		return err
+	}
+	for _, a := range attachments {
+		if err := repo.LinkAttachment(to, a.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,455 @@
+package app
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/goBookMarker/internal/models"
+	"github.com/goBookMarker/internal/netscape"
+	"github.com/goBookMarker/internal/storage"
+	"github.com/goBookMarker/internal/urlnorm"
+)
+
+// ImportStatus is the outcome of importing a single bookmark.
+type ImportStatus string
+
+const (
+	ImportCreated   ImportStatus = "created"
+	ImportDuplicate ImportStatus = "duplicate"
+	ImportSkipped   ImportStatus = "skipped"
+)
+
+// ImportEntry records what happened to one bookmark from an import file.
+type ImportEntry struct {
+	Title  string
+	URL    string
+	Folder string // folder path, e.g. "Bookmarks bar/Go"
+	Status ImportStatus
+	Reason string // why the entry was skipped or considered a duplicate
+}
+
+// ImportReport summarizes a bookmark import.
+type ImportReport struct {
+	Created     int
+	Duplicates  int
+	Skipped     int
+	TagsCreated int
+	Entries     []ImportEntry
+}
+
+func (r *ImportReport) add(e ImportEntry) {
+	switch e.Status {
+	case ImportCreated:
+		r.Created++
+	case ImportDuplicate:
+		r.Duplicates++
+	case ImportSkipped:
+		r.Skipped++
+	}
+	r.Entries = append(r.Entries, e)
+}
+
+// ImportBookmarksFile imports a Netscape bookmarks.html file, as exported
+// by browsers. See ImportBookmarksHTML.
+func (s *AppState) ImportBookmarksFile(path string) (*ImportReport, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open import file: %w", err)
+	}
+	defer f.Close()
+	return s.ImportBookmarksHTML(f)
+}
+
+// ImportBookmarksHTML imports bookmarks from a Netscape bookmarks.html
+// document. Folders become hierarchical tags, reusing existing tags with
+// the same name and parent, and each bookmark is tagged with its folder and
+// the names in its TAGS attribute. Bookmarks whose URL is already in the
+// library, or earlier in the file, are reported as duplicates and left
+// alone; entries without a web URL are skipped.
+func (s *AppState) ImportBookmarksHTML(r io.Reader) (*ImportReport, error) {
+	root, err := netscape.Parse(r)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	imp := &bookmarkImport{
+		state:  s,
+		report: &ImportReport{},
+		urls:   make(map[string]bool, len(s.bookmarks)),
+		tags:   make(map[string]models.Tag, len(s.tags)),
+	}
+	for _, b := range s.bookmarks {
+		imp.urls[urlnorm.Normalize(b.URL)] = true
+	}
+	for _, t := range s.tags {
+		imp.tags[folderKey(t.ParentID, t.Name)] = t
+	}
+	if s.currentUser != nil {
+		imp.userID = s.currentUser.ID
+	}
+	tagCount := len(s.tags)
+
+	importErr := imp.run(root)
+
+	// Reload even after a failure so the state reflects what was written.
+	if err := s.reloadLibraryLocked(); err != nil {
+		return imp.report, err
+	}
+	imp.report.TagsCreated = len(s.tags) - tagCount
+	if importErr != nil {
+		return imp.report, importErr
+	}
+	return imp.report, s.runSearchLocked()
+}
+
+type bookmarkImport struct {
+	state  *AppState
+	report *ImportReport
+	userID string
+	urls   map[string]bool       // by urlnorm.Normalize
+	tags   map[string]models.Tag // by folderKey
+	queue  []queuedBookmark
+}
+
+type queuedBookmark struct {
+	bookmark netscape.Bookmark
+	folder   models.Tag // zero for the root
+	path     string
+}
+
+// run creates the folder tags, then imports the bookmarks in file order so
+// the first occurrence of a URL is the one kept.
+func (imp *bookmarkImport) run(root *netscape.Folder) error {
+	if err := imp.folder(root, models.Tag{}, ""); err != nil {
+		return err
+	}
+	sort.SliceStable(imp.queue, func(i, j int) bool {
+		return imp.queue[i].bookmark.Index < imp.queue[j].bookmark.Index
+	})
+	for _, q := range imp.queue {
+		if err := imp.bookmark(q.bookmark, q.folder, q.path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// folder creates tags for the subfolders of f and queues its bookmarks.
+// tag and path identify f itself; both are empty for the root.
+func (imp *bookmarkImport) folder(f *netscape.Folder, tag models.Tag, path string) error {
+	for _, nb := range f.Bookmarks {
+		imp.queue = append(imp.queue, queuedBookmark{bookmark: nb, folder: tag, path: path})
+	}
+
+	for i, sub := range f.Folders {
+		title := strings.TrimSpace(sub.Title)
+		if title == "" {
+			title = "Untitled"
+		}
+		sub.Title = title
+		subTag, err := imp.folderTag(sub, tag.ID, i)
+		if err != nil {
+			return err
+		}
+		subPath := title
+		if path != "" {
+			subPath = path + "/" + title
+		}
+		if err := imp.folder(sub, subTag, subPath); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// folderTag returns the tag for folder f under parentID, creating it if
+// needed.
+func (imp *bookmarkImport) folderTag(f *netscape.Folder, parentID string, order int) (models.Tag, error) {
+	key := folderKey(parentID, f.Title)
+	if tag, ok := imp.tags[key]; ok {
+		return tag, nil
+	}
+
+	now := time.Now()
+	tag := models.Tag{
+		ID:        generateID(),
+		Name:      f.Title,
+		ParentID:  parentID,
+		Order:     order,
+		CreatedAt: firstNonZero(f.AddDate, now),
+		UpdatedAt: firstNonZero(f.LastModified, f.AddDate, now),
+	}
+	if err := imp.state.tagRepo.CreateTag(tag); err != nil {
+		return tag, fmt.Errorf("failed to create tag %s: %w", tag.Name, err)
+	}
+	imp.tags[key] = tag
+	return tag, nil
+}
+
+func (imp *bookmarkImport) bookmark(nb netscape.Bookmark, folder models.Tag, path string) error {
+	entry := ImportEntry{Title: nb.Title, URL: nb.URL, Folder: path}
+
+	if reason := unsupportedURL(nb.URL); reason != "" {
+		entry.Status, entry.Reason = ImportSkipped, reason
+		imp.report.add(entry)
+		return nil
+	}
+	key := urlnorm.Normalize(nb.URL)
+	if imp.urls[key] {
+		entry.Status, entry.Reason = ImportDuplicate, "URL already bookmarked"
+		imp.report.add(entry)
+		return nil
+	}
+
+	// The folder tag is linked by ID, as other folders may have the same
+	// name; names from the TAGS attribute are linked by name.
+	var tags, tagIDs []string
+	if folder.ID != "" {
+		tags, tagIDs = []string{folder.Name}, []string{folder.ID}
+	}
+	for _, name := range nb.Tags {
+		if name != "" && !containsFold(tags, name) {
+			tags = append(tags, name)
+		}
+	}
+
+	title := nb.Title
+	if title == "" {
+		title = nb.URL
+	}
+	b := models.Bookmark{
+		ID:          generateID(),
+		UserID:      imp.userID,
+		URL:         nb.URL,
+		Title:       title,
+		Description: nb.Description,
+		Tags:        tags,
+		TagIDs:      tagIDs,
+		CreatedAt:   firstNonZero(nb.AddDate, time.Now()),
+	}
+	if strings.HasPrefix(nb.IconURI, "http://") || strings.HasPrefix(nb.IconURI, "https://") {
+		b.FaviconURL = nb.IconURI
+	}
+
+	err := imp.state.bookmarkRepo.SaveBookmark(b)
+	if errors.Is(err, storage.ErrDuplicateURL) {
+		entry.Status, entry.Reason = ImportDuplicate, "URL already bookmarked"
+		imp.report.add(entry)
+		imp.urls[key] = true
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to import bookmark %s: %w", nb.URL, err)
+	}
+	imp.urls[key] = true
+	entry.Status = ImportCreated
+	imp.report.add(entry)
+	return nil
+}
+
+// unsupportedURL returns why rawURL cannot be imported, or "" if it can.
+// Browser exports include internal entries such as place: queries and
+// bookmarklets, which only make sense inside that browser.
+func unsupportedURL(rawURL string) string {
+	if rawURL == "" {
+		return "missing URL"
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "invalid URL"
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "http", "https", "ftp":
+		if u.Host == "" {
+			return "invalid URL"
+		}
+		return ""
+	case "":
+		return "invalid URL"
+	default:
+		return fmt.Sprintf("unsupported URL scheme %s:", u.Scheme)
+	}
+}
+
+func folderKey(parentID, name string) string {
+	return parentID + "\x00" + strings.ToLower(name)
+}
+
+func containsFold(names []string, name string) bool {
+	for _, n := range names {
+		if strings.EqualFold(n, name) {
+			return true
+		}
+	}
+	return false
+}
+
+func firstNonZero(times ...time.Time) time.Time {
+	for _, t := range times {
+		if !t.IsZero() {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// ExportBookmarks writes the whole library to a bookmarks.html file in the
+// working directory and returns its path.
+func (s *AppState) ExportBookmarks() (string, error) {
+	var buf bytes.Buffer
+	if err := s.ExportBookmarksHTML(&buf); err != nil {
+		return "", err
+	}
+
+	exportPath := fmt.Sprintf("bookmarks_export_%s.html", time.Now().Format("2006-01-02_15-04-05"))
+	if err := os.WriteFile(exportPath, buf.Bytes(), 0644); err != nil {
+		return "", fmt.Errorf("failed to write export file: %w", err)
+	}
+	return exportPath, nil
+}
+
+// ExportBookmarksHTML writes the whole library as a Netscape bookmarks.html
+// document. Hierarchical tags (tags with a parent or children) become
+// folders and each bookmark is placed in the deepest such tag it carries,
+// found by ID so that folders with the same name are told apart;
+// all of its tags are also listed in the TAGS attribute so an import
+// restores them.
+func (s *AppState) ExportBookmarksHTML(w io.Writer) error {
+	s.mu.RLock()
+	root := s.bookmarkTreeLocked()
+	s.mu.RUnlock()
+
+	if err := netscape.Write(w, root); err != nil {
+		return fmt.Errorf("failed to write bookmarks: %w", err)
+	}
+	return nil
+}
+
+// bookmarkTreeLocked arranges the library into folders. The caller must
+// hold s.mu.
+func (s *AppState) bookmarkTreeLocked() *netscape.Folder {
+	byID := make(map[string]models.Tag, len(s.tags))
+	children := make(map[string][]models.Tag)
+	for _, t := range s.tags {
+		byID[t.ID] = t
+	}
+	for _, t := range s.tags {
+		parent := t.ParentID
+		if _, ok := byID[parent]; !ok {
+			parent = ""
+		}
+		children[parent] = append(children[parent], t)
+	}
+	for _, list := range children {
+		sort.SliceStable(list, func(i, j int) bool { return list[i].Order < list[j].Order })
+	}
+
+	// Only tags that take part in a hierarchy become folders; flat tags
+	// stay in the TAGS attribute.
+	isFolder := func(t models.Tag) bool {
+		_, hasParent := byID[t.ParentID]
+		return hasParent || len(children[t.ID]) > 0
+	}
+	depth := func(t models.Tag) int {
+		d := 0
+		for seen := map[string]bool{}; !seen[t.ID]; d++ {
+			seen[t.ID] = true
+			parent, ok := byID[t.ParentID]
+			if !ok {
+				break
+			}
+			t = parent
+		}
+		return d
+	}
+	// Bookmarks without tag IDs, such as ones synced from an older
+	// version, are placed by name in the deepest folder of that name.
+	folderByName := make(map[string]models.Tag)
+	for _, t := range s.tags {
+		if !isFolder(t) {
+			continue
+		}
+		if prev, ok := folderByName[t.Name]; !ok || depth(t) > depth(prev) {
+			folderByName[t.Name] = t
+		}
+	}
+
+	root := &netscape.Folder{}
+	folders := map[string]*netscape.Folder{"": root}
+	var build func(parent string, into *netscape.Folder, seen map[string]bool)
+	build = func(parent string, into *netscape.Folder, seen map[string]bool) {
+		for _, t := range children[parent] {
+			if !isFolder(t) || seen[t.ID] {
+				continue
+			}
+			seen[t.ID] = true
+			f := &netscape.Folder{Title: t.Name, AddDate: t.CreatedAt, LastModified: t.UpdatedAt}
+			into.Folders = append(into.Folders, f)
+			folders[t.ID] = f
+			build(t.ID, f, seen)
+		}
+	}
+	build("", root, map[string]bool{})
+
+	for _, b := range s.bookmarks {
+		var candidates []models.Tag
+		linked := make(map[string]bool)
+		for _, id := range b.TagIDs {
+			if t, ok := byID[id]; ok && containsFold(b.Tags, t.Name) {
+				candidates = append(candidates, t)
+				linked[strings.ToLower(t.Name)] = true
+			}
+		}
+		for _, name := range b.Tags {
+			if t, ok := folderByName[name]; ok && !linked[strings.ToLower(name)] {
+				candidates = append(candidates, t)
+			}
+		}
+
+		target := root
+		best := -1
+		for _, t := range candidates {
+			if !isFolder(t) {
+				continue
+			}
+			if d := depth(t); d > best && folders[t.ID] != nil {
+				target, best = folders[t.ID], d
+			}
+		}
+		target.Bookmarks = append(target.Bookmarks, netscape.Bookmark{
+			Title:        b.Title,
+			URL:          b.URL,
+			Description:  b.Description,
+			AddDate:      b.CreatedAt,
+			LastModified: b.UpdatedAt,
+			Tags:         b.Tags,
+			IconURI:      b.FaviconURL,
+		})
+	}
+
+	pruneEmptyFolders(root)
+	return root
+}
+
+// pruneEmptyFolders drops folders that contain no bookmarks at any depth
+// and reports whether f itself is empty.
+func pruneEmptyFolders(f *netscape.Folder) bool {
+	kept := f.Folders[:0]
+	for _, sub := range f.Folders {
+		if !pruneEmptyFolders(sub) {
+			kept = append(kept, sub)
+		}
+	}
+	f.Folders = kept
+	return len(f.Folders) == 0 && len(f.Bookmarks) == 0
+}
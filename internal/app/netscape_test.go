@@ -0,0 +1,123 @@
+package app
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/goBookMarker/internal/netscape"
+	"github.com/goBookMarker/internal/storage"
+)
+
+const sameNameFolders = `<!DOCTYPE NETSCAPE-Bookmark-file-1>
+<TITLE>Bookmarks</TITLE>
+<H1>Bookmarks</H1>
+<DL><p>
+    <DT><H3>Bar</H3>
+    <DL><p>
+        <DT><H3>Go</H3>
+        <DL><p>
+            <DT><A HREF="https://go.dev/">Go</A>
+        </DL><p>
+    </DL><p>
+    <DT><H3>Other</H3>
+    <DL><p>
+        <DT><H3>Go</H3>
+        <DL><p>
+            <DT><A HREF="https://go.example.com/board-game">Board game</A>
+        </DL><p>
+    </DL><p>
+</DL><p>
+`
+
+func TestImportExportKeepsSameNamedFoldersApart(t *testing.T) {
+	store := storage.NewMemoryStore()
+	s := NewAppState(store, store, store)
+	report, err := s.ImportBookmarksHTML(strings.NewReader(sameNameFolders))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Created != 2 || report.TagsCreated != 4 {
+		t.Fatalf("created %d bookmarks and %d tags, want 2 and 4", report.Created, report.TagsCreated)
+	}
+
+	root, err := netscape.Parse(bytes.NewReader(exportHTML(t, s)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for path, want := range map[string]string{
+		"Bar/Go":   "https://go.dev/",
+		"Other/Go": "https://go.example.com/board-game",
+	} {
+		f := folderAt(root, path)
+		if f == nil || len(f.Bookmarks) != 1 || f.Bookmarks[0].URL != want {
+			t.Errorf("folder %s = %+v, want only %s", path, f, want)
+		}
+	}
+
+	// Importing the export again finds every bookmark and folder.
+	report, err = s.ImportBookmarksHTML(bytes.NewReader(exportHTML(t, s)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Duplicates != 2 || report.TagsCreated != 0 {
+		t.Fatalf("re-import: %d duplicates, %d tags created; want 2 and 0", report.Duplicates, report.TagsCreated)
+	}
+}
+
+func TestDeleteTagKeepsSameNamedTag(t *testing.T) {
+	store := storage.NewMemoryStore()
+	s := NewAppState(store, store, store)
+	if _, err := s.ImportBookmarksHTML(strings.NewReader(sameNameFolders)); err != nil {
+		t.Fatal(err)
+	}
+
+	var otherGo string
+	for _, b := range s.GetBookmarks() {
+		if b.URL == "https://go.example.com/board-game" {
+			otherGo = b.TagIDs[0]
+		}
+	}
+	if err := s.DeleteTags([]string{otherGo}); err != nil {
+		t.Fatal(err)
+	}
+	reloaded := NewAppState(store, store, store)
+	if err := reloaded.LoadInitialData(); err != nil {
+		t.Fatal(err)
+	}
+	for _, state := range []*AppState{s, reloaded} {
+		for _, b := range state.GetBookmarks() {
+			hasGo := containsFold(b.Tags, "Go")
+			if want := b.URL == "https://go.dev/"; hasGo != want {
+				t.Errorf("%s has tags %v %v after deleting Other/Go", b.URL, b.Tags, b.TagIDs)
+			}
+		}
+	}
+}
+
+func exportHTML(t *testing.T, s *AppState) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := s.ExportBookmarksHTML(&buf); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// folderAt returns the folder at a slash-separated path below root.
+func folderAt(root *netscape.Folder, path string) *netscape.Folder {
+	f := root
+	for _, title := range strings.Split(path, "/") {
+		var next *netscape.Folder
+		for _, sub := range f.Folders {
+			if sub.Title == title {
+				next = sub
+			}
+		}
+		if next == nil {
+			return nil
+		}
+		f = next
+	}
+	return f
+}
@@ -0,0 +1,158 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/goBookMarker/internal/models"
+	"github.com/goBookMarker/internal/share"
+	"github.com/goBookMarker/internal/storage"
+)
+
+// SaveSharedItem bookmarks content shared with the app, merging it into
+// the bookmark that already has its URL, and links the shared image to the
+// bookmark if there is one. It returns the bookmark as saved and whether it
+// was merged.
+func (s *AppState) SaveSharedItem(item *share.SharedItem) (*models.Bookmark, bool, error) {
+	saved, merged, err := s.SaveSharedBookmark(item.ToBookmark())
+	if err != nil {
+		return nil, false, err
+	}
+	if item.AttachmentID == "" {
+		return saved, merged, nil
+	}
+	repo, ok := s.bookmarkRepo.(storage.AttachmentRepository)
+	if !ok {
+		return saved, merged, fmt.Errorf("attachments are not supported by the bookmark repository")
+	}
+	if err := repo.LinkAttachment(saved.ID, item.AttachmentID); err != nil {
+		return saved, merged, err
+	}
+	return saved, merged, nil
+}
+
+// SaveSharedItems saves every item of a share, as when the user chooses to
+// save all links found in shared text. Items that fail do not stop the
+// others; the saved bookmarks are returned with the errors joined.
+func (s *AppState) SaveSharedItems(items []*share.SharedItem) ([]*models.Bookmark, error) {
+	var saved []*models.Bookmark
+	var errs []error
+	for _, item := range items {
+		b, _, err := s.SaveSharedItem(item)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to save %s: %w", item.Content, err))
+			continue
+		}
+		saved = append(saved, b)
+	}
+	return saved, errors.Join(errs...)
+}
+
+// SetShareHandler sets the handler whose queue of incoming shares the app
+// lists and saves from.
+func (s *AppState) SetShareHandler(h *share.ShareHandler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.shares = h
+	s.queuedShares = nil
+	s.sharesStale = true
+}
+
+// SharesChanged makes QueuedShares load the queue again. Call it when the
+// share handler's Changes channel fires.
+func (s *AppState) SharesChanged() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sharesStale = true
+}
+
+// QueuedShares returns the shares waiting to be saved or dismissed, oldest
+// first, including those still being processed and those that failed. The
+// UI reads them on every frame, so the list is cached until SharesChanged
+// is called, and images not stored yet carry only the header of their data
+// URI as content.
+func (s *AppState) QueuedShares() ([]models.QueuedShare, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.shares == nil || !s.sharesStale {
+		return s.queuedShares, nil
+	}
+	shares, err := s.shares.Shares()
+	if err != nil {
+		return nil, err
+	}
+	for i, queued := range shares {
+		if header, _, ok := strings.Cut(queued.Content, ","); ok && strings.HasPrefix(header, "data:") {
+			shares[i].Content = header + ","
+		}
+	}
+	s.queuedShares = shares
+	s.sharesStale = false
+	return shares, nil
+}
+
+// SaveQueuedShare saves the items of a queued share and takes it off the
+// queue. A share that is not ready is saved by its links alone. The share
+// stays queued if any item fails to save.
+func (s *AppState) SaveQueuedShare(id string) ([]*models.Bookmark, error) {
+	h := s.shareHandler()
+	if h == nil {
+		return nil, fmt.Errorf("no share queue")
+	}
+	shares, err := h.Shares()
+	if err != nil {
+		return nil, err
+	}
+	for _, queued := range shares {
+		if queued.ID != id {
+			continue
+		}
+		items, err := h.Items(queued)
+		if err != nil {
+			return nil, err
+		}
+		saved, err := s.SaveSharedItems(items)
+		if err != nil {
+			return saved, err
+		}
+		defer s.SharesChanged()
+		return saved, h.Remove(id)
+	}
+	return nil, fmt.Errorf("share %s not found", id)
+}
+
+// RetryShare processes a failed share again.
+func (s *AppState) RetryShare(id string) error {
+	h := s.shareHandler()
+	if h == nil {
+		return fmt.Errorf("no share queue")
+	}
+	defer s.SharesChanged()
+	return h.Retry(id)
+}
+
+// DismissShare takes a share off the queue without saving it.
+func (s *AppState) DismissShare(id string) error {
+	h := s.shareHandler()
+	if h == nil {
+		return fmt.Errorf("no share queue")
+	}
+	defer s.SharesChanged()
+	return h.Remove(id)
+}
+
+func (s *AppState) shareHandler() *share.ShareHandler {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.shares
+}
+
+// BookmarkAttachments returns the files attached to a bookmark.
+func (s *AppState) BookmarkAttachments(bookmarkID string) ([]models.Attachment, error) {
+	repo, ok := s.bookmarkRepo.(storage.AttachmentRepository)
+	if !ok {
+		return nil, nil
+	}
+	return repo.GetBookmarkAttachments(bookmarkID)
+}
@@ -0,0 +1,55 @@
+package app
+
+import (
+	"testing"
+
+	"github.com/goBookMarker/internal/share"
+	"github.com/goBookMarker/internal/storage"
+)
+
+func TestQueuedSharesAreCachedUntilChanged(t *testing.T) {
+	store := storage.NewMemoryStore()
+	h := share.NewShareHandler(store)
+	s := loadState(t, store)
+	s.SetShareHandler(h)
+
+	if err := h.HandleSharedContent("text/plain", "https://go.dev/"); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.HandleSharedContent("image/png", "data:image/png;base64,iVBORw0KGgoAAAANSUhEUg=="); err != nil {
+		t.Fatal(err)
+	}
+	shares, err := s.QueuedShares()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(shares) != 2 {
+		t.Fatalf("%d queued shares, want 2", len(shares))
+	}
+	for _, queued := range shares {
+		if queued.ContentType == "image/png" && queued.Content != "data:image/png;base64," {
+			t.Errorf("queued image content = %q, want only the data URI header", queued.Content)
+		}
+	}
+
+	// Until the handler reports a change, the list is not read again.
+	if err := h.HandleSharedContent("text/plain", "https://pkg.go.dev/"); err != nil {
+		t.Fatal(err)
+	}
+	if shares, _ := s.QueuedShares(); len(shares) != 2 {
+		t.Fatalf("%d queued shares before SharesChanged, want the cached 2", len(shares))
+	}
+	s.SharesChanged()
+	shares, err = s.QueuedShares()
+	if err != nil || len(shares) != 3 {
+		t.Fatalf("QueuedShares after SharesChanged = %d shares, %v, want 3", len(shares), err)
+	}
+
+	// The app's own changes show at once.
+	if err := s.DismissShare(shares[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	if shares, _ := s.QueuedShares(); len(shares) != 2 {
+		t.Fatalf("%d queued shares after dismissing one, want 2", len(shares))
+	}
+}
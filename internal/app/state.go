@@ -8,37 +8,184 @@ import (
 	"sync"
 	"time"
 
+	"github.com/google/uuid"
+
 	"github.com/goBookMarker/internal/models"
+	"github.com/goBookMarker/internal/search"
+	"github.com/goBookMarker/internal/share"
+	"github.com/goBookMarker/internal/storage"
 )
 
 type AppState struct {
-	mu          sync.RWMutex
-	bookmarks   []models.Bookmark
-	currentUser *models.User
-	searchQuery string
-	currentPage string
-	tags        []models.Tag
-	tagGroups   []models.TagGroup
+	mu            sync.RWMutex
+	bookmarks     []models.Bookmark
+	currentUser   *models.User
+	accounts      []models.LinkedAccount
+	searchQuery   string
+	query         *search.Query
+	searchErr     error
+	searchResults []models.SearchResult
+	currentPage   string
+	tags          []models.Tag
+	tagGroups     []models.TagGroup
+
+	bookmarkRepo storage.BookmarkRepository
+	tagRepo      storage.TagRepository
+	userRepo     storage.UserRepository
+
+	shares *share.ShareHandler
+	// queuedShares caches the share queue for QueuedShares until
+	// sharesStale is set.
+	queuedShares []models.QueuedShare
+	sharesStale  bool
 }
 
-func NewAppState() *AppState {
+// NewAppState creates the application state on top of the given
+// repositories. Every mutation is written through to the repository before
+// the in-memory copy is updated; call LoadInitialData to populate the state
+// from storage.
+func NewAppState(bookmarks storage.BookmarkRepository, tags storage.TagRepository, users storage.UserRepository) *AppState {
 	return &AppState{
-		bookmarks: make([]models.Bookmark, 0),
-		tags:      make([]models.Tag, 0),
-		tagGroups: make([]models.TagGroup, 0),
+		bookmarks:    make([]models.Bookmark, 0),
+		tags:         make([]models.Tag, 0),
+		tagGroups:    make([]models.TagGroup, 0),
+		bookmarkRepo: bookmarks,
+		tagRepo:      tags,
+		userRepo:     users,
+	}
+}
+
+// LoadInitialData replaces the in-memory state with the contents of the
+// repositories.
+func (s *AppState) LoadInitialData() error {
+	bookmarks, err := s.bookmarkRepo.GetAllBookmarks()
+	if err != nil {
+		return fmt.Errorf("failed to load bookmarks: %w", err)
+	}
+	tags, err := s.tagRepo.GetAllTags()
+	if err != nil {
+		return fmt.Errorf("failed to load tags: %w", err)
+	}
+	groups, err := s.tagRepo.GetAllTagGroups()
+	if err != nil {
+		return fmt.Errorf("failed to load tag groups: %w", err)
+	}
+	user, err := s.userRepo.GetCurrentUser()
+	if err != nil {
+		return fmt.Errorf("failed to load user: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.bookmarks = append(make([]models.Bookmark, 0, len(bookmarks)), bookmarks...)
+	s.tags = append(make([]models.Tag, 0, len(tags)), tags...)
+	s.tagGroups = append(make([]models.TagGroup, 0, len(groups)), groups...)
+	s.currentUser = user
+	return s.reloadAccountsLocked()
+}
+
+// reloadLibraryLocked replaces the in-memory bookmarks, tags and tag
+// groups with the repository contents. The caller must hold s.mu.
+func (s *AppState) reloadLibraryLocked() error {
+	bookmarks, err := s.bookmarkRepo.GetAllBookmarks()
+	if err != nil {
+		return fmt.Errorf("failed to reload bookmarks: %w", err)
+	}
+	tags, err := s.tagRepo.GetAllTags()
+	if err != nil {
+		return fmt.Errorf("failed to reload tags: %w", err)
 	}
+	groups, err := s.tagRepo.GetAllTagGroups()
+	if err != nil {
+		return fmt.Errorf("failed to reload tag groups: %w", err)
+	}
+	s.bookmarks = append(make([]models.Bookmark, 0, len(bookmarks)), bookmarks...)
+	s.tags = append(make([]models.Tag, 0, len(tags)), tags...)
+	s.tagGroups = append(make([]models.TagGroup, 0, len(groups)), groups...)
+	return nil
 }
 
+// GetBookmarks returns all bookmarks, or only those matching the active
+// search query. The matches are those of SearchResults, which the
+// repository finds by page text too.
 func (s *AppState) GetBookmarks() []models.Bookmark {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
+	if s.query != nil {
+		bookmarks := make([]models.Bookmark, len(s.searchResults))
+		for i, r := range s.searchResults {
+			bookmarks[i] = r.Bookmark
+		}
+		return bookmarks
+	}
 	return s.bookmarks
 }
 
-func (s *AppState) Search(query string) {
+// Search parses query and keeps the ranked results for SearchResults. An
+// empty query clears the search. A query that fails to parse is returned
+// as a *search.ParseError and also reported by SearchError; the previous
+// results are cleared.
+func (s *AppState) Search(query string) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	s.searchQuery = query
+	s.searchQuery = strings.TrimSpace(query)
+	s.query = nil
+	s.searchErr = nil
+	s.searchResults = nil
+	if s.searchQuery == "" {
+		return nil
+	}
+
+	q, err := search.Parse(s.searchQuery)
+	if err != nil {
+		s.searchErr = err
+		return err
+	}
+	s.query = q
+	return s.runSearchLocked()
+}
+
+// runSearchLocked refreshes searchResults for the current query. The
+// caller must hold s.mu.
+func (s *AppState) runSearchLocked() error {
+	s.searchResults = nil
+	if s.query == nil {
+		return nil
+	}
+
+	searcher, ok := s.bookmarkRepo.(storage.BookmarkSearcher)
+	if !ok {
+		for _, b := range s.query.Filter(s.bookmarks) {
+			s.searchResults = append(s.searchResults, s.query.Result(b))
+		}
+		return nil
+	}
+	results, err := searcher.QueryBookmarks(s.query, 0)
+	if err != nil {
+		s.searchErr = err
+		return fmt.Errorf("failed to search bookmarks: %w", err)
+	}
+	s.searchResults = results
+	return nil
+}
+
+func (s *AppState) SearchQuery() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.searchQuery
+}
+
+func (s *AppState) SearchResults() []models.SearchResult {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.searchResults
+}
+
+// SearchError returns the error from the last search, if any.
+func (s *AppState) SearchError() error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.searchErr
 }
 
 func (s *AppState) ShowAddBookmark() {
@@ -56,6 +203,9 @@ func (s *AppState) CurrentUser() *models.User {
 func (s *AppState) SaveUser(user *models.User) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	if err := s.userRepo.SaveUser(user); err != nil {
+		return fmt.Errorf("failed to save user: %w", err)
+	}
 	s.currentUser = user
 	return nil
 }
@@ -72,16 +222,57 @@ func (s *AppState) GetCurrentPage() string {
 	return s.currentPage
 }
 
+// SaveBookmark creates or updates a bookmark and marks it as modified now.
+// If another bookmark has the same normalized URL it returns a
+// *storage.DuplicateError; errors.Is(err, storage.ErrDuplicateURL) reports
+// true for it.
 func (s *AppState) SaveBookmark(bookmark *models.Bookmark) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	return s.saveBookmarkLocked(bookmark)
+}
+
+// saveBookmarkLocked is SaveBookmark for callers that hold s.mu.
+func (s *AppState) saveBookmarkLocked(bookmark *models.Bookmark) error {
+	bookmark.UpdatedAt = time.Now()
+	if bookmark.CreatedAt.IsZero() {
+		bookmark.CreatedAt = bookmark.UpdatedAt
+	}
+	if err := s.bookmarkRepo.SaveBookmark(*bookmark); err != nil {
+		return fmt.Errorf("failed to save bookmark: %w", err)
+	}
+	if err := s.refreshTagsLocked(bookmark.Tags); err != nil {
+		return err
+	}
 	for i, b := range s.bookmarks {
 		if b.ID == bookmark.ID {
 			s.bookmarks[i] = *bookmark
-			return nil
+			return s.runSearchLocked()
 		}
 	}
 	s.bookmarks = append(s.bookmarks, *bookmark)
+	return s.runSearchLocked()
+}
+
+// refreshTagsLocked reloads tags from the repository when a bookmark
+// references a tag name that is not known yet, since saving a bookmark may
+// create tags. The caller must hold s.mu.
+func (s *AppState) refreshTagsLocked(names []string) error {
+	known := make(map[string]bool, len(s.tags))
+	for _, t := range s.tags {
+		known[t.Name] = true
+	}
+	for _, name := range names {
+		if known[name] {
+			continue
+		}
+		tags, err := s.tagRepo.GetAllTags()
+		if err != nil {
+			return fmt.Errorf("failed to reload tags: %w", err)
+		}
+		s.tags = tags
+		return nil
+	}
 	return nil
 }
 
@@ -91,16 +282,20 @@ func (s *AppState) EditBookmark(bookmark *models.Bookmark) {
 	s.currentPage = "edit_bookmark"
 }
 
-func (s *AppState) DeleteBookmark(bookmark *models.Bookmark) {
+func (s *AppState) DeleteBookmark(bookmark *models.Bookmark) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	newBookmarks := make([]models.Bookmark, 0, len(s.bookmarks)-1)
+	if err := s.bookmarkRepo.DeleteBookmark(bookmark.ID); err != nil {
+		return fmt.Errorf("failed to delete bookmark: %w", err)
+	}
+	newBookmarks := make([]models.Bookmark, 0, len(s.bookmarks))
 	for _, b := range s.bookmarks {
 		if b.ID != bookmark.ID {
 			newBookmarks = append(newBookmarks, b)
 		}
 	}
 	s.bookmarks = newBookmarks
+	return s.runSearchLocked()
 }
 
 func (s *AppState) ShareBookmark(bookmark *models.Bookmark) {
@@ -121,30 +316,32 @@ func (s *AppState) GetTagsWithLocking() []models.Tag {
 	return s.tags
 }
 
+// SaveTag creates or updates a tag and marks it as modified now.
 func (s *AppState) SaveTag(tag *models.Tag) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	tag.UpdatedAt = time.Now()
+	if tag.CreatedAt.IsZero() {
+		tag.CreatedAt = tag.UpdatedAt
+	}
 	for i, t := range s.tags {
 		if t.ID == tag.ID {
+			if err := s.tagRepo.UpdateTag(*tag); err != nil {
+				return fmt.Errorf("failed to update tag: %w", err)
+			}
 			s.tags[i] = *tag
 			return nil
 		}
 	}
+	if err := s.tagRepo.CreateTag(*tag); err != nil {
+		return fmt.Errorf("failed to create tag: %w", err)
+	}
 	s.tags = append(s.tags, *tag)
 	return nil
 }
 
 func (s *AppState) DeleteTag(tag *models.Tag) error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	newTags := make([]models.Tag, 0, len(s.tags)-1)
-	for _, t := range s.tags {
-		if t.ID != tag.ID {
-			newTags = append(newTags, t)
-		}
-	}
-	s.tags = newTags
-	return nil
+	return s.DeleteTags([]string{tag.ID})
 }
 
 func (s *AppState) DeleteTags(tagIDs []string) error {
@@ -158,11 +355,17 @@ func (s *AppState) DeleteTags(tagIDs []string) error {
 	}
 
 	// Filter out the tags to be deleted
-	newTags := make([]models.Tag, 0, len(s.tags)-len(tagIDs))
+	newTags := make([]models.Tag, 0, len(s.tags))
+	deletedNames := make(map[string]bool)
 	for _, tag := range s.tags {
 		if !toDelete[tag.ID] {
 			newTags = append(newTags, tag)
+			continue
 		}
+		if err := s.tagRepo.DeleteTag(tag.ID); err != nil {
+			return fmt.Errorf("failed to delete tag %s: %w", tag.Name, err)
+		}
+		deletedNames[tag.Name] = true
 	}
 
 	// Update tag groups to remove deleted tags
@@ -173,7 +376,40 @@ func (s *AppState) DeleteTags(tagIDs []string) error {
 				newTagIDs = append(newTagIDs, tagID)
 			}
 		}
-		s.tagGroups[i].TagIDs = newTagIDs
+		if len(newTagIDs) == len(group.TagIDs) {
+			continue
+		}
+		group.TagIDs = newTagIDs
+		if err := s.tagRepo.UpdateTagGroup(group); err != nil {
+			return fmt.Errorf("failed to update tag group %s: %w", group.Name, err)
+		}
+		s.tagGroups[i] = group
+	}
+
+	// Drop the deleted tags from bookmarks; the repository removed the
+	// bookmark_tags rows together with the tags. A name stays if the
+	// bookmark's tag of that name is another tag with the same name.
+	names := make(map[string]string, len(s.tags))
+	for _, tag := range s.tags {
+		names[tag.ID] = tag.Name
+	}
+	for i, b := range s.bookmarks {
+		keptIDs := make([]string, 0, len(b.TagIDs))
+		linked := make(map[string]bool)
+		for _, id := range b.TagIDs {
+			if !toDelete[id] {
+				keptIDs = append(keptIDs, id)
+				linked[names[id]] = true
+			}
+		}
+		kept := make([]string, 0, len(b.Tags))
+		for _, name := range b.Tags {
+			if !deletedNames[name] || linked[name] {
+				kept = append(kept, name)
+			}
+		}
+		s.bookmarks[i].Tags = kept
+		s.bookmarks[i].TagIDs = keptIDs
 	}
 
 	s.tags = newTags
@@ -189,12 +425,24 @@ func (s *AppState) GetTagGroups() []models.TagGroup {
 func (s *AppState) SaveTagGroup(group *models.TagGroup) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	return s.saveTagGroupLocked(group)
+}
+
+// saveTagGroupLocked persists group and updates the in-memory copy. The
+// caller must hold s.mu.
+func (s *AppState) saveTagGroupLocked(group *models.TagGroup) error {
 	for i, existingGroup := range s.tagGroups {
 		if existingGroup.ID == group.ID {
+			if err := s.tagRepo.UpdateTagGroup(*group); err != nil {
+				return fmt.Errorf("failed to update tag group: %w", err)
+			}
 			s.tagGroups[i] = *group
 			return nil
 		}
 	}
+	if err := s.tagRepo.CreateTagGroup(*group); err != nil {
+		return fmt.Errorf("failed to create tag group: %w", err)
+	}
 	s.tagGroups = append(s.tagGroups, *group)
 	return nil
 }
@@ -204,6 +452,9 @@ func (s *AppState) DeleteTagGroup(group *models.TagGroup) error {
 	defer s.mu.Unlock()
 	for i, existingGroup := range s.tagGroups {
 		if existingGroup.ID == group.ID {
+			if err := s.tagRepo.DeleteTagGroup(group.ID); err != nil {
+				return fmt.Errorf("failed to delete tag group: %w", err)
+			}
 			s.tagGroups = append(s.tagGroups[:i], s.tagGroups[i+1:]...)
 			return nil
 		}
@@ -236,12 +487,16 @@ func (s *AppState) Logout() {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	s.currentUser = nil
+	s.accounts = nil
 	// Optionally reset other state if needed
 	s.bookmarks = make([]models.Bookmark, 0)
 	s.tags = make([]models.Tag, 0)
 	s.tagGroups = make([]models.TagGroup, 0)
 	s.currentPage = ""
 	s.searchQuery = ""
+	s.query = nil
+	s.searchErr = nil
+	s.searchResults = nil
 }
 
 func (s *AppState) GroupTags(tagIDs []string) error {
@@ -250,14 +505,14 @@ func (s *AppState) GroupTags(tagIDs []string) error {
 
 	// Create a new tag group
 	group := &models.TagGroup{
-		ID:       generateID(), // You'll need to implement this
-		Name:     "New Group",  // Default name, can be changed later
+		ID:       generateID(),
+		Name:     "New Group", // Default name, can be changed later
 		TagIDs:   tagIDs,
 		Order:    len(s.tagGroups), // Add to end of list
 		Expanded: true,
 	}
 
-	return s.SaveTagGroup(group)
+	return s.saveTagGroupLocked(group)
 }
 
 func (s *AppState) ExportTags(tagIDs []string) (string, error) {
@@ -318,5 +573,5 @@ func (s *AppState) ExportTags(tagIDs []string) (string, error) {
 }
 
 func generateID() string {
-	return fmt.Sprintf("%d", time.Now().UnixNano())
+	return uuid.New().String()
 }
@@ -0,0 +1,208 @@
+package app
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/goBookMarker/internal/models"
+	"github.com/goBookMarker/internal/storage"
+)
+
+// failingStore is a MemoryStore whose bookmark and tag writes fail.
+type failingStore struct {
+	*storage.MemoryStore
+}
+
+var errWriteFailed = errors.New("disk full")
+
+func (failingStore) SaveBookmark(models.Bookmark) error { return errWriteFailed }
+func (failingStore) CreateTag(models.Tag) error         { return errWriteFailed }
+
+// loadState returns an AppState loaded from store.
+func loadState(t *testing.T, store *storage.MemoryStore) *AppState {
+	t.Helper()
+	s := NewAppState(store, store, store)
+	if err := s.LoadInitialData(); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// bookmarkURLs returns the URLs of bookmarks, joined in order.
+func bookmarkURLs(bookmarks []models.Bookmark) string {
+	urls := make([]string, len(bookmarks))
+	for i, b := range bookmarks {
+		urls[i] = b.URL
+	}
+	return strings.Join(urls, ",")
+}
+
+func tagNames(tags []models.Tag) string {
+	names := make([]string, len(tags))
+	for i, tag := range tags {
+		names[i] = tag.Name
+	}
+	return strings.Join(names, ",")
+}
+
+func TestLoadInitialData(t *testing.T) {
+	store := storage.NewMemoryStore()
+	now := time.Now()
+	for i, url := range []string{"https://go.dev/", "https://pkg.go.dev/"} {
+		b := models.Bookmark{ID: generateID(), URL: url, Tags: []string{"go"}, CreatedAt: now.Add(time.Duration(-i) * time.Hour)}
+		if err := store.SaveBookmark(b); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tags := []models.Tag{{ID: "go", Name: "go", Order: 0}, {ID: "docs", Name: "docs", Order: 1}}
+	for _, tag := range tags {
+		if err := store.CreateTag(tag); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.CreateTagGroup(models.TagGroup{ID: "lang", Name: "Languages", TagIDs: []string{"go"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.SaveUser(&models.User{ID: "alice", Name: "Alice"}); err != nil {
+		t.Fatal(err)
+	}
+
+	s := loadState(t, store)
+	if got := bookmarkURLs(s.GetBookmarks()); got != "https://go.dev/,https://pkg.go.dev/" {
+		t.Errorf("bookmarks = %s", got)
+	}
+	if got := tagNames(s.GetTags()); got != "go,docs" {
+		t.Errorf("tags = %s", got)
+	}
+	if groups := s.GetTagGroups(); len(groups) != 1 || groups[0].Name != "Languages" {
+		t.Errorf("tag groups = %+v", groups)
+	}
+	if u := s.CurrentUser(); u == nil || u.ID != "alice" {
+		t.Errorf("current user = %+v, want alice", u)
+	}
+}
+
+func TestMutationsWriteThrough(t *testing.T) {
+	store := storage.NewMemoryStore()
+	s := loadState(t, store)
+
+	goTag := &models.Tag{ID: "go", Name: "go"}
+	docsTag := &models.Tag{ID: "docs", Name: "docs", Order: 1}
+	for _, tag := range []*models.Tag{goTag, docsTag} {
+		if err := s.SaveTag(tag); err != nil {
+			t.Fatal(err)
+		}
+	}
+	goTag.Color = "#00add8"
+	if err := s.SaveTag(goTag); err != nil {
+		t.Fatalf("updating a tag: %v", err)
+	}
+	group := &models.TagGroup{ID: "lang", Name: "Languages", TagIDs: []string{"go", "docs"}}
+	if err := s.SaveTagGroup(group); err != nil {
+		t.Fatal(err)
+	}
+	keep := &models.Bookmark{ID: generateID(), URL: "https://go.dev/", Tags: []string{"go", "docs"}, TagIDs: []string{"go", "docs"}}
+	drop := &models.Bookmark{ID: generateID(), URL: "https://example.com/"}
+	for _, b := range []*models.Bookmark{keep, drop} {
+		if err := s.SaveBookmark(b); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if keep.CreatedAt.IsZero() || keep.UpdatedAt.IsZero() {
+		t.Errorf("saved bookmark has CreatedAt %v, UpdatedAt %v", keep.CreatedAt, keep.UpdatedAt)
+	}
+	if err := s.DeleteBookmark(drop); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.DeleteTags([]string{"docs"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SaveUser(&models.User{ID: "alice", Name: "Alice"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The store holds what the state shows, so a restart sees the same.
+	for name, state := range map[string]*AppState{"state": s, "reloaded": loadState(t, store)} {
+		bookmarks := state.GetBookmarks()
+		if got := bookmarkURLs(bookmarks); got != "https://go.dev/" {
+			t.Errorf("%s: bookmarks = %s", name, got)
+		} else if b := bookmarks[0]; strings.Join(b.Tags, ",") != "go" || strings.Join(b.TagIDs, ",") != "go" {
+			t.Errorf("%s: bookmark tags = %v %v, want only go", name, b.Tags, b.TagIDs)
+		}
+		tags := state.GetTags()
+		if len(tags) != 1 || tags[0].Name != "go" || tags[0].Color != "#00add8" {
+			t.Errorf("%s: tags = %+v, want the updated go tag", name, tags)
+		}
+		groups := state.GetTagGroups()
+		if len(groups) != 1 || strings.Join(groups[0].TagIDs, ",") != "go" {
+			t.Errorf("%s: tag groups = %+v, want lang with go only", name, groups)
+		}
+		if u := state.CurrentUser(); u == nil || u.Name != "Alice" {
+			t.Errorf("%s: current user = %+v", name, u)
+		}
+	}
+
+	if err := s.DeleteTagGroup(group); err != nil {
+		t.Fatal(err)
+	}
+	if groups, _ := store.GetAllTagGroups(); len(groups) != 0 {
+		t.Errorf("store has tag groups %+v after DeleteTagGroup", groups)
+	}
+	if err := s.DeleteTagGroup(group); err == nil {
+		t.Error("deleting a missing tag group succeeded")
+	}
+}
+
+func TestFailedWriteLeavesStateUnchanged(t *testing.T) {
+	store := failingStore{storage.NewMemoryStore()}
+	s := NewAppState(store, store, store)
+	if err := s.LoadInitialData(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.SaveBookmark(&models.Bookmark{ID: generateID(), URL: "https://go.dev/"}); !errors.Is(err, errWriteFailed) {
+		t.Fatalf("SaveBookmark = %v, want the store's error", err)
+	}
+	if err := s.SaveTag(&models.Tag{ID: "go", Name: "go"}); !errors.Is(err, errWriteFailed) {
+		t.Fatalf("SaveTag = %v, want the store's error", err)
+	}
+	if n := len(s.GetBookmarks()); n != 0 {
+		t.Errorf("%d bookmarks in memory after a failed save", n)
+	}
+	if n := len(s.GetTags()); n != 0 {
+		t.Errorf("%d tags in memory after a failed save", n)
+	}
+}
+
+func TestSearchFindsPageText(t *testing.T) {
+	store := storage.NewMemoryStore()
+	s := loadState(t, store)
+	for _, b := range []*models.Bookmark{
+		{ID: generateID(), URL: "https://go.dev/blog/", Title: "Blog"},
+		{ID: generateID(), URL: "https://example.com/", Title: "Example"},
+	} {
+		if err := s.SaveBookmark(b); err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(b.URL, "go.dev") {
+			if err := store.SetPageText(b.ID, "generics arrive in Go 1.18"); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	if err := s.Search("generics"); err != nil {
+		t.Fatal(err)
+	}
+	if got := bookmarkURLs(s.GetBookmarks()); got != "https://go.dev/blog/" {
+		t.Errorf("bookmarks matching page text = %s", got)
+	}
+	if err := s.Search(""); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(s.GetBookmarks()); n != 2 {
+		t.Errorf("%d bookmarks after clearing the search, want 2", n)
+	}
+}
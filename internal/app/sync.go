@@ -0,0 +1,257 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/goBookMarker/internal/models"
+	"github.com/goBookMarker/internal/storage"
+	cloudsync "github.com/goBookMarker/internal/sync"
+)
+
+var _ cloudsync.ChangeSource = (*AppState)(nil)
+
+// SyncSnapshot returns the library as it should be uploaded.
+func (s *AppState) SyncSnapshot() (*cloudsync.Snapshot, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	snapshot := &cloudsync.Snapshot{
+		Bookmarks: append([]models.Bookmark(nil), s.bookmarks...),
+		Tags:      append([]models.Tag(nil), s.tags...),
+		TagGroups: append([]models.TagGroup(nil), s.tagGroups...),
+	}
+	if u := s.currentUser; u != nil {
+		snapshot.Preferences = &models.UserPreferences{
+			NavPosition: u.NavPosition,
+			NavItems:    append([]string(nil), u.NavItems...),
+			Theme:       u.Theme,
+			SyncEnabled: u.SyncEnabled,
+		}
+	}
+	return snapshot, nil
+}
+
+// ApplySyncSnapshot writes a merged snapshot to the repositories. Entities
+// edited locally while the sync ran keep their newer version, and only
+// entities with a tombstone are deleted, so ones added meanwhile survive;
+// both are picked up by the next sync. A synced bookmark with the URL of a
+// different local one is merged with it.
+func (s *AppState) ApplySyncSnapshot(snapshot *cloudsync.Snapshot) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Writes made here come from the sync, not the user, so they are
+	// dropped from the change log again below. Holding s.mu keeps user
+	// edits out of that range.
+	changeLog, logged := s.bookmarkRepo.(storage.ChangeLogRepository)
+	var lastSeq int64
+	if logged {
+		var err error
+		if lastSeq, err = changeLog.LatestChangeSeq(); err != nil {
+			return err
+		}
+	}
+
+	deleted := make(map[string]map[string]bool)
+	for _, t := range snapshot.Tombstones {
+		if deleted[t.Kind] == nil {
+			deleted[t.Kind] = make(map[string]bool)
+		}
+		deleted[t.Kind][t.ID] = true
+	}
+
+	// Tags go first so bookmarks are linked to the synced tags by name.
+	// Deleting a tag unlinks it from its bookmarks, so bookmarks carrying
+	// the name of a deleted tag are saved again below to link them to the
+	// tag that replaced it.
+	relink := make(map[string]bool)
+	currentTags := make(map[string]models.Tag, len(s.tags))
+	for _, t := range s.tags {
+		currentTags[t.ID] = t
+	}
+	for id := range deleted[cloudsync.KindTag] {
+		t, ok := currentTags[id]
+		if !ok {
+			continue
+		}
+		if err := s.tagRepo.DeleteTag(id); err != nil {
+			return fmt.Errorf("failed to delete synced tag: %w", err)
+		}
+		relink[strings.ToLower(t.Name)] = true
+	}
+	for _, t := range snapshot.Tags {
+		existing, ok := currentTags[t.ID]
+		switch {
+		case !ok:
+			if err := s.tagRepo.CreateTag(t); err != nil {
+				return fmt.Errorf("failed to create synced tag: %w", err)
+			}
+		case existing.UpdatedAt.After(t.UpdatedAt) || sameTag(existing, t):
+		default:
+			if err := s.tagRepo.UpdateTag(t); err != nil {
+				return fmt.Errorf("failed to update synced tag: %w", err)
+			}
+		}
+	}
+
+	currentBookmarks := make(map[string]models.Bookmark, len(s.bookmarks))
+	for _, b := range s.bookmarks {
+		currentBookmarks[b.ID] = b
+	}
+	for _, b := range snapshot.Bookmarks {
+		if existing, ok := currentBookmarks[b.ID]; ok && !hasAnyTag(b, relink) {
+			if existing.UpdatedAt.After(b.UpdatedAt) || sameBookmark(existing, b) {
+				continue
+			}
+		}
+		err := s.bookmarkRepo.SaveBookmark(b)
+		var dup *storage.DuplicateError
+		if errors.As(err, &dup) {
+			existing, ok := currentBookmarks[dup.ExistingID]
+			if !ok {
+				return fmt.Errorf("failed to save synced bookmark: %w", err)
+			}
+			delete(currentBookmarks, existing.ID)
+			b, err = s.resolveSyncedDuplicateLocked(b, existing)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to save synced bookmark: %w", err)
+		}
+		currentBookmarks[b.ID] = b
+	}
+	for id := range deleted[cloudsync.KindBookmark] {
+		if _, ok := currentBookmarks[id]; !ok {
+			continue
+		}
+		if err := s.bookmarkRepo.DeleteBookmark(id); err != nil {
+			return fmt.Errorf("failed to delete synced bookmark: %w", err)
+		}
+	}
+
+	currentGroups := make(map[string]models.TagGroup, len(s.tagGroups))
+	for _, g := range s.tagGroups {
+		currentGroups[g.ID] = g
+	}
+	for _, g := range snapshot.TagGroups {
+		existing, ok := currentGroups[g.ID]
+		switch {
+		case !ok:
+			if err := s.tagRepo.CreateTagGroup(g); err != nil {
+				return fmt.Errorf("failed to create synced tag group: %w", err)
+			}
+		case !sameTagGroup(existing, g):
+			if err := s.tagRepo.UpdateTagGroup(g); err != nil {
+				return fmt.Errorf("failed to update synced tag group: %w", err)
+			}
+		}
+	}
+	for id := range deleted[cloudsync.KindTagGroup] {
+		if _, ok := currentGroups[id]; !ok {
+			continue
+		}
+		if err := s.tagRepo.DeleteTagGroup(id); err != nil {
+			return fmt.Errorf("failed to delete synced tag group: %w", err)
+		}
+	}
+
+	if p := snapshot.Preferences; p != nil && s.currentUser != nil {
+		u := *s.currentUser
+		if u.NavPosition != p.NavPosition || u.Theme != p.Theme || !sameStrings(u.NavItems, p.NavItems) {
+			u.NavPosition = p.NavPosition
+			u.NavItems = append([]string(nil), p.NavItems...)
+			u.Theme = p.Theme
+			if err := s.userRepo.SaveUser(&u); err != nil {
+				return fmt.Errorf("failed to save synced preferences: %w", err)
+			}
+			s.currentUser = &u
+		}
+	}
+
+	if logged {
+		if err := changeLog.DiscardChangesAfter(lastSeq); err != nil {
+			return err
+		}
+	}
+
+	if err := s.reloadLibraryLocked(); err != nil {
+		return err
+	}
+	return s.runSearchLocked()
+}
+
+// ChangesSince returns the library changes logged after seq, or
+// cloudsync.ErrNotSupported if the repository keeps no change log.
+func (s *AppState) ChangesSince(seq int64) ([]models.Change, error) {
+	changeLog, ok := s.bookmarkRepo.(storage.ChangeLogRepository)
+	if !ok {
+		return nil, cloudsync.ErrNotSupported
+	}
+	return changeLog.ChangesSince(seq)
+}
+
+// AcknowledgeChanges prunes the change log up to and including seq once
+// the sync has uploaded those changes.
+func (s *AppState) AcknowledgeChanges(seq int64) error {
+	changeLog, ok := s.bookmarkRepo.(storage.ChangeLogRepository)
+	if !ok {
+		return nil
+	}
+	return changeLog.PruneChanges(seq)
+}
+
+// sameBookmark reports whether a and b have the same content. Timestamps
+// are compared to the second since that is what the database stores.
+func sameBookmark(a, b models.Bookmark) bool {
+	return a.URL == b.URL && a.Title == b.Title && a.Description == b.Description &&
+		a.ImageURL == b.ImageURL && a.FaviconURL == b.FaviconURL && a.IsFavorite == b.IsFavorite &&
+		a.UpdatedAt.Truncate(time.Second).Equal(b.UpdatedAt.Truncate(time.Second)) &&
+		sameFold(a.Tags, b.Tags)
+}
+
+func sameTag(a, b models.Tag) bool {
+	return a.Name == b.Name && a.Color == b.Color && a.Description == b.Description &&
+		a.ParentID == b.ParentID && a.Order == b.Order
+}
+
+func sameTagGroup(a, b models.TagGroup) bool {
+	return a.Name == b.Name && a.Order == b.Order && a.Expanded == b.Expanded &&
+		sameStrings(a.TagIDs, b.TagIDs)
+}
+
+func sameStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// sameFold reports whether a and b hold the same names in any order,
+// ignoring case.
+func sameFold(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for _, name := range a {
+		if !containsFold(b, name) {
+			return false
+		}
+	}
+	return true
+}
+
+func hasAnyTag(b models.Bookmark, names map[string]bool) bool {
+	for _, tag := range b.Tags {
+		if names[strings.ToLower(tag)] {
+			return true
+		}
+	}
+	return false
+}
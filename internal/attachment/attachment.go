@@ -0,0 +1,396 @@
+// Package attachment keeps files that belong to bookmarks, such as shared
+// images, in a directory on the device. Files are named by the SHA-256 of
+// their content, so the same file is stored once, and their metadata is
+// recorded in a storage.AttachmentRepository.
+package attachment
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	gosync "sync"
+	"time"
+
+	_ "image/gif"
+	_ "image/png"
+
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+
+	"github.com/goBookMarker/internal/models"
+	"github.com/goBookMarker/internal/storage"
+)
+
+var (
+	// ErrNotFound is returned for an attachment that is not stored.
+	ErrNotFound = errors.New("attachment not found")
+
+	// ErrTooLarge is returned for files over MaxSize.
+	ErrTooLarge = errors.New("attachment is too large")
+)
+
+const (
+	// MaxSize is the largest file the store accepts.
+	MaxSize = 20 << 20
+
+	// ThumbnailSize is the longest side of a thumbnail, in pixels.
+	ThumbnailSize = 256
+
+	// URLScheme is the scheme of attachment URLs, which bookmarks made from
+	// an attachment use as their URL.
+	URLScheme = "attachment"
+
+	// maxThumbnailPixels bounds the images decoded for thumbnails.
+	maxThumbnailPixels = 50_000_000
+
+	// orphanGrace is how long an unlinked attachment is kept, so that one
+	// stored for a bookmark that is being saved is not collected first.
+	orphanGrace = time.Hour
+)
+
+// Store keeps attachment files under a directory: the files in objects/
+// and JPEG thumbnails of images in thumbnails/, both sharded by the first
+// two characters of the ID.
+type Store struct {
+	dir  string
+	repo storage.AttachmentRepository
+
+	// mu keeps garbage collection from removing a file that Put is
+	// storing again.
+	mu gosync.Mutex
+}
+
+// NewStore creates a store that keeps files under dir, created on the first
+// Put, and records them in repo.
+func NewStore(dir string, repo storage.AttachmentRepository) *Store {
+	return &Store{dir: dir, repo: repo}
+}
+
+// URL returns the attachment URL for id.
+func URL(id string) string {
+	return URLScheme + ":" + id
+}
+
+// ParseURL returns the attachment ID in an attachment URL.
+func ParseURL(rawURL string) (string, bool) {
+	id, ok := strings.CutPrefix(rawURL, URLScheme+":")
+	if !ok || !validID(id) {
+		return "", false
+	}
+	return id, true
+}
+
+// DecodeDataURI returns the media type and content of a data: URI, which
+// may be base64 or percent-encoded.
+func DecodeDataURI(uri string) (string, []byte, error) {
+	rest, ok := strings.CutPrefix(uri, "data:")
+	if !ok {
+		return "", nil, fmt.Errorf("not a data URI")
+	}
+	header, payload, ok := strings.Cut(rest, ",")
+	if !ok {
+		return "", nil, fmt.Errorf("invalid data URI: no data")
+	}
+
+	isBase64 := false
+	if h, found := strings.CutSuffix(header, ";base64"); found {
+		header, isBase64 = h, true
+	}
+	mediaType := strings.TrimSpace(header)
+	if mediaType == "" || strings.HasPrefix(mediaType, ";") {
+		mediaType = "text/plain" + mediaType
+	}
+
+	if !isBase64 {
+		data, err := url.PathUnescape(payload)
+		if err != nil {
+			return "", nil, fmt.Errorf("invalid data URI: %w", err)
+		}
+		return mediaType, []byte(data), nil
+	}
+
+	// Shared data is often wrapped or URL-encoded on the way.
+	if unescaped, err := url.PathUnescape(payload); err == nil {
+		payload = unescaped
+	}
+	payload = strings.Map(func(r rune) rune {
+		if r == ' ' || r == '\n' || r == '\r' || r == '\t' {
+			return -1
+		}
+		return r
+	}, payload)
+	if base64.StdEncoding.DecodedLen(len(payload)) > MaxSize {
+		return "", nil, ErrTooLarge
+	}
+	data, err := base64.StdEncoding.DecodeString(payload)
+	if err != nil {
+		data, err = base64.RawStdEncoding.DecodeString(strings.TrimRight(payload, "="))
+	}
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid data URI: %w", err)
+	}
+	return mediaType, data, nil
+}
+
+// PutDataURI stores the content of a data: URI.
+func (s *Store) PutDataURI(uri string) (*models.Attachment, error) {
+	mediaType, data, err := DecodeDataURI(uri)
+	if err != nil {
+		return nil, err
+	}
+	return s.Put(data, mediaType)
+}
+
+// Put stores data and records it, returning the existing record if the
+// same content is already stored. For images the recorded MIME type and
+// dimensions come from the content, and a thumbnail is generated; other
+// files are recorded with mimeType, or a sniffed type if it is empty.
+func (s *Store) Put(data []byte, mimeType string) (*models.Attachment, error) {
+	if len(data) > MaxSize {
+		return nil, ErrTooLarge
+	}
+	sum := sha256.Sum256(data)
+	id := hex.EncodeToString(sum[:])
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	existing, err := s.repo.GetAttachment(id)
+	if err != nil {
+		return nil, err
+	}
+	path := s.objectPath(id)
+	if _, err := os.Stat(path); errors.Is(err, fs.ErrNotExist) {
+		if err := writeFile(path, data); err != nil {
+			return nil, fmt.Errorf("failed to store attachment: %w", err)
+		}
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to store attachment: %w", err)
+	}
+	if existing != nil {
+		return existing, nil
+	}
+
+	a := models.Attachment{
+		ID:        id,
+		MimeType:  mimeType,
+		Size:      int64(len(data)),
+		CreatedAt: time.Now(),
+	}
+	if config, format, err := image.DecodeConfig(bytes.NewReader(data)); err == nil {
+		a.MimeType = "image/" + format
+		a.Width, a.Height = config.Width, config.Height
+		// An attachment without a thumbnail is still usable.
+		s.writeThumbnail(a, data)
+	} else if a.MimeType == "" {
+		a.MimeType = http.DetectContentType(data)
+	}
+	if err := s.repo.SaveAttachment(a); err != nil {
+		return nil, err
+	}
+	return &a, nil
+}
+
+// Path returns the file holding the attachment's content.
+func (s *Store) Path(id string) (string, error) {
+	if !validID(id) {
+		return "", ErrNotFound
+	}
+	path := s.objectPath(id)
+	if _, err := os.Stat(path); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return "", ErrNotFound
+		}
+		return "", err
+	}
+	return path, nil
+}
+
+// ThumbnailPath returns the JPEG thumbnail of an image attachment,
+// generating it again if it was removed. It returns ErrNotFound for
+// attachments that are not images.
+func (s *Store) ThumbnailPath(id string) (string, error) {
+	if !validID(id) {
+		return "", ErrNotFound
+	}
+	path := s.thumbnailPath(id)
+	if _, err := os.Stat(path); err == nil {
+		return path, nil
+	}
+
+	a, err := s.repo.GetAttachment(id)
+	if err != nil {
+		return "", err
+	}
+	if a == nil || a.Width == 0 {
+		return "", ErrNotFound
+	}
+	data, err := os.ReadFile(s.objectPath(id))
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", ErrNotFound
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to read attachment: %w", err)
+	}
+	if err := s.writeThumbnail(*a, data); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// CollectGarbage deletes attachments that no bookmark links to, with their
+// files, and files left without a record, for example by a crash. Recent
+// attachments are kept so that one being attached is not lost. It returns
+// the number of files removed.
+func (s *Store) CollectGarbage() (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cutoff := time.Now().Add(-orphanGrace)
+	orphans, err := s.repo.OrphanedAttachments(cutoff)
+	if err != nil {
+		return 0, err
+	}
+	removed := 0
+	for _, a := range orphans {
+		deleted, err := s.repo.DeleteOrphanedAttachment(a.ID)
+		if err != nil {
+			return removed, err
+		}
+		if !deleted {
+			continue
+		}
+		if err := removeFile(s.objectPath(a.ID)); err != nil {
+			return removed, err
+		}
+		if err := removeFile(s.thumbnailPath(a.ID)); err != nil {
+			return removed, err
+		}
+		removed++
+	}
+
+	for _, sub := range []string{"objects", "thumbnails"} {
+		err := filepath.WalkDir(filepath.Join(s.dir, sub), func(path string, d fs.DirEntry, err error) error {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			if err != nil || d.IsDir() {
+				return err
+			}
+			info, err := d.Info()
+			if err != nil || info.ModTime().After(cutoff) {
+				return err
+			}
+			id := strings.TrimSuffix(d.Name(), ".jpg")
+			if validID(id) {
+				a, err := s.repo.GetAttachment(id)
+				if err != nil || a != nil {
+					return err
+				}
+			}
+			if err := removeFile(path); err != nil {
+				return err
+			}
+			if sub == "objects" {
+				removed++
+			}
+			return nil
+		})
+		if err != nil {
+			return removed, fmt.Errorf("failed to collect attachments: %w", err)
+		}
+	}
+	return removed, nil
+}
+
+func (s *Store) objectPath(id string) string {
+	return filepath.Join(s.dir, "objects", id[:2], id)
+}
+
+func (s *Store) thumbnailPath(id string) string {
+	return filepath.Join(s.dir, "thumbnails", id[:2], id+".jpg")
+}
+
+// writeThumbnail scales the image in data to fit ThumbnailSize, on white
+// for images with transparency, and saves it as a JPEG.
+func (s *Store) writeThumbnail(a models.Attachment, data []byte) error {
+	if a.Width*a.Height > maxThumbnailPixels {
+		return fmt.Errorf("image is too large for a thumbnail")
+	}
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("failed to decode image: %w", err)
+	}
+
+	bounds := src.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	if w > ThumbnailSize || h > ThumbnailSize {
+		if w >= h {
+			w, h = ThumbnailSize, max(1, h*ThumbnailSize/w)
+		} else {
+			w, h = max(1, w*ThumbnailSize/h), ThumbnailSize
+		}
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(dst, dst.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.CatmullRom.Scale(dst, dst.Bounds(), src, bounds, draw.Over, nil)
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, dst, &jpeg.Options{Quality: 80}); err != nil {
+		return fmt.Errorf("failed to encode thumbnail: %w", err)
+	}
+	if err := writeFile(s.thumbnailPath(a.ID), buf.Bytes()); err != nil {
+		return fmt.Errorf("failed to save thumbnail: %w", err)
+	}
+	return nil
+}
+
+// writeFile writes data to path through a temporary file, so a file under
+// its final name is always complete.
+func writeFile(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func removeFile(path string) error {
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to remove attachment file: %w", err)
+	}
+	return nil
+}
+
+// validID reports whether id is a hex SHA-256, which also keeps it from
+// naming a path outside the store.
+func validID(id string) bool {
+	if len(id) != sha256.Size*2 {
+		return false
+	}
+	_, err := hex.DecodeString(id)
+	return err == nil && strings.ToLower(id) == id
+}
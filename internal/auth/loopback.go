@@ -0,0 +1,139 @@
+//go:build !android && !ios
+// +build !android,!ios
+
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"net"
+	"net/http"
+	"os/exec"
+	"runtime"
+	"time"
+)
+
+// LoopbackTimeout is how long SignInLoopback waits for the browser to
+// redirect back when the context has no earlier deadline.
+const LoopbackTimeout = 5 * time.Minute
+
+// loopbackPath is the path of the redirect URL on the loopback listener.
+const loopbackPath = "/oauth2callback"
+
+// SignInLoopback signs in on desktop platforms, where the app cannot
+// register a custom URL scheme. It listens on a random port on 127.0.0.1,
+// opens the authorization URL with openBrowser (OpenBrowser if nil) using
+// the listener as the redirect URL, and completes the flow with the code
+// the browser brings back. It gives up when ctx is cancelled or after
+// LoopbackTimeout.
+//
+// The provider's client must allow loopback redirects with any port, as
+// desktop clients of Google and Microsoft do.
+func (p *OAuthProvider) SignInLoopback(ctx context.Context, openBrowser func(authURL string) error) error {
+	if openBrowser == nil {
+		openBrowser = OpenBrowser
+	}
+	ctx, cancel := context.WithTimeout(ctx, LoopbackTimeout)
+	defer cancel()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return fmt.Errorf("failed to start loopback listener: %w", err)
+	}
+	redirectURL := fmt.Sprintf("http://%s%s", ln.Addr().String(), loopbackPath)
+	authURL, state, err := p.startFlow(redirectURL)
+	if err != nil {
+		ln.Close()
+		return err
+	}
+	defer p.cancelFlow(state)
+
+	type callback struct {
+		code string
+		err  error
+		// done receives the outcome of the sign-in for the page shown
+		// in the browser.
+		done chan error
+	}
+	// Only the first callback with the right state counts; the listener
+	// ignores stray requests such as favicon fetches.
+	results := make(chan callback, 1)
+	mux := http.NewServeMux()
+	mux.HandleFunc(loopbackPath, func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		if query.Get("state") != state {
+			http.Error(w, "Unknown sign-in request.", http.StatusBadRequest)
+			return
+		}
+		result := callback{code: query.Get("code"), err: callbackError(query), done: make(chan error, 1)}
+		if result.err == nil && result.code == "" {
+			result.err = errors.New("authorization failed: no code in callback")
+		}
+		select {
+		case results <- result:
+		default:
+			http.Error(w, "Sign-in already handled.", http.StatusBadRequest)
+			return
+		}
+		select {
+		case err := <-result.done:
+			writeLoopbackPage(w, err)
+		case <-r.Context().Done():
+		}
+	})
+	srv := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go srv.Serve(ln)
+	defer func() {
+		// Let the response page reach the browser before closing.
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		srv.Shutdown(shutdownCtx)
+	}()
+
+	if err := openBrowser(authURL); err != nil {
+		return fmt.Errorf("failed to open browser: %w", err)
+	}
+
+	select {
+	case <-ctx.Done():
+		return fmt.Errorf("sign-in not completed: %w", ctx.Err())
+	case result := <-results:
+		err := result.err
+		if err == nil {
+			err = p.handleCallback(ctx, state, result.code)
+		}
+		result.done <- err
+		return err
+	}
+}
+
+// writeLoopbackPage tells the user in the browser how sign-in ended.
+func writeLoopbackPage(w http.ResponseWriter, err error) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	message := "Signed in to goBookMarker. You can close this window."
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		message = "Sign-in failed: " + err.Error()
+	}
+	fmt.Fprintf(w, "<!DOCTYPE html><html><head><title>goBookMarker</title></head><body><p>%s</p></body></html>", html.EscapeString(message))
+}
+
+// OpenBrowser opens url in the user's default browser.
+func OpenBrowser(url string) error {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "windows":
+		cmd = exec.Command("rundll32", "url.dll,FileProtocolHandler", url)
+	case "darwin":
+		cmd = exec.Command("open", url)
+	default:
+		cmd = exec.Command("xdg-open", url)
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	go cmd.Wait()
+	return nil
+}
@@ -2,116 +2,341 @@ package auth
 
 import (
 	"context"
-	"encoding/json"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
 	"fmt"
-	"os"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
 
-	"github.com/goBookMarker/internal/models"
 	"golang.org/x/oauth2"
-	"golang.org/x/oauth2/google"
-	"golang.org/x/oauth2/microsoft"
 )
 
+// ErrInvalidState is returned by HandleCallback when the state does not
+// belong to an authorization flow started by GetAuthURL, or the flow has
+// expired or was already completed.
+var ErrInvalidState = errors.New("invalid OAuth state")
+
+// ErrSignInRequired is returned by the provider's token source when there
+// is no token or it can no longer be refreshed, for example because the
+// user revoked access. The user has to go through GetAuthURL again.
+var ErrSignInRequired = errors.New("sign-in required")
+
+// flowTimeout is how long an authorization URL stays valid.
+const flowTimeout = 10 * time.Minute
+
+// OAuthProvider runs the authorization code flow with PKCE. Each call to
+// GetAuthURL starts a flow with its own random state and code verifier,
+// which HandleCallback checks and uses once. Without a client secret the
+// provider acts as a public client, as a mobile or desktop app must.
+//
+// With a TokenStore, the token is saved under the provider's name whenever
+// it is obtained or refreshed, and loaded again on the next launch.
 type OAuthProvider struct {
+	name   string
 	config *oauth2.Config
 	token  *oauth2.Token
+	store  TokenStore
+
+	mu    sync.Mutex
+	flows map[string]pendingFlow
+
+	// refreshMu serializes refreshes so concurrent requests do not spend
+	// the same refresh token twice.
+	refreshMu sync.Mutex
 }
 
+// pendingFlow is an authorization flow waiting for its callback.
+type pendingFlow struct {
+	verifier    string
+	redirectURL string
+	started     time.Time
+}
+
+// UserInfo is the signed-in user's profile, normalized across providers.
+// Subject is the provider's stable ID for the user.
 type UserInfo struct {
+	Subject  string `json:"subject"`
 	Email    string `json:"email"`
 	Name     string `json:"name"`
 	Picture  string `json:"picture"`
 	Provider string `json:"provider"`
 }
 
-func NewGoogleAuth() *OAuthProvider {
+// NewOAuthProvider creates a provider for config. name identifies the
+// provider, for example in a TokenStore. If config has no client secret,
+// the client ID is sent in the token request body instead of with basic
+// auth.
+func NewOAuthProvider(name string, config *oauth2.Config) *OAuthProvider {
+	if config.ClientSecret == "" && config.Endpoint.AuthStyle == oauth2.AuthStyleAutoDetect {
+		config.Endpoint.AuthStyle = oauth2.AuthStyleInParams
+	}
 	return &OAuthProvider{
-		config: &oauth2.Config{
-			ClientID:     os.Getenv("GOOGLE_CLIENT_ID"),
-			ClientSecret: os.Getenv("GOOGLE_CLIENT_SECRET"),
-			RedirectURL:  "com.gobookmarker:/oauth2callback",
-			Scopes: []string{
-				"https://www.googleapis.com/auth/userinfo.email",
-				"https://www.googleapis.com/auth/drive.appdata",
-			},
-			Endpoint: google.Endpoint,
-		},
+		name:   name,
+		config: config,
+		flows:  make(map[string]pendingFlow),
 	}
 }
 
-func NewMicrosoftAuth() *OAuthProvider {
-	return &OAuthProvider{
-		config: &oauth2.Config{
-			ClientID:     os.Getenv("MS_CLIENT_ID"),
-			ClientSecret: os.Getenv("MS_CLIENT_SECRET"),
-			RedirectURL:  "com.gobookmarker:/oauth2callback",
-			Scopes: []string{
-				"offline_access",
-				"User.Read",
-				"Files.ReadWrite.AppFolder",
-			},
-			Endpoint: microsoft.AzureADEndpoint("common"),
-		},
-	}
+// NewGoogleAuth creates an OAuthProvider for the registered "google"
+// provider.
+func NewGoogleAuth() (*OAuthProvider, error) {
+	return NewProviderAuth("google")
 }
 
-func (p *OAuthProvider) GetAuthURL() string {
-	return p.config.AuthCodeURL("state")
+// NewMicrosoftAuth creates an OAuthProvider for the registered "microsoft"
+// provider.
+func NewMicrosoftAuth() (*OAuthProvider, error) {
+	return NewProviderAuth("microsoft")
 }
 
-func (p *OAuthProvider) HandleCallback(code string) error {
-	ctx := context.Background()
-	token, err := p.config.Exchange(ctx, code)
+// GetAuthURL starts an authorization flow and returns the URL to open in
+// the browser. The URL carries a new random state and the S256 challenge
+// of a new code verifier.
+func (p *OAuthProvider) GetAuthURL() (string, error) {
+	authURL, _, err := p.startFlow(p.config.RedirectURL)
+	return authURL, err
+}
+
+// startFlow starts an authorization flow that redirects to redirectURL and
+// returns its URL and state.
+func (p *OAuthProvider) startFlow(redirectURL string) (authURL, state string, err error) {
+	state, err = randomState()
 	if err != nil {
-		return fmt.Errorf("token exchange error: %v", err)
+		return "", "", err
 	}
-	p.token = token
-	return nil
+	verifier := oauth2.GenerateVerifier()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := time.Now()
+	for s, flow := range p.flows {
+		if now.Sub(flow.started) > flowTimeout {
+			delete(p.flows, s)
+		}
+	}
+	p.flows[state] = pendingFlow{verifier: verifier, redirectURL: redirectURL, started: now}
+	opts := []oauth2.AuthCodeOption{oauth2.S256ChallengeOption(verifier)}
+	if redirectURL != "" {
+		opts = append(opts, oauth2.SetAuthURLParam("redirect_uri", redirectURL))
+	}
+	return p.config.AuthCodeURL(state, opts...), state, nil
 }
 
-func (p *OAuthProvider) GetUserInfo() (*models.User, error) {
-	if p.token == nil {
-		return nil, fmt.Errorf("no token available")
+// cancelFlow forgets the flow identified by state.
+func (p *OAuthProvider) cancelFlow(state string) {
+	p.mu.Lock()
+	delete(p.flows, state)
+	p.mu.Unlock()
+}
+
+// callbackError returns the error reported in the query of a callback URL,
+// or nil if there is none.
+func callbackError(query url.Values) error {
+	e := query.Get("error")
+	if e == "" {
+		return nil
+	}
+	if desc := query.Get("error_description"); desc != "" {
+		return fmt.Errorf("authorization failed: %s: %s", e, desc)
+	}
+	return fmt.Errorf("authorization failed: %s", e)
+}
+
+// randomState returns an unguessable OAuth state value.
+func randomState() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate OAuth state: %w", err)
 	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
 
-	client := p.config.Client(context.Background(), p.token)
+// HandleCallback completes the flow identified by state, exchanging code
+// and the flow's code verifier for a token. It returns ErrInvalidState for
+// an unknown, expired or reused state.
+func (p *OAuthProvider) HandleCallback(state, code string) error {
+	return p.handleCallback(context.Background(), state, code)
+}
 
-	// Different endpoints for different providers
-	var userInfoURL string
-	switch p.config.Endpoint {
-	case google.Endpoint:
-		userInfoURL = "https://www.googleapis.com/oauth2/v2/userinfo"
-	case microsoft.AzureADEndpoint("common"):
-		userInfoURL = "https://graph.microsoft.com/v1.0/me"
-	default:
-		return nil, fmt.Errorf("unsupported OAuth provider")
+func (p *OAuthProvider) handleCallback(ctx context.Context, state, code string) error {
+	p.mu.Lock()
+	flow, ok := p.flows[state]
+	delete(p.flows, state)
+	p.mu.Unlock()
+	if !ok || time.Since(flow.started) > flowTimeout {
+		return ErrInvalidState
 	}
 
-	resp, err := client.Get(userInfoURL)
+	opts := []oauth2.AuthCodeOption{oauth2.VerifierOption(flow.verifier)}
+	if flow.redirectURL != "" {
+		opts = append(opts, oauth2.SetAuthURLParam("redirect_uri", flow.redirectURL))
+	}
+	token, err := p.config.Exchange(ctx, code, opts...)
+	if err != nil {
+		return fmt.Errorf("token exchange error: %w", err)
+	}
+	return p.saveToken(token)
+}
+
+// HandleCallbackURL completes a flow from the URL the authorization server
+// redirected to, reporting an error it returned instead of a code.
+func (p *OAuthProvider) HandleCallbackURL(callbackURL string) error {
+	u, err := url.Parse(callbackURL)
+	if err != nil {
+		return fmt.Errorf("invalid callback URL: %w", err)
+	}
+	query := u.Query()
+	if err := callbackError(query); err != nil {
+		p.cancelFlow(query.Get("state"))
+		return err
+	}
+	return p.HandleCallback(query.Get("state"), query.Get("code"))
+}
+
+// GetUserInfo fetches the signed-in user's profile from the provider's
+// userinfo endpoint, as described by its registry entry.
+func (p *OAuthProvider) GetUserInfo() (*UserInfo, error) {
+	if p.GetToken() == nil {
+		return nil, fmt.Errorf("no token available")
+	}
+	spec, ok := LookupProvider(p.name)
+	if !ok || spec.UserInfoURL == "" || spec.DecodeUserInfo == nil {
+		return nil, fmt.Errorf("unsupported OAuth provider %q", p.name)
+	}
+
+	ctx := context.Background()
+	client := oauth2.NewClient(ctx, p.TokenSource(ctx))
+	resp, err := client.Get(spec.UserInfoURL)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get user info: %w", err)
 	}
 	defer resp.Body.Close()
-
-	var userInfo struct {
-		Email string `json:"email"`
-		Name  string `json:"name"`
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to get user info: unexpected status %s", resp.Status)
 	}
 
-	if err := json.NewDecoder(resp.Body).Decode(&userInfo); err != nil {
+	info, err := spec.DecodeUserInfo(resp.Body)
+	if err != nil {
 		return nil, fmt.Errorf("failed to decode user info: %w", err)
 	}
+	if info.Subject == "" {
+		return nil, fmt.Errorf("user info has no user ID")
+	}
+	info.Provider = p.name
+	return info, nil
+}
+
+// Name returns the name the provider was created with.
+func (p *OAuthProvider) Name() string {
+	return p.name
+}
 
-	return &models.User{
-		Email: userInfo.Email,
-		Name:  userInfo.Name,
-	}, nil
+// SetTokenStore makes the provider save its token to store and loads the
+// token saved by an earlier launch, if there is one.
+func (p *OAuthProvider) SetTokenStore(store TokenStore) error {
+	token, err := store.LoadToken(p.name)
+	if err != nil {
+		return err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.store = store
+	if token != nil {
+		p.token = token
+	}
+	return nil
+}
+
+// saveToken makes token the current one and saves it to the token store.
+func (p *OAuthProvider) saveToken(token *oauth2.Token) error {
+	p.mu.Lock()
+	p.token = token
+	store := p.store
+	p.mu.Unlock()
+	if store == nil {
+		return nil
+	}
+	if err := store.SaveToken(p.name, token); err != nil {
+		return fmt.Errorf("failed to save OAuth token: %w", err)
+	}
+	return nil
+}
+
+// SignOut forgets the token, removing it from the token store.
+func (p *OAuthProvider) SignOut() error {
+	p.mu.Lock()
+	p.token = nil
+	store := p.store
+	p.mu.Unlock()
+	if store == nil {
+		return nil
+	}
+	return store.DeleteToken(p.name)
+}
+
+// TokenSource returns a token source for API clients such as the sync
+// providers. It refreshes the token when it expires and saves the new one
+// to the token store, and picks up a token from a later sign-in. ctx is
+// used for refresh requests.
+func (p *OAuthProvider) TokenSource(ctx context.Context) oauth2.TokenSource {
+	return &persistingTokenSource{ctx: ctx, provider: p}
+}
+
+// persistingTokenSource is the token source returned by TokenSource.
+type persistingTokenSource struct {
+	ctx      context.Context
+	provider *OAuthProvider
+}
+
+func (s *persistingTokenSource) Token() (*oauth2.Token, error) {
+	p := s.provider
+	if token := p.GetToken(); token.Valid() {
+		return token, nil
+	}
+
+	p.refreshMu.Lock()
+	defer p.refreshMu.Unlock()
+	// Another caller may have refreshed it while this one waited.
+	token := p.GetToken()
+	if token.Valid() {
+		return token, nil
+	}
+	if token == nil || token.RefreshToken == "" {
+		return nil, ErrSignInRequired
+	}
+
+	refreshed, err := p.config.TokenSource(s.ctx, token).Token()
+	if err != nil {
+		var retrieveErr *oauth2.RetrieveError
+		if errors.As(err, &retrieveErr) && retrieveErr.ErrorCode == "invalid_grant" {
+			return nil, fmt.Errorf("%w: %v", ErrSignInRequired, err)
+		}
+		return nil, fmt.Errorf("failed to refresh OAuth token: %w", err)
+	}
+	p.mu.Lock()
+	current := p.token
+	p.mu.Unlock()
+	if current != token {
+		// Signed out or in again during the refresh.
+		return refreshed, nil
+	}
+	// A failed save only costs a refresh on the next launch.
+	p.saveToken(refreshed)
+	return refreshed, nil
 }
 
 func (p *OAuthProvider) GetToken() *oauth2.Token {
+	p.mu.Lock()
+	defer p.mu.Unlock()
 	return p.token
 }
 
+// SetToken replaces the token in memory without saving it.
 func (p *OAuthProvider) SetToken(token *oauth2.Token) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
 	p.token = token
 }
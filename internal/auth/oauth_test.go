@@ -0,0 +1,388 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+const testRedirectURL = "http://127.0.0.1/callback"
+
+// fakeAuthServer is an authorization server for the authorization code
+// flow with PKCE. Approving an authorization URL issues a code bound to its
+// client, redirect URI and code challenge; the token endpoint checks them
+// and accepts each code once. Refresh tokens are rotated: each is accepted
+// once and replaced by a new one.
+type fakeAuthServer struct {
+	t   *testing.T
+	srv *httptest.Server
+
+	mu            sync.Mutex
+	codes         map[string]authGrant
+	nextCode      int
+	refreshTokens map[string]bool
+	nextRefresh   int
+	// tokenRequests are the token requests received, with the client
+	// credentials sent by basic auth, if any.
+	tokenRequests []tokenRequest
+}
+
+type authGrant struct {
+	clientID    string
+	redirectURI string
+	challenge   string
+}
+
+type tokenRequest struct {
+	form      url.Values
+	basicUser string
+	basicPass string
+	basicAuth bool
+}
+
+func newFakeAuthServer(t *testing.T) *fakeAuthServer {
+	a := &fakeAuthServer{t: t, codes: make(map[string]authGrant), refreshTokens: make(map[string]bool)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/authorize", a.authorize)
+	mux.HandleFunc("/token", a.token)
+	a.srv = httptest.NewServer(mux)
+	t.Cleanup(a.srv.Close)
+	return a
+}
+
+func (a *fakeAuthServer) config(clientSecret string) *oauth2.Config {
+	return &oauth2.Config{
+		ClientID:     "client-id",
+		ClientSecret: clientSecret,
+		RedirectURL:  testRedirectURL,
+		Endpoint: oauth2.Endpoint{
+			AuthURL:  a.srv.URL + "/authorize",
+			TokenURL: a.srv.URL + "/token",
+		},
+	}
+}
+
+func (a *fakeAuthServer) authorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if q.Get("response_type") != "code" || q.Get("code_challenge_method") != "S256" || q.Get("code_challenge") == "" {
+		http.Error(w, "PKCE with S256 is required", http.StatusBadRequest)
+		return
+	}
+	a.mu.Lock()
+	a.nextCode++
+	code := fmt.Sprintf("code-%d", a.nextCode)
+	a.codes[code] = authGrant{
+		clientID:    q.Get("client_id"),
+		redirectURI: q.Get("redirect_uri"),
+		challenge:   q.Get("code_challenge"),
+	}
+	a.mu.Unlock()
+
+	redirect, _ := url.Parse(q.Get("redirect_uri"))
+	rq := redirect.Query()
+	rq.Set("code", code)
+	rq.Set("state", q.Get("state"))
+	redirect.RawQuery = rq.Encode()
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+func (a *fakeAuthServer) token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	req := tokenRequest{form: r.PostForm}
+	req.basicUser, req.basicPass, req.basicAuth = r.BasicAuth()
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.tokenRequests = append(a.tokenRequests, req)
+
+	if r.PostForm.Get("grant_type") == "refresh_token" {
+		refresh := r.PostForm.Get("refresh_token")
+		if !a.refreshTokens[refresh] {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		delete(a.refreshTokens, refresh)
+		a.nextRefresh++
+		a.issueLocked(w, fmt.Sprintf("refreshed-%d", a.nextRefresh))
+		return
+	}
+
+	clientID := r.PostForm.Get("client_id")
+	if req.basicAuth {
+		clientID = req.basicUser
+	}
+	code := r.PostForm.Get("code")
+	grant, ok := a.codes[code]
+	delete(a.codes, code)
+	sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	switch {
+	case r.PostForm.Get("grant_type") != "authorization_code", !ok,
+		grant.clientID != clientID,
+		grant.redirectURI != r.PostForm.Get("redirect_uri"),
+		grant.challenge != base64.RawURLEncoding.EncodeToString(sum[:]):
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+		return
+	}
+	a.issueLocked(w, code)
+}
+
+// issueLocked responds with a new access token and refresh token named
+// after grant. The caller must hold a.mu.
+func (a *fakeAuthServer) issueLocked(w http.ResponseWriter, grant string) {
+	a.refreshTokens["refresh-"+grant] = true
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"access_token":  "access-" + grant,
+		"refresh_token": "refresh-" + grant,
+		"token_type":    "Bearer",
+		"expires_in":    3600,
+	})
+}
+
+// signIn completes a sign-in with p and returns its token.
+func (a *fakeAuthServer) signIn(p *OAuthProvider) *oauth2.Token {
+	a.t.Helper()
+	authURL, err := p.GetAuthURL()
+	if err != nil {
+		a.t.Fatal(err)
+	}
+	if err := p.HandleCallbackURL(a.approve(authURL)); err != nil {
+		a.t.Fatal(err)
+	}
+	return p.GetToken()
+}
+
+// approve opens authURL as the user's browser would and returns the URL
+// the server redirected back to.
+func (a *fakeAuthServer) approve(authURL string) string {
+	a.t.Helper()
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	resp, err := client.Get(authURL)
+	if err != nil {
+		a.t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusFound {
+		a.t.Fatalf("authorization request: %s", resp.Status)
+	}
+	return resp.Header.Get("Location")
+}
+
+func (a *fakeAuthServer) lastTokenRequest() tokenRequest {
+	a.t.Helper()
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if len(a.tokenRequests) == 0 {
+		a.t.Fatal("no token request was made")
+	}
+	return a.tokenRequests[len(a.tokenRequests)-1]
+}
+
+func (a *fakeAuthServer) tokenRequestCount() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return len(a.tokenRequests)
+}
+
+func callbackParams(t *testing.T, callbackURL string) (state, code string) {
+	t.Helper()
+	u, err := url.Parse(callbackURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(callbackURL, testRedirectURL+"?") {
+		t.Fatalf("redirected to %s, want %s", callbackURL, testRedirectURL)
+	}
+	return u.Query().Get("state"), u.Query().Get("code")
+}
+
+func TestHandleCallbackSendsCodeVerifier(t *testing.T) {
+	a := newFakeAuthServer(t)
+	p := NewOAuthProvider("test", a.config("secret"))
+	authURL, err := p.GetAuthURL()
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, _ := url.Parse(authURL)
+	challenge := u.Query().Get("code_challenge")
+
+	if err := p.HandleCallbackURL(a.approve(authURL)); err != nil {
+		t.Fatal(err)
+	}
+	if token := p.GetToken(); token == nil || token.AccessToken != "access-code-1" {
+		t.Fatalf("token = %+v, want the exchanged token", token)
+	}
+
+	req := a.lastTokenRequest()
+	verifier := req.form.Get("code_verifier")
+	sum := sha256.Sum256([]byte(verifier))
+	if verifier == "" || base64.RawURLEncoding.EncodeToString(sum[:]) != challenge {
+		t.Errorf("code_verifier %q does not match the challenge %q", verifier, challenge)
+	}
+	if req.form.Get("redirect_uri") != testRedirectURL {
+		t.Errorf("redirect_uri = %q, want %q", req.form.Get("redirect_uri"), testRedirectURL)
+	}
+	if !req.basicAuth || req.basicUser != "client-id" || req.basicPass != "secret" {
+		t.Errorf("confidential client sent basic auth %v %q:%q", req.basicAuth, req.basicUser, req.basicPass)
+	}
+}
+
+func TestHandleCallbackUsesEachFlowsVerifier(t *testing.T) {
+	a := newFakeAuthServer(t)
+	p := NewOAuthProvider("test", a.config("secret"))
+	first, _ := p.GetAuthURL()
+	second, _ := p.GetAuthURL()
+	firstCallback, secondCallback := a.approve(first), a.approve(second)
+
+	// Completed in the other order, each flow still sends its own verifier.
+	if err := p.HandleCallbackURL(secondCallback); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.HandleCallbackURL(firstCallback); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHandleCallbackPublicClient(t *testing.T) {
+	a := newFakeAuthServer(t)
+	p := NewOAuthProvider("test", a.config(""))
+	authURL, _ := p.GetAuthURL()
+	if err := p.HandleCallbackURL(a.approve(authURL)); err != nil {
+		t.Fatal(err)
+	}
+
+	req := a.lastTokenRequest()
+	if req.basicAuth {
+		t.Errorf("public client sent basic auth %q:%q", req.basicUser, req.basicPass)
+	}
+	if req.form.Get("client_id") != "client-id" {
+		t.Errorf("client_id in body = %q, want client-id", req.form.Get("client_id"))
+	}
+	if _, ok := req.form["client_secret"]; ok {
+		t.Errorf("public client sent client_secret %q", req.form.Get("client_secret"))
+	}
+}
+
+func TestHandleCallbackStateMismatch(t *testing.T) {
+	a := newFakeAuthServer(t)
+	p := NewOAuthProvider("test", a.config("secret"))
+	authURL, _ := p.GetAuthURL()
+	_, code := callbackParams(t, a.approve(authURL))
+
+	for _, state := range []string{"", "forged-state"} {
+		if err := p.HandleCallback(state, code); !errors.Is(err, ErrInvalidState) {
+			t.Errorf("HandleCallback with state %q: %v, want ErrInvalidState", state, err)
+		}
+	}
+	if n := a.tokenRequestCount(); n != 0 {
+		t.Fatalf("%d token requests for an unknown state, want none", n)
+	}
+	if p.GetToken() != nil {
+		t.Fatal("signed in with an unknown state")
+	}
+}
+
+func TestHandleCallbackReusedState(t *testing.T) {
+	a := newFakeAuthServer(t)
+	p := NewOAuthProvider("test", a.config("secret"))
+	authURL, _ := p.GetAuthURL()
+	callback := a.approve(authURL)
+	if err := p.HandleCallbackURL(callback); err != nil {
+		t.Fatal(err)
+	}
+
+	// A replayed callback, even with a fresh code for the same state, is
+	// refused without contacting the server.
+	state, _ := callbackParams(t, callback)
+	_, code := callbackParams(t, a.approve(authURL))
+	if err := p.HandleCallback(state, code); !errors.Is(err, ErrInvalidState) {
+		t.Fatalf("reused state: %v, want ErrInvalidState", err)
+	}
+	if n := a.tokenRequestCount(); n != 1 {
+		t.Fatalf("%d token requests, want 1", n)
+	}
+
+	// A failed exchange also ends the flow.
+	authURL, _ = p.GetAuthURL()
+	state, _ = callbackParams(t, a.approve(authURL))
+	if err := p.HandleCallback(state, "bad-code"); err == nil || errors.Is(err, ErrInvalidState) {
+		t.Fatalf("exchange of a bad code: %v, want a token exchange error", err)
+	}
+	if err := p.HandleCallback(state, "bad-code"); !errors.Is(err, ErrInvalidState) {
+		t.Fatalf("state reused after a failed exchange: %v, want ErrInvalidState", err)
+	}
+}
+
+func TestHandleCallbackExpiredFlow(t *testing.T) {
+	a := newFakeAuthServer(t)
+	p := NewOAuthProvider("test", a.config("secret"))
+	authURL, _ := p.GetAuthURL()
+	state, code := callbackParams(t, a.approve(authURL))
+
+	p.mu.Lock()
+	flow := p.flows[state]
+	flow.started = time.Now().Add(-flowTimeout - time.Second)
+	p.flows[state] = flow
+	p.mu.Unlock()
+
+	if err := p.HandleCallback(state, code); !errors.Is(err, ErrInvalidState) {
+		t.Fatalf("expired flow: %v, want ErrInvalidState", err)
+	}
+	if n := a.tokenRequestCount(); n != 0 {
+		t.Fatalf("%d token requests for an expired flow, want none", n)
+	}
+}
+
+func TestHandleCallbackURLError(t *testing.T) {
+	a := newFakeAuthServer(t)
+	p := NewOAuthProvider("test", a.config("secret"))
+	authURL, _ := p.GetAuthURL()
+	state, code := callbackParams(t, a.approve(authURL))
+
+	denied := testRedirectURL + "?" + url.Values{
+		"state":             {state},
+		"error":             {"access_denied"},
+		"error_description": {"The user said no"},
+	}.Encode()
+	if err := p.HandleCallbackURL(denied); err == nil || !strings.Contains(err.Error(), "access_denied") {
+		t.Fatalf("HandleCallbackURL = %v, want the access_denied error", err)
+	}
+	// The denied flow cannot be completed afterwards.
+	if err := p.HandleCallback(state, code); !errors.Is(err, ErrInvalidState) {
+		t.Fatalf("HandleCallback after denial: %v, want ErrInvalidState", err)
+	}
+}
+
+func TestHandleCallbackCanceled(t *testing.T) {
+	a := newFakeAuthServer(t)
+	p := NewOAuthProvider("test", a.config("secret"))
+	authURL, _ := p.GetAuthURL()
+	state, code := callbackParams(t, a.approve(authURL))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := p.handleCallback(ctx, state, code); err == nil {
+		t.Fatal("exchange with a canceled context succeeded")
+	}
+}
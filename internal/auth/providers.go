@@ -0,0 +1,198 @@
+package auth
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/goBookMarker/internal/models"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+	"golang.org/x/oauth2/microsoft"
+)
+
+// ProviderSpec describes an identity provider: how to authorize with it and
+// how to read the signed-in user's profile.
+type ProviderSpec struct {
+	// Name identifies the provider in the registry, in token stores and
+	// in models.User.Provider.
+	Name     string
+	Endpoint oauth2.Endpoint
+	Scopes   []string
+
+	// ClientIDEnv and ClientSecretEnv name the environment variables that
+	// hold the client credentials. The secret is optional.
+	ClientIDEnv     string
+	ClientSecretEnv string
+
+	// UserInfoURL is fetched with the user's token and its response is
+	// decoded by DecodeUserInfo.
+	UserInfoURL    string
+	DecodeUserInfo func(r io.Reader) (*UserInfo, error)
+}
+
+// DefaultRedirectURL is the custom-scheme redirect used by the mobile apps.
+const DefaultRedirectURL = "com.gobookmarker:/oauth2callback"
+
+var (
+	registryMu sync.RWMutex
+	registry   = make(map[string]ProviderSpec)
+	tokenStore TokenStore
+)
+
+func init() {
+	RegisterProvider(ProviderSpec{
+		Name:     "google",
+		Endpoint: google.Endpoint,
+		Scopes: []string{
+			"https://www.googleapis.com/auth/userinfo.email",
+			"https://www.googleapis.com/auth/drive.appdata",
+		},
+		ClientIDEnv:     "GOOGLE_CLIENT_ID",
+		ClientSecretEnv: "GOOGLE_CLIENT_SECRET",
+		UserInfoURL:     "https://www.googleapis.com/oauth2/v2/userinfo",
+		DecodeUserInfo:  decodeGoogleUserInfo,
+	})
+	RegisterProvider(ProviderSpec{
+		Name:     "microsoft",
+		Endpoint: microsoft.AzureADEndpoint("common"),
+		Scopes: []string{
+			"offline_access",
+			"User.Read",
+			"Files.ReadWrite.AppFolder",
+		},
+		ClientIDEnv:     "MS_CLIENT_ID",
+		ClientSecretEnv: "MS_CLIENT_SECRET",
+		UserInfoURL:     "https://graph.microsoft.com/v1.0/me",
+		DecodeUserInfo:  decodeMicrosoftUserInfo,
+	})
+}
+
+// RegisterProvider adds spec to the registry, replacing a provider with the
+// same name.
+func RegisterProvider(spec ProviderSpec) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	registry[spec.Name] = spec
+}
+
+// LookupProvider returns the registered provider with the given name.
+func LookupProvider(name string) (ProviderSpec, bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	spec, ok := registry[name]
+	return spec, ok
+}
+
+// ProviderNames returns the names of the registered providers, sorted.
+func ProviderNames() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// SetDefaultTokenStore sets the TokenStore that NewProviderAuth gives the
+// providers it creates, usually DefaultTokenStore. The app sets it at
+// startup, before signing in.
+func SetDefaultTokenStore(store TokenStore) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	tokenStore = store
+}
+
+// NewProviderAuth creates an OAuthProvider for the registered provider
+// name, with client credentials from the environment and the mobile
+// redirect URL. With a default token store, the provider saves its token
+// there and starts with the token saved by an earlier launch.
+func NewProviderAuth(name string) (*OAuthProvider, error) {
+	spec, ok := LookupProvider(name)
+	if !ok {
+		return nil, fmt.Errorf("unknown OAuth provider %q", name)
+	}
+	var secret string
+	if spec.ClientSecretEnv != "" {
+		secret = os.Getenv(spec.ClientSecretEnv)
+	}
+	p := NewOAuthProvider(name, &oauth2.Config{
+		ClientID:     os.Getenv(spec.ClientIDEnv),
+		ClientSecret: secret,
+		RedirectURL:  DefaultRedirectURL,
+		Scopes:       append([]string(nil), spec.Scopes...),
+		Endpoint:     spec.Endpoint,
+	})
+
+	registryMu.RLock()
+	store := tokenStore
+	registryMu.RUnlock()
+	if store == nil {
+		return p, nil
+	}
+	if err := p.SetTokenStore(store); err != nil {
+		// A token that cannot be read, for example because its key
+		// file was lost, only means signing in again.
+		if err := store.DeleteToken(name); err != nil {
+			return nil, err
+		}
+		if err := p.SetTokenStore(store); err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+// ApplyTo copies the identity in info to user, keeping the user's
+// preferences.
+func (info *UserInfo) ApplyTo(user *models.User) {
+	user.Email = info.Email
+	user.Name = info.Name
+	user.Provider = info.Provider
+	user.SubjectID = info.Subject
+}
+
+// SameAccount reports whether user was signed in with the account info
+// describes, matching by provider and subject ID rather than email, which
+// can change.
+func (info *UserInfo) SameAccount(user *models.User) bool {
+	return user != nil && user.Provider == info.Provider && user.SubjectID != "" && user.SubjectID == info.Subject
+}
+
+func decodeGoogleUserInfo(r io.Reader) (*UserInfo, error) {
+	var v struct {
+		ID      string `json:"id"`
+		Email   string `json:"email"`
+		Name    string `json:"name"`
+		Picture string `json:"picture"`
+	}
+	if err := json.NewDecoder(r).Decode(&v); err != nil {
+		return nil, err
+	}
+	return &UserInfo{Subject: v.ID, Email: v.Email, Name: v.Name, Picture: v.Picture}, nil
+}
+
+// decodeMicrosoftUserInfo reads a Graph user. Graph has no email field:
+// mail is the mailbox address, empty for personal accounts without
+// Outlook, and userPrincipalName the sign-in name, usually an address.
+func decodeMicrosoftUserInfo(r io.Reader) (*UserInfo, error) {
+	var v struct {
+		ID                string `json:"id"`
+		DisplayName       string `json:"displayName"`
+		Mail              string `json:"mail"`
+		UserPrincipalName string `json:"userPrincipalName"`
+	}
+	if err := json.NewDecoder(r).Decode(&v); err != nil {
+		return nil, err
+	}
+	email := v.Mail
+	if email == "" {
+		email = v.UserPrincipalName
+	}
+	return &UserInfo{Subject: v.ID, Email: email, Name: v.DisplayName}, nil
+}
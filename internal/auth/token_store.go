@@ -0,0 +1,205 @@
+package auth
+
+import (
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/oauth2"
+)
+
+// TokenStore persists OAuth tokens by key, usually the provider name, so
+// that users stay signed in across launches. LoadToken returns nil for a
+// key that was never saved.
+type TokenStore interface {
+	LoadToken(key string) (*oauth2.Token, error)
+	SaveToken(key string, token *oauth2.Token) error
+	DeleteToken(key string) error
+}
+
+const (
+	tokenKeyFile   = "token.key"
+	tokenExtension = ".token"
+)
+
+// FileTokenStore keeps each token in its own file in a directory, encrypted
+// with XChaCha20-Poly1305 under a random key stored next to them. The key
+// file and token files are readable only by the user. This keeps tokens out
+// of plain sight in backups and synced folders that pick up the token
+// files, but not from someone who can read the whole directory.
+type FileTokenStore struct {
+	dir string
+
+	mu  sync.Mutex
+	key []byte
+}
+
+var _ TokenStore = (*FileTokenStore)(nil)
+
+// NewFileTokenStore creates a token store in dir, which is created on the
+// first save.
+func NewFileTokenStore(dir string) *FileTokenStore {
+	return &FileTokenStore{dir: dir}
+}
+
+// DefaultTokenStore returns a FileTokenStore in the app's data directory,
+// such as the one returned by gioui.org/app.DataDir. os.UserConfigDir is
+// not used since it is not available on Android.
+func DefaultTokenStore(dataDir string) *FileTokenStore {
+	return NewFileTokenStore(filepath.Join(dataDir, "tokens"))
+}
+
+// tokenPath returns the file for key, which must be a plain name.
+func (s *FileTokenStore) tokenPath(key string) (string, error) {
+	if key == "" || strings.ContainsAny(key, `/\`) || strings.HasPrefix(key, ".") {
+		return "", fmt.Errorf("invalid token key %q", key)
+	}
+	return filepath.Join(s.dir, key+tokenExtension), nil
+}
+
+// LoadToken reads and decrypts the token saved under key.
+func (s *FileTokenStore) LoadToken(key string) (*oauth2.Token, error) {
+	path, err := s.tokenPath(key)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sealed, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read token: %w", err)
+	}
+	aead, err := s.aeadLocked(false)
+	if err != nil {
+		return nil, err
+	}
+	if aead == nil || len(sealed) < aead.NonceSize() {
+		return nil, fmt.Errorf("failed to decrypt token: key missing or file truncated")
+	}
+	nonce, box := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	data, err := aead.Open(nil, nonce, box, []byte(key))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt token: %w", err)
+	}
+
+	var token oauth2.Token
+	if err := json.Unmarshal(data, &token); err != nil {
+		return nil, fmt.Errorf("failed to decode token: %w", err)
+	}
+	return &token, nil
+}
+
+// SaveToken encrypts token and saves it under key, replacing the file
+// atomically.
+func (s *FileTokenStore) SaveToken(key string, token *oauth2.Token) error {
+	path, err := s.tokenPath(key)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(token)
+	if err != nil {
+		return fmt.Errorf("failed to encode token: %w", err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := os.MkdirAll(s.dir, 0o700); err != nil {
+		return fmt.Errorf("failed to create token directory: %w", err)
+	}
+	aead, err := s.aeadLocked(true)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(data)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	sealed := aead.Seal(nonce, nonce, data, []byte(key))
+	if err := writePrivateFile(path, sealed); err != nil {
+		return fmt.Errorf("failed to save token: %w", err)
+	}
+	return nil
+}
+
+// DeleteToken removes the token saved under key, if any.
+func (s *FileTokenStore) DeleteToken(key string) error {
+	path, err := s.tokenPath(key)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to delete token: %w", err)
+	}
+	return nil
+}
+
+// aeadLocked returns the cipher for the store's key, reading the key file
+// or, if create is set, generating it. Without create it returns nil if
+// there is no key file.
+func (s *FileTokenStore) aeadLocked(create bool) (cipher.AEAD, error) {
+	if s.key == nil {
+		path := filepath.Join(s.dir, tokenKeyFile)
+		key, err := os.ReadFile(path)
+		switch {
+		case errors.Is(err, fs.ErrNotExist) && create:
+			key = make([]byte, chacha20poly1305.KeySize)
+			if _, err := rand.Read(key); err != nil {
+				return nil, fmt.Errorf("failed to generate token key: %w", err)
+			}
+			if err := writePrivateFile(path, key); err != nil {
+				return nil, fmt.Errorf("failed to save token key: %w", err)
+			}
+		case errors.Is(err, fs.ErrNotExist):
+			return nil, nil
+		case err != nil:
+			return nil, fmt.Errorf("failed to read token key: %w", err)
+		}
+		if len(key) != chacha20poly1305.KeySize {
+			return nil, fmt.Errorf("invalid token key file")
+		}
+		s.key = key
+	}
+	return chacha20poly1305.NewX(s.key)
+}
+
+// writePrivateFile writes data to path through a temporary file readable
+// only by the user, renaming it into place once it is on disk.
+func writePrivateFile(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-"+filepath.Base(path)+"-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := tmp.Chmod(0o600); err != nil {
+		tmp.Close()
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,173 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// useTestProvider registers a provider named "test" that authorizes with a
+// and makes store the default token store until the test ends.
+func useTestProvider(t *testing.T, a *fakeAuthServer, store TokenStore) {
+	config := a.config("")
+	RegisterProvider(ProviderSpec{Name: "test", Endpoint: config.Endpoint})
+	SetDefaultTokenStore(store)
+	t.Cleanup(func() {
+		registryMu.Lock()
+		delete(registry, "test")
+		tokenStore = nil
+		registryMu.Unlock()
+	})
+}
+
+// newTestAuth creates the "test" provider the way the app does, redirecting
+// to testRedirectURL.
+func newTestAuth(t *testing.T) *OAuthProvider {
+	t.Helper()
+	p, err := NewProviderAuth("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.config.RedirectURL = testRedirectURL
+	p.config.ClientID = "client-id"
+	return p
+}
+
+func TestFileTokenStoreSaveAndLoad(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "tokens")
+	token := &oauth2.Token{
+		AccessToken:  "secret-access-token",
+		RefreshToken: "secret-refresh-token",
+		TokenType:    "Bearer",
+		Expiry:       time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+	if err := NewFileTokenStore(dir).SaveToken("google", token); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "google"+tokenExtension))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(data, []byte("secret")) {
+		t.Fatal("token file is not encrypted")
+	}
+	for _, name := range []string{"google" + tokenExtension, tokenKeyFile} {
+		fi, err := os.Stat(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if perm := fi.Mode().Perm(); perm != 0o600 {
+			t.Errorf("%s has mode %o, want 600", name, perm)
+		}
+	}
+
+	store := NewFileTokenStore(dir)
+	got, err := store.LoadToken("google")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got == nil || got.AccessToken != token.AccessToken || got.RefreshToken != token.RefreshToken || !got.Expiry.Equal(token.Expiry) {
+		t.Fatalf("LoadToken = %+v, want %+v", got, token)
+	}
+	if got, err := store.LoadToken("microsoft"); got != nil || err != nil {
+		t.Fatalf("LoadToken of an unsaved key = %v, %v, want nil", got, err)
+	}
+
+	if err := store.DeleteToken("google"); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := store.LoadToken("google"); got != nil || err != nil {
+		t.Fatalf("LoadToken after DeleteToken = %v, %v, want nil", got, err)
+	}
+	if err := store.SaveToken("../google", token); err == nil {
+		t.Error("SaveToken accepted a key with a path separator")
+	}
+}
+
+func TestProviderTokenSurvivesRestart(t *testing.T) {
+	a := newFakeAuthServer(t)
+	dir := t.TempDir()
+	useTestProvider(t, a, DefaultTokenStore(dir))
+
+	token := a.signIn(newTestAuth(t))
+
+	// The next launch creates the provider again and is signed in.
+	restarted := newTestAuth(t)
+	if got := restarted.GetToken(); got == nil || got.AccessToken != token.AccessToken {
+		t.Fatalf("token after restart = %+v, want %s", got, token.AccessToken)
+	}
+	if err := restarted.SignOut(); err != nil {
+		t.Fatal(err)
+	}
+	if got := newTestAuth(t).GetToken(); got != nil {
+		t.Fatalf("token after sign-out and restart = %+v, want none", got)
+	}
+}
+
+func TestTokenSourceSavesRefreshedToken(t *testing.T) {
+	ctx := context.Background()
+	a := newFakeAuthServer(t)
+	useTestProvider(t, a, DefaultTokenStore(t.TempDir()))
+
+	p := newTestAuth(t)
+	signedIn := a.signIn(p)
+	expired := *signedIn
+	expired.Expiry = time.Now().Add(-time.Minute)
+	p.SetToken(&expired)
+
+	refreshed, err := p.TokenSource(ctx).Token()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if refreshed.AccessToken == signedIn.AccessToken || refreshed.RefreshToken == signedIn.RefreshToken {
+		t.Fatalf("token was not refreshed: %+v", refreshed)
+	}
+
+	// The server has spent the old refresh token, so the next launch
+	// must start with the refreshed one.
+	restarted := newTestAuth(t)
+	if got := restarted.GetToken(); got == nil || got.RefreshToken != refreshed.RefreshToken {
+		t.Fatalf("token after restart = %+v, want the refreshed one", got)
+	}
+	again := *restarted.GetToken()
+	again.Expiry = time.Now().Add(-time.Minute)
+	restarted.SetToken(&again)
+	if _, err := restarted.TokenSource(ctx).Token(); err != nil {
+		t.Fatalf("refreshing after restart: %v", err)
+	}
+
+	// A revoked refresh token means signing in again.
+	p.SetToken(&expired)
+	if _, err := p.TokenSource(ctx).Token(); !errors.Is(err, ErrSignInRequired) {
+		t.Fatalf("refreshing a spent token: %v, want ErrSignInRequired", err)
+	}
+}
+
+func TestProviderIgnoresUnreadableToken(t *testing.T) {
+	a := newFakeAuthServer(t)
+	dir := t.TempDir()
+	useTestProvider(t, a, DefaultTokenStore(dir))
+	a.signIn(newTestAuth(t))
+
+	// Without the key the saved token cannot be decrypted on the next
+	// launch.
+	if err := os.Remove(filepath.Join(dir, "tokens", tokenKeyFile)); err != nil {
+		t.Fatal(err)
+	}
+	SetDefaultTokenStore(DefaultTokenStore(dir))
+	p := newTestAuth(t)
+	if got := p.GetToken(); got != nil {
+		t.Fatalf("token = %+v, want none", got)
+	}
+	a.signIn(p)
+	if got := newTestAuth(t).GetToken(); got == nil {
+		t.Fatal("signing in again did not save a token")
+	}
+}
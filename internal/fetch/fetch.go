@@ -0,0 +1,413 @@
+// Package fetch downloads web pages on the user's behalf, for link previews
+// and metadata. Its Fetcher bounds every request in time, redirects and
+// size, refuses to connect to loopback, private and link-local addresses,
+// and caches recent responses.
+package fetch
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	gosync "sync"
+	"syscall"
+	"time"
+)
+
+var (
+	// ErrBlockedAddress is returned when a URL, or a redirect, points at an
+	// address that is not on the public internet.
+	ErrBlockedAddress = errors.New("address is not public")
+
+	// ErrTooManyRedirects is returned when a page redirects more than
+	// MaxRedirects times.
+	ErrTooManyRedirects = errors.New("too many redirects")
+
+	// ErrUnsupportedContentType is returned when the response is not one of
+	// the media types the caller accepts. The body is not read.
+	ErrUnsupportedContentType = errors.New("unsupported content type")
+)
+
+// StatusError is returned for a response with a status other than 200 OK.
+type StatusError struct {
+	URL        string
+	StatusCode int
+	Status     string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("failed to fetch %s: unexpected status %s", e.URL, e.Status)
+}
+
+// Temporary reports whether a failed Fetch may succeed later: the server
+// could not be reached, timed out or was overloaded. Blocked or invalid
+// URLs, other error statuses and unsupported content are not temporary.
+func Temporary(err error) bool {
+	if errors.Is(err, ErrBlockedAddress) || errors.Is(err, ErrTooManyRedirects) ||
+		errors.Is(err, ErrUnsupportedContentType) {
+		return false
+	}
+	var status *StatusError
+	if errors.As(err, &status) {
+		return status.StatusCode >= 500 ||
+			status.StatusCode == http.StatusRequestTimeout ||
+			status.StatusCode == http.StatusTooManyRequests
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, context.DeadlineExceeded) ||
+		errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+const (
+	DefaultTimeout      = 15 * time.Second
+	DefaultMaxRedirects = 5
+	DefaultMaxBodySize  = 1024 * 1024
+	DefaultUserAgent    = "goBookMarker/1.0 (+https://github.com/goBookMarker)"
+
+	defaultCacheTTL     = 10 * time.Minute
+	defaultCacheEntries = 64
+)
+
+// Response is a fetched page.
+type Response struct {
+	// URL is the final URL, after redirects.
+	URL        *url.URL
+	StatusCode int
+	Header     http.Header
+	// ContentType is the Content-Type header, or the sniffed type if the
+	// server sent none.
+	ContentType string
+	// Body holds at most MaxBodySize bytes; Truncated is set if the
+	// response was longer.
+	Body      []byte
+	Truncated bool
+	FetchedAt time.Time
+}
+
+// MediaType returns the response's media type without parameters, lower
+// case.
+func (r *Response) MediaType() string {
+	mediaType, _, err := mime.ParseMediaType(r.ContentType)
+	if err != nil {
+		return ""
+	}
+	return mediaType
+}
+
+// Fetcher fetches pages over HTTP and HTTPS. The exported fields can be
+// changed before the first Fetch. A Fetcher is safe for concurrent use.
+type Fetcher struct {
+	// Timeout bounds each fetch, including redirects and reading the body.
+	Timeout      time.Duration
+	MaxRedirects int
+	MaxBodySize  int64
+	UserAgent    string
+	// CacheTTL is how long a response is reused for the same URL. Zero
+	// disables the cache.
+	CacheTTL time.Duration
+
+	client *http.Client
+	// checkAddresses is set when the fetcher dials connections itself and
+	// so must also keep URLs naming non-public addresses out.
+	checkAddresses bool
+
+	mu    gosync.Mutex
+	cache map[string]cacheEntry
+}
+
+type cacheEntry struct {
+	resp    *Response
+	expires time.Time
+}
+
+// New creates a Fetcher with the default limits that only connects to
+// public addresses. It does not use proxies from the environment, so that
+// every connection goes to an address it has checked.
+func New() *Fetcher {
+	dialer := &net.Dialer{
+		Timeout: 10 * time.Second,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			return checkDialAddress(address)
+		},
+	}
+	f := newFetcher(&http.Transport{
+		DialContext:           dialer.DialContext,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          10,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ResponseHeaderTimeout: 10 * time.Second,
+	})
+	f.checkAddresses = true
+	return f
+}
+
+// NewWithTransport creates a Fetcher that sends requests with transport,
+// which is then responsible for address checks. It is meant for tests and
+// for callers that route requests themselves.
+func NewWithTransport(transport http.RoundTripper) *Fetcher {
+	return newFetcher(transport)
+}
+
+func newFetcher(transport http.RoundTripper) *Fetcher {
+	f := &Fetcher{
+		Timeout:      DefaultTimeout,
+		MaxRedirects: DefaultMaxRedirects,
+		MaxBodySize:  DefaultMaxBodySize,
+		UserAgent:    DefaultUserAgent,
+		CacheTTL:     defaultCacheTTL,
+		cache:        make(map[string]cacheEntry),
+	}
+	f.client = &http.Client{
+		Transport:     transport,
+		CheckRedirect: f.checkRedirect,
+	}
+	return f
+}
+
+func (f *Fetcher) checkRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) > f.MaxRedirects {
+		return ErrTooManyRedirects
+	}
+	return f.checkURL(req.URL)
+}
+
+// Fetch GETs rawURL and returns the response if its media type is one of
+// accept, or any type if accept is empty. Responses other than 200 OK are
+// errors. A response for the same URL fetched within CacheTTL is returned
+// from the cache, unless the server marked it no-store.
+func (f *Fetcher) Fetch(ctx context.Context, rawURL string, accept ...string) (*Response, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid URL: %w", err)
+	}
+	if err := f.checkURL(u); err != nil {
+		return nil, err
+	}
+
+	key := u.String()
+	resp, ok := f.cached(key)
+	if !ok {
+		resp, err = f.get(ctx, u, accept)
+		if err != nil {
+			return nil, err
+		}
+		if !strings.Contains(strings.ToLower(resp.Header.Get("Cache-Control")), "no-store") {
+			f.store(key, resp)
+		}
+	}
+	if !acceptable(resp.MediaType(), accept) {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedContentType, resp.ContentType)
+	}
+	return resp, nil
+}
+
+func (f *Fetcher) get(ctx context.Context, u *url.URL, accept []string) (*Response, error) {
+	if f.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, f.Timeout)
+		defer cancel()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("User-Agent", f.UserAgent)
+	if len(accept) > 0 {
+		req.Header.Set("Accept", strings.Join(accept, ", ")+", */*;q=0.1")
+	}
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch %s: %w", u.Redacted(), err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, &StatusError{URL: u.Redacted(), StatusCode: resp.StatusCode, Status: resp.Status}
+	}
+
+	result := &Response{
+		URL:         resp.Request.URL,
+		StatusCode:  resp.StatusCode,
+		Header:      resp.Header,
+		ContentType: resp.Header.Get("Content-Type"),
+		FetchedAt:   time.Now(),
+	}
+	body := io.Reader(resp.Body)
+	if result.ContentType == "" {
+		// Sniff from the first bytes, then put them back.
+		head := make([]byte, 512)
+		n, err := io.ReadFull(resp.Body, head)
+		if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+			return nil, fmt.Errorf("failed to read %s: %w", u.Redacted(), err)
+		}
+		result.ContentType = http.DetectContentType(head[:n])
+		body = io.MultiReader(bytes.NewReader(head[:n]), resp.Body)
+	}
+	if !acceptable(result.MediaType(), accept) {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedContentType, result.ContentType)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(body, f.MaxBodySize+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", u.Redacted(), err)
+	}
+	if int64(len(data)) > f.MaxBodySize {
+		data, result.Truncated = data[:f.MaxBodySize], true
+	}
+	result.Body = data
+	return result, nil
+}
+
+func (f *Fetcher) cached(key string) (*Response, bool) {
+	if f.CacheTTL <= 0 {
+		return nil, false
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	entry, ok := f.cache[key]
+	if !ok || time.Now().After(entry.expires) {
+		delete(f.cache, key)
+		return nil, false
+	}
+	return entry.resp, true
+}
+
+// store caches resp under key, making room by dropping expired entries and
+// then the one closest to expiring.
+func (f *Fetcher) store(key string, resp *Response) {
+	if f.CacheTTL <= 0 {
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	now := time.Now()
+	if len(f.cache) >= defaultCacheEntries {
+		oldest := ""
+		for k, entry := range f.cache {
+			if now.After(entry.expires) {
+				delete(f.cache, k)
+			} else if oldest == "" || entry.expires.Before(f.cache[oldest].expires) {
+				oldest = k
+			}
+		}
+		if len(f.cache) >= defaultCacheEntries {
+			delete(f.cache, oldest)
+		}
+	}
+	f.cache[key] = cacheEntry{resp: resp, expires: now.Add(f.CacheTTL)}
+}
+
+// acceptable reports whether mediaType is in accept, treating an empty
+// accept as anything.
+func acceptable(mediaType string, accept []string) bool {
+	if len(accept) == 0 {
+		return true
+	}
+	for _, a := range accept {
+		if strings.EqualFold(a, mediaType) {
+			return true
+		}
+		if prefix, ok := strings.CutSuffix(a, "/*"); ok && strings.HasPrefix(mediaType, strings.ToLower(prefix)+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// checkURL rejects URLs that are not HTTP or HTTPS, carry credentials, or,
+// if the fetcher checks addresses, name localhost or a non-public IP
+// address. Host names are checked again once they resolve, when the
+// connection is made.
+func (f *Fetcher) checkURL(u *url.URL) error {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("unsupported URL scheme %q", u.Scheme)
+	}
+	if u.User != nil {
+		return fmt.Errorf("URLs with credentials are not fetched")
+	}
+	host := u.Hostname()
+	if host == "" {
+		return fmt.Errorf("URL has no host")
+	}
+	if !f.checkAddresses {
+		return nil
+	}
+	if strings.EqualFold(host, "localhost") || strings.HasSuffix(strings.ToLower(host), ".localhost") {
+		return fmt.Errorf("%w: %s", ErrBlockedAddress, host)
+	}
+	if addr, err := netip.ParseAddr(host); err == nil && !isPublic(addr) {
+		return fmt.Errorf("%w: %s", ErrBlockedAddress, host)
+	}
+	return nil
+}
+
+// checkDialAddress rejects a resolved host:port that is not public. It runs
+// for every connection, so DNS answers pointing at internal addresses are
+// caught too.
+func checkDialAddress(address string) error {
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrBlockedAddress, address)
+	}
+	if !isPublic(addrPort.Addr()) {
+		return fmt.Errorf("%w: %s", ErrBlockedAddress, addrPort.Addr())
+	}
+	return nil
+}
+
+// nonPublic lists the special-purpose ranges that netip's predicates do
+// not cover.
+var nonPublic = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),     // "this network"
+	netip.MustParsePrefix("100.64.0.0/10"), // carrier-grade NAT
+	netip.MustParsePrefix("192.0.0.0/24"),  // IETF protocol assignments
+	netip.MustParsePrefix("198.18.0.0/15"), // benchmarking
+	netip.MustParsePrefix("240.0.0.0/4"),   // reserved, and broadcast
+	netip.MustParsePrefix("64:ff9b:1::/48"),
+	netip.MustParsePrefix("2001:db8::/32"), // documentation
+}
+
+var (
+	nat64     = netip.MustParsePrefix("64:ff9b::/96")
+	sixToFour = netip.MustParsePrefix("2002::/16")
+)
+
+// isPublic reports whether addr is a global unicast address outside the
+// private and special-purpose ranges. NAT64 and 6to4 addresses are public
+// only if the IPv4 address they lead to is.
+func isPublic(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	if !addr.IsGlobalUnicast() || addr.IsPrivate() {
+		return false
+	}
+	for _, prefix := range nonPublic {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+	if v4, ok := embeddedIPv4(addr); ok {
+		return isPublic(v4)
+	}
+	return true
+}
+
+// embeddedIPv4 returns the IPv4 address carried in a NAT64 or 6to4
+// address.
+func embeddedIPv4(addr netip.Addr) (netip.Addr, bool) {
+	b := addr.As16()
+	switch {
+	case nat64.Contains(addr):
+		return netip.AddrFrom4([4]byte(b[12:16])), true
+	case sixToFour.Contains(addr):
+		return netip.AddrFrom4([4]byte(b[2:6])), true
+	}
+	return netip.Addr{}, false
+}
@@ -0,0 +1,202 @@
+package fetch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+)
+
+// handlerTransport serves requests for any host with a handler, so tests
+// can fetch from hosts that look public.
+type handlerTransport struct {
+	handler http.Handler
+}
+
+func (t handlerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	rec := httptest.NewRecorder()
+	t.handler.ServeHTTP(rec, req)
+	resp := rec.Result()
+	resp.Request = req
+	return resp, nil
+}
+
+// newTestFetcher returns a fetcher that checks URLs and redirects like New
+// but serves every host from the test site.
+func newTestFetcher() *Fetcher {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/page", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		fmt.Fprint(w, "<title>Page</title>")
+	})
+	mux.HandleFunc("/sniffed", func(w http.ResponseWriter, r *http.Request) {
+		w.Header()["Content-Type"] = nil
+		fmt.Fprint(w, "<!DOCTYPE html><title>Sniffed</title>")
+	})
+	mux.HandleFunc("/image", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		fmt.Fprint(w, "\x89PNG")
+	})
+	mux.HandleFunc("/large", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, strings.Repeat("x", 100))
+	})
+	mux.HandleFunc("/redirect", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, r.URL.Query().Get("to"), http.StatusFound)
+	})
+	mux.HandleFunc("/hops/", func(w http.ResponseWriter, r *http.Request) {
+		var n int
+		fmt.Sscanf(strings.TrimPrefix(r.URL.Path, "/hops/"), "%d", &n)
+		if n == 0 {
+			http.Redirect(w, r, "/page", http.StatusFound)
+			return
+		}
+		http.Redirect(w, r, fmt.Sprintf("/hops/%d", n-1), http.StatusFound)
+	})
+
+	f := NewWithTransport(handlerTransport{mux})
+	f.checkAddresses = true
+	f.CacheTTL = 0
+	return f
+}
+
+func TestIsPublic(t *testing.T) {
+	for addr, want := range map[string]bool{
+		"93.184.216.34":        true,
+		"2606:2800:220:1::248": true,
+		"127.0.0.1":            false,
+		"10.1.2.3":             false,
+		"192.168.1.1":          false,
+		"169.254.169.254":      false,
+		"100.64.0.1":           false,
+		"::1":                  false,
+		"fe80::1":              false,
+		"fd00::1":              false,
+		"::ffff:127.0.0.1":     false,
+		// NAT64 and 6to4 addresses lead to the IPv4 address they carry.
+		"64:ff9b::5db8:d822":   true,
+		"64:ff9b::7f00:1":      false,
+		"64:ff9b::a9fe:a9fe":   false,
+		"2002:5db8:d822::1":    true,
+		"2002:7f00:1::1":       false,
+		"2002:c0a8:101::1":     false,
+		"2002:a00:1:1234::abc": false,
+	} {
+		if got := isPublic(netip.MustParseAddr(addr)); got != want {
+			t.Errorf("isPublic(%s) = %v, want %v", addr, got, want)
+		}
+	}
+}
+
+func TestFetchBlocksNonPublicAddresses(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("request reached %s", r.Host)
+	}))
+	defer srv.Close()
+
+	f := New()
+	for _, u := range []string{
+		srv.URL + "/",
+		"http://localhost/",
+		"http://app.localhost/",
+		"http://10.0.0.1/",
+		"http://[::1]/",
+		"http://[64:ff9b::7f00:1]/",
+		"http://[2002:c0a8:101::1]/",
+	} {
+		if _, err := f.Fetch(context.Background(), u); !errors.Is(err, ErrBlockedAddress) {
+			t.Errorf("Fetch(%s) = %v, want ErrBlockedAddress", u, err)
+		}
+	}
+
+	// Host names are checked once resolved, when dialing.
+	for address, blocked := range map[string]bool{
+		"127.0.0.1:80":             true,
+		"[64:ff9b::a00:1]:443":     true,
+		"[2002:7f00:1::1]:443":     true,
+		"93.184.216.34:443":        false,
+		"[64:ff9b::5db8:d822]:443": false,
+	} {
+		err := checkDialAddress(address)
+		if got := errors.Is(err, ErrBlockedAddress); got != blocked {
+			t.Errorf("checkDialAddress(%s) = %v, want blocked %v", address, err, blocked)
+		}
+	}
+}
+
+func TestFetchBlocksRedirectsToNonPublicAddresses(t *testing.T) {
+	f := newTestFetcher()
+	for _, to := range []string{
+		"http://127.0.0.1/page",
+		"http://localhost/page",
+		"http://192.168.1.1/page",
+		"http://[64:ff9b::c0a8:101]/page",
+	} {
+		_, err := f.Fetch(context.Background(), "http://public.example/redirect?to="+to)
+		if !errors.Is(err, ErrBlockedAddress) {
+			t.Errorf("redirect to %s: %v, want ErrBlockedAddress", to, err)
+		}
+		if Temporary(err) {
+			t.Errorf("redirect to %s reported as temporary", to)
+		}
+	}
+	resp, err := f.Fetch(context.Background(), "http://public.example/redirect?to=http://other.example/page")
+	if err != nil || resp.URL.Host != "other.example" {
+		t.Fatalf("redirect to a public host = %v, %v", resp, err)
+	}
+}
+
+func TestFetchRedirectLimit(t *testing.T) {
+	f := newTestFetcher()
+	f.MaxRedirects = 3
+	resp, err := f.Fetch(context.Background(), "http://public.example/hops/2")
+	if err != nil || resp.URL.Path != "/page" {
+		t.Fatalf("Fetch with 3 redirects = %v, %v", resp, err)
+	}
+	if _, err := f.Fetch(context.Background(), "http://public.example/hops/3"); !errors.Is(err, ErrTooManyRedirects) {
+		t.Fatalf("Fetch with 4 redirects: %v, want ErrTooManyRedirects", err)
+	}
+}
+
+func TestFetchTruncatesLargeBodies(t *testing.T) {
+	f := newTestFetcher()
+	f.MaxBodySize = 10
+	resp, err := f.Fetch(context.Background(), "http://public.example/large")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Body) != 10 || !resp.Truncated {
+		t.Fatalf("body of %d bytes, truncated %v; want 10 bytes, truncated", len(resp.Body), resp.Truncated)
+	}
+
+	f.MaxBodySize = DefaultMaxBodySize
+	if resp, err := f.Fetch(context.Background(), "http://public.example/large"); err != nil || resp.Truncated || len(resp.Body) != 100 {
+		t.Fatalf("body within the limit = %v, %v", resp, err)
+	}
+}
+
+func TestFetchFiltersContentType(t *testing.T) {
+	f := newTestFetcher()
+	ctx := context.Background()
+
+	if _, err := f.Fetch(ctx, "http://public.example/image", "text/html"); !errors.Is(err, ErrUnsupportedContentType) {
+		t.Errorf("image fetched as HTML: %v, want ErrUnsupportedContentType", err)
+	}
+	if resp, err := f.Fetch(ctx, "http://public.example/image", "image/*"); err != nil || resp.MediaType() != "image/png" {
+		t.Errorf("Fetch with image/* = %v, %v", resp, err)
+	}
+	if resp, err := f.Fetch(ctx, "http://public.example/page", "text/html"); err != nil || resp.MediaType() != "text/html" {
+		t.Errorf("Fetch of HTML with parameters = %v, %v", resp, err)
+	}
+	resp, err := f.Fetch(ctx, "http://public.example/sniffed", "text/html")
+	if err != nil || resp.MediaType() != "text/html" || !strings.Contains(string(resp.Body), "Sniffed") {
+		t.Errorf("Fetch without Content-Type = %v, %v, want the sniffed HTML", resp, err)
+	}
+	if _, err := f.Fetch(ctx, "http://public.example/page"); err != nil {
+		t.Errorf("Fetch accepting anything: %v", err)
+	}
+}
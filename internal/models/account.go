@@ -0,0 +1,16 @@
+package models
+
+import "time"
+
+// LinkedAccount is a provider account connected to the local profile. A
+// profile has at most one account per provider, and at most one of its
+// accounts backs sync.
+type LinkedAccount struct {
+	UserID        string    `json:"user_id"`
+	Provider      string    `json:"provider"`
+	SubjectID     string    `json:"subject_id"`
+	Email         string    `json:"email"`
+	Name          string    `json:"name"`
+	IsSyncAccount bool      `json:"is_sync_account"`
+	LinkedAt      time.Time `json:"linked_at"`
+}
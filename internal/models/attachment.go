@@ -0,0 +1,16 @@
+package models
+
+import "time"
+
+// Attachment is a file kept with the library, such as a shared image. ID
+// is the hex SHA-256 of the content, so a file is stored once however
+// many bookmarks use it. Width and Height are zero for files that are not
+// images.
+type Attachment struct {
+	ID        string    `json:"id"`
+	MimeType  string    `json:"mime_type"`
+	Size      int64     `json:"size"`
+	Width     int       `json:"width"`
+	Height    int       `json:"height"`
+	CreatedAt time.Time `json:"created_at"`
+}
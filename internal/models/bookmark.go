@@ -16,4 +16,8 @@ type Bookmark struct {
 	Tags        []string  `json:"tags"`
 	CreatedAt   time.Time `json:"created_at"`
 	UpdatedAt   time.Time `json:"updated_at"`
+	// TagIDs are the IDs of the tags named in Tags, where known. Tag names
+	// are only unique among siblings, so they tell apart tags such as
+	// "Bar/Go" and "Other/Go".
+	TagIDs []string `json:"tag_ids,omitempty"`
 }
@@ -0,0 +1,20 @@
+package models
+
+import "time"
+
+// Change is an entry in the local change log: an entity that was created,
+// updated or deleted. Kind is "bookmark", "tag", "tag_group" or
+// "preferences", matching the sync entity kinds.
+type Change struct {
+	Seq       int64     `json:"seq"`
+	Kind      string    `json:"kind"`
+	EntityID  string    `json:"entity_id"`
+	Op        string    `json:"op"`
+	ChangedAt time.Time `json:"changed_at"`
+}
+
+// Change operations.
+const (
+	ChangeUpsert = "upsert"
+	ChangeDelete = "delete"
+)
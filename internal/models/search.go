@@ -0,0 +1,21 @@
+package models
+
+// TextRange marks a matched span as byte offsets [Start, End) into a string.
+type TextRange struct {
+	Start int `json:"start"`
+	End   int `json:"end"`
+}
+
+// SearchResult is a bookmark matched by a full-text search together with
+// its relevance and the parts of the text that matched.
+type SearchResult struct {
+	Bookmark Bookmark `json:"bookmark"`
+	// Rank is the BM25 score; lower values are better matches.
+	Rank float64 `json:"rank"`
+	// TitleHighlights are the matched ranges within Bookmark.Title.
+	TitleHighlights []TextRange `json:"title_highlights"`
+	// Snippet is a short excerpt of the best matching field and
+	// SnippetHighlights the matched ranges within it.
+	Snippet           string      `json:"snippet"`
+	SnippetHighlights []TextRange `json:"snippet_highlights"`
+}
@@ -0,0 +1,35 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// ShareStatus is how far a queued share has been processed.
+type ShareStatus string
+
+const (
+	// SharePending shares wait for the pages they link to be fetched.
+	SharePending ShareStatus = "pending"
+	// ShareReady shares were processed and wait to be saved or dismissed.
+	ShareReady ShareStatus = "ready"
+	// ShareFailed shares could not be processed in several attempts. They
+	// can be retried, or saved without page details.
+	ShareFailed ShareStatus = "failed"
+)
+
+// QueuedShare is content shared with the app, kept until the user saves or
+// dismisses it. Content is what was shared, exactly; Items holds the
+// processed items, encoded by the share package, once the share is ready.
+type QueuedShare struct {
+	ID            string          `json:"id"`
+	ContentType   string          `json:"content_type"`
+	Content       string          `json:"content"`
+	Status        ShareStatus     `json:"status"`
+	Attempts      int             `json:"attempts"`
+	LastError     string          `json:"last_error,omitempty"`
+	NextAttemptAt time.Time       `json:"next_attempt_at"`
+	Items         json.RawMessage `json:"items,omitempty"`
+	CreatedAt     time.Time       `json:"created_at"`
+	UpdatedAt     time.Time       `json:"updated_at"`
+}
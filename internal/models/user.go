@@ -1,9 +1,14 @@
 package models
 
 type User struct {
-	ID           string   `json:"id"`
-	Email        string   `json:"email"`
-	Name         string   `json:"name"`
+	ID    string `json:"id"`
+	Email string `json:"email"`
+	Name  string `json:"name"`
+	// Provider and SubjectID identify the account the profile was created
+	// with: the provider's registry name and the provider's stable user ID.
+	// All linked accounts are kept as LinkedAccount.
+	Provider     string   `json:"provider,omitempty"`
+	SubjectID    string   `json:"subject_id,omitempty"`
 	NavPosition  string   `json:"nav_position"`
 	NavItems     []string `json:"nav_items"`
 	Theme        string   `json:"theme"`
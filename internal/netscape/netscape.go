@@ -0,0 +1,242 @@
+// Package netscape reads and writes the Netscape bookmark file format
+// (bookmarks.html) exported by every major browser.
+package netscape
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// Folder is a bookmark folder. The root folder returned by Parse has no
+// title and holds everything outside a named folder.
+type Folder struct {
+	Title        string
+	AddDate      time.Time
+	LastModified time.Time
+	Folders      []*Folder
+	Bookmarks    []Bookmark
+}
+
+// Bookmark is a single <A> entry.
+type Bookmark struct {
+	Title        string
+	URL          string
+	Description  string
+	AddDate      time.Time
+	LastModified time.Time
+	Tags         []string
+	IconURI      string
+	// Index is the bookmark's position among all bookmarks in the parsed
+	// document, so callers walking the folder tree can restore file order.
+	Index int
+}
+
+// Parse reads a bookmarks.html document. The format is loosely structured
+// HTML with unclosed <DT> and <p> elements, so it is read token by token:
+// <H3> names the folder opened by the following <DL>, <A> is a bookmark
+// and <DD> holds the description of the preceding bookmark.
+func Parse(r io.Reader) (*Folder, error) {
+	root := &Folder{}
+	stack := []*Folder{root}
+	var pending *Folder // folder named by <H3>, waiting for its <DL>
+	seenDL := false     // the first <DL> is the root list
+	count := 0
+
+	// text receives character data while inside <H3>, <A> or after <DD>.
+	var text *strings.Builder
+	var target func(string)
+
+	finish := func() {
+		if target != nil {
+			target(strings.TrimSpace(text.String()))
+		}
+		target, text = nil, nil
+	}
+	capture := func(fn func(string)) {
+		finish()
+		text, target = &strings.Builder{}, fn
+	}
+
+	z := html.NewTokenizer(r)
+	for {
+		tt := z.Next()
+		switch tt {
+		case html.ErrorToken:
+			if z.Err() == io.EOF {
+				finish()
+				return root, nil
+			}
+			return nil, fmt.Errorf("failed to read bookmarks file: %w", z.Err())
+
+		case html.TextToken:
+			if text != nil {
+				text.Write(z.Text())
+			}
+
+		case html.StartTagToken, html.SelfClosingTagToken:
+			tok := z.Token()
+			current := stack[len(stack)-1]
+			switch tok.DataAtom {
+			case atom.H3:
+				finish()
+				folder := &Folder{
+					AddDate:      parseTimestamp(attr(tok, "add_date")),
+					LastModified: parseTimestamp(attr(tok, "last_modified")),
+				}
+				current.Folders = append(current.Folders, folder)
+				pending = folder
+				capture(func(s string) { folder.Title = s })
+
+			case atom.Dl:
+				finish()
+				switch {
+				case pending != nil:
+					stack = append(stack, pending)
+					pending = nil
+				case seenDL:
+					// A nameless nested list; keep its entries in the
+					// enclosing folder.
+					stack = append(stack, current)
+				}
+				seenDL = true
+
+			case atom.A:
+				finish()
+				current.Bookmarks = append(current.Bookmarks, Bookmark{
+					URL:          strings.TrimSpace(attr(tok, "href")),
+					AddDate:      parseTimestamp(attr(tok, "add_date")),
+					LastModified: parseTimestamp(attr(tok, "last_modified")),
+					Tags:         splitTags(attr(tok, "tags")),
+					IconURI:      attr(tok, "icon_uri"),
+					Index:        count,
+				})
+				count++
+				b := &current.Bookmarks[len(current.Bookmarks)-1]
+				capture(func(s string) { b.Title = s })
+
+			case atom.Dd:
+				finish()
+				if n := len(current.Bookmarks); n > 0 {
+					b := &current.Bookmarks[n-1]
+					capture(func(s string) { b.Description = s })
+				}
+
+			case atom.Dt:
+				finish()
+			}
+
+		case html.EndTagToken:
+			tok := z.Token()
+			switch tok.DataAtom {
+			case atom.H3, atom.A:
+				finish()
+			case atom.Dl:
+				finish()
+				if len(stack) > 1 {
+					stack = stack[:len(stack)-1]
+				}
+			}
+		}
+	}
+}
+
+// Write renders root as a bookmarks.html document. The root folder's own
+// title is used as the document heading.
+func Write(w io.Writer, root *Folder) error {
+	bw := bufio.NewWriter(w)
+	title := root.Title
+	if title == "" {
+		title = "Bookmarks"
+	}
+
+	fmt.Fprint(bw, `<!DOCTYPE NETSCAPE-Bookmark-file-1>
+<!-- This is an automatically generated file.
+     It will be read and overwritten.
+     DO NOT EDIT! -->
+<META HTTP-EQUIV="Content-Type" CONTENT="text/html; charset=UTF-8">
+`)
+	fmt.Fprintf(bw, "<TITLE>%s</TITLE>\n<H1>%s</H1>\n", html.EscapeString(title), html.EscapeString(title))
+	writeList(bw, root, 0)
+	return bw.Flush()
+}
+
+func writeList(w *bufio.Writer, f *Folder, depth int) {
+	indent := strings.Repeat("    ", depth)
+	fmt.Fprintf(w, "%s<DL><p>\n", indent)
+
+	for _, sub := range f.Folders {
+		fmt.Fprintf(w, "%s    <DT><H3%s%s>%s</H3>\n", indent,
+			timestampAttr("ADD_DATE", sub.AddDate),
+			timestampAttr("LAST_MODIFIED", sub.LastModified),
+			html.EscapeString(sub.Title))
+		writeList(w, sub, depth+1)
+	}
+
+	for _, b := range f.Bookmarks {
+		fmt.Fprintf(w, `%s    <DT><A HREF="%s"%s%s`, indent, html.EscapeString(b.URL),
+			timestampAttr("ADD_DATE", b.AddDate),
+			timestampAttr("LAST_MODIFIED", b.LastModified))
+		if b.IconURI != "" {
+			fmt.Fprintf(w, ` ICON_URI="%s"`, html.EscapeString(b.IconURI))
+		}
+		if len(b.Tags) > 0 {
+			fmt.Fprintf(w, ` TAGS="%s"`, html.EscapeString(strings.Join(b.Tags, ",")))
+		}
+		fmt.Fprintf(w, ">%s</A>\n", html.EscapeString(b.Title))
+		if b.Description != "" {
+			fmt.Fprintf(w, "%s    <DD>%s\n", indent, html.EscapeString(b.Description))
+		}
+	}
+
+	fmt.Fprintf(w, "%s</DL><p>\n", indent)
+}
+
+func attr(tok html.Token, name string) string {
+	for _, a := range tok.Attr {
+		if a.Key == name {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+func splitTags(s string) []string {
+	var tags []string
+	for _, tag := range strings.Split(s, ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// parseTimestamp reads a Unix timestamp. Browsers write seconds, but some
+// tools write milliseconds or microseconds, which are detected by size.
+func parseTimestamp(s string) time.Time {
+	n, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+	if err != nil || n <= 0 {
+		return time.Time{}
+	}
+	switch {
+	case n > 1e15:
+		return time.UnixMicro(n)
+	case n > 1e12:
+		return time.UnixMilli(n)
+	default:
+		return time.Unix(n, 0)
+	}
+}
+
+func timestampAttr(name string, t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return fmt.Sprintf(` %s="%d"`, name, t.Unix())
+}
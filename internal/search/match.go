@@ -0,0 +1,170 @@
+package search
+
+import (
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/goBookMarker/internal/models"
+)
+
+// Match reports whether b satisfies every part of the query. Text is
+// matched case-insensitively as a substring of the title, description,
+// URL or a tag name. Bookmarks carry no page text; use MatchPage to search
+// it too, as SQL does.
+func (q *Query) Match(b models.Bookmark) bool {
+	return q.MatchPage(b, "")
+}
+
+// MatchPage is Match for a bookmark whose page text is pageText, which is
+// searched like the other fields.
+func (q *Query) MatchPage(b models.Bookmark, pageText string) bool {
+	haystack := strings.ToLower(strings.Join([]string{
+		b.Title, b.Description, b.URL, strings.Join(b.Tags, " "), pageText,
+	}, "\n"))
+
+	for _, term := range append(append([]string(nil), q.Terms...), q.Phrases...) {
+		if !strings.Contains(haystack, strings.ToLower(term)) {
+			return false
+		}
+	}
+	for _, term := range q.ExcludeTerms {
+		if strings.Contains(haystack, strings.ToLower(term)) {
+			return false
+		}
+	}
+
+	for _, tag := range q.Tags {
+		if !hasTag(b, tag) {
+			return false
+		}
+	}
+	for _, tag := range q.ExcludeTags {
+		if hasTag(b, tag) {
+			return false
+		}
+	}
+
+	host := hostname(b.URL)
+	for _, site := range q.Sites {
+		if !matchesSite(host, site) {
+			return false
+		}
+	}
+	for _, site := range q.ExcludeSites {
+		if matchesSite(host, site) {
+			return false
+		}
+	}
+
+	if q.Favorite != nil && b.IsFavorite != *q.Favorite {
+		return false
+	}
+	if !q.Created.Contains(b.CreatedAt) || !q.Updated.Contains(b.UpdatedAt) {
+		return false
+	}
+	return true
+}
+
+// Filter returns the bookmarks that match the query.
+func (q *Query) Filter(bookmarks []models.Bookmark) []models.Bookmark {
+	var matched []models.Bookmark
+	for _, b := range bookmarks {
+		if q.Match(b) {
+			matched = append(matched, b)
+		}
+	}
+	return matched
+}
+
+// Highlights returns the sorted, non-overlapping ranges of text matched by
+// the query's positive terms and phrases.
+func (q *Query) Highlights(text string) []models.TextRange {
+	lower := strings.ToLower(text)
+	if len(lower) != len(text) {
+		// Lowercasing changed byte offsets; skip highlighting.
+		return nil
+	}
+
+	var ranges []models.TextRange
+	for _, term := range append(append([]string(nil), q.Terms...), q.Phrases...) {
+		term = strings.ToLower(term)
+		for offset := 0; offset < len(lower); {
+			i := strings.Index(lower[offset:], term)
+			if i < 0 {
+				break
+			}
+			start := offset + i
+			ranges = append(ranges, models.TextRange{Start: start, End: start + len(term)})
+			offset = start + len(term)
+		}
+	}
+	return mergeRanges(ranges)
+}
+
+// Result builds a SearchResult for a bookmark matched without full-text
+// ranking, highlighting the title and the first matching field as the
+// snippet.
+func (q *Query) Result(b models.Bookmark) models.SearchResult {
+	r := models.SearchResult{Bookmark: b, TitleHighlights: q.Highlights(b.Title)}
+	for _, field := range []string{b.Description, b.URL} {
+		if ranges := q.Highlights(field); len(ranges) > 0 {
+			r.Snippet, r.SnippetHighlights = field, ranges
+			break
+		}
+	}
+	return r
+}
+
+// mergeRanges sorts ranges and joins overlapping ones.
+func mergeRanges(ranges []models.TextRange) []models.TextRange {
+	if len(ranges) < 2 {
+		return ranges
+	}
+	sort.Slice(ranges, func(i, j int) bool {
+		return ranges[i].Start < ranges[j].Start
+	})
+	merged := ranges[:1]
+	for _, r := range ranges[1:] {
+		last := &merged[len(merged)-1]
+		if r.Start <= last.End {
+			if r.End > last.End {
+				last.End = r.End
+			}
+			continue
+		}
+		merged = append(merged, r)
+	}
+	return merged
+}
+
+func hasTag(b models.Bookmark, tag string) bool {
+	for _, t := range b.Tags {
+		if strings.EqualFold(t, tag) {
+			return true
+		}
+	}
+	return false
+}
+
+func hostname(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(u.Hostname())
+}
+
+// matchesSite reports whether host is site or one of its subdomains,
+// like siteCondition.
+func matchesSite(host, site string) bool {
+	site = normalizeSite(site)
+	return host == site || strings.HasSuffix(host, "."+site)
+}
+
+// normalizeSite returns the domain a site: filter matches, along with its
+// subdomains. "www." is dropped so that site:www.example.com also matches
+// example.com.
+func normalizeSite(site string) string {
+	return strings.TrimPrefix(strings.ToLower(site), "www.")
+}
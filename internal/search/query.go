@@ -0,0 +1,303 @@
+package search
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Query is a parsed bookmark search. Free text and filters combine with
+// AND; every filter can be negated with a leading '-'.
+//
+//	go "exact phrase" tag:go -tag:old site:github.com is:fav created:>2024-01-01
+type Query struct {
+	// Terms are free words, matched as prefixes by full-text search and as
+	// substrings otherwise.
+	Terms []string
+	// Phrases are quoted strings matched as a whole.
+	Phrases []string
+	// ExcludeTerms are negated words and phrases.
+	ExcludeTerms []string
+
+	Tags        []string
+	ExcludeTags []string
+
+	Sites        []string
+	ExcludeSites []string
+
+	// Favorite is nil when is:fav was not used.
+	Favorite *bool
+
+	Created DateRange
+	Updated DateRange
+}
+
+// DateRange bounds a timestamp. From is inclusive and To exclusive; a
+// zero value leaves that side open.
+type DateRange struct {
+	From time.Time
+	To   time.Time
+}
+
+// IsZero reports whether the range has no bounds.
+func (r DateRange) IsZero() bool {
+	return r.From.IsZero() && r.To.IsZero()
+}
+
+// Contains reports whether t lies within the range.
+func (r DateRange) Contains(t time.Time) bool {
+	if !r.From.IsZero() && t.Before(r.From) {
+		return false
+	}
+	if !r.To.IsZero() && !t.Before(r.To) {
+		return false
+	}
+	return true
+}
+
+// IsEmpty reports whether the query matches every bookmark.
+func (q *Query) IsEmpty() bool {
+	return len(q.Terms) == 0 && len(q.Phrases) == 0 && len(q.ExcludeTerms) == 0 &&
+		len(q.Tags) == 0 && len(q.ExcludeTags) == 0 &&
+		len(q.Sites) == 0 && len(q.ExcludeSites) == 0 &&
+		q.Favorite == nil && q.Created.IsZero() && q.Updated.IsZero()
+}
+
+// HasText reports whether the query has positive free-text terms that can
+// be ranked.
+func (q *Query) HasText() bool {
+	return len(q.Terms) > 0 || len(q.Phrases) > 0
+}
+
+// ParseError describes invalid query syntax. Pos is the byte offset of the
+// offending token in the input.
+type ParseError struct {
+	Pos int
+	Msg string
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("%s (at position %d)", e.Msg, e.Pos+1)
+}
+
+var knownFilters = map[string]bool{
+	"tag":     true,
+	"site":    true,
+	"is":      true,
+	"created": true,
+	"updated": true,
+}
+
+// dateLayout is the accepted format for created: and updated: values,
+// interpreted in the local time zone.
+const dateLayout = "2006-01-02"
+
+// Parse parses a search string. Unknown filters, empty filter values,
+// unterminated quotes and malformed dates are reported as *ParseError.
+func Parse(input string) (*Query, error) {
+	q := &Query{}
+	tokens, err := tokenize(input)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, tok := range tokens {
+		if err := q.add(tok); err != nil {
+			return nil, err
+		}
+	}
+	return q, nil
+}
+
+type token struct {
+	pos     int
+	negated bool
+	key     string // empty for free text
+	value   string
+	quoted  bool
+}
+
+func tokenize(input string) ([]token, error) {
+	var tokens []token
+	i := 0
+	for i < len(input) {
+		if isSpace(input[i]) {
+			i++
+			continue
+		}
+
+		tok := token{pos: i}
+		if input[i] == '-' && i+1 < len(input) && !isSpace(input[i+1]) {
+			tok.negated = true
+			i++
+		}
+
+		// key:value, where value may be quoted; "scheme://" starts a URL
+		if keyEnd := keyLength(input[i:]); keyEnd > 0 && !strings.HasPrefix(input[i+keyEnd+1:], "//") {
+			tok.key = strings.ToLower(input[i : i+keyEnd])
+			i += keyEnd + 1
+		}
+
+		if i < len(input) && input[i] == '"' {
+			end := strings.IndexByte(input[i+1:], '"')
+			if end < 0 {
+				return nil, &ParseError{Pos: i, Msg: "unterminated quote"}
+			}
+			tok.value = input[i+1 : i+1+end]
+			tok.quoted = true
+			i += end + 2
+		} else {
+			start := i
+			for i < len(input) && !isSpace(input[i]) {
+				i++
+			}
+			tok.value = input[start:i]
+		}
+		tokens = append(tokens, tok)
+	}
+	return tokens, nil
+}
+
+// keyLength returns the length of a leading "key:" filter name, or 0 if s
+// does not start with one.
+func keyLength(s string) int {
+	for i, r := range s {
+		if r == ':' {
+			return i
+		}
+		if !unicode.IsLetter(r) {
+			return 0
+		}
+	}
+	return 0
+}
+
+func isSpace(b byte) bool {
+	return b == ' ' || b == '\t' || b == '\n' || b == '\r'
+}
+
+func (q *Query) add(tok token) error {
+	value := strings.TrimSpace(tok.value)
+	if tok.key == "" {
+		if value == "" {
+			if tok.quoted {
+				return &ParseError{Pos: tok.pos, Msg: "empty phrase"}
+			}
+			return nil
+		}
+		if !tok.quoted && !hasWordChar(value) {
+			return nil
+		}
+		switch {
+		case tok.negated:
+			q.ExcludeTerms = append(q.ExcludeTerms, value)
+		case tok.quoted:
+			q.Phrases = append(q.Phrases, value)
+		default:
+			q.Terms = append(q.Terms, value)
+		}
+		return nil
+	}
+
+	if value == "" {
+		if !knownFilters[tok.key] {
+			// A trailing "word:" is free text, not a filter.
+			return q.add(token{pos: tok.pos, negated: tok.negated, value: tok.key})
+		}
+		return &ParseError{Pos: tok.pos, Msg: fmt.Sprintf("missing value for %s:", tok.key)}
+	}
+
+	switch tok.key {
+	case "tag":
+		if tok.negated {
+			q.ExcludeTags = append(q.ExcludeTags, value)
+		} else {
+			q.Tags = append(q.Tags, value)
+		}
+	case "site":
+		site := normalizeSite(value)
+		if tok.negated {
+			q.ExcludeSites = append(q.ExcludeSites, site)
+		} else {
+			q.Sites = append(q.Sites, site)
+		}
+	case "is":
+		switch strings.ToLower(value) {
+		case "fav", "favorite", "favourite":
+			fav := !tok.negated
+			q.Favorite = &fav
+		default:
+			return &ParseError{Pos: tok.pos, Msg: fmt.Sprintf("unknown value is:%s, expected is:fav", value)}
+		}
+	case "created", "updated":
+		if tok.negated {
+			return &ParseError{Pos: tok.pos, Msg: fmt.Sprintf("%s: cannot be negated", tok.key)}
+		}
+		r := &q.Created
+		if tok.key == "updated" {
+			r = &q.Updated
+		}
+		if err := parseDateFilter(r, value); err != nil {
+			return &ParseError{Pos: tok.pos, Msg: fmt.Sprintf("%s: %v", tok.key, err)}
+		}
+	default:
+		return &ParseError{
+			Pos: tok.pos,
+			Msg: fmt.Sprintf("unknown filter %s:, expected tag:, site:, is:, created: or updated:", tok.key),
+		}
+	}
+	return nil
+}
+
+// parseDateFilter narrows r by a value such as ">2024-01-01", "<=2024-06-30"
+// or "2024-03-15" (that whole day).
+func parseDateFilter(r *DateRange, value string) error {
+	op := ""
+	for _, prefix := range []string{">=", "<=", ">", "<", "="} {
+		if strings.HasPrefix(value, prefix) {
+			op = prefix
+			value = value[len(prefix):]
+			break
+		}
+	}
+
+	day, err := time.ParseInLocation(dateLayout, value, time.Local)
+	if err != nil {
+		return fmt.Errorf("invalid date %q, expected YYYY-MM-DD", value)
+	}
+	next := day.AddDate(0, 0, 1)
+
+	switch op {
+	case ">":
+		narrowFrom(r, next)
+	case ">=":
+		narrowFrom(r, day)
+	case "<":
+		narrowTo(r, day)
+	case "<=":
+		narrowTo(r, next)
+	default:
+		narrowFrom(r, day)
+		narrowTo(r, next)
+	}
+	return nil
+}
+
+func narrowFrom(r *DateRange, t time.Time) {
+	if r.From.IsZero() || t.After(r.From) {
+		r.From = t
+	}
+}
+
+func narrowTo(r *DateRange, t time.Time) {
+	if r.To.IsZero() || t.Before(r.To) {
+		r.To = t
+	}
+}
+
+func hasWordChar(s string) bool {
+	return strings.IndexFunc(s, func(r rune) bool {
+		return unicode.IsLetter(r) || unicode.IsDigit(r)
+	}) >= 0
+}
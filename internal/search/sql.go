@@ -0,0 +1,125 @@
+package search
+
+import (
+	"fmt"
+	"strings"
+)
+
+// sqlTimeLayout matches the format SQLite's datetime() produces, so bounds
+// compare correctly against CURRENT_TIMESTAMP defaults.
+const sqlTimeLayout = "2006-01-02 15:04:05"
+
+// SQL compiles the query into a WHERE expression over the bookmarks table
+// aliased as alias, returning the expression and its arguments. When
+// fullText is true the positive terms and phrases are left out because the
+// caller matches them against the FTS5 index with FTSMatch.
+func (q *Query) SQL(alias string, fullText bool) (string, []interface{}) {
+	var conds []string
+	var args []interface{}
+
+	if !fullText {
+		for _, term := range append(append([]string(nil), q.Terms...), q.Phrases...) {
+			cond, condArgs := textCondition(alias, term)
+			conds = append(conds, cond)
+			args = append(args, condArgs...)
+		}
+	}
+	for _, term := range q.ExcludeTerms {
+		cond, condArgs := textCondition(alias, term)
+		conds = append(conds, "NOT "+cond)
+		args = append(args, condArgs...)
+	}
+
+	for _, tag := range q.Tags {
+		conds = append(conds, tagCondition(alias))
+		args = append(args, tag)
+	}
+	for _, tag := range q.ExcludeTags {
+		conds = append(conds, "NOT "+tagCondition(alias))
+		args = append(args, tag)
+	}
+
+	for _, site := range q.Sites {
+		cond, condArgs := siteCondition(alias, site)
+		conds = append(conds, cond)
+		args = append(args, condArgs...)
+	}
+	for _, site := range q.ExcludeSites {
+		cond, condArgs := siteCondition(alias, site)
+		conds = append(conds, "NOT "+cond)
+		args = append(args, condArgs...)
+	}
+
+	if q.Favorite != nil {
+		conds = append(conds, fmt.Sprintf("%s.is_favorite = ?", alias))
+		args = append(args, *q.Favorite)
+	}
+
+	for _, dr := range []struct {
+		column string
+		r      DateRange
+	}{{"created_at", q.Created}, {"updated_at", q.Updated}} {
+		if !dr.r.From.IsZero() {
+			conds = append(conds, fmt.Sprintf("datetime(%s.%s) >= datetime(?)", alias, dr.column))
+			args = append(args, dr.r.From.UTC().Format(sqlTimeLayout))
+		}
+		if !dr.r.To.IsZero() {
+			conds = append(conds, fmt.Sprintf("datetime(%s.%s) < datetime(?)", alias, dr.column))
+			args = append(args, dr.r.To.UTC().Format(sqlTimeLayout))
+		}
+	}
+
+	if len(conds) == 0 {
+		return "1", nil
+	}
+	return strings.Join(conds, " AND "), args
+}
+
+// FTSMatch returns an FTS5 MATCH expression for the positive terms and
+// phrases, or "" if there are none. Terms match as prefixes. Everything is
+// quoted so user input is never interpreted as FTS5 syntax.
+func (q *Query) FTSMatch() string {
+	var parts []string
+	for _, term := range q.Terms {
+		parts = append(parts, ftsString(term)+"*")
+	}
+	for _, phrase := range q.Phrases {
+		parts = append(parts, ftsString(phrase))
+	}
+	return strings.Join(parts, " ")
+}
+
+func ftsString(s string) string {
+	return `"` + strings.ReplaceAll(s, `"`, `""`) + `"`
+}
+
+func textCondition(alias, term string) (string, []interface{}) {
+	pattern := "%" + escapeLike(term) + "%"
+	cond := fmt.Sprintf(`(%[1]s.title LIKE ? ESCAPE '\' OR %[1]s.description LIKE ? ESCAPE '\'
+		OR %[1]s.url LIKE ? ESCAPE '\' OR %[1]s.page_text LIKE ? ESCAPE '\'
+		OR EXISTS (SELECT 1 FROM bookmark_tags bt JOIN tags t ON t.id = bt.tag_id
+			WHERE bt.bookmark_id = %[1]s.id AND t.name LIKE ? ESCAPE '\'))`, alias)
+	return cond, []interface{}{pattern, pattern, pattern, pattern, pattern}
+}
+
+func tagCondition(alias string) string {
+	return fmt.Sprintf(`EXISTS (SELECT 1 FROM bookmark_tags bt JOIN tags t ON t.id = bt.tag_id
+		WHERE bt.bookmark_id = %s.id AND t.name = ? COLLATE NOCASE)`, alias)
+}
+
+// siteCondition matches the URL host against site and its subdomains,
+// with or without user info or an explicit port, like matchesSite.
+func siteCondition(alias, site string) (string, []interface{}) {
+	site = normalizeSite(site)
+	rest := fmt.Sprintf("substr(%[1]s.url, instr(%[1]s.url, '://') + 3)", alias)
+	authority := fmt.Sprintf("CASE WHEN instr(%[1]s, '/') > 0 THEN substr(%[1]s, 1, instr(%[1]s, '/') - 1) ELSE %[1]s END", rest)
+	host := fmt.Sprintf("lower(substr(%[1]s, instr(%[1]s, '@') + 1))", authority)
+	escaped := escapeLike(site)
+	cond := fmt.Sprintf(`(%[1]s = ? OR %[1]s LIKE ? ESCAPE '\' OR %[1]s LIKE ? ESCAPE '\' OR %[1]s LIKE ? ESCAPE '\')`, host)
+	return cond, []interface{}{site, "%." + escaped, escaped + ":%", "%." + escaped + ":%"}
+}
+
+func escapeLike(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+	return r.Replace(s)
+}
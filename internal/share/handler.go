@@ -1,132 +1,236 @@
 package share
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
-	"io"
-	"net/http"
 	"net/url"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/goBookMarker/internal/attachment"
+	"github.com/goBookMarker/internal/fetch"
 	"github.com/goBookMarker/internal/models"
+	"github.com/goBookMarker/internal/storage"
 )
 
+// ShareHandler receives content shared with the app. Shares are queued in
+// a ShareQueueRepository as they arrive, so none is lost while offline or
+// when the app stops, and Run processes them in the background.
 type ShareHandler struct {
-	// Channel for receiving shared content
-	SharedContent chan *SharedItem
+	// Fetcher downloads shared pages for their metadata.
+	Fetcher *fetch.Fetcher
+	// Attachments stores shared image data. Without it, only links to
+	// images can be shared.
+	Attachments *attachment.Store
+
+	queue   storage.ShareQueueRepository
+	wake    chan struct{}
+	changes chan struct{}
 }
 
 type SharedItem struct {
 	Type        string // url, image
-	Content     string // URL or base64 image data
+	Content     string // URL, or attachment URL for image data
 	Title       string
 	Description string
+	ImageURL    string
+	FaviconURL  string
+	// CanonicalURL is the URL the page declares as its own, if any.
+	CanonicalURL string
+	// AttachmentID is the stored image for shared image data. Content is
+	// then its attachment URL.
+	AttachmentID string
+	// Note is the text shared around the link, if any.
+	Note string
+	// Pending is set for a link in shared text whose page could not be
+	// fetched yet. The item has its URL alone until it is fetched again.
+	Pending bool `json:",omitempty"`
 }
 
-func NewShareHandler() *ShareHandler {
+func NewShareHandler(queue storage.ShareQueueRepository) *ShareHandler {
 	return &ShareHandler{
-		SharedContent: make(chan *SharedItem, 10),
+		Fetcher: fetch.New(),
+		queue:   queue,
+		wake:    make(chan struct{}, 1),
+		changes: make(chan struct{}, 1),
 	}
 }
 
-func (h *ShareHandler) HandleSharedContent(contentType, content string) error {
-	item := &SharedItem{}
+// Process turns shared content into items: a link with its page's
+// metadata, an image, or one item per link found in text, with the rest of
+// the text as their note. A page that cannot be fetched for good is kept
+// by its URL alone; if one cannot be fetched for now, Process fails with an
+// error for which fetch.Temporary reports true. Each link in text falls
+// back on its own: the items are returned along with the error, the links
+// that failed marked Pending, for FetchPending to try again.
+func (h *ShareHandler) Process(ctx context.Context, content string) ([]*SharedItem, error) {
+	content = strings.TrimSpace(content)
 
 	// Determine content type and process accordingly
 	if isURL(content) {
-		item.Type = "url"
-		if err := h.processURL(item, content); err != nil {
-			return fmt.Errorf("failed to process URL: %v", err)
+		item := &SharedItem{Type: "url"}
+		if err := h.processURL(ctx, item, content); err != nil {
+			return nil, fmt.Errorf("failed to process URL: %w", err)
 		}
+		return []*SharedItem{item}, nil
 	} else if isImage(content) {
-		item.Type = "image"
+		item := &SharedItem{Type: "image"}
 		if err := h.processImage(item, content); err != nil {
-			return fmt.Errorf("failed to process image: %v", err)
+			return nil, fmt.Errorf("failed to process image: %w", err)
 		}
-	} else {
-		return fmt.Errorf("unsupported content type")
+		return []*SharedItem{item}, nil
+	} else if urls, note := ExtractURLs(content); len(urls) > 0 {
+		return h.processTextURLs(ctx, urls, note)
 	}
+	return nil, fmt.Errorf("unsupported content type")
+}
 
-	// Send to channel for processing
-	h.SharedContent <- item
-	return nil
+// processTextURLs makes an item for each link found in shared text, with
+// the rest of the text as its note, and fetches their pages.
+func (h *ShareHandler) processTextURLs(ctx context.Context, urls []string, note string) ([]*SharedItem, error) {
+	items := make([]*SharedItem, len(urls))
+	for i, u := range urls {
+		items[i] = &SharedItem{Type: "url", Content: u, Note: note, Pending: true}
+	}
+	return items, h.FetchPending(ctx, items)
 }
 
-func (h *ShareHandler) processURL(item *SharedItem, urlStr string) error {
+// FetchPending fetches the pages of the items marked Pending, a few at a
+// time. An item whose page cannot be fetched for now stays Pending, by its
+// URL alone, and the returned error, for which fetch.Temporary reports
+// true, names its link.
+func (h *ShareHandler) FetchPending(ctx context.Context, items []*SharedItem) error {
+	errs := make([]error, len(items))
+	sem := make(chan struct{}, 4)
+	var wg sync.WaitGroup
+	for i, item := range items {
+		if !item.Pending {
+			continue
+		}
+		wg.Add(1)
+		go func(i int, item *SharedItem) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			fetched := &SharedItem{Type: "url", Note: item.Note}
+			if err := h.processURL(ctx, fetched, item.Content); err != nil {
+				errs[i] = fmt.Errorf("failed to process %s: %w", item.Content, err)
+				return
+			}
+			*item = *fetched
+		}(i, item)
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+func (h *ShareHandler) processURL(ctx context.Context, item *SharedItem, urlStr string) error {
 	item.Content = urlStr
 
-	// Fetch metadata
-	resp, err := http.Get(urlStr)
+	// Fetch metadata. Only HTML pages describe themselves; anything else
+	// keeps just the URL, as does a page that will not be fetched however
+	// often it is tried.
+	resp, err := h.Fetcher.Fetch(ctx, urlStr, "text/html", "application/xhtml+xml")
 	if err != nil {
-		return err
+		if fetch.Temporary(err) {
+			return err
+		}
+		return nil
 	}
-	defer resp.Body.Close()
 
-	// Read the first 1MB of the response
-	body, err := io.ReadAll(io.LimitReader(resp.Body, 1024*1024))
+	meta, err := ExtractMetadata(bytes.NewReader(resp.Body), resp.ContentType, resp.URL)
 	if err != nil {
-		return err
+		return nil
 	}
-
-	// Extract title and description from HTML
-	bodyStr := string(body)
-	item.Title = extractMetaTag(bodyStr, "title")
-	item.Description = extractMetaTag(bodyStr, "description")
+	item.Title = meta.Title
+	item.Description = meta.Description
+	item.ImageURL = meta.ImageURL
+	item.FaviconURL = meta.FaviconURL
+	item.CanonicalURL = meta.CanonicalURL
 
 	return nil
 }
 
 func (h *ShareHandler) processImage(item *SharedItem, imageData string) error {
-	item.Content = imageData
 	item.Title = "Shared Image"
 	item.Description = fmt.Sprintf("Image shared on %s", time.Now().Format("Jan 2, 2006"))
+
+	if id, ok := attachment.ParseURL(imageData); ok {
+		// Image data that was stored already.
+		item.Content = imageData
+		item.AttachmentID = id
+		return nil
+	}
+	if !strings.HasPrefix(imageData, "data:") {
+		// A link to an image is bookmarked as it is.
+		item.Content = imageData
+		item.ImageURL = imageData
+		return nil
+	}
+	if h.Attachments == nil {
+		return fmt.Errorf("no attachment store for shared image data")
+	}
+	a, err := h.Attachments.PutDataURI(imageData)
+	if err != nil {
+		return err
+	}
+	item.Content = attachment.URL(a.ID)
+	item.AttachmentID = a.ID
 	return nil
 }
 
+// BookmarkURL returns the URL to bookmark for a shared link: the page's
+// canonical URL if it declared one, otherwise the shared URL. A canonical
+// URL pointing at the site's home page from a deeper page is ignored, as
+// that is a common misconfiguration.
+func (item *SharedItem) BookmarkURL() string {
+	if item.Type != "url" || !isURL(item.CanonicalURL) {
+		return item.Content
+	}
+	canonical, _ := url.Parse(item.CanonicalURL)
+	shared, err := url.Parse(item.Content)
+	if err == nil && strings.Trim(canonical.Path, "/") == "" && strings.Trim(shared.Path, "/") != "" {
+		return item.Content
+	}
+	return item.CanonicalURL
+}
+
 func isURL(str string) bool {
 	u, err := url.Parse(str)
 	return err == nil && (u.Scheme == "http" || u.Scheme == "https")
 }
 
 func isImage(str string) bool {
-	return strings.HasPrefix(str, "data:image/") ||
+	_, stored := attachment.ParseURL(str)
+	return stored || strings.HasPrefix(str, "data:image/") ||
 		strings.HasSuffix(strings.ToLower(str), ".jpg") ||
 		strings.HasSuffix(strings.ToLower(str), ".jpeg") ||
 		strings.HasSuffix(strings.ToLower(str), ".png") ||
 		strings.HasSuffix(strings.ToLower(str), ".gif")
 }
 
-func extractMetaTag(html, tag string) string {
-	tagStart := strings.Index(html, fmt.Sprintf(`<meta name="%s"`, tag))
-	if tagStart == -1 {
-		tagStart = strings.Index(html, fmt.Sprintf(`<meta property="%s"`, tag))
-	}
-	if tagStart == -1 {
-		return ""
-	}
-
-	contentStart := strings.Index(html[tagStart:], `content="`)
-	if contentStart == -1 {
-		return ""
-	}
-	contentStart += tagStart + 9
-
-	contentEnd := strings.Index(html[contentStart:], `"`)
-	if contentEnd == -1 {
-		return ""
-	}
-	contentEnd += contentStart
-
-	return html[contentStart:contentEnd]
-}
-
 // Convert SharedItem to Bookmark
 func (item *SharedItem) ToBookmark() *models.Bookmark {
 	return &models.Bookmark{
-		URL:         item.Content,
+		URL:         item.BookmarkURL(),
 		Title:       item.Title,
-		Description: item.Description,
+		Description: joinNonEmpty("\n\n", item.Note, item.Description),
+		ImageURL:    item.ImageURL,
+		FaviconURL:  item.FaviconURL,
 		CreatedAt:   time.Now(),
 		UpdatedAt:   time.Now(),
 	}
 }
+
+func joinNonEmpty(sep string, values ...string) string {
+	var parts []string
+	for _, v := range values {
+		if v != "" {
+			parts = append(parts, v)
+		}
+	}
+	return strings.Join(parts, sep)
+}
@@ -0,0 +1,333 @@
+package share
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+	"golang.org/x/net/html/charset"
+)
+
+// Metadata is what a page says about itself. URLs are absolute.
+type Metadata struct {
+	Title        string
+	Description  string
+	SiteName     string
+	CanonicalURL string
+	ImageURL     string
+	FaviconURL   string
+}
+
+// metaCandidates collects every source of each field while the document is
+// read; the most specific one wins once it is done.
+type metaCandidates struct {
+	title, ogTitle, twitterTitle, metaTitle string
+	description, ogDescription, twitterDesc string
+	siteName                                string
+	canonical, ogURL                        string
+	ogImage, twitterImage                   string
+	icon, appleIcon                         string
+	base                                    string
+	jsonLD                                  []jsonLDThing
+}
+
+// jsonLDThing is the part of a schema.org JSON-LD object the extractor
+// uses.
+type jsonLDThing struct {
+	Headline    string
+	Name        string
+	Description string
+	Image       string
+}
+
+// ExtractMetadata reads an HTML document and returns its metadata, taking
+// OpenGraph and Twitter Card tags over <title> and plain meta tags, and
+// JSON-LD as a fallback. contentType is the Content-Type header of the
+// response, if any; the document is decoded using its charset, the byte
+// order mark or the document's own declaration, in that order. Relative
+// URLs are resolved against base, or the document's <base> element.
+// Without an icon link the favicon is assumed at /favicon.ico on base's
+// host.
+func ExtractMetadata(r io.Reader, contentType string, base *url.URL) (*Metadata, error) {
+	decoded, err := charset.NewReader(r, contentType)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode page: %w", err)
+	}
+
+	var c metaCandidates
+	var text *strings.Builder // inside <title> or a JSON-LD <script>
+	var target *string
+	svgDepth := 0
+	var jsonLD strings.Builder
+	inJSONLD := false
+
+	z := html.NewTokenizer(decoded)
+	for {
+		tt := z.Next()
+		switch tt {
+		case html.ErrorToken:
+			if z.Err() != io.EOF {
+				return nil, fmt.Errorf("failed to read page: %w", z.Err())
+			}
+			return c.metadata(base), nil
+
+		case html.TextToken:
+			switch {
+			case inJSONLD:
+				jsonLD.Write(z.Text())
+			case text != nil:
+				text.Write(z.Text())
+			}
+
+		case html.StartTagToken, html.SelfClosingTagToken:
+			tok := z.Token()
+			switch tok.DataAtom {
+			case atom.Svg:
+				if tt == html.StartTagToken {
+					svgDepth++
+				}
+			case atom.Title:
+				// <title> inside inline SVG labels the image, not the page.
+				if svgDepth == 0 && c.title == "" && tt == html.StartTagToken {
+					text, target = &strings.Builder{}, &c.title
+				}
+			case atom.Meta:
+				c.addMeta(tok)
+			case atom.Link:
+				c.addLink(tok)
+			case atom.Base:
+				if c.base == "" {
+					c.base = attr(tok, "href")
+				}
+			case atom.Script:
+				if tt == html.StartTagToken && strings.EqualFold(strings.TrimSpace(attr(tok, "type")), "application/ld+json") {
+					inJSONLD = true
+					jsonLD.Reset()
+				}
+			}
+
+		case html.EndTagToken:
+			tok := z.Token()
+			switch tok.DataAtom {
+			case atom.Svg:
+				if svgDepth > 0 {
+					svgDepth--
+				}
+			case atom.Title:
+				if text != nil {
+					*target = collapseSpace(text.String())
+					text, target = nil, nil
+				}
+			case atom.Script:
+				if inJSONLD {
+					c.jsonLD = append(c.jsonLD, parseJSONLD(jsonLD.String())...)
+					inJSONLD = false
+				}
+			}
+		}
+	}
+}
+
+// addMeta records a <meta> tag. OpenGraph uses property=, the rest name=,
+// but pages mix them up so both are accepted for every key.
+func (c *metaCandidates) addMeta(tok html.Token) {
+	key := strings.ToLower(strings.TrimSpace(attr(tok, "property")))
+	if key == "" {
+		key = strings.ToLower(strings.TrimSpace(attr(tok, "name")))
+	}
+	content := collapseSpace(attr(tok, "content"))
+	if key == "" || content == "" {
+		return
+	}
+	set := func(field *string) {
+		if *field == "" {
+			*field = content
+		}
+	}
+	switch key {
+	case "og:title":
+		set(&c.ogTitle)
+	case "twitter:title":
+		set(&c.twitterTitle)
+	case "title":
+		set(&c.metaTitle)
+	case "og:description":
+		set(&c.ogDescription)
+	case "twitter:description":
+		set(&c.twitterDesc)
+	case "description":
+		set(&c.description)
+	case "og:site_name":
+		set(&c.siteName)
+	case "og:url":
+		set(&c.ogURL)
+	case "og:image", "og:image:url", "og:image:secure_url":
+		set(&c.ogImage)
+	case "twitter:image", "twitter:image:src":
+		set(&c.twitterImage)
+	}
+}
+
+// addLink records canonical and icon <link> tags. rel is a list of
+// space-separated keywords.
+func (c *metaCandidates) addLink(tok html.Token) {
+	href := strings.TrimSpace(attr(tok, "href"))
+	if href == "" {
+		return
+	}
+	for _, rel := range strings.Fields(strings.ToLower(attr(tok, "rel"))) {
+		switch rel {
+		case "canonical":
+			if c.canonical == "" {
+				c.canonical = href
+			}
+		case "icon":
+			if c.icon == "" {
+				c.icon = href
+			}
+		case "apple-touch-icon", "apple-touch-icon-precomposed":
+			if c.appleIcon == "" {
+				c.appleIcon = href
+			}
+		}
+	}
+}
+
+// metadata picks the best candidate for each field and resolves URLs.
+func (c *metaCandidates) metadata(base *url.URL) *Metadata {
+	var ld jsonLDThing
+	for _, thing := range c.jsonLD {
+		if ld.Headline == "" {
+			ld.Headline = thing.Headline
+		}
+		if ld.Name == "" {
+			ld.Name = thing.Name
+		}
+		if ld.Description == "" {
+			ld.Description = thing.Description
+		}
+		if ld.Image == "" {
+			ld.Image = thing.Image
+		}
+	}
+
+	page := base
+	if c.base != "" {
+		if b := resolveURL(base, c.base); b != "" {
+			base, _ = url.Parse(b)
+		}
+	}
+
+	m := &Metadata{
+		Title:        firstNonEmpty(c.ogTitle, c.twitterTitle, c.title, ld.Headline, ld.Name, c.metaTitle),
+		Description:  firstNonEmpty(c.ogDescription, c.twitterDesc, c.description, ld.Description),
+		SiteName:     c.siteName,
+		CanonicalURL: resolveURL(base, firstNonEmpty(c.canonical, c.ogURL)),
+		ImageURL:     resolveURL(base, firstNonEmpty(c.ogImage, c.twitterImage, ld.Image)),
+		FaviconURL:   resolveURL(base, firstNonEmpty(c.icon, c.appleIcon)),
+	}
+	if m.FaviconURL == "" && page != nil && (page.Scheme == "http" || page.Scheme == "https") {
+		m.FaviconURL = (&url.URL{Scheme: page.Scheme, Host: page.Host, Path: "/favicon.ico"}).String()
+	}
+	return m
+}
+
+// parseJSONLD decodes the contents of a JSON-LD script, which may hold one
+// object, an array of them or an @graph. Invalid scripts are ignored.
+func parseJSONLD(data string) []jsonLDThing {
+	var v interface{}
+	if err := json.Unmarshal([]byte(strings.TrimSpace(data)), &v); err != nil {
+		return nil
+	}
+	var things []jsonLDThing
+	var walk func(v interface{})
+	walk = func(v interface{}) {
+		switch v := v.(type) {
+		case []interface{}:
+			for _, item := range v {
+				walk(item)
+			}
+		case map[string]interface{}:
+			if graph, ok := v["@graph"]; ok {
+				walk(graph)
+			}
+			thing := jsonLDThing{
+				Headline:    jsonLDString(v["headline"]),
+				Name:        jsonLDString(v["name"]),
+				Description: jsonLDString(v["description"]),
+				Image:       jsonLDString(v["image"]),
+			}
+			if thing != (jsonLDThing{}) {
+				things = append(things, thing)
+			}
+		}
+	}
+	walk(v)
+	return things
+}
+
+// jsonLDString returns a text or URL value, which may be a plain string, an
+// object with a url, or a list of either.
+func jsonLDString(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return collapseSpace(html.UnescapeString(v))
+	case []interface{}:
+		for _, item := range v {
+			if s := jsonLDString(item); s != "" {
+				return s
+			}
+		}
+	case map[string]interface{}:
+		return jsonLDString(v["url"])
+	}
+	return ""
+}
+
+// resolveURL returns ref resolved against base, or "" if ref is empty or
+// does not resolve to an http(s) or data URL.
+func resolveURL(base *url.URL, ref string) string {
+	ref = strings.TrimSpace(ref)
+	if ref == "" {
+		return ""
+	}
+	u, err := url.Parse(ref)
+	if err != nil {
+		return ""
+	}
+	if base != nil {
+		u = base.ResolveReference(u)
+	}
+	switch u.Scheme {
+	case "http", "https", "data":
+		return u.String()
+	}
+	return ""
+}
+
+func attr(tok html.Token, name string) string {
+	for _, a := range tok.Attr {
+		if a.Key == name {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// collapseSpace trims s and replaces runs of whitespace with one space.
+func collapseSpace(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
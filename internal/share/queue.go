@@ -0,0 +1,309 @@
+package share
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/goBookMarker/internal/fetch"
+	"github.com/goBookMarker/internal/models"
+)
+
+const (
+	// MaxShareAttempts is how many times a share is processed before it is
+	// marked failed.
+	MaxShareAttempts = 6
+
+	// retryDelay is the wait after the first temporary failure; it doubles
+	// with each further one, up to maxRetryDelay.
+	retryDelay    = 30 * time.Second
+	maxRetryDelay = 30 * time.Minute
+)
+
+// HandleSharedContent queues content shared with the app. It only stores
+// the share, so it returns at once, offline too; Run processes it.
+func (h *ShareHandler) HandleSharedContent(contentType, content string) error {
+	content = strings.TrimSpace(content)
+	if content == "" {
+		return fmt.Errorf("nothing was shared")
+	}
+	now := time.Now()
+	share := models.QueuedShare{
+		ID:            uuid.New().String(),
+		ContentType:   contentType,
+		Content:       content,
+		Status:        models.SharePending,
+		NextAttemptAt: now,
+		CreatedAt:     now,
+		UpdatedAt:     now,
+	}
+	if err := h.queue.EnqueueShare(share); err != nil {
+		return err
+	}
+	signal(h.wake)
+	signal(h.changes)
+	return nil
+}
+
+// Run processes queued shares until ctx is done: each pending share when
+// it is due, trying again later after a temporary failure such as being
+// offline, with the delay doubling each time. Shares still pending when
+// the app stopped are picked up when it runs again.
+func (h *ShareHandler) Run(ctx context.Context) error {
+	for {
+		next, err := h.processDue(ctx)
+		if err != nil {
+			log.Printf("failed to process shares: %v", err)
+			next = time.Now().Add(retryDelay)
+		}
+
+		var timer *time.Timer
+		var due <-chan time.Time
+		if !next.IsZero() {
+			timer = time.NewTimer(time.Until(next))
+			due = timer.C
+		}
+		select {
+		case <-ctx.Done():
+		case <-h.wake:
+		case <-due:
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+	}
+}
+
+// processDue processes the pending shares that are due and returns when
+// the next one will be, or the zero time if none is pending.
+func (h *ShareHandler) processDue(ctx context.Context) (time.Time, error) {
+	shares, err := h.queue.GetQueuedShares()
+	if err != nil {
+		return time.Time{}, err
+	}
+	var next time.Time
+	for _, share := range shares {
+		if share.Status != models.SharePending {
+			continue
+		}
+		if share.NextAttemptAt.After(time.Now()) {
+			if next.IsZero() || share.NextAttemptAt.Before(next) {
+				next = share.NextAttemptAt
+			}
+			continue
+		}
+		if err := h.process(ctx, &share); err != nil {
+			return time.Time{}, err
+		}
+		if ctx.Err() != nil {
+			return time.Time{}, nil
+		}
+		if share.Status == models.SharePending && (next.IsZero() || share.NextAttemptAt.Before(next)) {
+			next = share.NextAttemptAt
+		}
+	}
+	return next, nil
+}
+
+// process makes one attempt at a share and stores the outcome. Of shared
+// text, only the links that failed last time are fetched again; once the
+// attempts run out, they are kept by their URLs alone.
+func (h *ShareHandler) process(ctx context.Context, share *models.QueuedShare) error {
+	var items []*SharedItem
+	var err error
+	if len(share.Items) > 0 {
+		if err := json.Unmarshal(share.Items, &items); err != nil {
+			return fmt.Errorf("failed to decode shared items: %w", err)
+		}
+		err = h.FetchPending(ctx, items)
+	} else {
+		items, err = h.Process(ctx, share.Content)
+	}
+	if ctx.Err() != nil {
+		// Stopped rather than failed; the attempt does not count.
+		return nil
+	}
+
+	share.Attempts++
+	share.UpdatedAt = time.Now()
+	switch {
+	case err == nil:
+		data, err := json.Marshal(items)
+		if err != nil {
+			return fmt.Errorf("failed to encode shared items: %w", err)
+		}
+		share.Status = models.ShareReady
+		share.Items = data
+		share.LastError = ""
+		// Shared image data is kept as an attachment now, so the queue
+		// need not hold on to it too.
+		if len(items) == 1 && items[0].AttachmentID != "" {
+			share.Content = items[0].Content
+		}
+	case fetch.Temporary(err) && share.Attempts < MaxShareAttempts:
+		share.LastError = err.Error()
+		share.NextAttemptAt = share.UpdatedAt.Add(backoff(share.Attempts))
+		if items != nil {
+			data, err := json.Marshal(items)
+			if err != nil {
+				return fmt.Errorf("failed to encode shared items: %w", err)
+			}
+			share.Items = data
+		}
+	case items != nil:
+		for _, item := range items {
+			item.Pending = false
+		}
+		data, err := json.Marshal(items)
+		if err != nil {
+			return fmt.Errorf("failed to encode shared items: %w", err)
+		}
+		share.Status = models.ShareReady
+		share.Items = data
+		share.LastError = ""
+	default:
+		share.Status = models.ShareFailed
+		share.LastError = err.Error()
+	}
+
+	if err := h.queue.UpdateQueuedShare(*share); err != nil {
+		return err
+	}
+	signal(h.changes)
+	return nil
+}
+
+// backoff returns the delay before the next attempt at a share that has
+// failed attempts times.
+func backoff(attempts int) time.Duration {
+	delay := retryDelay
+	for i := 1; i < attempts && delay < maxRetryDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, maxRetryDelay)
+}
+
+// Shares returns the queued shares, oldest first.
+func (h *ShareHandler) Shares() ([]models.QueuedShare, error) {
+	return h.queue.GetQueuedShares()
+}
+
+// Items returns the items of a queued share. A share that is not ready
+// yet, or failed, gives its links without page details, except for those
+// of shared text that were already fetched.
+func (h *ShareHandler) Items(share models.QueuedShare) ([]*SharedItem, error) {
+	if len(share.Items) > 0 {
+		var items []*SharedItem
+		if err := json.Unmarshal(share.Items, &items); err != nil {
+			return nil, fmt.Errorf("failed to decode shared items: %w", err)
+		}
+		return items, nil
+	}
+
+	content := strings.TrimSpace(share.Content)
+	if isURL(content) {
+		return []*SharedItem{{Type: "url", Content: content}}, nil
+	} else if isImage(content) {
+		if strings.HasPrefix(content, "data:") {
+			return nil, fmt.Errorf("shared image was not stored")
+		}
+		item := &SharedItem{Type: "image"}
+		if err := h.processImage(item, content); err != nil {
+			return nil, err
+		}
+		return []*SharedItem{item}, nil
+	} else if urls, note := ExtractURLs(content); len(urls) > 0 {
+		items := make([]*SharedItem, len(urls))
+		for i, u := range urls {
+			items[i] = &SharedItem{Type: "url", Content: u, Note: note}
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("unsupported content type")
+}
+
+// Retry processes a failed share again, as if it had just been shared.
+func (h *ShareHandler) Retry(id string) error {
+	share, err := h.find(id)
+	if err != nil {
+		return err
+	}
+	share.Status = models.SharePending
+	share.Attempts = 0
+	share.LastError = ""
+	share.Items = nil
+	share.NextAttemptAt = time.Now()
+	share.UpdatedAt = share.NextAttemptAt
+	if err := h.queue.UpdateQueuedShare(share); err != nil {
+		return err
+	}
+	signal(h.wake)
+	signal(h.changes)
+	return nil
+}
+
+// Resume tries pending shares now instead of waiting for their next
+// attempt. Call it when the device comes back online.
+func (h *ShareHandler) Resume() error {
+	shares, err := h.queue.GetQueuedShares()
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	for _, share := range shares {
+		if share.Status != models.SharePending || !share.NextAttemptAt.After(now) {
+			continue
+		}
+		share.NextAttemptAt = now
+		if err := h.queue.UpdateQueuedShare(share); err != nil {
+			return err
+		}
+	}
+	signal(h.wake)
+	return nil
+}
+
+// Remove takes a share off the queue, once it is saved or dismissed.
+func (h *ShareHandler) Remove(id string) error {
+	if err := h.queue.DeleteQueuedShare(id); err != nil {
+		return err
+	}
+	signal(h.changes)
+	return nil
+}
+
+// Changes returns a channel that receives a value after the queue
+// changes, for the UI to redraw. Changes in quick succession may be
+// reported once.
+func (h *ShareHandler) Changes() <-chan struct{} {
+	return h.changes
+}
+
+func (h *ShareHandler) find(id string) (models.QueuedShare, error) {
+	shares, err := h.queue.GetQueuedShares()
+	if err != nil {
+		return models.QueuedShare{}, err
+	}
+	for _, share := range shares {
+		if share.ID == id {
+			return share, nil
+		}
+	}
+	return models.QueuedShare{}, fmt.Errorf("share %s not found", id)
+}
+
+// signal sends on a channel with room for one value without blocking.
+func signal(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
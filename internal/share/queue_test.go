@@ -0,0 +1,208 @@
+package share
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"image"
+	"image/png"
+	"io"
+	"net/http"
+	"strings"
+	gosync "sync"
+	"testing"
+	"time"
+
+	"github.com/goBookMarker/internal/attachment"
+	"github.com/goBookMarker/internal/fetch"
+	"github.com/goBookMarker/internal/models"
+	"github.com/goBookMarker/internal/storage"
+)
+
+func pngDataURI(t *testing.T) string {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, 2, 2))); err != nil {
+		t.Fatal(err)
+	}
+	return "data:image/png;base64," + base64.StdEncoding.EncodeToString(buf.Bytes())
+}
+
+func TestSharedImageDataIsNotKeptInQueue(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemoryStore()
+	h := NewShareHandler(store)
+	h.Attachments = attachment.NewStore(t.TempDir(), store)
+
+	if err := h.HandleSharedContent("image/png", pngDataURI(t)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := h.processDue(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	shares, err := h.Shares()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(shares) != 1 || shares[0].Status != models.ShareReady {
+		t.Fatalf("shares = %+v, want one ready share", shares)
+	}
+	share := shares[0]
+	id, ok := attachment.ParseURL(share.Content)
+	if !ok {
+		t.Fatalf("queued content is %.40q, want the attachment URL", share.Content)
+	}
+	items, err := h.Items(share)
+	if err != nil || len(items) != 1 || items[0].AttachmentID != id {
+		t.Fatalf("Items = %+v, %v, want the stored image", items, err)
+	}
+
+	// Processed again, as after Retry, the share still gives the image.
+	share.Status, share.Items = models.SharePending, nil
+	if items, err := h.Items(share); err != nil || len(items) != 1 || items[0].AttachmentID != id {
+		t.Fatalf("Items of the pending share = %+v, %v, want the stored image", items, err)
+	}
+	items, err = h.Process(ctx, share.Content)
+	if err != nil || len(items) != 1 || items[0].Type != "image" || items[0].AttachmentID != id {
+		t.Fatalf("Process = %+v, %v, want the stored image", items, err)
+	}
+
+	// The attachment is not collected while only the queue refers to it.
+	if err := h.Retry(share.ID); err != nil {
+		t.Fatal(err)
+	}
+	orphans, err := store.OrphanedAttachments(time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(orphans) != 0 {
+		t.Fatalf("attachment %s collected while queued", orphans[0].ID)
+	}
+}
+
+// pages is an http.RoundTripper serving a page titled after its host, or
+// 503 Service Unavailable for hosts that are down.
+type pages struct {
+	mu       gosync.Mutex
+	down     map[string]bool
+	requests map[string]int
+}
+
+func (p *pages) RoundTrip(req *http.Request) (*http.Response, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.requests[req.URL.Host]++
+	resp := &http.Response{StatusCode: http.StatusOK, Header: make(http.Header), Request: req}
+	if p.down[req.URL.Host] {
+		resp.StatusCode = http.StatusServiceUnavailable
+		resp.Body = io.NopCloser(strings.NewReader(""))
+		return resp, nil
+	}
+	resp.Header.Set("Content-Type", "text/html")
+	resp.Body = io.NopCloser(strings.NewReader("<title>" + req.URL.Host + "</title>"))
+	return resp, nil
+}
+
+func (p *pages) setDown(host string, down bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.down[host] = down
+}
+
+func (p *pages) count(host string) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.requests[host]
+}
+
+// newTextShare queues text linking to an up and a down host and makes
+// the first attempt at it.
+func newTextShare(t *testing.T) (*ShareHandler, *pages) {
+	t.Helper()
+	p := &pages{down: map[string]bool{"down.example": true}, requests: make(map[string]int)}
+	h := NewShareHandler(storage.NewMemoryStore())
+	h.Fetcher = fetch.NewWithTransport(p)
+	h.Fetcher.CacheTTL = 0
+	if err := h.HandleSharedContent("text/plain", "Look https://up.example/ and https://down.example/ via app"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := h.processDue(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return h, p
+}
+
+// onlyShare returns the queued share and its items.
+func onlyShare(t *testing.T, h *ShareHandler) (models.QueuedShare, []*SharedItem) {
+	t.Helper()
+	shares, err := h.Shares()
+	if err != nil || len(shares) != 1 {
+		t.Fatalf("Shares = %+v, %v, want one share", shares, err)
+	}
+	items, err := h.Items(shares[0])
+	if err != nil || len(items) != 2 {
+		t.Fatalf("Items = %+v, %v, want two", items, err)
+	}
+	return shares[0], items
+}
+
+func TestTextShareRetriesOnlyFailedLinks(t *testing.T) {
+	h, p := newTextShare(t)
+
+	share, items := onlyShare(t, h)
+	if share.Status != models.SharePending || !strings.Contains(share.LastError, "down.example") {
+		t.Fatalf("share is %s (%s), want pending on down.example", share.Status, share.LastError)
+	}
+	if items[0].Title != "up.example" || items[0].Pending {
+		t.Errorf("fetched link = %+v, want its page details", items[0])
+	}
+	if items[1].Content != "https://down.example/" || !items[1].Pending || items[1].Note == "" {
+		t.Errorf("failed link = %+v, want it pending by its URL with the note", items[1])
+	}
+
+	p.setDown("down.example", false)
+	if err := h.Resume(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := h.processDue(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	share, items = onlyShare(t, h)
+	if share.Status != models.ShareReady {
+		t.Fatalf("share is %s (%s), want ready", share.Status, share.LastError)
+	}
+	if items[0].Title != "up.example" || items[1].Title != "down.example" || items[1].Pending {
+		t.Errorf("items = %+v %+v, want both with page details", items[0], items[1])
+	}
+	if n := p.count("up.example"); n != 1 {
+		t.Errorf("up.example fetched %d times, want once", n)
+	}
+}
+
+func TestTextShareKeepsFailedLinksByURL(t *testing.T) {
+	h, p := newTextShare(t)
+	for i := 1; i < MaxShareAttempts; i++ {
+		if err := h.Resume(); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := h.processDue(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Out of attempts, the link falls back to its URL on its own.
+	share, items := onlyShare(t, h)
+	if share.Status != models.ShareReady {
+		t.Fatalf("share is %s (%s), want ready", share.Status, share.LastError)
+	}
+	if items[0].Title != "up.example" {
+		t.Errorf("fetched link = %+v, want its page details kept", items[0])
+	}
+	if items[1].Content != "https://down.example/" || items[1].Title != "" || items[1].Pending {
+		t.Errorf("failed link = %+v, want it by its URL alone", items[1])
+	}
+	if n := p.count("down.example"); n != MaxShareAttempts {
+		t.Errorf("down.example fetched %d times, want %d", n, MaxShareAttempts)
+	}
+}
@@ -0,0 +1,88 @@
+package share
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/goBookMarker/internal/urlnorm"
+)
+
+// MaxTextURLs is the most links taken from one shared text.
+const MaxTextURLs = 20
+
+// urlPattern finds link candidates in text: anything from http://,
+// https:// or www. up to whitespace or a character that cannot appear in a
+// URL as written.
+var urlPattern = regexp.MustCompile(`(?i)\b(?:https?://|www\.)[^\s<>"\x{00AB}\x{00BB}\x{201C}\x{201D}]+`)
+
+// ExtractURLs finds the links in shared text such as "Check this out
+// https://example.com/a via @app" and returns them in order, without
+// duplicates, together with the rest of the text as a note. Links starting
+// with www. get https://. Punctuation that ends a sentence or closes a
+// bracket opened before the link is not taken as part of it.
+func ExtractURLs(text string) (urls []string, note string) {
+	seen := make(map[string]bool)
+	var rest strings.Builder
+	last := 0
+	for _, loc := range urlPattern.FindAllStringIndex(text, -1) {
+		candidate := trimURL(text[loc[0]:loc[1]])
+		end := loc[0] + len(candidate)
+
+		link := candidate
+		if strings.HasPrefix(strings.ToLower(link), "www.") {
+			link = "https://" + link
+		}
+		u, err := url.Parse(link)
+		if err != nil || u.Hostname() == "" {
+			continue
+		}
+
+		rest.WriteString(text[last:loc[0]])
+		rest.WriteString(" ")
+		last = end
+		if key := urlnorm.Normalize(link); !seen[key] && len(urls) < MaxTextURLs {
+			seen[key] = true
+			urls = append(urls, link)
+		}
+	}
+	rest.WriteString(text[last:])
+	return urls, cleanNote(rest.String())
+}
+
+// trimURL drops trailing characters that belong to the sentence around a
+// link rather than to the link.
+func trimURL(s string) string {
+	for s != "" {
+		last := s[len(s)-1]
+		switch {
+		case strings.IndexByte(".,;:!?'*", last) >= 0:
+			s = s[:len(s)-1]
+		case last == ')' && strings.Count(s, "(") < strings.Count(s, ")"),
+			last == ']' && strings.Count(s, "[") < strings.Count(s, "]"),
+			last == '}' && strings.Count(s, "{") < strings.Count(s, "}"):
+			s = s[:len(s)-1]
+		default:
+			return s
+		}
+	}
+	return s
+}
+
+var (
+	// emptyPair matches brackets or quotes left empty by removing a link.
+	emptyPair = regexp.MustCompile(`[(\[{<"'\x{2018}\x{201C}]\s*[)\]}>"'\x{2019}\x{201D}]`)
+	// spaceBeforePunct matches space left before punctuation that
+	// followed a link.
+	spaceBeforePunct = regexp.MustCompile(`\s+([.,;:!?])`)
+)
+
+// cleanNote tidies the text left around links: it drops brackets and
+// quotes that held a link, space before punctuation and separators left
+// dangling at either end, as in "Title - ", and collapses whitespace.
+func cleanNote(s string) string {
+	s = emptyPair.ReplaceAllString(s, " ")
+	s = collapseSpace(s)
+	s = spaceBeforePunct.ReplaceAllString(s, "$1")
+	return strings.Trim(s, " -\u2013\u2014:|\u00B7\u2022")
+}
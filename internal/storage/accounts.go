@@ -0,0 +1,67 @@
+package storage
+
+import (
+	"fmt"
+
+	"github.com/goBookMarker/internal/models"
+)
+
+// GetLinkedAccounts returns the accounts linked to the user, in the order
+// they were linked.
+func (s *SQLiteDB) GetLinkedAccounts(userID string) ([]models.LinkedAccount, error) {
+	rows, err := s.db.Query(`
+		SELECT user_id, provider, subject_id, email, name, is_sync_account, linked_at
+		FROM linked_accounts WHERE user_id = ? ORDER BY linked_at, provider
+	`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query linked accounts: %w", err)
+	}
+	defer rows.Close()
+
+	var accounts []models.LinkedAccount
+	for rows.Next() {
+		var a models.LinkedAccount
+		if err := rows.Scan(&a.UserID, &a.Provider, &a.SubjectID, &a.Email, &a.Name, &a.IsSyncAccount, &a.LinkedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan linked account: %w", err)
+		}
+		accounts = append(accounts, a)
+	}
+	return accounts, rows.Err()
+}
+
+// LinkAccount links the account to its user, replacing the user's account
+// at the same provider. Whether an already linked account backs sync is
+// kept.
+func (s *SQLiteDB) LinkAccount(account models.LinkedAccount) error {
+	_, err := s.db.Exec(`
+		INSERT INTO linked_accounts (user_id, provider, subject_id, email, name, is_sync_account, linked_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(user_id, provider) DO UPDATE SET
+			subject_id = excluded.subject_id,
+			email = excluded.email,
+			name = excluded.name
+	`, account.UserID, account.Provider, account.SubjectID, account.Email, account.Name, account.IsSyncAccount, account.LinkedAt)
+	if err != nil {
+		return fmt.Errorf("failed to link account: %w", err)
+	}
+	return nil
+}
+
+// UnlinkAccount removes the user's account at provider. Nothing else about
+// the user is touched.
+func (s *SQLiteDB) UnlinkAccount(userID, provider string) error {
+	if _, err := s.db.Exec("DELETE FROM linked_accounts WHERE user_id = ? AND provider = ?", userID, provider); err != nil {
+		return fmt.Errorf("failed to unlink account: %w", err)
+	}
+	return nil
+}
+
+// SetSyncAccount makes the user's account at provider the one that backs
+// sync, or clears it if provider is "".
+func (s *SQLiteDB) SetSyncAccount(userID, provider string) error {
+	_, err := s.db.Exec("UPDATE linked_accounts SET is_sync_account = (provider = ?) WHERE user_id = ?", provider, userID)
+	if err != nil {
+		return fmt.Errorf("failed to set sync account: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,115 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/goBookMarker/internal/models"
+)
+
+// SaveAttachment records an attachment. Saving one that exists keeps the
+// existing record.
+func (s *SQLiteDB) SaveAttachment(a models.Attachment) error {
+	_, err := s.db.Exec(`
+		INSERT INTO attachments (id, mime_type, size, width, height, created_at)
+		VALUES (?, ?, ?, ?, ?, COALESCE(?, CURRENT_TIMESTAMP))
+		ON CONFLICT(id) DO NOTHING
+	`, a.ID, a.MimeType, a.Size, a.Width, a.Height, sqlTime(a.CreatedAt))
+	if err != nil {
+		return fmt.Errorf("failed to save attachment: %w", err)
+	}
+	return nil
+}
+
+// GetAttachment returns the attachment with the given id, or nil if there
+// is none.
+func (s *SQLiteDB) GetAttachment(id string) (*models.Attachment, error) {
+	var a models.Attachment
+	err := s.db.QueryRow(`
+		SELECT id, mime_type, size, width, height, created_at FROM attachments WHERE id = ?
+	`, id).Scan(&a.ID, &a.MimeType, &a.Size, &a.Width, &a.Height, &a.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load attachment: %w", err)
+	}
+	return &a, nil
+}
+
+// LinkAttachment attaches an attachment to a bookmark.
+func (s *SQLiteDB) LinkAttachment(bookmarkID, attachmentID string) error {
+	_, err := s.db.Exec(`
+		INSERT INTO bookmark_attachments (bookmark_id, attachment_id) VALUES (?, ?)
+		ON CONFLICT(bookmark_id, attachment_id) DO NOTHING
+	`, bookmarkID, attachmentID)
+	if err != nil {
+		return fmt.Errorf("failed to link attachment: %w", err)
+	}
+	return nil
+}
+
+// GetBookmarkAttachments returns the attachments of a bookmark in the
+// order they were attached.
+func (s *SQLiteDB) GetBookmarkAttachments(bookmarkID string) ([]models.Attachment, error) {
+	rows, err := s.db.Query(`
+		SELECT a.id, a.mime_type, a.size, a.width, a.height, a.created_at
+		FROM attachments a
+		JOIN bookmark_attachments ba ON ba.attachment_id = a.id
+		WHERE ba.bookmark_id = ?
+		ORDER BY ba.created_at, a.id
+	`, bookmarkID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query attachments: %w", err)
+	}
+	defer rows.Close()
+	return scanAttachments(rows)
+}
+
+// OrphanedAttachments returns the attachments created before the given
+// time that no bookmark links to and no queued share refers to.
+func (s *SQLiteDB) OrphanedAttachments(before time.Time) ([]models.Attachment, error) {
+	rows, err := s.db.Query(`
+		SELECT id, mime_type, size, width, height, created_at FROM attachments a
+		WHERE created_at < ?
+		AND NOT EXISTS (SELECT 1 FROM bookmark_attachments ba WHERE ba.attachment_id = a.id)
+		AND NOT EXISTS (SELECT 1 FROM share_queue q
+			WHERE instr(q.items, a.id) > 0 OR instr(q.content, a.id) > 0)
+	`, sqlTime(before))
+	if err != nil {
+		return nil, fmt.Errorf("failed to query orphaned attachments: %w", err)
+	}
+	defer rows.Close()
+	return scanAttachments(rows)
+}
+
+// DeleteOrphanedAttachment deletes the attachment's record if no bookmark
+// links to it and no queued share refers to it, and reports whether it did.
+func (s *SQLiteDB) DeleteOrphanedAttachment(id string) (bool, error) {
+	res, err := s.db.Exec(`
+		DELETE FROM attachments WHERE id = ?
+		AND NOT EXISTS (SELECT 1 FROM bookmark_attachments WHERE attachment_id = ?)
+		AND NOT EXISTS (SELECT 1 FROM share_queue WHERE instr(items, ?) > 0 OR instr(content, ?) > 0)
+	`, id, id, id, id)
+	if err != nil {
+		return false, fmt.Errorf("failed to delete attachment: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to delete attachment: %w", err)
+	}
+	return n > 0, nil
+}
+
+func scanAttachments(rows *sql.Rows) ([]models.Attachment, error) {
+	var attachments []models.Attachment
+	for rows.Next() {
+		var a models.Attachment
+		if err := rows.Scan(&a.ID, &a.MimeType, &a.Size, &a.Width, &a.Height, &a.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan attachment: %w", err)
+		}
+		attachments = append(attachments, a)
+	}
+	return attachments, rows.Err()
+}
@@ -0,0 +1,58 @@
+package storage
+
+import (
+	"fmt"
+
+	"github.com/goBookMarker/internal/models"
+)
+
+// ChangesSince returns the changes recorded after seq, oldest first. The
+// rows are written by triggers, so every write to the library is covered.
+func (s *SQLiteDB) ChangesSince(seq int64) ([]models.Change, error) {
+	rows, err := s.db.Query(`
+		SELECT seq, kind, entity_id, op, changed_at
+		FROM changes WHERE seq > ? ORDER BY seq
+	`, seq)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query changes: %w", err)
+	}
+	defer rows.Close()
+
+	var changes []models.Change
+	for rows.Next() {
+		var c models.Change
+		if err := rows.Scan(&c.Seq, &c.Kind, &c.EntityID, &c.Op, &c.ChangedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan change: %w", err)
+		}
+		changes = append(changes, c)
+	}
+	return changes, rows.Err()
+}
+
+// LatestChangeSeq returns the sequence of the newest change, or 0 if none
+// was ever recorded.
+func (s *SQLiteDB) LatestChangeSeq() (int64, error) {
+	var seq int64
+	err := s.db.QueryRow("SELECT COALESCE((SELECT seq FROM sqlite_sequence WHERE name = 'changes'), 0)").Scan(&seq)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read change sequence: %w", err)
+	}
+	return seq, nil
+}
+
+// PruneChanges removes the changes up to and including seq.
+func (s *SQLiteDB) PruneChanges(seq int64) error {
+	if _, err := s.db.Exec("DELETE FROM changes WHERE seq <= ?", seq); err != nil {
+		return fmt.Errorf("failed to prune changes: %w", err)
+	}
+	return nil
+}
+
+// DiscardChangesAfter removes the changes recorded after seq, for writes
+// that should not count as local edits.
+func (s *SQLiteDB) DiscardChangesAfter(seq int64) error {
+	if _, err := s.db.Exec("DELETE FROM changes WHERE seq > ?", seq); err != nil {
+		return fmt.Errorf("failed to discard changes: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+package storage
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/goBookMarker/internal/urlnorm"
+)
+
+// ErrDuplicateURL is returned, wrapped in a DuplicateError, when a bookmark
+// is saved with the URL of another bookmark. URLs are compared in the form
+// returned by urlnorm.Normalize.
+var ErrDuplicateURL = errors.New("URL is already bookmarked")
+
+// DuplicateError reports the bookmark that already has the URL being saved.
+type DuplicateError struct {
+	URL        string
+	ExistingID string
+}
+
+func (e *DuplicateError) Error() string {
+	return fmt.Sprintf("%s: %s", ErrDuplicateURL, e.URL)
+}
+
+func (e *DuplicateError) Unwrap() error {
+	return ErrDuplicateURL
+}
+
+// normalizedURLTx returns the normalized URL to store for bookmark id, or a
+// DuplicateError if another bookmark has it. A bookmark saved with the URL
+// it already has is never a duplicate: bookmarks that shared a URL before
+// normalized URLs were stored keep an empty one and can still be edited.
+func normalizedURLTx(tx *sql.Tx, id, rawURL string) (string, error) {
+	key := urlnorm.Normalize(rawURL)
+	if key == "" {
+		return "", nil
+	}
+	var existingID string
+	err := tx.QueryRow("SELECT id FROM bookmarks WHERE normalized_url = ? AND id != ?", key, id).Scan(&existingID)
+	if err == sql.ErrNoRows {
+		return key, nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to check for duplicate URL: %w", err)
+	}
+
+	var storedURL string
+	err = tx.QueryRow("SELECT url FROM bookmarks WHERE id = ?", id).Scan(&storedURL)
+	if err == nil && storedURL == rawURL {
+		return "", nil
+	}
+	if err != nil && err != sql.ErrNoRows {
+		return "", fmt.Errorf("failed to load bookmark: %w", err)
+	}
+	return "", &DuplicateError{URL: rawURL, ExistingID: existingID}
+}
+
+// backfillNormalizedURLs stores the normalized URL of bookmarks that have
+// none yet, oldest first. Where older bookmarks already share a URL, the
+// first one gets it and the others are left without, so they can be found
+// and merged by the user instead of failing the upgrade.
+func (s *SQLiteDB) backfillNormalizedURLs() error {
+	rows, err := s.db.Query("SELECT id, url FROM bookmarks WHERE normalized_url = '' ORDER BY created_at, id")
+	if err != nil {
+		return fmt.Errorf("failed to load bookmarks: %w", err)
+	}
+	type pending struct{ id, url string }
+	var bookmarks []pending
+	for rows.Next() {
+		var p pending
+		if err := rows.Scan(&p.id, &p.url); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to load bookmarks: %w", err)
+		}
+		bookmarks = append(bookmarks, p)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to load bookmarks: %w", err)
+	}
+	if len(bookmarks) == 0 {
+		return nil
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, b := range bookmarks {
+		key := urlnorm.Normalize(b.url)
+		if key == "" {
+			continue
+		}
+		_, err := tx.Exec(`
+			UPDATE bookmarks SET normalized_url = ?
+			WHERE id = ? AND NOT EXISTS (SELECT 1 FROM bookmarks WHERE normalized_url = ?)
+		`, key, b.id, key)
+		if err != nil {
+			return fmt.Errorf("failed to store normalized URL: %w", err)
+		}
+	}
+	return tx.Commit()
+}
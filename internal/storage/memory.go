@@ -0,0 +1,548 @@
+package storage
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/goBookMarker/internal/models"
+	"github.com/goBookMarker/internal/search"
+	"github.com/goBookMarker/internal/urlnorm"
+)
+
+// MemoryStore is an in-memory implementation of BookmarkRepository,
+// TagRepository, UserRepository, AttachmentRepository,
+// ShareQueueRepository, SyncStateRepository and ChangeLogRepository. It is intended for tests
+// and for running the app without a database.
+type MemoryStore struct {
+	mu        sync.RWMutex
+	bookmarks map[string]models.Bookmark
+	pageText  map[string]string // by bookmark ID
+	tags      map[string]models.Tag
+	tagGroups map[string]models.TagGroup
+	user      *models.User
+	accounts  []models.LinkedAccount
+	files     map[string]models.Attachment
+	fileLinks map[string][]string // attachment IDs by bookmark ID
+	shares    []models.QueuedShare
+	syncState map[string][]byte
+	changes   []models.Change
+	changeSeq int64
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		bookmarks: make(map[string]models.Bookmark),
+		pageText:  make(map[string]string),
+		tags:      make(map[string]models.Tag),
+		tagGroups: make(map[string]models.TagGroup),
+		syncState: make(map[string][]byte),
+		files:     make(map[string]models.Attachment),
+		fileLinks: make(map[string][]string),
+	}
+}
+
+var (
+	_ BookmarkRepository   = (*MemoryStore)(nil)
+	_ BookmarkSearcher     = (*MemoryStore)(nil)
+	_ TagRepository        = (*MemoryStore)(nil)
+	_ UserRepository       = (*MemoryStore)(nil)
+	_ AccountRepository    = (*MemoryStore)(nil)
+	_ AttachmentRepository = (*MemoryStore)(nil)
+	_ ShareQueueRepository = (*MemoryStore)(nil)
+	_ SyncStateRepository  = (*MemoryStore)(nil)
+	_ ChangeLogRepository  = (*MemoryStore)(nil)
+)
+
+func (m *MemoryStore) GetAllBookmarks() ([]models.Bookmark, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	bookmarks := make([]models.Bookmark, 0, len(m.bookmarks))
+	for _, b := range m.bookmarks {
+		bookmarks = append(bookmarks, b)
+	}
+	sort.Slice(bookmarks, func(i, j int) bool {
+		return bookmarks[i].CreatedAt.After(bookmarks[j].CreatedAt)
+	})
+	return bookmarks, nil
+}
+
+// SaveBookmark creates or updates b. Like SQLiteDB, it returns a
+// DuplicateError if another bookmark has the same URL once normalized,
+// unless b keeps the URL it already had.
+func (m *MemoryStore) SaveBookmark(b models.Bookmark) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if current, ok := m.bookmarks[b.ID]; !ok || current.URL != b.URL {
+		key := urlnorm.Normalize(b.URL)
+		for id, other := range m.bookmarks {
+			if id != b.ID && key != "" && urlnorm.Normalize(other.URL) == key {
+				return &DuplicateError{URL: b.URL, ExistingID: id}
+			}
+		}
+	}
+	b.Tags = append([]string(nil), b.Tags...)
+	b.TagIDs = append([]string(nil), b.TagIDs...)
+	m.bookmarks[b.ID] = b
+	m.recordLocked("bookmark", b.ID, models.ChangeUpsert)
+	return nil
+}
+
+func (m *MemoryStore) DeleteBookmark(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.fileLinks, id)
+	delete(m.pageText, id)
+	if _, exists := m.bookmarks[id]; exists {
+		delete(m.bookmarks, id)
+		m.recordLocked("bookmark", id, models.ChangeDelete)
+	}
+	return nil
+}
+
+// QueryBookmarks evaluates q with its in-memory predicate, searching page
+// text like SQLiteDB. Results are ordered like GetAllBookmarks and carry
+// no rank.
+func (m *MemoryStore) QueryBookmarks(q *search.Query, limit int) ([]models.SearchResult, error) {
+	bookmarks, err := m.GetAllBookmarks()
+	if err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var results []models.SearchResult
+	for _, b := range bookmarks {
+		if !q.MatchPage(b, m.pageText[b.ID]) {
+			continue
+		}
+		results = append(results, q.Result(b))
+		if limit > 0 && len(results) == limit {
+			break
+		}
+	}
+	return results, nil
+}
+
+// SetPageText stores text extracted from the bookmarked page so it is
+// searched along with the bookmark, like SQLiteDB.SetPageText.
+func (m *MemoryStore) SetPageText(bookmarkID, text string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.bookmarks[bookmarkID]; ok {
+		m.pageText[bookmarkID] = text
+	}
+	return nil
+}
+
+func (m *MemoryStore) GetAllTags() ([]models.Tag, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	tags := make([]models.Tag, 0, len(m.tags))
+	for _, t := range m.tags {
+		tags = append(tags, t)
+	}
+	sort.Slice(tags, func(i, j int) bool {
+		return tags[i].Order < tags[j].Order
+	})
+	return tags, nil
+}
+
+func (m *MemoryStore) CreateTag(tag models.Tag) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, exists := m.tags[tag.ID]; exists {
+		return fmt.Errorf("tag %s already exists", tag.ID)
+	}
+	m.tags[tag.ID] = tag
+	m.recordLocked("tag", tag.ID, models.ChangeUpsert)
+	return nil
+}
+
+func (m *MemoryStore) UpdateTag(tag models.Tag) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, exists := m.tags[tag.ID]; !exists {
+		return fmt.Errorf("tag %s not found", tag.ID)
+	}
+	m.tags[tag.ID] = tag
+	m.recordLocked("tag", tag.ID, models.ChangeUpsert)
+	return nil
+}
+
+func (m *MemoryStore) DeleteTag(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	tag, exists := m.tags[id]
+	if !exists {
+		return nil
+	}
+	delete(m.tags, id)
+	m.recordLocked("tag", id, models.ChangeDelete)
+	for bookmarkID, b := range m.bookmarks {
+		// Bookmarks with tag IDs are linked to another tag of the same
+		// name unless they list this one.
+		ids := make([]string, 0, len(b.TagIDs))
+		for _, tagID := range b.TagIDs {
+			if tagID != id {
+				ids = append(ids, tagID)
+			}
+		}
+		if len(ids) > 0 && len(ids) == len(b.TagIDs) {
+			continue
+		}
+		kept := make([]string, 0, len(b.Tags))
+		for _, name := range b.Tags {
+			if name != tag.Name {
+				kept = append(kept, name)
+			}
+		}
+		if len(kept) == len(b.Tags) {
+			continue
+		}
+		b.Tags, b.TagIDs = kept, ids
+		m.bookmarks[bookmarkID] = b
+		m.recordLocked("bookmark", bookmarkID, models.ChangeUpsert)
+	}
+	for childID, t := range m.tags {
+		if t.ParentID == id {
+			t.ParentID = ""
+			m.tags[childID] = t
+			m.recordLocked("tag", childID, models.ChangeUpsert)
+		}
+	}
+	return nil
+}
+
+func (m *MemoryStore) GetAllTagGroups() ([]models.TagGroup, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	groups := make([]models.TagGroup, 0, len(m.tagGroups))
+	for _, g := range m.tagGroups {
+		groups = append(groups, g)
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		return groups[i].Order < groups[j].Order
+	})
+	return groups, nil
+}
+
+func (m *MemoryStore) CreateTagGroup(group models.TagGroup) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, exists := m.tagGroups[group.ID]; exists {
+		return fmt.Errorf("tag group %s already exists", group.ID)
+	}
+	group.TagIDs = append([]string(nil), group.TagIDs...)
+	m.tagGroups[group.ID] = group
+	m.recordLocked("tag_group", group.ID, models.ChangeUpsert)
+	return nil
+}
+
+func (m *MemoryStore) UpdateTagGroup(group models.TagGroup) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, exists := m.tagGroups[group.ID]; !exists {
+		return fmt.Errorf("tag group %s not found", group.ID)
+	}
+	group.TagIDs = append([]string(nil), group.TagIDs...)
+	m.tagGroups[group.ID] = group
+	m.recordLocked("tag_group", group.ID, models.ChangeUpsert)
+	return nil
+}
+
+func (m *MemoryStore) DeleteTagGroup(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, exists := m.tagGroups[id]; exists {
+		delete(m.tagGroups, id)
+		m.recordLocked("tag_group", id, models.ChangeDelete)
+	}
+	return nil
+}
+
+func (m *MemoryStore) GetCurrentUser() (*models.User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.user == nil {
+		return nil, nil
+	}
+	user := *m.user
+	return &user, nil
+}
+
+func (m *MemoryStore) SaveUser(user *models.User) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	saved := *user
+	m.user = &saved
+	m.recordLocked("preferences", user.ID, models.ChangeUpsert)
+	return nil
+}
+
+func (m *MemoryStore) GetLinkedAccounts(userID string) ([]models.LinkedAccount, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var accounts []models.LinkedAccount
+	for _, a := range m.accounts {
+		if a.UserID == userID {
+			accounts = append(accounts, a)
+		}
+	}
+	return accounts, nil
+}
+
+func (m *MemoryStore) LinkAccount(account models.LinkedAccount) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, a := range m.accounts {
+		if a.UserID == account.UserID && a.Provider == account.Provider {
+			account.IsSyncAccount = a.IsSyncAccount
+			account.LinkedAt = a.LinkedAt
+			m.accounts[i] = account
+			return nil
+		}
+	}
+	m.accounts = append(m.accounts, account)
+	return nil
+}
+
+func (m *MemoryStore) UnlinkAccount(userID, provider string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	kept := m.accounts[:0]
+	for _, a := range m.accounts {
+		if a.UserID != userID || a.Provider != provider {
+			kept = append(kept, a)
+		}
+	}
+	m.accounts = kept
+	return nil
+}
+
+func (m *MemoryStore) SetSyncAccount(userID, provider string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := range m.accounts {
+		if m.accounts[i].UserID == userID {
+			m.accounts[i].IsSyncAccount = m.accounts[i].Provider == provider
+		}
+	}
+	return nil
+}
+
+func (m *MemoryStore) SaveAttachment(a models.Attachment) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, exists := m.files[a.ID]; !exists {
+		if a.CreatedAt.IsZero() {
+			a.CreatedAt = time.Now()
+		}
+		m.files[a.ID] = a
+	}
+	return nil
+}
+
+func (m *MemoryStore) GetAttachment(id string) (*models.Attachment, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	a, ok := m.files[id]
+	if !ok {
+		return nil, nil
+	}
+	return &a, nil
+}
+
+func (m *MemoryStore) LinkAttachment(bookmarkID, attachmentID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.files[attachmentID]; !ok {
+		return fmt.Errorf("attachment %s not found", attachmentID)
+	}
+	for _, id := range m.fileLinks[bookmarkID] {
+		if id == attachmentID {
+			return nil
+		}
+	}
+	m.fileLinks[bookmarkID] = append(m.fileLinks[bookmarkID], attachmentID)
+	return nil
+}
+
+func (m *MemoryStore) GetBookmarkAttachments(bookmarkID string) ([]models.Attachment, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var attachments []models.Attachment
+	for _, id := range m.fileLinks[bookmarkID] {
+		attachments = append(attachments, m.files[id])
+	}
+	return attachments, nil
+}
+
+func (m *MemoryStore) OrphanedAttachments(before time.Time) ([]models.Attachment, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var orphans []models.Attachment
+	for id, a := range m.files {
+		if a.CreatedAt.Before(before) && !m.attachmentLinkedLocked(id) {
+			orphans = append(orphans, a)
+		}
+	}
+	return orphans, nil
+}
+
+func (m *MemoryStore) DeleteOrphanedAttachment(id string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.files[id]; !ok || m.attachmentLinkedLocked(id) {
+		return false, nil
+	}
+	delete(m.files, id)
+	return true, nil
+}
+
+func (m *MemoryStore) attachmentLinkedLocked(id string) bool {
+	for _, ids := range m.fileLinks {
+		for _, linked := range ids {
+			if linked == id {
+				return true
+			}
+		}
+	}
+	for _, share := range m.shares {
+		if strings.Contains(string(share.Items), id) || strings.Contains(share.Content, id) {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *MemoryStore) EnqueueShare(share models.QueuedShare) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, existing := range m.shares {
+		if existing.ID == share.ID {
+			return fmt.Errorf("share %s is already queued", share.ID)
+		}
+	}
+	now := time.Now()
+	if share.CreatedAt.IsZero() {
+		share.CreatedAt = now
+	}
+	if share.UpdatedAt.IsZero() {
+		share.UpdatedAt = now
+	}
+	if share.NextAttemptAt.IsZero() {
+		share.NextAttemptAt = now
+	}
+	m.shares = append(m.shares, share)
+	return nil
+}
+
+func (m *MemoryStore) GetQueuedShares() ([]models.QueuedShare, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return append([]models.QueuedShare(nil), m.shares...), nil
+}
+
+func (m *MemoryStore) UpdateQueuedShare(share models.QueuedShare) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := range m.shares {
+		if m.shares[i].ID == share.ID {
+			share.ContentType = m.shares[i].ContentType
+			share.CreatedAt = m.shares[i].CreatedAt
+			if share.UpdatedAt.IsZero() {
+				share.UpdatedAt = time.Now()
+			}
+			m.shares[i] = share
+			return nil
+		}
+	}
+	return nil
+}
+
+func (m *MemoryStore) DeleteQueuedShare(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := range m.shares {
+		if m.shares[i].ID == id {
+			m.shares = append(m.shares[:i], m.shares[i+1:]...)
+			return nil
+		}
+	}
+	return nil
+}
+
+func (m *MemoryStore) GetSyncState(key string) ([]byte, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return append([]byte(nil), m.syncState[key]...), nil
+}
+
+func (m *MemoryStore) SaveSyncState(key string, data []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.syncState[key] = append([]byte(nil), data...)
+	return nil
+}
+
+// recordLocked appends to the change log. The caller must hold m.mu.
+func (m *MemoryStore) recordLocked(kind, id, op string) {
+	m.changeSeq++
+	m.changes = append(m.changes, models.Change{
+		Seq:       m.changeSeq,
+		Kind:      kind,
+		EntityID:  id,
+		Op:        op,
+		ChangedAt: time.Now(),
+	})
+}
+
+func (m *MemoryStore) ChangesSince(seq int64) ([]models.Change, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var changes []models.Change
+	for _, c := range m.changes {
+		if c.Seq > seq {
+			changes = append(changes, c)
+		}
+	}
+	return changes, nil
+}
+
+func (m *MemoryStore) LatestChangeSeq() (int64, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.changeSeq, nil
+}
+
+func (m *MemoryStore) PruneChanges(seq int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	kept := m.changes[:0]
+	for _, c := range m.changes {
+		if c.Seq > seq {
+			kept = append(kept, c)
+		}
+	}
+	m.changes = kept
+	return nil
+}
+
+func (m *MemoryStore) DiscardChangesAfter(seq int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	kept := m.changes[:0]
+	for _, c := range m.changes {
+		if c.Seq <= seq {
+			kept = append(kept, c)
+		}
+	}
+	m.changes = kept
+	return nil
+}
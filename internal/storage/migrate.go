@@ -0,0 +1,251 @@
+package storage
+
+import (
+	"database/sql"
+	"embed"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// Migration is a single ordered schema change. Migrations are loaded from
+// the embedded migrations directory and named NNNN_description.sql.
+type Migration struct {
+	Version int
+	Name    string
+	SQL     string
+}
+
+const createSchemaMigrationsTable = `
+	CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	)`
+
+// Migrations returns every embedded migration sorted by version.
+func Migrations() ([]Migration, error) {
+	entries, err := migrationFiles.ReadDir("migrations")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read migrations: %w", err)
+	}
+
+	var migrations []Migration
+	seen := make(map[int]string)
+	for _, entry := range entries {
+		if entry.IsDir() || path.Ext(entry.Name()) != ".sql" {
+			continue
+		}
+
+		base := strings.TrimSuffix(entry.Name(), ".sql")
+		prefix, name, ok := strings.Cut(base, "_")
+		if !ok {
+			return nil, fmt.Errorf("invalid migration file name %q", entry.Name())
+		}
+		version, err := strconv.Atoi(prefix)
+		if err != nil || version <= 0 {
+			return nil, fmt.Errorf("invalid migration version in %q", entry.Name())
+		}
+		if other, dup := seen[version]; dup {
+			return nil, fmt.Errorf("duplicate migration version %d: %s and %s", version, other, entry.Name())
+		}
+		seen[version] = entry.Name()
+
+		body, err := migrationFiles.ReadFile(path.Join("migrations", entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read migration %s: %w", entry.Name(), err)
+		}
+
+		migrations = append(migrations, Migration{
+			Version: version,
+			Name:    name,
+			SQL:     string(body),
+		})
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	return migrations, nil
+}
+
+// Migrate brings the database schema up to the latest embedded version.
+// Each migration runs in its own transaction together with its
+// schema_migrations record, so a failed step leaves the previous version
+// intact. Databases created before versioning was introduced are adopted
+// in place.
+func Migrate(db *sql.DB) error {
+	if _, err := db.Exec(createSchemaMigrationsTable); err != nil {
+		return fmt.Errorf("failed to create schema_migrations: %w", err)
+	}
+
+	migrations, err := Migrations()
+	if err != nil {
+		return err
+	}
+
+	current, err := SchemaVersion(db)
+	if err != nil {
+		return err
+	}
+
+	if current == 0 {
+		if err := adoptLegacySchema(db); err != nil {
+			return fmt.Errorf("failed to upgrade legacy schema: %w", err)
+		}
+	}
+
+	for _, m := range migrations {
+		if m.Version <= current {
+			continue
+		}
+		if err := applyMigration(db, m); err != nil {
+			return fmt.Errorf("migration %04d_%s failed: %w", m.Version, m.Name, err)
+		}
+	}
+	return nil
+}
+
+// SchemaVersion returns the highest applied migration version, or 0 for a
+// database that has never been migrated.
+func SchemaVersion(db *sql.DB) (int, error) {
+	var version sql.NullInt64
+	err := db.QueryRow("SELECT MAX(version) FROM schema_migrations").Scan(&version)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read schema version: %w", err)
+	}
+	return int(version.Int64), nil
+}
+
+func applyMigration(db *sql.DB, m Migration) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(m.SQL); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("INSERT INTO schema_migrations (version, name) VALUES (?, ?)", m.Version, m.Name); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// unifiedTagColumns lists the columns of the unified tags table together
+// with the expression used when a legacy table lacks them.
+var unifiedTagColumns = []struct {
+	name     string
+	fallback string
+}{
+	{"id", ""},
+	{"name", ""},
+	{"color", "''"},
+	{"description", "''"},
+	{"parent_id", "NULL"},
+	{"tag_order", "0"},
+	{"created_at", "CURRENT_TIMESTAMP"},
+	{"updated_at", "CURRENT_TIMESTAMP"},
+	{"usage_stats", "'{}'"},
+}
+
+// adoptLegacySchema rewrites a tags table created by either of the old
+// CREATE TABLE IF NOT EXISTS paths (SQLiteDB used order_num and had no
+// hierarchy, TagStore used tag_order and parent_id) into the unified
+// layout so the initial migration can run against it.
+func adoptLegacySchema(db *sql.DB) error {
+	columns, err := tableColumns(db, "tags")
+	if err != nil {
+		return err
+	}
+	if len(columns) == 0 {
+		return nil
+	}
+
+	var selects []string
+	for _, col := range unifiedTagColumns {
+		switch {
+		case col.name == "tag_order" && !columns["tag_order"] && columns["order_num"]:
+			selects = append(selects, "COALESCE(order_num, 0)")
+		case col.name == "updated_at" && !columns["updated_at"] && columns["created_at"]:
+			selects = append(selects, "COALESCE(created_at, CURRENT_TIMESTAMP)")
+		case columns[col.name] && col.name == "parent_id":
+			selects = append(selects, "NULLIF(parent_id, '')")
+		case columns[col.name] && col.name == "usage_stats":
+			selects = append(selects, "COALESCE(NULLIF(usage_stats, ''), '{}')")
+		case columns[col.name] && col.fallback != "" && col.fallback != "NULL":
+			selects = append(selects, fmt.Sprintf("COALESCE(%s, %s)", col.name, col.fallback))
+		case columns[col.name]:
+			selects = append(selects, col.name)
+		default:
+			selects = append(selects, col.fallback)
+		}
+	}
+
+	names := make([]string, len(unifiedTagColumns))
+	for i, col := range unifiedTagColumns {
+		names[i] = col.name
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	statements := []string{
+		`CREATE TABLE tags_unified (
+			id TEXT PRIMARY KEY,
+			name TEXT NOT NULL,
+			color TEXT NOT NULL DEFAULT '',
+			description TEXT NOT NULL DEFAULT '',
+			parent_id TEXT,
+			tag_order INTEGER NOT NULL DEFAULT 0,
+			created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			usage_stats TEXT NOT NULL DEFAULT '{}',
+			FOREIGN KEY(parent_id) REFERENCES tags(id) ON DELETE SET NULL
+		)`,
+		fmt.Sprintf("INSERT INTO tags_unified (%s) SELECT %s FROM tags",
+			strings.Join(names, ", "), strings.Join(selects, ", ")),
+		`DROP TABLE tags`,
+		`ALTER TABLE tags_unified RENAME TO tags`,
+	}
+	for _, stmt := range statements {
+		if _, err := tx.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func tableColumns(db *sql.DB, table string) (map[string]bool, error) {
+	rows, err := db.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	columns := make(map[string]bool)
+	for rows.Next() {
+		var (
+			cid       int
+			name      string
+			colType   string
+			notNull   bool
+			dfltValue sql.NullString
+			pk        int
+		)
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &dfltValue, &pk); err != nil {
+			return nil, err
+		}
+		columns[name] = true
+	}
+	return columns, rows.Err()
+}
@@ -0,0 +1,199 @@
+package storage
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// Tables the app created before schema versioning. SQLiteDB created the
+// tags table with order_num (its minimal CREATE TABLE was later extended
+// by hand), TagStore with tag_order and parent_id.
+const (
+	legacyUsersAndBookmarks = `
+	CREATE TABLE users (
+		id TEXT PRIMARY KEY,
+		email TEXT UNIQUE,
+		name TEXT,
+		nav_position TEXT DEFAULT 'bottom',
+		nav_items TEXT,
+		theme TEXT DEFAULT 'system',
+		sync_enabled BOOLEAN DEFAULT false,
+		last_sync TEXT,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE TABLE bookmarks (
+		id TEXT PRIMARY KEY,
+		user_id TEXT,
+		url TEXT NOT NULL,
+		title TEXT,
+		description TEXT,
+		image_url TEXT,
+		favicon_url TEXT,
+		is_favorite BOOLEAN DEFAULT false,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY(user_id) REFERENCES users(id)
+	);
+	CREATE TABLE bookmark_tags (
+		bookmark_id TEXT,
+		tag_id TEXT,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY(bookmark_id, tag_id),
+		FOREIGN KEY(bookmark_id) REFERENCES bookmarks(id) ON DELETE CASCADE,
+		FOREIGN KEY(tag_id) REFERENCES tags(id) ON DELETE CASCADE
+	);
+	INSERT INTO users (id, email, name, nav_items, last_sync) VALUES ('u1', 'alice@example.com', 'Alice', '["home"]', '');
+	INSERT INTO bookmarks (id, user_id, url, title, description, image_url, favicon_url, created_at, updated_at) VALUES
+		('b1', 'u1', 'https://go.dev/', 'Go', '', '', '', '2023-01-02 03:04:05', '2023-01-02 03:04:05'),
+		('b2', 'u1', 'https://example.com/', 'Example', '', '', '', '2023-02-03 04:05:06', '2023-02-03 04:05:06');
+	INSERT INTO bookmark_tags (bookmark_id, tag_id) VALUES ('b1', 't1'), ('b1', 't2'), ('b2', 't2');`
+
+	legacyOrderNumTags = `
+	CREATE TABLE tags (
+		id TEXT PRIMARY KEY,
+		name TEXT UNIQUE NOT NULL,
+		color TEXT,
+		description TEXT,
+		parent_id TEXT,
+		order_num INTEGER,
+		count INTEGER,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP,
+		usage_stats TEXT
+	);
+	INSERT INTO tags (id, name, color, description, parent_id, order_num, usage_stats) VALUES
+		('t1', 'golang', '#00add8', 'The Go language', '', 2, ''),
+		('t2', 'reading', NULL, NULL, 't1', 1, NULL);`
+
+	legacyTagOrderTags = `
+	CREATE TABLE tags (
+		id TEXT PRIMARY KEY,
+		name TEXT NOT NULL,
+		color TEXT NOT NULL,
+		description TEXT,
+		parent_id TEXT,
+		tag_order INTEGER DEFAULT 0,
+		created_at TIMESTAMP NOT NULL,
+		updated_at TIMESTAMP NOT NULL,
+		usage_stats TEXT,
+		FOREIGN KEY(parent_id) REFERENCES tags(id) ON DELETE SET NULL
+	);
+	CREATE TABLE tag_groups (
+		id TEXT PRIMARY KEY,
+		name TEXT NOT NULL,
+		tag_ids TEXT NOT NULL,
+		group_order INTEGER DEFAULT 0,
+		expanded BOOLEAN DEFAULT true
+	);
+	CREATE INDEX idx_tags_parent_id ON tags(parent_id);
+	CREATE INDEX idx_tags_order ON tags(tag_order);
+	INSERT INTO tags (id, name, color, description, parent_id, tag_order, created_at, updated_at, usage_stats) VALUES
+		('t1', 'golang', '#00add8', 'The Go language', NULL, 2, '2023-01-01 00:00:00', '2023-01-01 00:00:00', '{"usage_count":3}'),
+		('t2', 'reading', '', NULL, 't1', 1, '2023-01-01 00:00:00', '2023-01-01 00:00:00', NULL);
+	INSERT INTO tag_groups (id, name, tag_ids, group_order) VALUES ('g1', 'Topics', '["t1","t2"]', 0);`
+)
+
+// openInDir opens the database the app uses, bookmarker.db in the working
+// directory, with dir as the working directory.
+func openInDir(t *testing.T, dir string) *SQLiteDB {
+	t.Helper()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+	db, err := NewSQLiteDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestMigrateAdoptsLegacyDatabase(t *testing.T) {
+	migrations, err := Migrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	latest := migrations[len(migrations)-1].Version
+
+	for name, tags := range map[string]string{
+		"order_num": legacyOrderNumTags,
+		"tag_order": legacyTagOrderTags,
+	} {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			legacy, err := sql.Open("sqlite", filepath.Join(dir, "bookmarker.db"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := legacy.Exec(tags + legacyUsersAndBookmarks); err != nil {
+				t.Fatal(err)
+			}
+			legacy.Close()
+
+			// Opening twice shows the upgraded database opens as usual.
+			openInDir(t, dir).Close()
+			db := openInDir(t, dir)
+
+			if version, err := SchemaVersion(db.db); err != nil || version != latest {
+				t.Fatalf("schema version = %d, %v, want %d", version, err, latest)
+			}
+
+			user, err := db.GetCurrentUser()
+			if err != nil || user == nil || user.Email != "alice@example.com" || strings.Join(user.NavItems, ",") != "home" {
+				t.Errorf("user = %+v, %v", user, err)
+			}
+
+			bookmarks, err := db.GetAllBookmarks()
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := make(map[string]string)
+			for _, b := range bookmarks {
+				sort.Strings(b.Tags)
+				got[b.URL] = b.Title + ":" + strings.Join(b.Tags, ",")
+				if b.CreatedAt.IsZero() {
+					t.Errorf("%s lost its creation time", b.URL)
+				}
+			}
+			if len(got) != 2 || got["https://go.dev/"] != "Go:golang,reading" || got["https://example.com/"] != "Example:reading" {
+				t.Errorf("bookmarks = %v", got)
+			}
+
+			all, err := db.TagStore().GetAllTags()
+			if err != nil {
+				t.Fatal(err)
+			}
+			byID := make(map[string]int)
+			for i, tag := range all {
+				byID[tag.ID] = i
+			}
+			if len(all) != 2 {
+				t.Fatalf("tags = %+v, want 2", all)
+			}
+			golang, reading := all[byID["t1"]], all[byID["t2"]]
+			if golang.Name != "golang" || golang.Color != "#00add8" || golang.Description != "The Go language" || golang.Order != 2 || golang.ParentID != "" {
+				t.Errorf("golang tag = %+v", golang)
+			}
+			if reading.Name != "reading" || reading.Order != 1 || reading.ParentID != "t1" {
+				t.Errorf("reading tag = %+v", reading)
+			}
+
+			groups, err := db.TagStore().GetAllTagGroups()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if name == "tag_order" && (len(groups) != 1 || strings.Join(groups[0].TagIDs, ",") != "t1,t2") {
+				t.Errorf("tag groups = %+v, want Topics with both tags", groups)
+			}
+		})
+	}
+}
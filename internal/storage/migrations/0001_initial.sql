@@ -0,0 +1,60 @@
+CREATE TABLE IF NOT EXISTS users (
+	id TEXT PRIMARY KEY,
+	email TEXT UNIQUE,
+	name TEXT,
+	nav_position TEXT DEFAULT 'bottom',
+	nav_items TEXT,
+	theme TEXT DEFAULT 'system',
+	sync_enabled BOOLEAN DEFAULT false,
+	last_sync TEXT,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS bookmarks (
+	id TEXT PRIMARY KEY,
+	user_id TEXT,
+	url TEXT NOT NULL,
+	title TEXT,
+	description TEXT,
+	image_url TEXT,
+	favicon_url TEXT,
+	is_favorite BOOLEAN DEFAULT false,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY(user_id) REFERENCES users(id)
+);
+
+CREATE TABLE IF NOT EXISTS tags (
+	id TEXT PRIMARY KEY,
+	name TEXT NOT NULL,
+	color TEXT NOT NULL DEFAULT '',
+	description TEXT NOT NULL DEFAULT '',
+	parent_id TEXT,
+	tag_order INTEGER NOT NULL DEFAULT 0,
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	usage_stats TEXT NOT NULL DEFAULT '{}',
+	FOREIGN KEY(parent_id) REFERENCES tags(id) ON DELETE SET NULL
+);
+
+CREATE TABLE IF NOT EXISTS tag_groups (
+	id TEXT PRIMARY KEY,
+	name TEXT NOT NULL,
+	tag_ids TEXT NOT NULL,
+	group_order INTEGER DEFAULT 0,
+	expanded BOOLEAN DEFAULT true
+);
+
+CREATE TABLE IF NOT EXISTS bookmark_tags (
+	bookmark_id TEXT NOT NULL,
+	tag_id TEXT NOT NULL,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY(bookmark_id, tag_id),
+	FOREIGN KEY(bookmark_id) REFERENCES bookmarks(id) ON DELETE CASCADE,
+	FOREIGN KEY(tag_id) REFERENCES tags(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_tags_name ON tags(name);
+CREATE INDEX IF NOT EXISTS idx_tags_parent_id ON tags(parent_id);
+CREATE INDEX IF NOT EXISTS idx_tags_order ON tags(tag_order);
+CREATE INDEX IF NOT EXISTS idx_tag_groups_order ON tag_groups(group_order);
@@ -0,0 +1,2 @@
+-- Text extracted from the bookmarked page, indexed for full-text search.
+ALTER TABLE bookmarks ADD COLUMN page_text TEXT NOT NULL DEFAULT '';
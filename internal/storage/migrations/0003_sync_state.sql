@@ -0,0 +1,7 @@
+-- Opaque per-key state kept by the sync engine, such as the snapshot from
+-- the last successful sync that serves as the merge base.
+CREATE TABLE IF NOT EXISTS sync_state (
+    key TEXT PRIMARY KEY,
+    data BLOB NOT NULL,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
@@ -0,0 +1,81 @@
+-- Local change log for delta sync. Every create, update or delete of a
+-- synced entity appends a row; seq never repeats, so the sync engine can
+-- remember the last sequence it uploaded.
+CREATE TABLE IF NOT EXISTS changes (
+    seq INTEGER PRIMARY KEY AUTOINCREMENT,
+    kind TEXT NOT NULL,
+    entity_id TEXT NOT NULL,
+    op TEXT NOT NULL,
+    changed_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TRIGGER IF NOT EXISTS changes_bookmark_insert AFTER INSERT ON bookmarks
+BEGIN
+    INSERT INTO changes (kind, entity_id, op) VALUES ('bookmark', NEW.id, 'upsert');
+END;
+
+CREATE TRIGGER IF NOT EXISTS changes_bookmark_update
+AFTER UPDATE OF url, title, description, image_url, favicon_url, is_favorite, updated_at ON bookmarks
+BEGIN
+    INSERT INTO changes (kind, entity_id, op) VALUES ('bookmark', NEW.id, 'upsert');
+END;
+
+CREATE TRIGGER IF NOT EXISTS changes_bookmark_delete AFTER DELETE ON bookmarks
+BEGIN
+    INSERT INTO changes (kind, entity_id, op) VALUES ('bookmark', OLD.id, 'delete');
+END;
+
+-- Tag assignments are part of the bookmark. Rows removed together with the
+-- bookmark are not recorded.
+CREATE TRIGGER IF NOT EXISTS changes_bookmark_tag_insert AFTER INSERT ON bookmark_tags
+BEGIN
+    INSERT INTO changes (kind, entity_id, op) VALUES ('bookmark', NEW.bookmark_id, 'upsert');
+END;
+
+CREATE TRIGGER IF NOT EXISTS changes_bookmark_tag_delete AFTER DELETE ON bookmark_tags
+WHEN EXISTS (SELECT 1 FROM bookmarks WHERE id = OLD.bookmark_id)
+BEGIN
+    INSERT INTO changes (kind, entity_id, op) VALUES ('bookmark', OLD.bookmark_id, 'upsert');
+END;
+
+CREATE TRIGGER IF NOT EXISTS changes_tag_insert AFTER INSERT ON tags
+BEGIN
+    INSERT INTO changes (kind, entity_id, op) VALUES ('tag', NEW.id, 'upsert');
+END;
+
+CREATE TRIGGER IF NOT EXISTS changes_tag_update
+AFTER UPDATE OF name, color, description, parent_id, tag_order, updated_at ON tags
+BEGIN
+    INSERT INTO changes (kind, entity_id, op) VALUES ('tag', NEW.id, 'upsert');
+END;
+
+CREATE TRIGGER IF NOT EXISTS changes_tag_delete AFTER DELETE ON tags
+BEGIN
+    INSERT INTO changes (kind, entity_id, op) VALUES ('tag', OLD.id, 'delete');
+END;
+
+CREATE TRIGGER IF NOT EXISTS changes_tag_group_insert AFTER INSERT ON tag_groups
+BEGIN
+    INSERT INTO changes (kind, entity_id, op) VALUES ('tag_group', NEW.id, 'upsert');
+END;
+
+CREATE TRIGGER IF NOT EXISTS changes_tag_group_update AFTER UPDATE ON tag_groups
+BEGIN
+    INSERT INTO changes (kind, entity_id, op) VALUES ('tag_group', NEW.id, 'upsert');
+END;
+
+CREATE TRIGGER IF NOT EXISTS changes_tag_group_delete AFTER DELETE ON tag_groups
+BEGIN
+    INSERT INTO changes (kind, entity_id, op) VALUES ('tag_group', OLD.id, 'delete');
+END;
+
+CREATE TRIGGER IF NOT EXISTS changes_preferences_insert AFTER INSERT ON users
+BEGIN
+    INSERT INTO changes (kind, entity_id, op) VALUES ('preferences', NEW.id, 'upsert');
+END;
+
+CREATE TRIGGER IF NOT EXISTS changes_preferences_update
+AFTER UPDATE OF nav_position, nav_items, theme ON users
+BEGIN
+    INSERT INTO changes (kind, entity_id, op) VALUES ('preferences', NEW.id, 'upsert');
+END;
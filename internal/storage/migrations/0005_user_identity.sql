@@ -0,0 +1,5 @@
+-- The identity provider the user signed in with and the user's stable ID
+-- there, so a sign-in can be matched to the same account even when the
+-- email address changes.
+ALTER TABLE users ADD COLUMN provider TEXT NOT NULL DEFAULT '';
+ALTER TABLE users ADD COLUMN subject_id TEXT NOT NULL DEFAULT '';
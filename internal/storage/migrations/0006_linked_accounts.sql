@@ -0,0 +1,17 @@
+-- Provider accounts connected to a local profile, one per provider. The
+-- account a profile was created with is carried over and backs sync.
+CREATE TABLE linked_accounts (
+	user_id TEXT NOT NULL,
+	provider TEXT NOT NULL,
+	subject_id TEXT NOT NULL,
+	email TEXT NOT NULL DEFAULT '',
+	name TEXT NOT NULL DEFAULT '',
+	is_sync_account BOOLEAN NOT NULL DEFAULT false,
+	linked_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (user_id, provider),
+	FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+INSERT INTO linked_accounts (user_id, provider, subject_id, email, name, is_sync_account)
+SELECT id, provider, subject_id, COALESCE(email, ''), COALESCE(name, ''), true
+FROM users WHERE provider != '' AND subject_id != '';
@@ -0,0 +1,7 @@
+-- Canonical form of the bookmark URL, used to detect the same page being
+-- saved twice. Existing rows are filled in on open; the index skips rows
+-- that have no value yet, as well as older duplicates that keep none.
+ALTER TABLE bookmarks ADD COLUMN normalized_url TEXT NOT NULL DEFAULT '';
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_bookmarks_normalized_url
+ON bookmarks(normalized_url) WHERE normalized_url != '';
@@ -0,0 +1,22 @@
+-- Files kept with the library, stored on disk by the SHA-256 of their
+-- content, which is also the id. Attachments no bookmark links to are
+-- garbage-collected together with their files.
+CREATE TABLE attachments (
+	id TEXT PRIMARY KEY,
+	mime_type TEXT NOT NULL,
+	size INTEGER NOT NULL,
+	width INTEGER NOT NULL DEFAULT 0,
+	height INTEGER NOT NULL DEFAULT 0,
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE bookmark_attachments (
+	bookmark_id TEXT NOT NULL,
+	attachment_id TEXT NOT NULL,
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (bookmark_id, attachment_id),
+	FOREIGN KEY(bookmark_id) REFERENCES bookmarks(id) ON DELETE CASCADE,
+	FOREIGN KEY(attachment_id) REFERENCES attachments(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_bookmark_attachments_attachment ON bookmark_attachments(attachment_id);
@@ -0,0 +1,15 @@
+-- Content shared with the app, kept from the moment it arrives until the
+-- user saves or dismisses it, so shares survive restarts and going
+-- offline. items holds the processed share as JSON once it is ready.
+CREATE TABLE share_queue (
+	id TEXT PRIMARY KEY,
+	content_type TEXT NOT NULL DEFAULT '',
+	content TEXT NOT NULL,
+	status TEXT NOT NULL DEFAULT 'pending',
+	attempts INTEGER NOT NULL DEFAULT 0,
+	last_error TEXT NOT NULL DEFAULT '',
+	next_attempt_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	items TEXT NOT NULL DEFAULT '',
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
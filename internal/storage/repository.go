@@ -0,0 +1,105 @@
+package storage
+
+import (
+	"time"
+
+	"github.com/goBookMarker/internal/models"
+	"github.com/goBookMarker/internal/search"
+)
+
+// BookmarkRepository persists bookmarks and their tag assignments.
+// SQLiteDB is the production implementation. SaveBookmark returns a
+// DuplicateError when another bookmark has the same normalized URL.
+type BookmarkRepository interface {
+	GetAllBookmarks() ([]models.Bookmark, error)
+	SaveBookmark(b models.Bookmark) error
+	DeleteBookmark(id string) error
+}
+
+// BookmarkSearcher is implemented by repositories that can evaluate a
+// structured search query, ranking free-text matches where possible.
+type BookmarkSearcher interface {
+	QueryBookmarks(q *search.Query, limit int) ([]models.SearchResult, error)
+}
+
+// TagRepository persists tags and tag groups. TagStore is the production
+// implementation.
+type TagRepository interface {
+	GetAllTags() ([]models.Tag, error)
+	CreateTag(tag models.Tag) error
+	UpdateTag(tag models.Tag) error
+	DeleteTag(id string) error
+
+	GetAllTagGroups() ([]models.TagGroup, error)
+	CreateTagGroup(group models.TagGroup) error
+	UpdateTagGroup(group models.TagGroup) error
+	DeleteTagGroup(id string) error
+}
+
+// UserRepository persists the local user profile and preferences.
+type UserRepository interface {
+	GetCurrentUser() (*models.User, error)
+	SaveUser(user *models.User) error
+}
+
+// AccountRepository persists the provider accounts linked to a user. A
+// user has at most one account per provider.
+type AccountRepository interface {
+	GetLinkedAccounts(userID string) ([]models.LinkedAccount, error)
+	LinkAccount(account models.LinkedAccount) error
+	UnlinkAccount(userID, provider string) error
+	SetSyncAccount(userID, provider string) error
+}
+
+// AttachmentRepository records attachments and the bookmarks they belong
+// to. The files themselves are kept by attachment.Store. GetAttachment
+// returns nil for an unknown ID. An attachment that a queued share refers
+// to is not orphaned.
+type AttachmentRepository interface {
+	SaveAttachment(a models.Attachment) error
+	GetAttachment(id string) (*models.Attachment, error)
+	LinkAttachment(bookmarkID, attachmentID string) error
+	GetBookmarkAttachments(bookmarkID string) ([]models.Attachment, error)
+	OrphanedAttachments(before time.Time) ([]models.Attachment, error)
+	DeleteOrphanedAttachment(id string) (bool, error)
+}
+
+// ShareQueueRepository keeps content shared with the app until the user
+// saves or dismisses it. GetQueuedShares returns the oldest share first.
+// UpdateQueuedShare stores the share's content and processing state;
+// updating a share that was deleted does nothing.
+type ShareQueueRepository interface {
+	EnqueueShare(share models.QueuedShare) error
+	GetQueuedShares() ([]models.QueuedShare, error)
+	UpdateQueuedShare(share models.QueuedShare) error
+	DeleteQueuedShare(id string) error
+}
+
+// SyncStateRepository stores opaque state for the sync engine by key.
+// GetSyncState returns nil data for a key that was never saved.
+type SyncStateRepository interface {
+	GetSyncState(key string) ([]byte, error)
+	SaveSyncState(key string, data []byte) error
+}
+
+// ChangeLogRepository exposes the log of local changes used by delta sync.
+// Sequence numbers increase monotonically and are never reused, even after
+// changes are pruned or discarded.
+type ChangeLogRepository interface {
+	ChangesSince(seq int64) ([]models.Change, error)
+	LatestChangeSeq() (int64, error)
+	PruneChanges(seq int64) error
+	DiscardChangesAfter(seq int64) error
+}
+
+var (
+	_ BookmarkRepository   = (*SQLiteDB)(nil)
+	_ BookmarkSearcher     = (*SQLiteDB)(nil)
+	_ UserRepository       = (*SQLiteDB)(nil)
+	_ AccountRepository    = (*SQLiteDB)(nil)
+	_ AttachmentRepository = (*SQLiteDB)(nil)
+	_ ShareQueueRepository = (*SQLiteDB)(nil)
+	_ SyncStateRepository  = (*SQLiteDB)(nil)
+	_ ChangeLogRepository  = (*SQLiteDB)(nil)
+	_ TagRepository        = (*TagStore)(nil)
+)
@@ -0,0 +1,301 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/goBookMarker/internal/models"
+	"github.com/goBookMarker/internal/search"
+)
+
+// The full-text index is created outside the versioned migrations because
+// it depends on the sqlite build: when FTS5 is missing the app falls back
+// to LIKE queries instead of failing to start.
+const (
+	createBookmarksFTS = `
+	CREATE VIRTUAL TABLE IF NOT EXISTS bookmarks_fts USING fts5(
+		bookmark_id UNINDEXED,
+		title,
+		description,
+		url,
+		tags,
+		page_text,
+		tokenize = 'unicode61 remove_diacritics 2'
+	)`
+
+	// bookmarkTagNames is the space separated list of tag names for the
+	// bookmark identified by the placeholder column.
+	bookmarkTagNames = `(SELECT COALESCE(GROUP_CONCAT(t.name, ' '), '')
+		FROM bookmark_tags bt JOIN tags t ON t.id = bt.tag_id
+		WHERE bt.bookmark_id = %s)`
+)
+
+var rebuildBookmarksFTS = `
+	DELETE FROM bookmarks_fts;
+	INSERT INTO bookmarks_fts (bookmark_id, title, description, url, tags, page_text)
+	SELECT b.id, COALESCE(b.title, ''), COALESCE(b.description, ''), b.url, ` +
+	fmt.Sprintf(bookmarkTagNames, "b.id") + `, b.page_text
+	FROM bookmarks b`
+
+var bookmarksFTSTriggers = []string{
+	`CREATE TRIGGER IF NOT EXISTS bookmarks_fts_insert AFTER INSERT ON bookmarks BEGIN
+		INSERT INTO bookmarks_fts (bookmark_id, title, description, url, tags, page_text)
+		VALUES (new.id, COALESCE(new.title, ''), COALESCE(new.description, ''), new.url, ` +
+		fmt.Sprintf(bookmarkTagNames, "new.id") + `, new.page_text);
+	END`,
+	`CREATE TRIGGER IF NOT EXISTS bookmarks_fts_update AFTER UPDATE ON bookmarks BEGIN
+		DELETE FROM bookmarks_fts WHERE bookmark_id = old.id;
+		INSERT INTO bookmarks_fts (bookmark_id, title, description, url, tags, page_text)
+		VALUES (new.id, COALESCE(new.title, ''), COALESCE(new.description, ''), new.url, ` +
+		fmt.Sprintf(bookmarkTagNames, "new.id") + `, new.page_text);
+	END`,
+	`CREATE TRIGGER IF NOT EXISTS bookmarks_fts_delete AFTER DELETE ON bookmarks BEGIN
+		DELETE FROM bookmarks_fts WHERE bookmark_id = old.id;
+	END`,
+	`CREATE TRIGGER IF NOT EXISTS bookmarks_fts_tag_insert AFTER INSERT ON bookmark_tags BEGIN
+		UPDATE bookmarks_fts SET tags = ` + fmt.Sprintf(bookmarkTagNames, "new.bookmark_id") + `
+		WHERE bookmark_id = new.bookmark_id;
+	END`,
+	`CREATE TRIGGER IF NOT EXISTS bookmarks_fts_tag_delete AFTER DELETE ON bookmark_tags BEGIN
+		UPDATE bookmarks_fts SET tags = ` + fmt.Sprintf(bookmarkTagNames, "old.bookmark_id") + `
+		WHERE bookmark_id = old.bookmark_id;
+	END`,
+	`CREATE TRIGGER IF NOT EXISTS bookmarks_fts_tag_rename AFTER UPDATE OF name ON tags BEGIN
+		UPDATE bookmarks_fts SET tags = ` + fmt.Sprintf(bookmarkTagNames, "bookmarks_fts.bookmark_id") + `
+		WHERE bookmark_id IN (SELECT bookmark_id FROM bookmark_tags WHERE tag_id = new.id);
+	END`,
+}
+
+var bookmarksFTSTriggerNames = []string{
+	"bookmarks_fts_insert",
+	"bookmarks_fts_update",
+	"bookmarks_fts_delete",
+	"bookmarks_fts_tag_insert",
+	"bookmarks_fts_tag_delete",
+	"bookmarks_fts_tag_rename",
+}
+
+// Markers wrapped around matched text by highlight() and snippet(); they
+// are stripped again and turned into TextRanges.
+const (
+	matchStart = "\x01"
+	matchEnd   = "\x02"
+)
+
+// initFullTextSearch sets up the FTS5 index and its triggers when the
+// sqlite build supports it. If the triggers were missing, the index is
+// rebuilt from the bookmarks table, which covers both new databases and
+// databases last opened by a build without FTS5.
+func (s *SQLiteDB) initFullTextSearch() error {
+	if !hasFTS5(s.db) {
+		s.fts = false
+		// Triggers left behind by an FTS5 build would fail every write.
+		for _, name := range bookmarksFTSTriggerNames {
+			if _, err := s.db.Exec("DROP TRIGGER IF EXISTS " + name); err != nil {
+				return fmt.Errorf("failed to drop search trigger: %w", err)
+			}
+		}
+		return nil
+	}
+
+	var existing int
+	err := s.db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'trigger' AND name = 'bookmarks_fts_insert'`).Scan(&existing)
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(createBookmarksFTS); err != nil {
+		return fmt.Errorf("failed to create search index: %w", err)
+	}
+	for _, trigger := range bookmarksFTSTriggers {
+		if _, err := tx.Exec(trigger); err != nil {
+			return fmt.Errorf("failed to create search trigger: %w", err)
+		}
+	}
+	if existing == 0 {
+		if _, err := tx.Exec(rebuildBookmarksFTS); err != nil {
+			return fmt.Errorf("failed to build search index: %w", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	s.fts = true
+	return nil
+}
+
+func hasFTS5(db *sql.DB) bool {
+	if _, err := db.Exec("CREATE VIRTUAL TABLE IF NOT EXISTS temp.fts5_probe USING fts5(x)"); err != nil {
+		return false
+	}
+	db.Exec("DROP TABLE IF EXISTS temp.fts5_probe")
+	return true
+}
+
+// FullTextSearch reports whether searches use the FTS5 index.
+func (s *SQLiteDB) FullTextSearch() bool {
+	return s.fts
+}
+
+// SetPageText stores text extracted from the bookmarked page so it is
+// included in full-text search.
+func (s *SQLiteDB) SetPageText(bookmarkID, text string) error {
+	_, err := s.db.Exec("UPDATE bookmarks SET page_text = ? WHERE id = ?", text, bookmarkID)
+	if err != nil {
+		return fmt.Errorf("failed to set page text: %w", err)
+	}
+	return nil
+}
+
+// SearchBookmarksRanked returns bookmarks matching every word of the
+// free-text query, best match first. Each word also matches as a prefix.
+// A limit of zero or less returns all matches.
+func (s *SQLiteDB) SearchBookmarksRanked(text string, limit int) ([]models.SearchResult, error) {
+	terms := searchTerms(text)
+	if len(terms) == 0 {
+		return nil, nil
+	}
+	return s.QueryBookmarks(&search.Query{Terms: terms}, limit)
+}
+
+// QueryBookmarks returns the bookmarks matching a structured query. Free
+// text is ranked with BM25 when the FTS5 index is available; otherwise,
+// and for queries with only filters, results are ordered by last update.
+// A limit of zero or less returns all matches.
+func (s *SQLiteDB) QueryBookmarks(q *search.Query, limit int) ([]models.SearchResult, error) {
+	if limit <= 0 {
+		limit = -1
+	}
+	if !s.fts || !q.HasText() {
+		return s.queryBookmarksUnranked(q, limit)
+	}
+
+	where, args := q.SQL("b", true)
+	args = append([]interface{}{matchStart, matchEnd, matchStart, matchEnd, q.FTSMatch()}, args...)
+	args = append(args, limit)
+
+	rows, err := s.db.Query(`
+		SELECT b.id, b.url, b.title, b.description, b.image_url, b.favicon_url, b.is_favorite,
+			   b.created_at, b.updated_at,
+			   (SELECT GROUP_CONCAT(t.name) FROM bookmark_tags bt JOIN tags t ON t.id = bt.tag_id
+			    WHERE bt.bookmark_id = b.id) as tags,
+			   (SELECT GROUP_CONCAT(t.id) FROM bookmark_tags bt JOIN tags t ON t.id = bt.tag_id
+			    WHERE bt.bookmark_id = b.id) as tag_ids,
+			   bm25(bookmarks_fts, 0.0, 10.0, 4.0, 2.0, 6.0, 1.0) as rank,
+			   highlight(bookmarks_fts, 1, ?, ?),
+			   snippet(bookmarks_fts, -1, ?, ?, '…', 16)
+		FROM bookmarks_fts
+		JOIN bookmarks b ON b.id = bookmarks_fts.bookmark_id
+		WHERE bookmarks_fts MATCH ? AND `+where+`
+		ORDER BY rank
+		LIMIT ?
+	`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search bookmarks: %w", err)
+	}
+	defer rows.Close()
+
+	var results []models.SearchResult
+	for rows.Next() {
+		var r models.SearchResult
+		var tags, tagIDs sql.NullString
+		var title, snippet string
+		b := &r.Bookmark
+		err := rows.Scan(&b.ID, &b.URL, &b.Title, &b.Description, &b.ImageURL,
+			&b.FaviconURL, &b.IsFavorite, &b.CreatedAt, &b.UpdatedAt, &tags, &tagIDs,
+			&r.Rank, &title, &snippet)
+		if err != nil {
+			return nil, err
+		}
+		if tags.Valid {
+			b.Tags = splitTags(tags.String)
+		}
+		if tagIDs.Valid {
+			b.TagIDs = splitTags(tagIDs.String)
+		}
+		_, r.TitleHighlights = stripMarkers(title)
+		r.Snippet, r.SnippetHighlights = stripMarkers(snippet)
+		results = append(results, r)
+	}
+	return results, rows.Err()
+}
+
+// queryBookmarksUnranked evaluates the whole query with plain SQL. It is
+// the fallback when FTS5 is unavailable and computes highlights in Go.
+func (s *SQLiteDB) queryBookmarksUnranked(q *search.Query, limit int) ([]models.SearchResult, error) {
+	where, args := q.SQL("b", false)
+	args = append(args, limit)
+
+	rows, err := s.db.Query(`
+		SELECT b.id, b.url, b.title, b.description, b.image_url, b.favicon_url, b.is_favorite,
+			   b.created_at, b.updated_at,
+			   (SELECT GROUP_CONCAT(t.name) FROM bookmark_tags bt JOIN tags t ON t.id = bt.tag_id
+			    WHERE bt.bookmark_id = b.id) as tags,
+			   (SELECT GROUP_CONCAT(t.id) FROM bookmark_tags bt JOIN tags t ON t.id = bt.tag_id
+			    WHERE bt.bookmark_id = b.id) as tag_ids
+		FROM bookmarks b
+		WHERE `+where+`
+		ORDER BY b.updated_at DESC
+		LIMIT ?
+	`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search bookmarks: %w", err)
+	}
+	defer rows.Close()
+
+	bookmarks, err := scanBookmarks(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]models.SearchResult, 0, len(bookmarks))
+	for _, b := range bookmarks {
+		results = append(results, q.Result(b))
+	}
+	return results, nil
+}
+
+// searchTerms splits a free-text query into words, dropping anything that
+// contains no letters or digits since FTS5 would reject it.
+func searchTerms(query string) []string {
+	var terms []string
+	for _, field := range strings.Fields(query) {
+		if strings.IndexFunc(field, func(r rune) bool {
+			return unicode.IsLetter(r) || unicode.IsDigit(r)
+		}) >= 0 {
+			terms = append(terms, field)
+		}
+	}
+	return terms
+}
+
+// stripMarkers removes the highlight markers from s and returns the
+// positions they enclosed.
+func stripMarkers(s string) (string, []models.TextRange) {
+	var b strings.Builder
+	var ranges []models.TextRange
+	start := -1
+	for _, r := range s {
+		switch string(r) {
+		case matchStart:
+			start = b.Len()
+		case matchEnd:
+			if start >= 0 {
+				ranges = append(ranges, models.TextRange{Start: start, End: b.Len()})
+				start = -1
+			}
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String(), ranges
+}
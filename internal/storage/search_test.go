@@ -0,0 +1,92 @@
+package storage
+
+import (
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/goBookMarker/internal/models"
+	"github.com/goBookMarker/internal/search"
+)
+
+// TestSearchPathsAgree checks that SQL and the in-memory predicate match
+// the same bookmarks.
+func TestSearchPathsAgree(t *testing.T) {
+	bookmarks := []models.Bookmark{
+		{ID: "www", URL: "https://www.example.com/a", Title: "Home"},
+		{ID: "bare", URL: "https://example.com/b", Title: "Bare"},
+		{ID: "blog", URL: "https://blog.example.com/c", Title: "Blog"},
+		{ID: "port", URL: "https://user@Example.org:8080/d", Title: "Port"},
+		{ID: "other", URL: "https://notexample.com/e", Title: "Other"},
+	}
+	pageText := map[string]string{"www": "a gopher tutorial", "other": "nothing here"}
+
+	db := newTestDB(t)
+	mem := NewMemoryStore()
+	for _, b := range bookmarks {
+		for _, store := range []interface {
+			SaveBookmark(models.Bookmark) error
+			SetPageText(string, string) error
+		}{db, mem} {
+			if err := store.SaveBookmark(b); err != nil {
+				t.Fatal(err)
+			}
+			if err := store.SetPageText(b.ID, pageText[b.ID]); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	// Queries built directly rather than parsed are normalized the same way.
+	direct := &search.Query{Sites: []string{"WWW.Example.com"}}
+
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"site:example.com", "bare,blog,www"},
+		{"site:www.example.com", "bare,blog,www"},
+		{"site:blog.example.com", "blog"},
+		{"-site:example.com", "other,port"},
+		{"site:example.org", "port"},
+		{"gopher", "www"},
+		{"tutorial site:example.com", "www"},
+		{"-gopher site:example.com", "bare,blog"},
+		{"", "bare,blog,www"},
+	}
+	for _, tt := range tests {
+		q := direct
+		if tt.query != "" {
+			var err error
+			if q, err = search.Parse(tt.query); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for _, path := range []struct {
+			name string
+			run  func() ([]models.SearchResult, error)
+		}{
+			{"memory", func() ([]models.SearchResult, error) { return mem.QueryBookmarks(q, 0) }},
+			{"sql", func() ([]models.SearchResult, error) { return db.QueryBookmarks(q, 0) }},
+			{"sql without fts", func() ([]models.SearchResult, error) {
+				fts := db.fts
+				db.fts = false
+				defer func() { db.fts = fts }()
+				return db.QueryBookmarks(q, 0)
+			}},
+		} {
+			results, err := path.run()
+			if err != nil {
+				t.Fatalf("%s: %q: %v", path.name, tt.query, err)
+			}
+			var ids []string
+			for _, r := range results {
+				ids = append(ids, r.Bookmark.ID)
+			}
+			sort.Strings(ids)
+			if got := strings.Join(ids, ","); got != tt.want {
+				t.Errorf("%s: %q matched %s, want %s", path.name, tt.query, got, tt.want)
+			}
+		}
+	}
+}
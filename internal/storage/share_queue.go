@@ -0,0 +1,77 @@
+package storage
+
+import (
+	"fmt"
+
+	"github.com/goBookMarker/internal/models"
+)
+
+// EnqueueShare stores a share that has just been received.
+func (s *SQLiteDB) EnqueueShare(share models.QueuedShare) error {
+	_, err := s.db.Exec(`
+		INSERT INTO share_queue (id, content_type, content, status, attempts, last_error,
+			next_attempt_at, items, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, COALESCE(?, CURRENT_TIMESTAMP), ?,
+			COALESCE(?, CURRENT_TIMESTAMP), COALESCE(?, CURRENT_TIMESTAMP))
+	`, share.ID, share.ContentType, share.Content, share.Status, share.Attempts, share.LastError,
+		sqlTime(share.NextAttemptAt), string(share.Items), sqlTime(share.CreatedAt), sqlTime(share.UpdatedAt))
+	if err != nil {
+		return fmt.Errorf("failed to queue share: %w", err)
+	}
+	return nil
+}
+
+// GetQueuedShares returns the shares in the queue, oldest first.
+func (s *SQLiteDB) GetQueuedShares() ([]models.QueuedShare, error) {
+	rows, err := s.db.Query(`
+		SELECT id, content_type, content, status, attempts, last_error,
+			next_attempt_at, items, created_at, updated_at
+		FROM share_queue
+		ORDER BY created_at, rowid
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query share queue: %w", err)
+	}
+	defer rows.Close()
+
+	var shares []models.QueuedShare
+	for rows.Next() {
+		var share models.QueuedShare
+		var items string
+		if err := rows.Scan(&share.ID, &share.ContentType, &share.Content, &share.Status,
+			&share.Attempts, &share.LastError, &share.NextAttemptAt, &items,
+			&share.CreatedAt, &share.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan queued share: %w", err)
+		}
+		if items != "" {
+			share.Items = []byte(items)
+		}
+		shares = append(shares, share)
+	}
+	return shares, rows.Err()
+}
+
+// UpdateQueuedShare stores the processing state of a queued share, and
+// its content, which processing may replace with a reference to where it
+// was stored. A share that was removed stays removed.
+func (s *SQLiteDB) UpdateQueuedShare(share models.QueuedShare) error {
+	_, err := s.db.Exec(`
+		UPDATE share_queue SET content = ?, status = ?, attempts = ?, last_error = ?,
+			next_attempt_at = COALESCE(?, CURRENT_TIMESTAMP), items = ?,
+			updated_at = COALESCE(?, CURRENT_TIMESTAMP)
+		WHERE id = ?
+	`, share.Content, share.Status, share.Attempts, share.LastError, sqlTime(share.NextAttemptAt),
+		string(share.Items), sqlTime(share.UpdatedAt), share.ID)
+	if err != nil {
+		return fmt.Errorf("failed to update queued share: %w", err)
+	}
+	return nil
+}
+
+// DeleteQueuedShare removes a share from the queue.
+func (s *SQLiteDB) DeleteQueuedShare(id string) error {
+	if _, err := s.db.Exec("DELETE FROM share_queue WHERE id = ?", id); err != nil {
+		return fmt.Errorf("failed to delete queued share: %w", err)
+	}
+	return nil
+}
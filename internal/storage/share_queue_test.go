@@ -0,0 +1,58 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/goBookMarker/internal/models"
+)
+
+func TestUpdateQueuedShareReplacesContent(t *testing.T) {
+	db := newTestDB(t)
+	a := models.Attachment{ID: "0123abcd", MimeType: "image/png", Size: 3, CreatedAt: time.Now().Add(-time.Hour)}
+	if err := db.SaveAttachment(a); err != nil {
+		t.Fatal(err)
+	}
+	share := models.QueuedShare{
+		ID:          "s1",
+		ContentType: "image/png",
+		Content:     "data:image/png;base64,AAAA",
+		Status:      models.SharePending,
+	}
+	if err := db.EnqueueShare(share); err != nil {
+		t.Fatal(err)
+	}
+
+	share.Content = "attachment:" + a.ID
+	share.Status = models.ShareFailed
+	if err := db.UpdateQueuedShare(share); err != nil {
+		t.Fatal(err)
+	}
+	shares, err := db.GetQueuedShares()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(shares) != 1 || shares[0].Content != share.Content || shares[0].ContentType != "image/png" {
+		t.Fatalf("shares = %+v, want the content replaced", shares)
+	}
+
+	// The attachment is still in use by the queued share, which has no
+	// items.
+	orphans, err := db.OrphanedAttachments(time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(orphans) != 0 {
+		t.Fatalf("orphaned attachments = %+v, want none", orphans)
+	}
+	if deleted, err := db.DeleteOrphanedAttachment(a.ID); err != nil || deleted {
+		t.Fatalf("DeleteOrphanedAttachment = %v, %v, want the attachment kept", deleted, err)
+	}
+
+	if err := db.DeleteQueuedShare(share.ID); err != nil {
+		t.Fatal(err)
+	}
+	if deleted, err := db.DeleteOrphanedAttachment(a.ID); err != nil || !deleted {
+		t.Fatalf("DeleteOrphanedAttachment after removing the share = %v, %v", deleted, err)
+	}
+}
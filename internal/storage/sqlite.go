@@ -14,59 +14,17 @@ import (
 )
 
 type SQLiteDB struct {
-	db *sql.DB
+	db  *sql.DB
+	fts bool
 }
 
-const (
-	createUsersTable = `
-	CREATE TABLE IF NOT EXISTS users (
-		id TEXT PRIMARY KEY,
-		email TEXT UNIQUE,
-		name TEXT,
-		nav_position TEXT DEFAULT 'bottom',
-		nav_items TEXT,
-		theme TEXT DEFAULT 'system',
-		sync_enabled BOOLEAN DEFAULT false,
-		last_sync TEXT,
-		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
-	)`
-
-	createBookmarksTable = `
-	CREATE TABLE IF NOT EXISTS bookmarks (
-		id TEXT PRIMARY KEY,
-		user_id TEXT,
-		url TEXT NOT NULL,
-		title TEXT,
-		description TEXT,
-		image_url TEXT,
-		favicon_url TEXT,
-		is_favorite BOOLEAN DEFAULT false,
-		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-		FOREIGN KEY(user_id) REFERENCES users(id)
-	)`
-
-	createTagsTable = `
-	CREATE TABLE IF NOT EXISTS tags (
-		id TEXT PRIMARY KEY,
-		name TEXT UNIQUE NOT NULL,
-		color TEXT,
-		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
-	)`
-
-	createBookmarkTagsTable = `
-	CREATE TABLE IF NOT EXISTS bookmark_tags (
-		bookmark_id TEXT,
-		tag_id TEXT,
-		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-		PRIMARY KEY(bookmark_id, tag_id),
-		FOREIGN KEY(bookmark_id) REFERENCES bookmarks(id) ON DELETE CASCADE,
-		FOREIGN KEY(tag_id) REFERENCES tags(id) ON DELETE CASCADE
-	)`
-)
-
 func NewSQLiteDB() (*SQLiteDB, error) {
-	db, err := sql.Open("sqlite", "bookmarker.db")
+	return openSQLiteDB("bookmarker.db")
+}
+
+// openSQLiteDB opens the database at path, creating it if needed.
+func openSQLiteDB(path string) (*SQLiteDB, error) {
+	db, err := sql.Open("sqlite", path)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open database: %v", err)
 	}
@@ -76,9 +34,16 @@ func NewSQLiteDB() (*SQLiteDB, error) {
 	}
 
 	sqlite := &SQLiteDB{db: db}
-	if err := sqlite.initSchema(); err != nil {
+	if err := Migrate(db); err != nil {
 		return nil, fmt.Errorf("failed to initialize schema: %v", err)
 	}
+	if err := sqlite.backfillNormalizedURLs(); err != nil {
+		return nil, fmt.Errorf("failed to initialize schema: %v", err)
+	}
+
+	if err := sqlite.initFullTextSearch(); err != nil {
+		return nil, fmt.Errorf("failed to initialize search: %v", err)
+	}
 
 	return sqlite, nil
 }
@@ -87,20 +52,9 @@ func (s *SQLiteDB) Close() error {
 	return s.db.Close()
 }
 
-func (s *SQLiteDB) initSchema() error {
-	tables := []string{
-		createUsersTable,
-		createBookmarksTable,
-		createTagsTable,
-		createBookmarkTagsTable,
-	}
-
-	for _, table := range tables {
-		if _, err := s.db.Exec(table); err != nil {
-			return fmt.Errorf("failed to create table: %v", err)
-		}
-	}
-	return nil
+// TagStore returns a TagStore backed by the same database connection.
+func (s *SQLiteDB) TagStore() *TagStore {
+	return NewTagStore(s.db)
 }
 
 func (s *SQLiteDB) GetCurrentUser() (*models.User, error) {
@@ -108,9 +62,9 @@ func (s *SQLiteDB) GetCurrentUser() (*models.User, error) {
 	var navItemsJSON string
 
 	err := s.db.QueryRow(`
-		SELECT id, email, name, nav_position, nav_items, theme, sync_enabled, last_sync
-		FROM users LIMIT 1
-	`).Scan(&user.ID, &user.Email, &user.Name, &user.NavPosition, &navItemsJSON, &user.Theme, &user.SyncEnabled, &user.LastSync)
+		SELECT id, email, name, provider, subject_id, nav_position, nav_items, theme, sync_enabled, last_sync
+		FROM users ORDER BY created_at, id LIMIT 1
+	`).Scan(&user.ID, &user.Email, &user.Name, &user.Provider, &user.SubjectID, &user.NavPosition, &navItemsJSON, &user.Theme, &user.SyncEnabled, &user.LastSync)
 
 	if err == sql.ErrNoRows {
 		return nil, nil
@@ -133,17 +87,19 @@ func (s *SQLiteDB) SaveUser(user *models.User) error {
 	}
 
 	_, err = s.db.Exec(`
-		INSERT INTO users (id, email, name, nav_position, nav_items, theme, sync_enabled, last_sync)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		INSERT INTO users (id, email, name, provider, subject_id, nav_position, nav_items, theme, sync_enabled, last_sync)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 		ON CONFLICT(id) DO UPDATE SET
 			email = excluded.email,
 			name = excluded.name,
+			provider = excluded.provider,
+			subject_id = excluded.subject_id,
 			nav_position = excluded.nav_position,
 			nav_items = excluded.nav_items,
 			theme = excluded.theme,
 			sync_enabled = excluded.sync_enabled,
 			last_sync = excluded.last_sync
-	`, user.ID, user.Email, user.Name, user.NavPosition, navItemsJSON, user.Theme, user.SyncEnabled, user.LastSync)
+	`, user.ID, user.Email, user.Name, user.Provider, user.SubjectID, user.NavPosition, navItemsJSON, user.Theme, user.SyncEnabled, user.LastSync)
 
 	return err
 }
@@ -151,7 +107,7 @@ func (s *SQLiteDB) SaveUser(user *models.User) error {
 func (s *SQLiteDB) GetRecentBookmarks(limit int) ([]models.Bookmark, error) {
 	rows, err := s.db.Query(`
 		SELECT b.id, b.url, b.title, b.description, b.image_url, b.favicon_url, b.is_favorite,
-			   b.created_at, b.updated_at, GROUP_CONCAT(t.name) as tags
+			   b.created_at, b.updated_at, GROUP_CONCAT(t.name) as tags, GROUP_CONCAT(t.id) as tag_ids
 		FROM bookmarks b
 		LEFT JOIN bookmark_tags bt ON b.id = bt.bookmark_id
 		LEFT JOIN tags t ON bt.tag_id = t.id
@@ -164,25 +120,29 @@ func (s *SQLiteDB) GetRecentBookmarks(limit int) ([]models.Bookmark, error) {
 	}
 	defer rows.Close()
 
-	var bookmarks []models.Bookmark
-	for rows.Next() {
-		var b models.Bookmark
-		var tags sql.NullString
-		err := rows.Scan(&b.ID, &b.URL, &b.Title, &b.Description, &b.ImageURL,
-			&b.FaviconURL, &b.IsFavorite, &b.CreatedAt, &b.UpdatedAt, &tags)
-		if err != nil {
-			return nil, err
-		}
+	return scanBookmarks(rows)
+}
 
-		if tags.Valid {
-			b.Tags = splitTags(tags.String)
-		}
-		bookmarks = append(bookmarks, b)
+func (s *SQLiteDB) GetAllBookmarks() ([]models.Bookmark, error) {
+	rows, err := s.db.Query(`
+		SELECT b.id, b.url, b.title, b.description, b.image_url, b.favicon_url, b.is_favorite,
+			   b.created_at, b.updated_at, GROUP_CONCAT(t.name) as tags, GROUP_CONCAT(t.id) as tag_ids
+		FROM bookmarks b
+		LEFT JOIN bookmark_tags bt ON b.id = bt.bookmark_id
+		LEFT JOIN tags t ON bt.tag_id = t.id
+		GROUP BY b.id
+		ORDER BY b.created_at DESC
+	`)
+	if err != nil {
+		return nil, err
 	}
+	defer rows.Close()
 
-	return bookmarks, nil
+	return scanBookmarks(rows)
 }
 
+// SaveBookmark creates or updates b. It returns a DuplicateError if
+// another bookmark has the same URL once normalized.
 func (s *SQLiteDB) SaveBookmark(b models.Bookmark) error {
 	tx, err := s.db.Begin()
 	if err != nil {
@@ -190,19 +150,28 @@ func (s *SQLiteDB) SaveBookmark(b models.Bookmark) error {
 	}
 	defer tx.Rollback()
 
-	// Insert or update bookmark
+	normalized, err := normalizedURLTx(tx, b.ID, b.URL)
+	if err != nil {
+		return err
+	}
+
+	// Insert or update bookmark. CreatedAt is kept when set, e.g. for
+	// imported bookmarks, and never changes afterwards. UpdatedAt is kept
+	// when set so synced changes retain their original time.
 	_, err = tx.Exec(`
-		INSERT INTO bookmarks (id, user_id, url, title, description, image_url, favicon_url, is_favorite, updated_at)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP)
+		INSERT INTO bookmarks (id, user_id, url, normalized_url, title, description, image_url, favicon_url, is_favorite, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, COALESCE(?, CURRENT_TIMESTAMP), COALESCE(?, CURRENT_TIMESTAMP))
 		ON CONFLICT(id) DO UPDATE SET
 			url = excluded.url,
+			normalized_url = excluded.normalized_url,
 			title = excluded.title,
 			description = excluded.description,
 			image_url = excluded.image_url,
 			favicon_url = excluded.favicon_url,
 			is_favorite = excluded.is_favorite,
-			updated_at = CURRENT_TIMESTAMP
-	`, b.ID, b.UserID, b.URL, b.Title, b.Description, b.ImageURL, b.FaviconURL, b.IsFavorite)
+			updated_at = excluded.updated_at
+	`, b.ID, b.UserID, b.URL, normalized, b.Title, b.Description, b.ImageURL, b.FaviconURL, b.IsFavorite,
+		sqlTime(b.CreatedAt), sqlTime(b.UpdatedAt))
 	if err != nil {
 		return err
 	}
@@ -215,17 +184,17 @@ func (s *SQLiteDB) SaveBookmark(b models.Bookmark) error {
 
 	// Insert tags and bookmark-tag relationships
 	for _, tag := range b.Tags {
-		var tagID string
-		err := tx.QueryRow("SELECT id FROM tags WHERE name = ?", tag).Scan(&tagID)
-		if err == sql.ErrNoRows {
+		tagID, err := tagIDTx(tx, tag, b.TagIDs)
+		if err != nil {
+			return err
+		}
+		if tagID == "" {
 			// Create new tag
 			tagID = generateID()
 			_, err = tx.Exec("INSERT INTO tags (id, name) VALUES (?, ?)", tagID, tag)
 			if err != nil {
 				return err
 			}
-		} else if err != nil {
-			return err
 		}
 
 		// Create bookmark-tag relationship
@@ -238,41 +207,71 @@ func (s *SQLiteDB) SaveBookmark(b models.Bookmark) error {
 	return tx.Commit()
 }
 
-func (s *SQLiteDB) SearchBookmarks(query string) ([]models.Bookmark, error) {
-	rows, err := s.db.Query(`
-		SELECT DISTINCT b.id, b.url, b.title, b.description, b.image_url, b.favicon_url,
-			   b.is_favorite, b.created_at, b.updated_at, GROUP_CONCAT(t.name) as tags
-		FROM bookmarks b
-		LEFT JOIN bookmark_tags bt ON b.id = bt.bookmark_id
-		LEFT JOIN tags t ON bt.tag_id = t.id
-		WHERE b.title LIKE ? OR b.description LIKE ? OR b.url LIKE ? OR t.name LIKE ?
-		GROUP BY b.id
-		ORDER BY b.updated_at DESC
-	`, "%"+query+"%", "%"+query+"%", "%"+query+"%", "%"+query+"%")
+// tagIDTx returns the ID of the tag named name, or "" if there is none.
+// Names are only unique among siblings, so of several tags with the name,
+// the one in ids wins, then a top-level one.
+func tagIDTx(tx *sql.Tx, name string, ids []string) (string, error) {
+	rows, err := tx.Query(`
+		SELECT id FROM tags WHERE name = ?
+		ORDER BY parent_id IS NOT NULL, created_at, id
+	`, name)
 	if err != nil {
-		return nil, err
+		return "", err
 	}
 	defer rows.Close()
 
-	var bookmarks []models.Bookmark
+	var first string
 	for rows.Next() {
-		var b models.Bookmark
-		var tags sql.NullString
-		err := rows.Scan(&b.ID, &b.URL, &b.Title, &b.Description, &b.ImageURL,
-			&b.FaviconURL, &b.IsFavorite, &b.CreatedAt, &b.UpdatedAt, &tags)
-		if err != nil {
-			return nil, err
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return "", err
 		}
-
-		if tags.Valid {
-			b.Tags = splitTags(tags.String)
+		for _, want := range ids {
+			if id == want {
+				return id, nil
+			}
 		}
-		bookmarks = append(bookmarks, b)
+		if first == "" {
+			first = id
+		}
+	}
+	return first, rows.Err()
+}
+
+// SearchBookmarks returns the bookmarks matching query, best match first.
+// See SearchBookmarksRanked for the matching rules.
+func (s *SQLiteDB) SearchBookmarks(query string) ([]models.Bookmark, error) {
+	results, err := s.SearchBookmarksRanked(query, 0)
+	if err != nil {
+		return nil, err
 	}
 
+	bookmarks := make([]models.Bookmark, len(results))
+	for i, r := range results {
+		bookmarks[i] = r.Bookmark
+	}
 	return bookmarks, nil
 }
 
+func (s *SQLiteDB) DeleteBookmark(id string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM bookmark_tags WHERE bookmark_id = ?", id); err != nil {
+		return fmt.Errorf("failed to delete bookmark tags: %w", err)
+	}
+	if _, err := tx.Exec("DELETE FROM bookmark_attachments WHERE bookmark_id = ?", id); err != nil {
+		return fmt.Errorf("failed to delete bookmark attachments: %w", err)
+	}
+	if _, err := tx.Exec("DELETE FROM bookmarks WHERE id = ?", id); err != nil {
+		return fmt.Errorf("failed to delete bookmark: %w", err)
+	}
+	return tx.Commit()
+}
+
 func (s *SQLiteDB) GetAllTags() ([]models.Tag, error) {
 	rows, err := s.db.Query(`
 		SELECT id, name, color, created_at,
@@ -318,9 +317,9 @@ func (s *SQLiteDB) UpdateUser(user *models.User) error {
 }
 
 func (s *SQLiteDB) CreateTag(tag models.Tag) error {
-	query := `INSERT INTO tags (id, name, color, description, parent_id, order_num, count, created_at, updated_at, usage_stats)
-			  VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
-	
+	query := `INSERT INTO tags (id, name, color, description, parent_id, tag_order, created_at, updated_at, usage_stats)
+			  VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
 	statsJSON, err := json.Marshal(tag.UsageStats)
 	if err != nil {
 		return fmt.Errorf("failed to marshal usage stats: %w", err)
@@ -331,12 +330,11 @@ func (s *SQLiteDB) CreateTag(tag models.Tag) error {
 		tag.Name,
 		tag.Color,
 		tag.Description,
-		tag.ParentID,
+		nullIfEmpty(tag.ParentID),
 		tag.Order,
-		tag.Count,
 		tag.CreatedAt,
 		tag.UpdatedAt,
-		statsJSON,
+		string(statsJSON),
 	)
 	if err != nil {
 		return fmt.Errorf("failed to create tag: %w", err)
@@ -345,10 +343,10 @@ func (s *SQLiteDB) CreateTag(tag models.Tag) error {
 }
 
 func (s *SQLiteDB) UpdateTag(tag models.Tag) error {
-	query := `UPDATE tags SET name = ?, color = ?, description = ?, parent_id = ?, 
-			  order_num = ?, count = ?, updated_at = ?, usage_stats = ?
+	query := `UPDATE tags SET name = ?, color = ?, description = ?, parent_id = ?,
+			  tag_order = ?, updated_at = ?, usage_stats = ?
 			  WHERE id = ?`
-	
+
 	statsJSON, err := json.Marshal(tag.UsageStats)
 	if err != nil {
 		return fmt.Errorf("failed to marshal usage stats: %w", err)
@@ -358,11 +356,10 @@ func (s *SQLiteDB) UpdateTag(tag models.Tag) error {
 		tag.Name,
 		tag.Color,
 		tag.Description,
-		tag.ParentID,
+		nullIfEmpty(tag.ParentID),
 		tag.Order,
-		tag.Count,
 		tag.UpdatedAt,
-		statsJSON,
+		string(statsJSON),
 		tag.ID,
 	)
 	if err != nil {
@@ -381,10 +378,13 @@ func (s *SQLiteDB) DeleteTag(tagID string) error {
 }
 
 func (s *SQLiteDB) GetTagsByBookmark(bookmarkID string) ([]models.Tag, error) {
-	query := `SELECT t.* FROM tags t
+	query := `SELECT t.id, t.name, t.color, t.description, COALESCE(t.parent_id, ''), t.tag_order,
+			  (SELECT COUNT(*) FROM bookmark_tags WHERE tag_id = t.id) as count,
+			  t.created_at, t.updated_at, t.usage_stats
+			  FROM tags t
 			  JOIN bookmark_tags bt ON bt.tag_id = t.id
 			  WHERE bt.bookmark_id = ?`
-	
+
 	rows, err := s.db.Query(query, bookmarkID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get tags: %w", err)
@@ -421,6 +421,28 @@ func (s *SQLiteDB) GetTagsByBookmark(bookmarkID string) ([]models.Tag, error) {
 }
 
 // Helper functions
+func scanBookmarks(rows *sql.Rows) ([]models.Bookmark, error) {
+	var bookmarks []models.Bookmark
+	for rows.Next() {
+		var b models.Bookmark
+		var tags, tagIDs sql.NullString
+		err := rows.Scan(&b.ID, &b.URL, &b.Title, &b.Description, &b.ImageURL,
+			&b.FaviconURL, &b.IsFavorite, &b.CreatedAt, &b.UpdatedAt, &tags, &tagIDs)
+		if err != nil {
+			return nil, err
+		}
+
+		if tags.Valid {
+			b.Tags = splitTags(tags.String)
+		}
+		if tagIDs.Valid {
+			b.TagIDs = splitTags(tagIDs.String)
+		}
+		bookmarks = append(bookmarks, b)
+	}
+	return bookmarks, rows.Err()
+}
+
 func splitTags(tags string) []string {
 	if tags == "" {
 		return nil
@@ -428,6 +450,24 @@ func splitTags(tags string) []string {
 	return strings.Split(tags, ",")
 }
 
+// nullIfEmpty stores optional references such as tags.parent_id as NULL
+// rather than an empty string.
+func nullIfEmpty(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+// sqlTime formats t like CURRENT_TIMESTAMP so it compares correctly with
+// datetime(), and stores the zero time as NULL.
+func sqlTime(t time.Time) interface{} {
+	if t.IsZero() {
+		return nil
+	}
+	return t.UTC().Format("2006-01-02 15:04:05")
+}
+
 func generateID() string {
 	return uuid.New().String()
 }
@@ -0,0 +1,74 @@
+package storage
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/goBookMarker/internal/models"
+)
+
+func newTestDB(t *testing.T) *SQLiteDB {
+	t.Helper()
+	db, err := openSQLiteDB(filepath.Join(t.TempDir(), "bookmarker.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestSaveBookmarkLinksTagsByID(t *testing.T) {
+	db := newTestDB(t)
+	tags := db.TagStore()
+	now := time.Now()
+	for _, tag := range []models.Tag{
+		{ID: "bar", Name: "Bar"},
+		{ID: "bar-go", Name: "Go", ParentID: "bar"},
+		{ID: "other", Name: "Other"},
+		{ID: "other-go", Name: "Go", ParentID: "other"},
+	} {
+		tag.CreatedAt, tag.UpdatedAt = now, now
+		if err := tags.CreateTag(tag); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	bookmarks := []models.Bookmark{
+		{ID: "b1", URL: "https://example.com/1", Tags: []string{"Go"}, TagIDs: []string{"other-go"}},
+		{ID: "b2", URL: "https://example.com/2", Tags: []string{"Go"}, TagIDs: []string{"bar-go"}},
+		// A tag ID that no longer matches the name is ignored.
+		{ID: "b3", URL: "https://example.com/3", Tags: []string{"Bar"}, TagIDs: []string{"other-go"}},
+		{ID: "b4", URL: "https://example.com/4", Tags: []string{"New"}},
+	}
+	for _, b := range bookmarks {
+		if err := db.SaveBookmark(b); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	saved, err := db.GetAllBookmarks()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make(map[string]models.Bookmark)
+	for _, b := range saved {
+		got[b.ID] = b
+	}
+	for id, want := range map[string]string{"b1": "other-go", "b2": "bar-go", "b3": "bar"} {
+		if ids := got[id].TagIDs; len(ids) != 1 || ids[0] != want {
+			t.Errorf("%s linked to %v, want %s", id, ids, want)
+		}
+	}
+	if b := got["b4"]; len(b.Tags) != 1 || b.Tags[0] != "New" || len(b.TagIDs) != 1 {
+		t.Errorf("b4 has tags %v %v, want a new tag", b.Tags, b.TagIDs)
+	}
+
+	results, err := db.SearchBookmarksRanked("https://example.com/1", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || len(results[0].Bookmark.TagIDs) != 1 || results[0].Bookmark.TagIDs[0] != "other-go" {
+		t.Errorf("search results %+v, want b1 with its tag ID", results)
+	}
+}
@@ -0,0 +1,32 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// GetSyncState returns the sync state stored under key, or nil if there is
+// none.
+func (s *SQLiteDB) GetSyncState(key string) ([]byte, error) {
+	var data []byte
+	err := s.db.QueryRow("SELECT data FROM sync_state WHERE key = ?", key).Scan(&data)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load sync state: %w", err)
+	}
+	return data, nil
+}
+
+// SaveSyncState replaces the sync state stored under key.
+func (s *SQLiteDB) SaveSyncState(key string, data []byte) error {
+	_, err := s.db.Exec(`
+		INSERT INTO sync_state (key, data, updated_at) VALUES (?, ?, CURRENT_TIMESTAMP)
+		ON CONFLICT(key) DO UPDATE SET data = excluded.data, updated_at = CURRENT_TIMESTAMP
+	`, key, data)
+	if err != nil {
+		return fmt.Errorf("failed to save sync state: %w", err)
+	}
+	return nil
+}
@@ -3,7 +3,6 @@ package storage
 import (
 	"database/sql"
 	"encoding/json"
-	"fmt"
 	"time"
 
 	"github.com/goBookMarker/internal/models"
@@ -17,46 +16,11 @@ func NewTagStore(db *sql.DB) *TagStore {
 	return &TagStore{db: db}
 }
 
+// InitSchema applies any pending schema migrations. The tags, tag_groups
+// and bookmark_tags tables are shared with SQLiteDB and defined once in
+// the embedded migrations.
 func (s *TagStore) InitSchema() error {
-	queries := []string{
-		`CREATE TABLE IF NOT EXISTS tags (
-			id TEXT PRIMARY KEY,
-			name TEXT NOT NULL,
-			color TEXT NOT NULL,
-			description TEXT,
-			parent_id TEXT,
-			tag_order INTEGER DEFAULT 0,
-			created_at TIMESTAMP NOT NULL,
-			updated_at TIMESTAMP NOT NULL,
-			usage_stats TEXT,
-			FOREIGN KEY(parent_id) REFERENCES tags(id) ON DELETE SET NULL
-		)`,
-		`CREATE TABLE IF NOT EXISTS tag_groups (
-			id TEXT PRIMARY KEY,
-			name TEXT NOT NULL,
-			tag_ids TEXT NOT NULL,
-			group_order INTEGER DEFAULT 0,
-			expanded BOOLEAN DEFAULT true
-		)`,
-		`CREATE TABLE IF NOT EXISTS bookmark_tags (
-			bookmark_id TEXT NOT NULL,
-			tag_id TEXT NOT NULL,
-			created_at TIMESTAMP NOT NULL,
-			PRIMARY KEY (bookmark_id, tag_id),
-			FOREIGN KEY(bookmark_id) REFERENCES bookmarks(id) ON DELETE CASCADE,
-			FOREIGN KEY(tag_id) REFERENCES tags(id) ON DELETE CASCADE
-		)`,
-		`CREATE INDEX IF NOT EXISTS idx_tags_parent_id ON tags(parent_id)`,
-		`CREATE INDEX IF NOT EXISTS idx_tags_order ON tags(tag_order)`,
-		`CREATE INDEX IF NOT EXISTS idx_tag_groups_order ON tag_groups(group_order)`,
-	}
-
-	for _, query := range queries {
-		if _, err := s.db.Exec(query); err != nil {
-			return fmt.Errorf("failed to create schema: %w", err)
-		}
-	}
-	return nil
+	return Migrate(s.db)
 }
 
 // CRUD operations for tags
@@ -75,7 +39,7 @@ func (s *TagStore) CreateTag(tag models.Tag) error {
 		tag.Name,
 		tag.Color,
 		tag.Description,
-		tag.ParentID,
+		nullIfEmpty(tag.ParentID),
 		tag.Order,
 		tag.CreatedAt,
 		tag.UpdatedAt,
@@ -89,7 +53,7 @@ func (s *TagStore) GetTag(id string) (*models.Tag, error) {
 	var statsJSON string
 
 	query := `
-		SELECT id, name, color, description, parent_id, tag_order, created_at, updated_at, usage_stats
+		SELECT id, name, color, description, COALESCE(parent_id, ''), tag_order, created_at, updated_at, usage_stats
 		FROM tags WHERE id = ?
 	`
 	err := s.db.QueryRow(query, id).Scan(
@@ -119,6 +83,9 @@ func (s *TagStore) UpdateTag(tag models.Tag) error {
 	if err != nil {
 		return err
 	}
+	if tag.UpdatedAt.IsZero() {
+		tag.UpdatedAt = time.Now()
+	}
 
 	query := `
 		UPDATE tags 
@@ -130,9 +97,9 @@ func (s *TagStore) UpdateTag(tag models.Tag) error {
 		tag.Name,
 		tag.Color,
 		tag.Description,
-		tag.ParentID,
+		nullIfEmpty(tag.ParentID),
 		tag.Order,
-		time.Now(),
+		tag.UpdatedAt,
 		string(statsJSON),
 		tag.ID,
 	)
@@ -140,8 +107,22 @@ func (s *TagStore) UpdateTag(tag models.Tag) error {
 }
 
 func (s *TagStore) DeleteTag(id string) error {
-	_, err := s.db.Exec("DELETE FROM tags WHERE id = ?", id)
-	return err
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM bookmark_tags WHERE tag_id = ?", id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("UPDATE tags SET parent_id = NULL WHERE parent_id = ?", id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM tags WHERE id = ?", id); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
 // Tag Group operations
@@ -217,7 +198,7 @@ func (s *TagStore) DeleteTagGroup(id string) error {
 // Advanced queries
 func (s *TagStore) GetTagsByParent(parentID string) ([]models.Tag, error) {
 	query := `
-		SELECT id, name, color, description, parent_id, tag_order, created_at, updated_at, usage_stats
+		SELECT id, name, color, description, COALESCE(parent_id, ''), tag_order, created_at, updated_at, usage_stats
 		FROM tags 
 		WHERE parent_id = ?
 		ORDER BY tag_order
@@ -258,7 +239,7 @@ func (s *TagStore) GetTagsByParent(parentID string) ([]models.Tag, error) {
 
 func (s *TagStore) GetAllTags() ([]models.Tag, error) {
 	query := `
-		SELECT id, name, color, description, parent_id, tag_order, created_at, updated_at, usage_stats
+		SELECT id, name, color, description, COALESCE(parent_id, ''), tag_order, created_at, updated_at, usage_stats
 		FROM tags 
 		ORDER BY tag_order
 	`
@@ -379,7 +360,7 @@ func (s *TagStore) ImportTags(export *models.TagExport) error {
 			tag.Name,
 			tag.Color,
 			tag.Description,
-			tag.ParentID,
+			nullIfEmpty(tag.ParentID),
 			tag.Order,
 			tag.CreatedAt,
 			tag.UpdatedAt,
@@ -0,0 +1,561 @@
+package sync
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/goBookMarker/internal/models"
+)
+
+// errSnapshotSuperseded is returned by a compaction whose snapshot lost to
+// one another device wrote at the same time.
+var errSnapshotSuperseded = errors.New("sync snapshot superseded")
+
+// ErrNotSupported is returned by optional provider or source methods that
+// the underlying implementation lacks, such as the FileStore methods of an
+// EncryptedSync wrapping a provider without them.
+var ErrNotSupported = errors.New("not supported by the sync provider")
+
+// FileStore is implemented by providers that can hold several named files
+// next to each other. SyncManager uses it for delta sync: instead of
+// rewriting the whole library on every sync, each device appends segment
+// files holding only what changed, which are periodically compacted into a
+// snapshot file.
+//
+// Names are flat and made of letters, digits, '-', '_' and '.'. GetFile
+// returns ErrNoRemoteData for a missing file and DeleteFile ignores one.
+type FileStore interface {
+	PutFile(ctx context.Context, name string, data []byte) error
+	GetFile(ctx context.Context, name string) ([]byte, error)
+	ListFiles(ctx context.Context, prefix string) ([]string, error)
+	DeleteFile(ctx context.Context, name string) error
+}
+
+// ChangeSource is a Source that keeps a log of local changes. With it,
+// SyncManager skips syncs when neither side changed and makes sure every
+// logged change is uploaded. ChangesSince returns ErrNotSupported when the
+// source has no log after all.
+type ChangeSource interface {
+	Source
+	ChangesSince(seq int64) ([]models.Change, error)
+	// AcknowledgeChanges tells the source that the changes up to and
+	// including seq have been uploaded.
+	AcknowledgeChanges(seq int64) error
+}
+
+const (
+	deltaPrefix    = "delta-"
+	snapshotPrefix = "delta-snapshot-"
+	segmentPrefix  = "delta-segment-"
+
+	// compactAfterSegments is how many segments may pile up on top of a
+	// snapshot before a sync compacts them into a new one.
+	compactAfterSegments = 32
+)
+
+// deltaState is what the manager remembers about the remote files: the
+// snapshot and segments that the base was built from.
+type deltaState struct {
+	Snapshot string   `json:"snapshot,omitempty"`
+	Segments []string `json:"segments,omitempty"`
+	AckedSeq int64    `json:"acked_seq,omitempty"`
+}
+
+// snapshotFile is a compacted library. Base is the snapshot it was
+// compacted on top of, if any. Compacted lists the segments it includes;
+// segments not listed are applied on top of it.
+type snapshotFile struct {
+	Device    string          `json:"device"`
+	CreatedAt time.Time       `json:"created_at"`
+	Base      string          `json:"base,omitempty"`
+	Compacted []string        `json:"compacted,omitempty"`
+	Library   json.RawMessage `json:"library"`
+}
+
+// segmentFile holds the entities one sync changed, with their merged
+// values, and tombstones for the ones it deleted.
+type segmentFile struct {
+	Device    string          `json:"device"`
+	CreatedAt time.Time       `json:"created_at"`
+	Changes   json.RawMessage `json:"changes"`
+}
+
+// checkFileName reports whether name is valid for a FileStore.
+func checkFileName(name string) error {
+	if name == "" || name[0] == '.' {
+		return fmt.Errorf("invalid sync file name %q", name)
+	}
+	for _, r := range name {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_', r == '.':
+		default:
+			return fmt.Errorf("invalid sync file name %q", name)
+		}
+	}
+	return nil
+}
+
+// deltaFileName returns a new file name that sorts after the ones created
+// earlier.
+func deltaFileName(prefix, device string, now time.Time) string {
+	return fmt.Sprintf("%s%020d-%s.json", prefix, now.UnixNano(), device)
+}
+
+// renamedSnapshot returns a name for a copy of the named snapshot file
+// that sorts after every file created before now.
+func renamedSnapshot(name string, now time.Time) string {
+	device := strings.TrimSuffix(strings.TrimPrefix(name, snapshotPrefix), ".json")
+	if i := strings.IndexByte(device, '-'); i >= 0 {
+		device = device[i+1:]
+	}
+	return deltaFileName(snapshotPrefix, device, now)
+}
+
+// syncDelta is Sync for providers that implement FileStore. It returns
+// ErrNotSupported, before changing anything, if the provider cannot list
+// files after all.
+func (sm *SyncManager) syncDelta(ctx context.Context, files FileStore, source Source) (*MergeResult, error) {
+	result, err := sm.syncDeltaOnce(ctx, files, source, false)
+	if errors.Is(err, errSnapshotSuperseded) {
+		// Another device compacted at the same time and its snapshot won.
+		// Nothing was applied or acknowledged yet, so merge again on top
+		// of it.
+		return sm.syncDeltaOnce(ctx, files, source, true)
+	}
+	return result, err
+}
+
+// syncDeltaOnce makes one attempt at a delta sync. With rebase set, the
+// library is merged without a base, so that entities the remote lacks are
+// added to it rather than deleted locally, and no compaction is attempted.
+func (sm *SyncManager) syncDeltaOnce(ctx context.Context, files FileStore, source Source, rebase bool) (*MergeResult, error) {
+	names, err := files.ListFiles(ctx, deltaPrefix)
+	if err != nil {
+		if errors.Is(err, ErrNotSupported) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to list sync files: %w", err)
+	}
+	var snapshots, segments []string
+	for _, name := range names {
+		switch {
+		case strings.HasPrefix(name, snapshotPrefix):
+			snapshots = append(snapshots, name)
+		case strings.HasPrefix(name, segmentPrefix):
+			segments = append(segments, name)
+		}
+	}
+	sort.Strings(snapshots)
+	sort.Strings(segments)
+	var latest string
+	if len(snapshots) > 0 {
+		latest = snapshots[len(snapshots)-1]
+	}
+
+	state, err := sm.loadState()
+	if err != nil {
+		return nil, err
+	}
+	if state.DeviceID == "" {
+		state.DeviceID = uuid.New().String()
+	}
+	delta := state.Delta
+	if delta == nil {
+		delta = &deltaState{}
+	}
+
+	var changes []models.Change
+	logged := false
+	if cs, ok := source.(ChangeSource); ok {
+		changes, err = cs.ChangesSince(delta.AckedSeq)
+		switch {
+		case err == nil:
+			logged = true
+		case !errors.Is(err, ErrNotSupported):
+			return nil, fmt.Errorf("failed to read local changes: %w", err)
+		}
+	}
+
+	// The base is the remote library as of the last sync, so when the
+	// snapshot has not been replaced only newer segments are downloaded.
+	base := state.Base
+	if rebase {
+		base = nil
+	}
+	applied := make(map[string]bool)
+	var remote *Snapshot
+	var pending []string
+	if base != nil && state.Delta != nil && latest != "" && latest == delta.Snapshot {
+		for _, name := range delta.Segments {
+			applied[name] = true
+		}
+		for _, name := range segments {
+			if !applied[name] {
+				pending = append(pending, name)
+			}
+		}
+		if logged && len(changes) == 0 && len(pending) == 0 {
+			return &MergeResult{Snapshot: state.Base}, nil
+		}
+		remote = state.Base
+	} else {
+		var compacted map[string]bool
+		var from string
+		if remote, compacted, from, err = sm.downloadSnapshot(ctx, files, latest); err != nil {
+			return nil, err
+		}
+		if delta.Snapshot != "" && latest != delta.Snapshot && from != delta.Snapshot {
+			// The snapshot was not compacted on top of the last one seen
+			// here, so it may lack what this device uploaded into that
+			// one, as when two devices compact at once. Without a base,
+			// what it lacks counts as added here, not deleted there.
+			base = nil
+		}
+		for _, name := range segments {
+			if !compacted[name] {
+				pending = append(pending, name)
+			}
+		}
+	}
+	for _, name := range pending {
+		data, err := files.GetFile(ctx, name)
+		if errors.Is(err, ErrNoRemoteData) {
+			// Compacted in the meantime. Anything it held that the
+			// compacted snapshot lacks is uploaded again by its device.
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to download sync segment: %w", err)
+		}
+		segment, err := decodeSegment(data)
+		if err != nil {
+			return nil, err
+		}
+		remote = applySegment(remote, segment)
+		applied[name] = true
+	}
+
+	local, err := source.SyncSnapshot()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read local library: %w", err)
+	}
+	now := sm.clock.Now()
+	result := Merge(base, local, remote, now)
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if !rebase && (latest == "" || len(applied) >= compactAfterSegments) {
+		name := deltaFileName(snapshotPrefix, state.DeviceID, now)
+		data, err := encodeSnapshotFile(state.DeviceID, now, latest, sortedKeys(applied), result.Snapshot)
+		if err != nil {
+			return nil, err
+		}
+		if err := files.PutFile(ctx, name, data); err != nil {
+			return nil, fmt.Errorf("failed to upload sync snapshot: %w", err)
+		}
+		// Readers take the latest snapshot, so a snapshot another device
+		// wrote meanwhile replaces this one, and with it what only this
+		// one holds. Find out before anything is applied or acknowledged.
+		winner, err := latestSnapshot(ctx, files)
+		if err != nil {
+			return nil, err
+		}
+		if winner != name {
+			files.DeleteFile(ctx, name)
+			return nil, errSnapshotSuperseded
+		}
+		delta.Snapshot, applied = name, nil
+	} else {
+		if changed := deltaSnapshot(remote, result.Snapshot, changes); changed != nil {
+			name := deltaFileName(segmentPrefix, state.DeviceID, now)
+			data, err := encodeSegmentFile(state.DeviceID, now, changed)
+			if err != nil {
+				return nil, err
+			}
+			if err := files.PutFile(ctx, name, data); err != nil {
+				return nil, fmt.Errorf("failed to upload sync segment: %w", err)
+			}
+			applied[name] = true
+		}
+		delta.Snapshot = latest
+	}
+	delta.Segments = sortedKeys(applied)
+
+	if err := source.ApplySyncSnapshot(result.Snapshot); err != nil {
+		return nil, fmt.Errorf("failed to apply merged library: %w", err)
+	}
+
+	if logged && len(changes) > 0 {
+		delta.AckedSeq = changes[len(changes)-1].Seq
+	}
+	state.Base = result.Snapshot
+	state.Conflicts = append(state.Conflicts, result.Conflicts...)
+	state.Delta = delta
+	if err := sm.saveState(state); err != nil {
+		return nil, err
+	}
+	if logged && len(changes) > 0 {
+		if err := source.(ChangeSource).AcknowledgeChanges(delta.AckedSeq); err != nil {
+			return nil, fmt.Errorf("failed to acknowledge local changes: %w", err)
+		}
+	}
+
+	// Files replaced by the current snapshot are removed last, once the
+	// sync has succeeded. Each device removes only the files it wrote, so
+	// a device never deletes what another may still need. Failures are
+	// harmless: they are retried by the next sync and readers skip
+	// compacted segments anyway.
+	current := make(map[string]bool)
+	for _, name := range delta.Segments {
+		current[name] = true
+	}
+	own := "-" + state.DeviceID + ".json"
+	for _, name := range snapshots {
+		if strings.HasSuffix(name, own) && name != delta.Snapshot {
+			files.DeleteFile(ctx, name)
+		}
+	}
+	for _, name := range segments {
+		if strings.HasSuffix(name, own) && !current[name] {
+			files.DeleteFile(ctx, name)
+		}
+	}
+	return result, nil
+}
+
+// latestSnapshot returns the name of the snapshot file readers start from,
+// or "" if there is none.
+func latestSnapshot(ctx context.Context, files FileStore) (string, error) {
+	names, err := files.ListFiles(ctx, snapshotPrefix)
+	if err != nil {
+		return "", fmt.Errorf("failed to list sync files: %w", err)
+	}
+	latest := ""
+	for _, name := range names {
+		if strings.HasPrefix(name, snapshotPrefix) && name > latest {
+			latest = name
+		}
+	}
+	return latest, nil
+}
+
+// downloadSnapshot returns the library in the named snapshot file, the
+// segments compacted into it and the snapshot it was compacted on top of.
+// Without a snapshot, a library uploaded before delta sync was used is
+// taken as the starting point, if there is one.
+func (sm *SyncManager) downloadSnapshot(ctx context.Context, files FileStore, name string) (*Snapshot, map[string]bool, string, error) {
+	compacted := make(map[string]bool)
+	if name == "" {
+		snapshot, err := sm.downloadRemote(ctx)
+		return snapshot, compacted, "", err
+	}
+
+	data, err := files.GetFile(ctx, name)
+	if errors.Is(err, ErrNoRemoteData) {
+		// Replaced by a newer snapshot since the listing.
+		return nil, nil, "", ErrRemoteChanged
+	}
+	if err != nil {
+		return nil, nil, "", fmt.Errorf("failed to download sync snapshot: %w", err)
+	}
+	var file snapshotFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, nil, "", fmt.Errorf("failed to decode sync snapshot: %w", err)
+	}
+	snapshot, err := DecodeEnvelope(file.Library)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	for _, name := range file.Compacted {
+		compacted[name] = true
+	}
+	return snapshot, compacted, file.Base, nil
+}
+
+func encodeSnapshotFile(device string, now time.Time, base string, compacted []string, s *Snapshot) ([]byte, error) {
+	library, err := EncodeEnvelope(s)
+	if err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(snapshotFile{Device: device, CreatedAt: now, Base: base, Compacted: compacted, Library: library})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal sync snapshot: %w", err)
+	}
+	return data, nil
+}
+
+func encodeSegmentFile(device string, now time.Time, s *Snapshot) ([]byte, error) {
+	changes, err := EncodeEnvelope(s)
+	if err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(segmentFile{Device: device, CreatedAt: now, Changes: changes})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal sync segment: %w", err)
+	}
+	return data, nil
+}
+
+func decodeSegment(data []byte) (*Snapshot, error) {
+	var file segmentFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to decode sync segment: %w", err)
+	}
+	return DecodeEnvelope(file.Changes)
+}
+
+// deltaSnapshot returns the part of merged that differs from remote, plus
+// the entities named by changes, or nil if there is nothing to upload.
+// Logged changes are included even when they look unchanged so that a
+// change is never lost to a comparison that ignores some detail.
+func deltaSnapshot(remote, merged *Snapshot, changes []models.Change) *Snapshot {
+	touched := make(map[string]map[string]bool)
+	for _, c := range changes {
+		if touched[c.Kind] == nil {
+			touched[c.Kind] = make(map[string]bool)
+		}
+		touched[c.Kind][c.EntityID] = true
+	}
+
+	out := &Snapshot{
+		Bookmarks: changedEntities(bookmarkEntity, entities(remote, (*Snapshot).bookmarks), merged.Bookmarks, touched[KindBookmark]),
+		Tags:      changedEntities(tagEntity, entities(remote, (*Snapshot).tags), merged.Tags, touched[KindTag]),
+		TagGroups: changedEntities(tagGroupEntity, entities(remote, (*Snapshot).tagGroups), merged.TagGroups, touched[KindTagGroup]),
+	}
+
+	if p := merged.Preferences; p != nil {
+		rp := prefs(remote)
+		if rp == nil || changed(preferencesEntity, rp, p) || len(touched[KindPreferences]) > 0 {
+			out.Preferences = p
+		}
+	}
+
+	remoteTombs := make(map[string]bool)
+	if remote != nil {
+		for _, t := range remote.Tombstones {
+			remoteTombs[t.Kind+"\x00"+t.ID] = true
+		}
+	}
+	for _, t := range merged.Tombstones {
+		if !remoteTombs[t.Kind+"\x00"+t.ID] || touched[t.Kind][t.ID] {
+			out.Tombstones = append(out.Tombstones, t)
+		}
+	}
+
+	if len(out.Bookmarks) == 0 && len(out.Tags) == 0 && len(out.TagGroups) == 0 &&
+		out.Preferences == nil && len(out.Tombstones) == 0 {
+		return nil
+	}
+	return out
+}
+
+func changedEntities[T any](e entity[T], remote, merged []T, touched map[string]bool) []T {
+	remoteByID := make(map[string]*T, len(remote))
+	for i := range remote {
+		remoteByID[e.id(&remote[i])] = &remote[i]
+	}
+	var out []T
+	for i := range merged {
+		x := &merged[i]
+		r, ok := remoteByID[e.id(x)]
+		if !ok || touched[e.id(x)] || changed(e, r, x) {
+			out = append(out, *x)
+		}
+	}
+	return out
+}
+
+// applySegment returns view with the entities in segment replaced or
+// deleted. Whole entities are replaced: the segment holds values its
+// device already merged, and concurrent segments are reconciled by the
+// three-way merge of whichever device syncs next.
+func applySegment(view, segment *Snapshot) *Snapshot {
+	deleted := make(map[string]map[string]bool)
+	for _, t := range segment.Tombstones {
+		if deleted[t.Kind] == nil {
+			deleted[t.Kind] = make(map[string]bool)
+		}
+		deleted[t.Kind][t.ID] = true
+	}
+
+	out := &Snapshot{
+		Bookmarks:   applyEntities(bookmarkEntity, entities(view, (*Snapshot).bookmarks), segment.Bookmarks, deleted[KindBookmark]),
+		Tags:        applyEntities(tagEntity, entities(view, (*Snapshot).tags), segment.Tags, deleted[KindTag]),
+		TagGroups:   applyEntities(tagGroupEntity, entities(view, (*Snapshot).tagGroups), segment.TagGroups, deleted[KindTagGroup]),
+		Preferences: prefs(view),
+	}
+	if segment.Preferences != nil {
+		out.Preferences = segment.Preferences
+	}
+
+	// Tombstones of entities the segment brought back are dropped.
+	upserted := make(map[string]bool)
+	for _, b := range segment.Bookmarks {
+		upserted[KindBookmark+"\x00"+b.ID] = true
+	}
+	for _, t := range segment.Tags {
+		upserted[KindTag+"\x00"+t.ID] = true
+	}
+	for _, g := range segment.TagGroups {
+		upserted[KindTagGroup+"\x00"+g.ID] = true
+	}
+	tombs := make(map[string]Tombstone)
+	if view != nil {
+		for _, t := range view.Tombstones {
+			tombs[t.Kind+"\x00"+t.ID] = t
+		}
+	}
+	for _, t := range segment.Tombstones {
+		tombs[t.Kind+"\x00"+t.ID] = t
+	}
+	for key, t := range tombs {
+		if !upserted[key] {
+			out.Tombstones = append(out.Tombstones, t)
+		}
+	}
+	sort.Slice(out.Tombstones, func(i, j int) bool {
+		if out.Tombstones[i].Kind != out.Tombstones[j].Kind {
+			return out.Tombstones[i].Kind < out.Tombstones[j].Kind
+		}
+		return out.Tombstones[i].ID < out.Tombstones[j].ID
+	})
+	return out
+}
+
+func applyEntities[T any](e entity[T], current, upserts []T, deleted map[string]bool) []T {
+	byID := make(map[string]T, len(current)+len(upserts))
+	for i := range current {
+		byID[e.id(&current[i])] = current[i]
+	}
+	for id := range deleted {
+		delete(byID, id)
+	}
+	for i := range upserts {
+		byID[e.id(&upserts[i])] = upserts[i]
+	}
+	out := make([]T, 0, len(byID))
+	for _, x := range byID {
+		out = append(out, x)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return e.id(&out[i]) < e.id(&out[j])
+	})
+	return out
+}
+
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for k := range set {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
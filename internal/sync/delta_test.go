@@ -0,0 +1,242 @@
+package sync
+
+import (
+	"context"
+	"sort"
+	"strings"
+	gosync "sync"
+	"testing"
+	"time"
+
+	"github.com/goBookMarker/internal/models"
+)
+
+// memFiles is a FileStore in memory. beforePut, if set, runs before each
+// PutFile stores its file.
+type memFiles struct {
+	mu        gosync.Mutex
+	files     map[string][]byte
+	beforePut func(name string)
+}
+
+func newMemFiles() *memFiles {
+	return &memFiles{files: make(map[string][]byte)}
+}
+
+func (m *memFiles) Upload(ctx context.Context, data []byte) error { return ErrNotSupported }
+
+func (m *memFiles) Download(ctx context.Context) ([]byte, error) { return nil, ErrNoRemoteData }
+
+func (m *memFiles) LastSync() time.Time { return time.Time{} }
+
+func (m *memFiles) PutFile(ctx context.Context, name string, data []byte) error {
+	if hook := m.beforePut; hook != nil {
+		hook(name)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.files[name] = append([]byte(nil), data...)
+	return nil
+}
+
+func (m *memFiles) GetFile(ctx context.Context, name string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	data, ok := m.files[name]
+	if !ok {
+		return nil, ErrNoRemoteData
+	}
+	return data, nil
+}
+
+func (m *memFiles) ListFiles(ctx context.Context, prefix string) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var names []string
+	for name := range m.files {
+		if strings.HasPrefix(name, prefix) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (m *memFiles) DeleteFile(ctx context.Context, name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.files, name)
+	return nil
+}
+
+// memState is a StateStore in memory.
+type memState map[string][]byte
+
+func (m memState) GetSyncState(key string) ([]byte, error) { return m[key], nil }
+
+func (m memState) SaveSyncState(key string, data []byte) error {
+	m[key] = data
+	return nil
+}
+
+// memSource is a Source holding a library in memory.
+type memSource struct {
+	snapshot *Snapshot
+}
+
+func (s *memSource) SyncSnapshot() (*Snapshot, error) {
+	copied := *s.snapshot
+	copied.Bookmarks = append([]models.Bookmark(nil), s.snapshot.Bookmarks...)
+	return &copied, nil
+}
+
+func (s *memSource) ApplySyncSnapshot(snapshot *Snapshot) error {
+	s.snapshot = snapshot
+	return nil
+}
+
+// device is one installation syncing through a shared store.
+type device struct {
+	manager *SyncManager
+	source  *memSource
+	state   memState
+}
+
+func newDevice(files *memFiles, bookmarkIDs ...string) *device {
+	now := time.Now()
+	snapshot := &Snapshot{}
+	for _, id := range bookmarkIDs {
+		snapshot.Bookmarks = append(snapshot.Bookmarks, models.Bookmark{
+			ID: id, URL: "https://example.com/" + id, Title: id, CreatedAt: now, UpdatedAt: now,
+		})
+	}
+	state := make(memState)
+	return &device{
+		manager: NewSyncManager(files, state, 0),
+		source:  &memSource{snapshot: snapshot},
+		state:   state,
+	}
+}
+
+func (d *device) sync(t *testing.T) {
+	t.Helper()
+	if _, err := d.manager.Sync(context.Background(), d.source); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+}
+
+func (d *device) deviceID(t *testing.T) string {
+	t.Helper()
+	state, err := d.manager.loadState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return state.DeviceID
+}
+
+func bookmarkIDs(s *Snapshot) []string {
+	var ids []string
+	for _, b := range s.Bookmarks {
+		ids = append(ids, b.ID)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func assertBookmarks(t *testing.T, d *device, want ...string) {
+	t.Helper()
+	got := strings.Join(bookmarkIDs(d.source.snapshot), ",")
+	if got != strings.Join(want, ",") {
+		t.Fatalf("bookmarks = %s, want %s (tombstones %v)", got, strings.Join(want, ","), d.source.snapshot.Tombstones)
+	}
+}
+
+func TestDeltaConcurrentFirstSnapshotsKeepBothLibraries(t *testing.T) {
+	files := newMemFiles()
+	a := newDevice(files, "a1")
+	b := newDevice(files, "b1")
+
+	// A compacts while B's snapshot upload is in flight, so both write a
+	// first snapshot and A's, written later, wins.
+	first := true
+	files.beforePut = func(name string) {
+		if first && strings.HasPrefix(name, snapshotPrefix) {
+			first = false
+			a.sync(t)
+		}
+	}
+	b.sync(t)
+	files.beforePut = nil
+
+	assertBookmarks(t, b, "a1", "b1")
+	a.sync(t)
+	assertBookmarks(t, a, "a1", "b1")
+	b.sync(t)
+	assertBookmarks(t, b, "a1", "b1")
+}
+
+func TestDeltaSnapshotNotBasedOnOwnRebases(t *testing.T) {
+	files := newMemFiles()
+	b := newDevice(files, "b1")
+	b.sync(t)
+	assertBookmarks(t, b, "b1")
+
+	// Another device compacted without having seen B's snapshot, so its
+	// snapshot lacks b1. B must not take that for a deletion.
+	otherFiles := newMemFiles()
+	newDevice(otherFiles, "c1").sync(t)
+	names, _ := otherFiles.ListFiles(context.Background(), snapshotPrefix)
+	if len(names) != 1 {
+		t.Fatalf("other device wrote %v, want one snapshot", names)
+	}
+	name := deltaFileName(snapshotPrefix, "other-device", time.Now())
+	if err := files.PutFile(context.Background(), name, otherFiles.files[names[0]]); err != nil {
+		t.Fatal(err)
+	}
+
+	b.sync(t)
+	assertBookmarks(t, b, "b1", "c1")
+}
+
+func TestDeltaDeletesOnlyOwnFiles(t *testing.T) {
+	files := newMemFiles()
+	a := newDevice(files, "a1")
+	b := newDevice(files, "b1")
+	a.sync(t)
+	b.sync(t)
+	a.sync(t)
+
+	// Force a compaction by B on top of A's snapshot and segments.
+	state, err := b.manager.loadState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < compactAfterSegments; i++ {
+		state.Delta.Segments = append(state.Delta.Segments, deltaFileName(segmentPrefix, "gone", time.Now()))
+	}
+	if err := b.manager.saveState(state); err != nil {
+		t.Fatal(err)
+	}
+	b.source.snapshot.Bookmarks[0].Title = "changed"
+	b.source.snapshot.Bookmarks[0].UpdatedAt = time.Now().Add(time.Second)
+	b.sync(t)
+
+	aID := a.deviceID(t)
+	var aFiles int
+	for name := range files.files {
+		if strings.HasSuffix(name, "-"+aID+".json") {
+			aFiles++
+		}
+	}
+	if aFiles == 0 {
+		t.Fatalf("B deleted A's files: %v", files.files)
+	}
+
+	a.sync(t)
+	assertBookmarks(t, a, "a1", "b1")
+	for name := range files.files {
+		if strings.HasSuffix(name, "-"+aID+".json") && strings.HasPrefix(name, segmentPrefix) {
+			t.Errorf("A kept its compacted segment %s", name)
+		}
+	}
+}
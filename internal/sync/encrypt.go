@@ -0,0 +1,589 @@
+package sync
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	gosync "sync"
+	"time"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// ErrWrongPassphrase is returned when the sync data was encrypted with a
+// different passphrase, for example after another device changed it.
+var ErrWrongPassphrase = errors.New("wrong sync passphrase")
+
+// ErrUnencryptedData is returned for sync data that is not encrypted once
+// encrypted data has been seen, since anyone able to write to the provider
+// could have put it there.
+var ErrUnencryptedData = errors.New("sync data is not encrypted")
+
+const (
+	// encryptedFormat identifies an encrypted sync file.
+	encryptedFormat = "goBookMarker-encrypted"
+
+	encryptedVersion = 1
+
+	// keyCheckPlaintext is sealed into every file so a wrong passphrase is
+	// reported as such instead of as corrupt data.
+	keyCheckPlaintext = "goBookMarker key check"
+
+	saltSize = 16
+
+	// encryptionStateKey is the StateStore key for encryptionState.
+	encryptionStateKey = "encryption"
+)
+
+// encryptionState is what EncryptedSync keeps locally.
+type encryptionState struct {
+	// Encrypted is set once encrypted data was read or written, after
+	// which unencrypted data is refused.
+	Encrypted bool `json:"encrypted"`
+}
+
+// Argon2id parameters for new keys, following the RFC 9106 recommendation
+// for memory-constrained environments. They are stored in the file so they
+// can be raised later without breaking existing data.
+const (
+	argonTime    = 3
+	argonMemory  = 64 << 10 // KiB
+	argonThreads = 4
+)
+
+// Limits on the Argon2id parameters read from a file. Anyone able to write
+// to the provider chooses them, so they must not be able to make a device
+// run out of memory or spend minutes deriving a key.
+const (
+	maxArgonTime   = 10
+	maxArgonMemory = argonMemory
+)
+
+// kdfParams describes how the file key is derived from the passphrase.
+type kdfParams struct {
+	Name    string `json:"name"`
+	Salt    []byte `json:"salt"`
+	Time    uint32 `json:"time"`
+	Memory  uint32 `json:"memory"`
+	Threads uint8  `json:"threads"`
+}
+
+// encryptedFile is what EncryptedSync stores with the provider. Both the
+// key check and the payload are sealed with XChaCha20-Poly1305, using the
+// serialized KDF parameters as additional data so they cannot be swapped.
+type encryptedFile struct {
+	Format     string    `json:"format"`
+	Version    int       `json:"version"`
+	KDF        kdfParams `json:"kdf"`
+	KeyCheck   []byte    `json:"key_check"`
+	Ciphertext []byte    `json:"ciphertext"`
+}
+
+// fileKey is a key derived from the passphrase with particular parameters.
+type fileKey struct {
+	kdf kdfParams
+	key []byte
+}
+
+// EncryptedSync wraps a CloudSync provider and encrypts everything it
+// stores with a key derived from a user passphrase, so the provider only
+// ever sees ciphertext. Unencrypted data left by an earlier version is
+// read only until encrypted data has been seen, which is recorded in the
+// StateStore, and is replaced by the next upload.
+type EncryptedSync struct {
+	provider CloudSync
+	state    StateStore
+
+	mu         gosync.Mutex
+	passphrase []byte
+	// key is the key of the file as last downloaded or uploaded, reused
+	// for uploads so all devices keep sharing one salt.
+	key *fileKey
+	// encrypted caches encryptionState.Encrypted once loaded.
+	encrypted *bool
+}
+
+var (
+	_ CloudSync       = (*EncryptedSync)(nil)
+	_ MultiDownloader = (*EncryptedSync)(nil)
+	_ FileStore       = (*EncryptedSync)(nil)
+)
+
+func NewEncryptedSync(provider CloudSync, state StateStore, passphrase string) *EncryptedSync {
+	return &EncryptedSync{
+		provider:   provider,
+		state:      state,
+		passphrase: []byte(passphrase),
+	}
+}
+
+// SetPassphrase replaces the passphrase used to decrypt and encrypt, for
+// example after Download returned ErrWrongPassphrase. The stored data is
+// not changed; use ChangePassphrase for that.
+func (s *EncryptedSync) SetPassphrase(passphrase string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.passphrase = []byte(passphrase)
+	s.key = nil
+}
+
+// Upload encrypts data and stores it with the provider.
+func (s *EncryptedSync) Upload(ctx context.Context, data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.key == nil {
+		key, err := newFileKey(s.passphrase)
+		if err != nil {
+			return err
+		}
+		s.key = key
+	}
+	sealed, err := seal(s.key, data)
+	if err != nil {
+		return err
+	}
+	if err := s.provider.Upload(ctx, sealed); err != nil {
+		return err
+	}
+	return s.markEncryptedLocked()
+}
+
+// Download fetches and decrypts the stored data. It returns
+// ErrWrongPassphrase if the passphrase does not match the one the data was
+// encrypted with.
+func (s *EncryptedSync) Download(ctx context.Context) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, err := s.provider.Download(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return s.openLocked(data)
+}
+
+// DownloadAll fetches and decrypts every device's file when the wrapped
+// provider keeps one per device, and otherwise returns the single file.
+// Older files still encrypted with a key from before ChangePassphrase are
+// skipped, since the file written by ChangePassphrase includes their
+// content; ErrWrongPassphrase is returned if the newest file cannot be
+// opened.
+func (s *EncryptedSync) DownloadAll(ctx context.Context) ([][]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.downloadAllLocked(ctx)
+}
+
+func (s *EncryptedSync) downloadAllLocked(ctx context.Context) ([][]byte, error) {
+	md, ok := s.provider.(MultiDownloader)
+	if !ok {
+		data, err := s.provider.Download(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if data, err = s.openLocked(data); err != nil {
+			return nil, err
+		}
+		return [][]byte{data}, nil
+	}
+
+	sealed, err := md.DownloadAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// MultiDownloader returns the files oldest first.
+	var payloads [][]byte
+	for i, data := range sealed {
+		data, err := s.openLocked(data)
+		if errors.Is(err, ErrWrongPassphrase) && i < len(sealed)-1 {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		payloads = append(payloads, data)
+	}
+	return payloads, nil
+}
+
+// ChangePassphrase re-encrypts the stored data under a new passphrase with
+// a fresh salt. Passing the current passphrase rotates the key without
+// changing the passphrase. With a provider that keeps one file per device,
+// the files of all devices are combined into this device's file. Other
+// devices get ErrWrongPassphrase until they are given the new passphrase
+// with SetPassphrase. The files used by delta sync are re-encrypted too.
+func (s *EncryptedSync) ChangePassphrase(ctx context.Context, passphrase string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var data []byte
+	payloads, err := s.downloadAllLocked(ctx)
+	switch {
+	case errors.Is(err, ErrNoRemoteData):
+	case err != nil:
+		return err
+	case len(payloads) == 1:
+		data = payloads[0]
+	default:
+		combined, err := combineSnapshots(payloads)
+		if err != nil {
+			return err
+		}
+		if data, err = EncodeEnvelope(combined); err != nil {
+			return err
+		}
+	}
+
+	// The delta sync files are all read before any is rewritten, so a
+	// file that cannot be decrypted leaves everything as it was. Only the
+	// latest snapshot is kept, under a new name: devices still using the
+	// old passphrase then have to download it and fail, rather than
+	// uploading segments the others cannot read.
+	files, _ := s.provider.(FileStore)
+	var names, obsolete []string
+	var contents [][]byte
+	if files != nil {
+		listed, err := files.ListFiles(ctx, deltaPrefix)
+		if err != nil {
+			return fmt.Errorf("failed to list sync files: %w", err)
+		}
+		sort.Strings(listed)
+		latest := ""
+		for _, name := range listed {
+			if strings.HasPrefix(name, snapshotPrefix) {
+				latest = name
+			}
+		}
+		for _, name := range listed {
+			if strings.HasPrefix(name, snapshotPrefix) && name != latest {
+				obsolete = append(obsolete, name)
+			} else {
+				names = append(names, name)
+			}
+		}
+		for _, name := range names {
+			data, err := files.GetFile(ctx, name)
+			if errors.Is(err, ErrNoRemoteData) {
+				data = nil
+			} else if err != nil {
+				return err
+			} else if data, err = s.openLocked(data); err != nil {
+				return err
+			}
+			contents = append(contents, data)
+		}
+	}
+
+	key, err := newFileKey([]byte(passphrase))
+	if err != nil {
+		return err
+	}
+	if data != nil {
+		sealed, err := seal(key, data)
+		if err != nil {
+			return err
+		}
+		if err := s.provider.Upload(ctx, sealed); err != nil {
+			return err
+		}
+	}
+	for i, name := range names {
+		if contents[i] == nil {
+			// Deleted by a compaction since the listing.
+			continue
+		}
+		data := contents[i]
+		if strings.HasPrefix(name, snapshotPrefix) {
+			// The copy is based on the original, so devices that knew
+			// the original merge it as usual.
+			var file snapshotFile
+			if err := json.Unmarshal(data, &file); err != nil {
+				return fmt.Errorf("failed to decode sync snapshot: %w", err)
+			}
+			file.Base = name
+			if data, err = json.Marshal(file); err != nil {
+				return fmt.Errorf("failed to marshal sync snapshot: %w", err)
+			}
+			obsolete = append(obsolete, name)
+			name = renamedSnapshot(name, time.Now())
+		}
+		sealed, err := seal(key, data)
+		if err != nil {
+			return err
+		}
+		if err := files.PutFile(ctx, name, sealed); err != nil {
+			return err
+		}
+	}
+	for _, name := range obsolete {
+		files.DeleteFile(ctx, name)
+	}
+	s.passphrase = []byte(passphrase)
+	s.key = key
+	return s.markEncryptedLocked()
+}
+
+// PutFile encrypts data and stores it as the named file, if the wrapped
+// provider implements FileStore.
+func (s *EncryptedSync) PutFile(ctx context.Context, name string, data []byte) error {
+	files, ok := s.provider.(FileStore)
+	if !ok {
+		return ErrNotSupported
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.key == nil {
+		key, err := newFileKey(s.passphrase)
+		if err != nil {
+			return err
+		}
+		s.key = key
+	}
+	sealed, err := seal(s.key, data)
+	if err != nil {
+		return err
+	}
+	if err := files.PutFile(ctx, name, sealed); err != nil {
+		return err
+	}
+	return s.markEncryptedLocked()
+}
+
+// GetFile fetches and decrypts the named file.
+func (s *EncryptedSync) GetFile(ctx context.Context, name string) ([]byte, error) {
+	files, ok := s.provider.(FileStore)
+	if !ok {
+		return nil, ErrNotSupported
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, err := files.GetFile(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	return s.openLocked(data)
+}
+
+// ListFiles lists the files of the wrapped provider. Names are not
+// encrypted.
+func (s *EncryptedSync) ListFiles(ctx context.Context, prefix string) ([]string, error) {
+	files, ok := s.provider.(FileStore)
+	if !ok {
+		return nil, ErrNotSupported
+	}
+	return files.ListFiles(ctx, prefix)
+}
+
+func (s *EncryptedSync) DeleteFile(ctx context.Context, name string) error {
+	files, ok := s.provider.(FileStore)
+	if !ok {
+		return ErrNotSupported
+	}
+	return files.DeleteFile(ctx, name)
+}
+
+func (s *EncryptedSync) LastSync() time.Time {
+	return s.provider.LastSync()
+}
+
+// openLocked decrypts a downloaded file, deriving the key again only when
+// its KDF parameters differ from the cached key's.
+func (s *EncryptedSync) openLocked(data []byte) ([]byte, error) {
+	var file encryptedFile
+	if json.Unmarshal(data, &file) != nil || file.Format != encryptedFormat {
+		// Written before encryption was enabled. Once the data has been
+		// encrypted, plaintext can only have been put there by someone
+		// else, and merging it would defeat the encryption.
+		encrypted, err := s.encryptedLocked()
+		if err != nil {
+			return nil, err
+		}
+		if encrypted {
+			return nil, ErrUnencryptedData
+		}
+		return data, nil
+	}
+	if file.Version > encryptedVersion {
+		return nil, fmt.Errorf("encrypted sync data has version %d, newer than the supported %d; update the app", file.Version, encryptedVersion)
+	}
+
+	key := s.key
+	if key == nil || !sameKDF(key.kdf, file.KDF) {
+		var err error
+		if key, err = deriveKey(s.passphrase, file.KDF); err != nil {
+			return nil, err
+		}
+	}
+	plaintext, err := open(key, &file)
+	if err != nil {
+		return nil, err
+	}
+	s.key = key
+	if err := s.markEncryptedLocked(); err != nil {
+		return nil, err
+	}
+	return plaintext, nil
+}
+
+// encryptedLocked reports whether encrypted data has been read or written.
+func (s *EncryptedSync) encryptedLocked() (bool, error) {
+	if s.encrypted == nil {
+		data, err := s.state.GetSyncState(encryptionStateKey)
+		if err != nil {
+			return false, fmt.Errorf("failed to load encryption state: %w", err)
+		}
+		var state encryptionState
+		if len(data) > 0 {
+			if err := json.Unmarshal(data, &state); err != nil {
+				return false, fmt.Errorf("failed to decode encryption state: %w", err)
+			}
+		}
+		s.encrypted = &state.Encrypted
+	}
+	return *s.encrypted, nil
+}
+
+// markEncryptedLocked records that encrypted data has been read or
+// written.
+func (s *EncryptedSync) markEncryptedLocked() error {
+	if encrypted, err := s.encryptedLocked(); err != nil || encrypted {
+		return err
+	}
+	data, err := json.Marshal(encryptionState{Encrypted: true})
+	if err != nil {
+		return fmt.Errorf("failed to marshal encryption state: %w", err)
+	}
+	if err := s.state.SaveSyncState(encryptionStateKey, data); err != nil {
+		return fmt.Errorf("failed to save encryption state: %w", err)
+	}
+	encrypted := true
+	s.encrypted = &encrypted
+	return nil
+}
+
+func newFileKey(passphrase []byte) (*fileKey, error) {
+	salt := make([]byte, saltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, fmt.Errorf("failed to generate salt: %w", err)
+	}
+	return deriveKey(passphrase, kdfParams{
+		Name:    "argon2id",
+		Salt:    salt,
+		Time:    argonTime,
+		Memory:  argonMemory,
+		Threads: argonThreads,
+	})
+}
+
+func deriveKey(passphrase []byte, kdf kdfParams) (*fileKey, error) {
+	if kdf.Name != "argon2id" {
+		return nil, fmt.Errorf("unsupported key derivation %q", kdf.Name)
+	}
+	if len(passphrase) == 0 {
+		return nil, fmt.Errorf("sync passphrase is empty")
+	}
+	if err := checkKDF(kdf); err != nil {
+		return nil, err
+	}
+	key := argon2.IDKey(passphrase, kdf.Salt, kdf.Time, kdf.Memory, kdf.Threads, chacha20poly1305.KeySize)
+	return &fileKey{kdf: kdf, key: key}, nil
+}
+
+// checkKDF rejects Argon2id parameters that argon2.IDKey would panic on or
+// that exceed the limits for files from the provider.
+func checkKDF(kdf kdfParams) error {
+	switch {
+	case len(kdf.Salt) < saltSize:
+		return fmt.Errorf("key derivation salt is %d bytes, want at least %d", len(kdf.Salt), saltSize)
+	case kdf.Time < 1 || kdf.Time > maxArgonTime:
+		return fmt.Errorf("key derivation time %d is out of range 1-%d", kdf.Time, maxArgonTime)
+	case kdf.Memory > maxArgonMemory:
+		return fmt.Errorf("key derivation memory %d KiB exceeds %d KiB", kdf.Memory, maxArgonMemory)
+	case kdf.Threads < 1:
+		return fmt.Errorf("key derivation threads must be at least 1")
+	}
+	return nil
+}
+
+func sameKDF(a, b kdfParams) bool {
+	return a.Name == b.Name && a.Time == b.Time && a.Memory == b.Memory &&
+		a.Threads == b.Threads && subtle.ConstantTimeCompare(a.Salt, b.Salt) == 1
+}
+
+func seal(key *fileKey, plaintext []byte) ([]byte, error) {
+	aad, err := json.Marshal(key.kdf)
+	if err != nil {
+		return nil, err
+	}
+	keyCheck, err := sealBox(key.key, []byte(keyCheckPlaintext), aad)
+	if err != nil {
+		return nil, err
+	}
+	ciphertext, err := sealBox(key.key, plaintext, aad)
+	if err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(encryptedFile{
+		Format:     encryptedFormat,
+		Version:    encryptedVersion,
+		KDF:        key.kdf,
+		KeyCheck:   keyCheck,
+		Ciphertext: ciphertext,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal encrypted sync data: %w", err)
+	}
+	return data, nil
+}
+
+func open(key *fileKey, file *encryptedFile) ([]byte, error) {
+	aad, err := json.Marshal(file.KDF)
+	if err != nil {
+		return nil, err
+	}
+	check, err := openBox(key.key, file.KeyCheck, aad)
+	if err != nil || !bytes.Equal(check, []byte(keyCheckPlaintext)) {
+		return nil, ErrWrongPassphrase
+	}
+	plaintext, err := openBox(key.key, file.Ciphertext, aad)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt sync data: %w", err)
+	}
+	return plaintext, nil
+}
+
+// sealBox encrypts plaintext with a random nonce, which it prepends to the
+// result.
+func sealBox(key, plaintext, aad []byte) ([]byte, error) {
+	aead, err := chacha20poly1305.NewX(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	return aead.Seal(nonce, nonce, plaintext, aad), nil
+}
+
+func openBox(key, box, aad []byte) ([]byte, error) {
+	aead, err := chacha20poly1305.NewX(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(box) < aead.NonceSize() {
+		return nil, fmt.Errorf("encrypted data is truncated")
+	}
+	nonce, ciphertext := box[:aead.NonceSize()], box[aead.NonceSize():]
+	return aead.Open(nil, nonce, ciphertext, aad)
+}
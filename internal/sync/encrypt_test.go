@@ -0,0 +1,132 @@
+package sync
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// memCloud is a CloudSync holding one file in memory.
+type memCloud struct {
+	data []byte
+}
+
+func (m *memCloud) Upload(ctx context.Context, data []byte) error {
+	m.data = append([]byte(nil), data...)
+	return nil
+}
+
+func (m *memCloud) Download(ctx context.Context) ([]byte, error) {
+	if m.data == nil {
+		return nil, ErrNoRemoteData
+	}
+	return m.data, nil
+}
+
+func (m *memCloud) LastSync() time.Time { return time.Time{} }
+
+func TestEncryptedSyncRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	cloud := &memCloud{}
+	s := NewEncryptedSync(cloud, make(memState), "secret")
+	if err := s.Upload(ctx, []byte(`{"bookmarks":[]}`)); err != nil {
+		t.Fatal(err)
+	}
+	if string(cloud.data) == `{"bookmarks":[]}` {
+		t.Fatal("uploaded data is not encrypted")
+	}
+
+	got, err := NewEncryptedSync(cloud, make(memState), "secret").Download(ctx)
+	if err != nil || string(got) != `{"bookmarks":[]}` {
+		t.Fatalf("Download = %q, %v", got, err)
+	}
+	if _, err := NewEncryptedSync(cloud, make(memState), "wrong").Download(ctx); !errors.Is(err, ErrWrongPassphrase) {
+		t.Fatalf("Download with wrong passphrase: %v, want ErrWrongPassphrase", err)
+	}
+}
+
+func TestEncryptedSyncReadsPlaintextOnlyBeforeEncryption(t *testing.T) {
+	ctx := context.Background()
+	plaintext := []byte(`{"bookmarks":[]}`)
+	cloud := &memCloud{data: plaintext}
+	state := make(memState)
+
+	// Migrating: data from before encryption was enabled is read.
+	s := NewEncryptedSync(cloud, state, "secret")
+	if got, err := s.Download(ctx); err != nil || string(got) != string(plaintext) {
+		t.Fatalf("Download before encryption = %q, %v", got, err)
+	}
+	if err := s.Upload(ctx, plaintext); err != nil {
+		t.Fatal(err)
+	}
+
+	// Someone with access to the provider replaces the ciphertext.
+	cloud.data = []byte(`{"bookmarks":[{"id":"planted"}]}`)
+	if _, err := s.Download(ctx); !errors.Is(err, ErrUnencryptedData) {
+		t.Fatalf("Download of swapped plaintext: %v, want ErrUnencryptedData", err)
+	}
+	// The refusal survives a restart.
+	if _, err := NewEncryptedSync(cloud, state, "secret").Download(ctx); !errors.Is(err, ErrUnencryptedData) {
+		t.Fatalf("Download after restart: %v, want ErrUnencryptedData", err)
+	}
+}
+
+func TestEncryptedSyncRefusesPlaintextAfterReadingCiphertext(t *testing.T) {
+	ctx := context.Background()
+	cloud := &memCloud{}
+	if err := NewEncryptedSync(cloud, make(memState), "secret").Upload(ctx, []byte(`{}`)); err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewEncryptedSync(cloud, make(memState), "secret")
+	if _, err := s.Download(ctx); err != nil {
+		t.Fatal(err)
+	}
+	cloud.data = []byte(`{}`)
+	if _, err := s.Download(ctx); !errors.Is(err, ErrUnencryptedData) {
+		t.Fatalf("Download of swapped plaintext: %v, want ErrUnencryptedData", err)
+	}
+}
+
+func TestEncryptedSyncRejectsTamperedKDF(t *testing.T) {
+	ctx := context.Background()
+	cloud := &memCloud{}
+	if err := NewEncryptedSync(cloud, make(memState), "secret").Upload(ctx, []byte(`{}`)); err != nil {
+		t.Fatal(err)
+	}
+	original := cloud.data
+
+	for _, tc := range []struct {
+		name   string
+		tamper func(*kdfParams)
+		want   string
+	}{
+		{"zero time", func(k *kdfParams) { k.Time = 0 }, "time"},
+		{"huge time", func(k *kdfParams) { k.Time = 1 << 30 }, "time"},
+		{"zero threads", func(k *kdfParams) { k.Threads = 0 }, "threads"},
+		{"huge memory", func(k *kdfParams) { k.Memory = 1 << 31 }, "memory"},
+		{"short salt", func(k *kdfParams) { k.Salt = k.Salt[:4] }, "salt"},
+		{"no salt", func(k *kdfParams) { k.Salt = nil }, "salt"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var file encryptedFile
+			if err := json.Unmarshal(original, &file); err != nil {
+				t.Fatal(err)
+			}
+			tc.tamper(&file.KDF)
+			data, err := json.Marshal(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			cloud.data = data
+
+			_, err = NewEncryptedSync(cloud, make(memState), "secret").Download(ctx)
+			if err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Fatalf("Download = %v, want an error about the %s", err, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,82 @@
+package sync
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"github.com/goBookMarker/internal/models"
+)
+
+// EnvelopeVersion is the version of the sync file format written by this
+// build.
+//
+//	0: a bare JSON array of bookmarks
+//	1: {"bookmarks", "tombstones"} with bookmark tombstones only
+//	2: adds "version", tags, tag groups, preferences and tombstone kinds
+const EnvelopeVersion = 2
+
+// Envelope is the document stored with the cloud provider.
+type Envelope struct {
+	Version int `json:"version"`
+	Snapshot
+}
+
+// EncodeEnvelope serializes a snapshot in the current format.
+func EncodeEnvelope(s *Snapshot) ([]byte, error) {
+	data, err := json.Marshal(Envelope{Version: EnvelopeVersion, Snapshot: *s})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal sync envelope: %w", err)
+	}
+	return data, nil
+}
+
+// DecodeEnvelope reads a sync file written by this or an earlier version
+// and upgrades it to the current format. Files from a newer version are
+// rejected rather than merged, since fields this build does not know about
+// would be lost on upload.
+func DecodeEnvelope(data []byte) (*Snapshot, error) {
+	data = bytes.TrimSpace(data)
+	if len(data) > 0 && data[0] == '[' {
+		var bookmarks []models.Bookmark
+		if err := json.Unmarshal(data, &bookmarks); err != nil {
+			return nil, fmt.Errorf("failed to decode sync envelope: %w", err)
+		}
+		env := &Envelope{Snapshot: Snapshot{Bookmarks: bookmarks}}
+		return upgradeEnvelope(env)
+	}
+
+	env := &Envelope{}
+	if err := json.Unmarshal(data, env); err != nil {
+		return nil, fmt.Errorf("failed to decode sync envelope: %w", err)
+	}
+	if env.Version == 0 {
+		// Version 1 had no version field.
+		env.Version = 1
+	}
+	return upgradeEnvelope(env)
+}
+
+// envelopeUpgrades[v] upgrades an envelope from version v to v+1.
+var envelopeUpgrades = map[int]func(*Envelope){
+	0: func(env *Envelope) {},
+	1: func(env *Envelope) {
+		env.bookmarksOnly = true
+		for i := range env.Tombstones {
+			if env.Tombstones[i].Kind == "" {
+				env.Tombstones[i].Kind = KindBookmark
+			}
+		}
+	},
+}
+
+func upgradeEnvelope(env *Envelope) (*Snapshot, error) {
+	if env.Version > EnvelopeVersion {
+		return nil, fmt.Errorf("sync data has version %d, newer than the supported %d; update the app", env.Version, EnvelopeVersion)
+	}
+	for env.Version < EnvelopeVersion {
+		envelopeUpgrades[env.Version](env)
+		env.Version++
+	}
+	return &env.Snapshot, nil
+}
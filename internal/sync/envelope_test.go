@@ -0,0 +1,86 @@
+package sync
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDecodeEnvelope(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		// tombstones lists kind/id of the decoded tombstones.
+		tombstones    string
+		tags          int
+		bookmarksOnly bool
+	}{
+		{
+			name:          "version 0",
+			data:          ` [{"id":"b1","url":"https://go.dev/","tags":["go"]}]`,
+			bookmarksOnly: true,
+		},
+		{
+			name:          "version 1",
+			data:          `{"bookmarks":[{"id":"b1","url":"https://go.dev/"}],"tombstones":[{"id":"b2","deleted_at":"2024-01-01T00:00:00Z"}]}`,
+			tombstones:    "bookmark/b2",
+			bookmarksOnly: true,
+		},
+		{
+			name:       "version 2",
+			data:       `{"version":2,"bookmarks":[{"id":"b1","url":"https://go.dev/"}],"tags":[{"id":"t1","name":"go","created_at":"2024-01-01T00:00:00Z","updated_at":"2024-01-01T00:00:00Z"}],"tombstones":[{"kind":"tag","id":"t2","deleted_at":"2024-01-01T00:00:00Z"}]}`,
+			tombstones: "tag/t2",
+			tags:       1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := DecodeEnvelope([]byte(tt.data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(s.Bookmarks) != 1 || s.Bookmarks[0].URL != "https://go.dev/" {
+				t.Errorf("bookmarks = %+v", s.Bookmarks)
+			}
+			var tombstones []string
+			for _, ts := range s.Tombstones {
+				tombstones = append(tombstones, ts.Kind+"/"+ts.ID)
+			}
+			if got := strings.Join(tombstones, ","); got != tt.tombstones {
+				t.Errorf("tombstones = %s, want %s", got, tt.tombstones)
+			}
+			if len(s.Tags) != tt.tags {
+				t.Errorf("%d tags, want %d", len(s.Tags), tt.tags)
+			}
+			if s.bookmarksOnly != tt.bookmarksOnly {
+				t.Errorf("bookmarksOnly = %v, want %v", s.bookmarksOnly, tt.bookmarksOnly)
+			}
+
+			// Encoding writes the current version, which decodes the same.
+			data, err := EncodeEnvelope(s)
+			if err != nil {
+				t.Fatal(err)
+			}
+			again, err := DecodeEnvelope(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(again.Bookmarks) != 1 || len(again.Tombstones) != len(s.Tombstones) || again.bookmarksOnly {
+				t.Errorf("re-decoded snapshot = %+v", again)
+			}
+		})
+	}
+}
+
+func TestDecodeEnvelopeRejectsNewerVersion(t *testing.T) {
+	_, err := DecodeEnvelope([]byte(`{"version":3,"bookmarks":[]}`))
+	if err == nil {
+		t.Fatal("decoded an envelope from a newer version")
+	}
+	if !strings.Contains(err.Error(), "version 3") {
+		t.Errorf("error %q does not name the version", err)
+	}
+
+	if _, err := DecodeEnvelope([]byte(`{"version":`)); err == nil {
+		t.Error("decoded a truncated envelope")
+	}
+}
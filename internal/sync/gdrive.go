@@ -0,0 +1,394 @@
+package sync
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"strings"
+	gosync "sync"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// DefaultDriveBaseURL is the root of the Google Drive REST API.
+const DefaultDriveBaseURL = "https://www.googleapis.com"
+
+// driveFileName is the name of the sync file in the app data folder.
+const driveFileName = "bookmarks.json"
+
+// GoogleDriveSync stores the sync data as a single file in the Drive
+// appDataFolder, which is private to the app and needs only the
+// drive.appdata scope.
+type GoogleDriveSync struct {
+	client  *http.Client
+	baseURL string
+
+	mu           gosync.Mutex
+	fileID       string
+	etag         string
+	modifiedTime time.Time
+}
+
+var _ FileStore = (*GoogleDriveSync)(nil)
+
+// driveFile is the subset of Drive file metadata the sync uses.
+type driveFile struct {
+	ID           string    `json:"id"`
+	Name         string    `json:"name"`
+	ModifiedTime time.Time `json:"modifiedTime"`
+}
+
+const driveFileFields = "id,name,modifiedTime"
+
+// NewGoogleDriveSync creates a Drive sync that authorizes requests with tokens
+// from source, which should refresh them, such as the one returned by
+// auth.OAuthProvider.TokenSource.
+func NewGoogleDriveSync(source oauth2.TokenSource) *GoogleDriveSync {
+	return NewGoogleDriveSyncClient(newOAuthClient(source), DefaultDriveBaseURL)
+}
+
+// NewGoogleDriveSyncClient creates a Drive sync that sends requests to
+// baseURL with client, which must add authorization itself.
+func NewGoogleDriveSyncClient(client *http.Client, baseURL string) *GoogleDriveSync {
+	return &GoogleDriveSync{
+		client:  client,
+		baseURL: baseURL,
+	}
+}
+
+// Upload replaces the contents of the sync file, creating it on first use.
+func (s *GoogleDriveSync) Upload(ctx context.Context, data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.fileID == "" {
+		if err := s.lookupLocked(ctx); err != nil {
+			return err
+		}
+	}
+
+	file, etag, err := s.upload(ctx, s.fileID, driveFileName, data)
+	if isStatus(err, http.StatusNotFound) && s.fileID != "" {
+		// The file was deleted since it was looked up; create it again.
+		s.fileID = ""
+		file, etag, err = s.upload(ctx, "", driveFileName, data)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to upload to Google Drive: %w", err)
+	}
+
+	s.fileID = file.ID
+	s.etag = etag
+	s.modifiedTime = file.ModifiedTime
+	return nil
+}
+
+// upload sends a multipart update request for the file with the given ID,
+// or a create request for a new file with the given name if fileID is "".
+func (s *GoogleDriveSync) upload(ctx context.Context, fileID, name string, data []byte) (*driveFile, string, error) {
+	metadata := map[string]interface{}{}
+	method := http.MethodPatch
+	endpoint := s.baseURL + "/upload/drive/v3/files/" + url.PathEscape(fileID)
+	if fileID == "" {
+		method = http.MethodPost
+		endpoint = s.baseURL + "/upload/drive/v3/files"
+		metadata["name"] = name
+		metadata["parents"] = []string{"appDataFolder"}
+		metadata["mimeType"] = "application/json"
+	}
+
+	body, contentType, err := multipartRelated(metadata, data)
+	if err != nil {
+		return nil, "", err
+	}
+
+	query := url.Values{"uploadType": {"multipart"}, "fields": {driveFileFields}}
+	req, err := http.NewRequestWithContext(ctx, method, endpoint+"?"+query.Encode(), body)
+	if err != nil {
+		return nil, "", err
+	}
+	req.Header.Set("Content-Type", contentType)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	if err := checkResponse(resp); err != nil {
+		return nil, "", err
+	}
+
+	var file driveFile
+	if err := json.NewDecoder(resp.Body).Decode(&file); err != nil {
+		return nil, "", fmt.Errorf("failed to decode upload response: %w", err)
+	}
+	return &file, resp.Header.Get("ETag"), nil
+}
+
+// multipartRelated builds a multipart/related upload body with the JSON
+// metadata part followed by the media part.
+func multipartRelated(metadata map[string]interface{}, data []byte) (io.Reader, string, error) {
+	var buf bytes.Buffer
+	w := multipart.NewWriter(&buf)
+
+	meta, err := json.Marshal(metadata)
+	if err != nil {
+		return nil, "", err
+	}
+	part, err := w.CreatePart(textproto.MIMEHeader{"Content-Type": {"application/json; charset=UTF-8"}})
+	if err != nil {
+		return nil, "", err
+	}
+	part.Write(meta)
+
+	part, err = w.CreatePart(textproto.MIMEHeader{"Content-Type": {"application/json"}})
+	if err != nil {
+		return nil, "", err
+	}
+	part.Write(data)
+
+	if err := w.Close(); err != nil {
+		return nil, "", err
+	}
+	return &buf, "multipart/related; boundary=" + w.Boundary(), nil
+}
+
+// Download returns the contents of the sync file, or ErrNoRemoteData if it
+// does not exist yet.
+func (s *GoogleDriveSync) Download(ctx context.Context) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.lookupLocked(ctx); err != nil {
+		return nil, err
+	}
+	if s.fileID == "" {
+		return nil, ErrNoRemoteData
+	}
+
+	data, etag, err := s.download(ctx, s.fileID)
+	if isStatus(err, http.StatusNotFound) {
+		s.fileID = ""
+		return nil, ErrNoRemoteData
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to download from Google Drive: %w", err)
+	}
+	if etag != "" {
+		s.etag = etag
+	}
+	return data, nil
+}
+
+// download returns the contents and ETag of the file with the given ID.
+func (s *GoogleDriveSync) download(ctx context.Context, fileID string) ([]byte, string, error) {
+	endpoint := s.baseURL + "/drive/v3/files/" + url.PathEscape(fileID) + "?alt=media"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	if err := checkResponse(resp); err != nil {
+		return nil, "", err
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", err
+	}
+	return data, resp.Header.Get("ETag"), nil
+}
+
+// lookupLocked finds the sync file in the app data folder and records its
+// ID and modification time. The file ID stays empty if there is none.
+func (s *GoogleDriveSync) lookupLocked(ctx context.Context) error {
+	file, err := s.find(ctx, driveFileName)
+	if err != nil {
+		return err
+	}
+	if file == nil {
+		s.fileID = ""
+		return nil
+	}
+	if file.ID != s.fileID {
+		s.etag = ""
+	}
+	s.fileID = file.ID
+	s.modifiedTime = file.ModifiedTime
+	return nil
+}
+
+// find returns the most recently modified file with the given name in the
+// app data folder, or nil if there is none.
+func (s *GoogleDriveSync) find(ctx context.Context, name string) (*driveFile, error) {
+	files, err := s.list(ctx, fmt.Sprintf("name = '%s' and trashed = false", name), "1")
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up sync file: %w", err)
+	}
+	if len(files) == 0 {
+		return nil, nil
+	}
+	return &files[0], nil
+}
+
+// list returns the files in the app data folder matching the query q,
+// newest first. With a page size it returns only the first page.
+func (s *GoogleDriveSync) list(ctx context.Context, q, pageSize string) ([]driveFile, error) {
+	query := url.Values{
+		"spaces":  {"appDataFolder"},
+		"q":       {q},
+		"fields":  {"nextPageToken,files(" + driveFileFields + ")"},
+		"orderBy": {"modifiedTime desc"},
+	}
+	if pageSize != "" {
+		query.Set("pageSize", pageSize)
+	}
+
+	var files []driveFile
+	for {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.baseURL+"/drive/v3/files?"+query.Encode(), nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := s.client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		var page struct {
+			NextPageToken string      `json:"nextPageToken"`
+			Files         []driveFile `json:"files"`
+		}
+		err = checkResponse(resp)
+		if err == nil {
+			if err = json.NewDecoder(resp.Body).Decode(&page); err != nil {
+				err = fmt.Errorf("failed to decode file list: %w", err)
+			}
+		}
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, page.Files...)
+		if pageSize != "" || page.NextPageToken == "" {
+			return files, nil
+		}
+		query.Set("pageToken", page.NextPageToken)
+	}
+}
+
+// PutFile stores the named file in the app data folder, replacing an
+// existing file with that name.
+func (s *GoogleDriveSync) PutFile(ctx context.Context, name string, data []byte) error {
+	if err := checkFileName(name); err != nil {
+		return err
+	}
+	file, err := s.find(ctx, name)
+	if err != nil {
+		return err
+	}
+	fileID := ""
+	if file != nil {
+		fileID = file.ID
+	}
+	if _, _, err := s.upload(ctx, fileID, name, data); err != nil {
+		return fmt.Errorf("failed to upload to Google Drive: %w", err)
+	}
+	return nil
+}
+
+// GetFile returns the contents of the named file in the app data folder.
+func (s *GoogleDriveSync) GetFile(ctx context.Context, name string) ([]byte, error) {
+	if err := checkFileName(name); err != nil {
+		return nil, err
+	}
+	file, err := s.find(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if file == nil {
+		return nil, ErrNoRemoteData
+	}
+	data, _, err := s.download(ctx, file.ID)
+	if isStatus(err, http.StatusNotFound) {
+		return nil, ErrNoRemoteData
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to download from Google Drive: %w", err)
+	}
+	return data, nil
+}
+
+// ListFiles returns the names of the files in the app data folder that
+// start with prefix.
+func (s *GoogleDriveSync) ListFiles(ctx context.Context, prefix string) ([]string, error) {
+	// Drive's "contains" matches name prefixes, so the results are
+	// filtered again.
+	q := "trashed = false"
+	if prefix != "" {
+		q = fmt.Sprintf("name contains '%s' and %s", prefix, q)
+	}
+	files, err := s.list(ctx, q, "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list Google Drive app data: %w", err)
+	}
+	seen := make(map[string]bool)
+	var names []string
+	for _, f := range files {
+		if strings.HasPrefix(f.Name, prefix) && !seen[f.Name] {
+			seen[f.Name] = true
+			names = append(names, f.Name)
+		}
+	}
+	return names, nil
+}
+
+// DeleteFile removes the named file from the app data folder.
+func (s *GoogleDriveSync) DeleteFile(ctx context.Context, name string) error {
+	if err := checkFileName(name); err != nil {
+		return err
+	}
+	file, err := s.find(ctx, name)
+	if err != nil || file == nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, s.baseURL+"/drive/v3/files/"+url.PathEscape(file.ID), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to delete from Google Drive: %w", err)
+	}
+	defer resp.Body.Close()
+	err = checkResponse(resp)
+	if err != nil && !isStatus(err, http.StatusNotFound) {
+		return fmt.Errorf("failed to delete from Google Drive: %w", err)
+	}
+	return nil
+}
+
+// LastSync returns the modification time of the sync file as of the last
+// upload or download, or the zero time if neither has happened.
+func (s *GoogleDriveSync) LastSync() time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.modifiedTime
+}
+
+// ETag returns the entity tag of the sync file as last seen, or "".
+func (s *GoogleDriveSync) ETag() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.etag
+}
@@ -0,0 +1,355 @@
+package sync
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"sort"
+	"strings"
+	gosync "sync"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// fakeDrive is an in-memory Google Drive serving the parts of the REST API
+// that GoogleDriveSync uses.
+type fakeDrive struct {
+	mu      gosync.Mutex
+	files   map[string]*fakeDriveFile
+	nextID  int
+	clock   time.Time
+	creates int
+	updates int
+}
+
+type fakeDriveFile struct {
+	id       string
+	name     string
+	parents  []string
+	data     []byte
+	version  int
+	modified time.Time
+}
+
+func (f *fakeDriveFile) etag() string {
+	return fmt.Sprintf(`"%s-%d"`, f.id, f.version)
+}
+
+func (f *fakeDriveFile) metadata() driveFile {
+	return driveFile{ID: f.id, Name: f.name, ModifiedTime: f.modified}
+}
+
+func newFakeDrive(t *testing.T) (*fakeDrive, *GoogleDriveSync) {
+	d := &fakeDrive{
+		files: make(map[string]*fakeDriveFile),
+		clock: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+	srv := httptest.NewServer(d)
+	t.Cleanup(srv.Close)
+	return d, NewGoogleDriveSyncClient(srv.Client(), srv.URL)
+}
+
+// client returns another device's view of the same Drive.
+func (d *fakeDrive) client(s *GoogleDriveSync) *GoogleDriveSync {
+	return NewGoogleDriveSyncClient(s.client, s.baseURL)
+}
+
+// put stores a file as if another client had uploaded it.
+func (d *fakeDrive) put(name string, data []byte) *fakeDriveFile {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.createLocked(name, []string{"appDataFolder"}, data)
+}
+
+func (d *fakeDrive) createLocked(name string, parents []string, data []byte) *fakeDriveFile {
+	d.nextID++
+	d.clock = d.clock.Add(time.Second)
+	f := &fakeDriveFile{
+		id:       fmt.Sprintf("file%d", d.nextID),
+		name:     name,
+		parents:  parents,
+		data:     data,
+		version:  1,
+		modified: d.clock,
+	}
+	d.files[f.id] = f
+	return f
+}
+
+var driveNameQuery = regexp.MustCompile(`name (=|contains) '([^']*)'`)
+
+func (d *fakeDrive) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == "/drive/v3/files":
+		d.list(w, r)
+	case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/drive/v3/files/"):
+		f := d.files[strings.TrimPrefix(r.URL.Path, "/drive/v3/files/")]
+		if f == nil || r.URL.Query().Get("alt") != "media" {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("ETag", f.etag())
+		w.Write(f.data)
+	case r.Method == http.MethodDelete && strings.HasPrefix(r.URL.Path, "/drive/v3/files/"):
+		id := strings.TrimPrefix(r.URL.Path, "/drive/v3/files/")
+		if d.files[id] == nil {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		delete(d.files, id)
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodPost && r.URL.Path == "/upload/drive/v3/files":
+		meta, data, err := readMultipartRelated(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var m struct {
+			Name    string   `json:"name"`
+			Parents []string `json:"parents"`
+		}
+		json.Unmarshal(meta, &m)
+		d.creates++
+		f := d.createLocked(m.Name, m.Parents, data)
+		w.Header().Set("ETag", f.etag())
+		json.NewEncoder(w).Encode(f.metadata())
+	case r.Method == http.MethodPatch && strings.HasPrefix(r.URL.Path, "/upload/drive/v3/files/"):
+		f := d.files[strings.TrimPrefix(r.URL.Path, "/upload/drive/v3/files/")]
+		if f == nil {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		_, data, err := readMultipartRelated(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		d.updates++
+		d.clock = d.clock.Add(time.Second)
+		f.data, f.modified = data, d.clock
+		f.version++
+		w.Header().Set("ETag", f.etag())
+		json.NewEncoder(w).Encode(f.metadata())
+	default:
+		http.Error(w, "unexpected request", http.StatusBadRequest)
+	}
+}
+
+func (d *fakeDrive) list(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if query.Get("spaces") != "appDataFolder" {
+		http.Error(w, "only appDataFolder is allowed", http.StatusForbidden)
+		return
+	}
+	var matched []*fakeDriveFile
+	m := driveNameQuery.FindStringSubmatch(query.Get("q"))
+	for _, f := range d.files {
+		switch {
+		case m == nil:
+		case m[1] == "=" && f.name != m[2]:
+			continue
+		case m[1] == "contains" && !strings.Contains(f.name, m[2]):
+			continue
+		}
+		matched = append(matched, f)
+	}
+	sort.Slice(matched, func(i, j int) bool { return matched[i].modified.After(matched[j].modified) })
+	if query.Get("pageSize") == "1" && len(matched) > 1 {
+		matched = matched[:1]
+	}
+	page := struct {
+		Files []driveFile `json:"files"`
+	}{Files: []driveFile{}}
+	for _, f := range matched {
+		page.Files = append(page.Files, f.metadata())
+	}
+	json.NewEncoder(w).Encode(page)
+}
+
+// readMultipartRelated returns the metadata and media parts of an upload.
+func readMultipartRelated(r *http.Request) ([]byte, []byte, error) {
+	if r.URL.Query().Get("uploadType") != "multipart" {
+		return nil, nil, errors.New("not a multipart upload")
+	}
+	mediaType, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/related" {
+		return nil, nil, fmt.Errorf("unexpected content type %q", r.Header.Get("Content-Type"))
+	}
+	mr := multipart.NewReader(r.Body, params["boundary"])
+	var parts [][]byte
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		data, err := io.ReadAll(part)
+		if err != nil {
+			return nil, nil, err
+		}
+		parts = append(parts, data)
+	}
+	if len(parts) != 2 {
+		return nil, nil, fmt.Errorf("got %d parts, want 2", len(parts))
+	}
+	return parts[0], parts[1], nil
+}
+
+func TestGoogleDriveUploadCreatesThenUpdates(t *testing.T) {
+	ctx := context.Background()
+	d, s := newFakeDrive(t)
+
+	if _, err := s.Download(ctx); !errors.Is(err, ErrNoRemoteData) {
+		t.Fatalf("Download before upload: %v, want ErrNoRemoteData", err)
+	}
+	if err := s.Upload(ctx, []byte("v1")); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Upload(ctx, []byte("v2")); err != nil {
+		t.Fatal(err)
+	}
+	if d.creates != 1 || d.updates != 1 {
+		t.Fatalf("creates = %d, updates = %d, want 1 and 1", d.creates, d.updates)
+	}
+	if len(d.files) != 1 {
+		t.Fatalf("Drive has %d files, want 1", len(d.files))
+	}
+	for _, f := range d.files {
+		if f.name != driveFileName || len(f.parents) != 1 || f.parents[0] != "appDataFolder" {
+			t.Fatalf("file %q in %v, want %q in appDataFolder", f.name, f.parents, driveFileName)
+		}
+	}
+
+	got, err := d.client(s).Download(ctx)
+	if err != nil || string(got) != "v2" {
+		t.Fatalf("Download = %q, %v, want v2", got, err)
+	}
+}
+
+func TestGoogleDriveFindsFileByName(t *testing.T) {
+	ctx := context.Background()
+	d, s := newFakeDrive(t)
+	d.put("other.json", []byte("other"))
+	existing := d.put(driveFileName, []byte("existing"))
+	d.put("delta-segment-1.json", []byte("segment"))
+
+	got, err := s.Download(ctx)
+	if err != nil || string(got) != "existing" {
+		t.Fatalf("Download = %q, %v, want existing", got, err)
+	}
+	if err := s.Upload(ctx, []byte("updated")); err != nil {
+		t.Fatal(err)
+	}
+	if d.creates != 0 || string(existing.data) != "updated" {
+		t.Fatalf("upload created %d files, existing has %q; want the existing file updated", d.creates, existing.data)
+	}
+}
+
+func TestGoogleDriveRecreatesDeletedFile(t *testing.T) {
+	ctx := context.Background()
+	d, s := newFakeDrive(t)
+	if err := s.Upload(ctx, []byte("v1")); err != nil {
+		t.Fatal(err)
+	}
+
+	// Another client deletes the file after this one looked it up.
+	d.mu.Lock()
+	d.files = make(map[string]*fakeDriveFile)
+	d.mu.Unlock()
+
+	if err := s.Upload(ctx, []byte("v2")); err != nil {
+		t.Fatal(err)
+	}
+	if d.creates != 2 || len(d.files) != 1 {
+		t.Fatalf("creates = %d with %d files, want the file created again", d.creates, len(d.files))
+	}
+	got, err := s.Download(ctx)
+	if err != nil || string(got) != "v2" {
+		t.Fatalf("Download = %q, %v, want v2", got, err)
+	}
+}
+
+func TestGoogleDriveTracksChanges(t *testing.T) {
+	ctx := context.Background()
+	d, s := newFakeDrive(t)
+	if err := s.Upload(ctx, []byte("v1")); err != nil {
+		t.Fatal(err)
+	}
+	etag, modified := s.ETag(), s.LastSync()
+	if etag == "" || modified.IsZero() {
+		t.Fatalf("after upload ETag = %q, LastSync = %v", etag, modified)
+	}
+
+	if _, err := s.Download(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if s.ETag() != etag || !s.LastSync().Equal(modified) {
+		t.Fatalf("unchanged file: ETag %q -> %q, LastSync %v -> %v", etag, s.ETag(), modified, s.LastSync())
+	}
+
+	if err := d.client(s).Upload(ctx, []byte("v2")); err != nil {
+		t.Fatal(err)
+	}
+	got, err := s.Download(ctx)
+	if err != nil || string(got) != "v2" {
+		t.Fatalf("Download = %q, %v, want v2", got, err)
+	}
+	if s.ETag() == etag {
+		t.Errorf("ETag %q did not change after another client's upload", etag)
+	}
+	if !s.LastSync().After(modified) {
+		t.Errorf("LastSync %v did not advance past %v", s.LastSync(), modified)
+	}
+}
+
+func TestGoogleDriveFiles(t *testing.T) {
+	ctx := context.Background()
+	_, s := newFakeDrive(t)
+	for _, name := range []string{"delta-a.json", "delta-b.json", "other.json"} {
+		if err := s.PutFile(ctx, name, []byte(name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.PutFile(ctx, "delta-a.json", []byte("replaced")); err != nil {
+		t.Fatal(err)
+	}
+
+	names, err := s.ListFiles(ctx, "delta-")
+	sort.Strings(names)
+	if err != nil || strings.Join(names, ",") != "delta-a.json,delta-b.json" {
+		t.Fatalf("ListFiles = %v, %v", names, err)
+	}
+	if got, err := s.GetFile(ctx, "delta-a.json"); err != nil || string(got) != "replaced" {
+		t.Fatalf("GetFile = %q, %v, want replaced", got, err)
+	}
+	if err := s.DeleteFile(ctx, "delta-a.json"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.GetFile(ctx, "delta-a.json"); !errors.Is(err, ErrNoRemoteData) {
+		t.Fatalf("GetFile after delete: %v, want ErrNoRemoteData", err)
+	}
+	if err := s.DeleteFile(ctx, "delta-a.json"); err != nil {
+		t.Fatalf("DeleteFile of a missing file: %v", err)
+	}
+}
+
+func TestNewGoogleDriveSyncHasTimeout(t *testing.T) {
+	s := NewGoogleDriveSync(oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "token"}))
+	if s.client.Timeout == 0 {
+		t.Error("Drive client has no timeout")
+	}
+}
@@ -0,0 +1,71 @@
+package sync
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// ErrNoRemoteData is returned by Download when nothing has been uploaded to
+// the provider yet.
+var ErrNoRemoteData = errors.New("no remote sync data")
+
+// ErrRemoteChanged is returned by Upload when the provider can tell that
+// the remote data changed since it was downloaded, so the upload would
+// overwrite another device's changes.
+var ErrRemoteChanged = errors.New("remote sync data changed since download")
+
+// HTTPError is a non-success response from a cloud provider's API.
+type HTTPError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *HTTPError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("unexpected status %d %s", e.StatusCode, http.StatusText(e.StatusCode))
+	}
+	return fmt.Sprintf("unexpected status %d %s: %s", e.StatusCode, http.StatusText(e.StatusCode), e.Message)
+}
+
+// requestTimeout bounds each request to a provider, including reading the
+// response, so a stalled server fails the sync instead of hanging it.
+const requestTimeout = 2 * time.Minute
+
+// newHTTPClient returns a client for unauthenticated provider requests.
+func newHTTPClient() *http.Client {
+	return &http.Client{Timeout: requestTimeout}
+}
+
+// newOAuthClient returns a client that authorizes requests with tokens
+// from source.
+func newOAuthClient(source oauth2.TokenSource) *http.Client {
+	return &http.Client{
+		Transport: &oauth2.Transport{Source: oauth2.ReuseTokenSource(nil, source)},
+		Timeout:   requestTimeout,
+	}
+}
+
+// maxErrorBody bounds how much of an error response is kept in HTTPError.
+const maxErrorBody = 512
+
+// checkResponse returns an *HTTPError for responses outside 2xx. The body
+// is read but not closed.
+func checkResponse(resp *http.Response) error {
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+	return &HTTPError{StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(body))}
+}
+
+// isStatus reports whether err is an *HTTPError with the given status.
+func isStatus(err error, status int) bool {
+	var httpErr *HTTPError
+	return errors.As(err, &httpErr) && httpErr.StatusCode == status
+}
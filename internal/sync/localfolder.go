@@ -0,0 +1,346 @@
+package sync
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	gosync "sync"
+	"time"
+)
+
+const (
+	// localFilePrefix and localFileSuffix surround the device ID in the
+	// name of each device's sync file.
+	localFilePrefix = "bookmarks-"
+	localFileSuffix = ".json"
+
+	// lockRetryDelay is how often a locked folder is tried again, and
+	// lockTimeout how long for before giving up. Writes take well under a
+	// second.
+	lockRetryDelay = 100 * time.Millisecond
+	lockTimeout    = 10 * time.Second
+)
+
+// LocalFolderSync stores the sync data in a directory on disk that is
+// replicated by other means, such as Syncthing or a USB drive. Each device
+// writes only its own files, so replication never has to resolve
+// concurrent writes to the same file: the per-device file written by
+// Upload, which DownloadAll combines with the other devices' ones, and the
+// delta sync files, whose names include the device. Files are replaced by
+// atomic rename so a reader never sees a partial write.
+type LocalFolderSync struct {
+	dir      string
+	deviceID string
+	lockPath string
+
+	mu           gosync.Mutex
+	lastModified time.Time
+}
+
+var (
+	_ MultiDownloader = (*LocalFolderSync)(nil)
+	_ FileStore       = (*LocalFolderSync)(nil)
+)
+
+// NewLocalFolderSync creates a local-folder sync that keeps its files in
+// dir. deviceID must be stable for the device and unique among the devices
+// sharing the folder.
+func NewLocalFolderSync(dir, deviceID string) *LocalFolderSync {
+	return &LocalFolderSync{
+		dir:      dir,
+		deviceID: sanitizeDeviceID(deviceID),
+		lockPath: localLockPath(dir),
+	}
+}
+
+// localLockPath returns the lock file for the folder at dir. It is kept in
+// the user's cache directory, named after the folder, since a lock file in
+// the folder itself would be replicated to the other devices.
+func localLockPath(dir string) string {
+	base, err := os.UserCacheDir()
+	if err != nil {
+		base = os.TempDir()
+	}
+	if abs, err := filepath.Abs(dir); err == nil {
+		dir = abs
+	}
+	sum := sha256.Sum256([]byte(dir))
+	return filepath.Join(base, "goBookMarker", "locks", hex.EncodeToString(sum[:8])+".lock")
+}
+
+// sanitizeDeviceID makes id safe to use in a file name.
+func sanitizeDeviceID(id string) string {
+	id = strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_':
+			return r
+		default:
+			return '_'
+		}
+	}, id)
+	if id == "" {
+		return "default"
+	}
+	return id
+}
+
+func (s *LocalFolderSync) filePath() string {
+	return filepath.Join(s.dir, localFilePrefix+s.deviceID+localFileSuffix)
+}
+
+// Upload atomically replaces this device's file, creating the folder if
+// needed.
+func (s *LocalFolderSync) Upload(ctx context.Context, data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := os.MkdirAll(s.dir, 0o755); err != nil {
+		return fmt.Errorf("failed to create sync folder: %w", err)
+	}
+	unlock, err := s.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if err := writeFileAtomic(s.filePath(), data); err != nil {
+		return fmt.Errorf("failed to write sync file: %w", err)
+	}
+	s.lastModified = time.Now()
+	return nil
+}
+
+// writeFileAtomic writes data to a temporary file next to path and renames
+// it into place once it is on disk.
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-"+filepath.Base(path)+"-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Download returns the most recently written device file, or
+// ErrNoRemoteData if there is none. SyncManager uses DownloadAll instead.
+func (s *LocalFolderSync) Download(ctx context.Context) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	files, err := s.readAllLocked(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return files[len(files)-1].data, nil
+}
+
+// DownloadAll returns the files of all devices sharing the folder,
+// including this one, oldest first. Conflict copies made by the
+// replication tool are included too, so their content is not lost.
+func (s *LocalFolderSync) DownloadAll(ctx context.Context) ([][]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	files, err := s.readAllLocked(ctx)
+	if err != nil {
+		return nil, err
+	}
+	payloads := make([][]byte, len(files))
+	for i, f := range files {
+		payloads[i] = f.data
+	}
+	return payloads, nil
+}
+
+type localFile struct {
+	data    []byte
+	modTime time.Time
+}
+
+// readAllLocked reads every device file, sorted by modification time.
+func (s *LocalFolderSync) readAllLocked(ctx context.Context) ([]localFile, error) {
+	entries, err := os.ReadDir(s.dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNoRemoteData
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read sync folder: %w", err)
+	}
+
+	unlock, err := s.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	var files []localFile
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, localFilePrefix) || !strings.HasSuffix(name, localFileSuffix) {
+			continue
+		}
+		info, err := entry.Info()
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read sync file: %w", err)
+		}
+		data, err := os.ReadFile(filepath.Join(s.dir, name))
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read sync file: %w", err)
+		}
+		if len(data) == 0 {
+			continue
+		}
+		files = append(files, localFile{data: data, modTime: info.ModTime()})
+	}
+	if len(files) == 0 {
+		return nil, ErrNoRemoteData
+	}
+
+	sort.SliceStable(files, func(i, j int) bool {
+		return files[i].modTime.Before(files[j].modTime)
+	})
+	if t := files[len(files)-1].modTime; t.After(s.lastModified) {
+		s.lastModified = t
+	}
+	return files, nil
+}
+
+// lock takes the folder's lock, waiting for another process on this
+// device to release it. The lock is held by the operating system on the
+// open lock file, so it goes away with a process that crashes while
+// holding it. Other devices sharing the folder are not locked out; they
+// write only their own files.
+func (s *LocalFolderSync) lock(ctx context.Context) (func(), error) {
+	ctx, cancel := context.WithTimeout(ctx, lockTimeout)
+	defer cancel()
+
+	if err := os.MkdirAll(filepath.Dir(s.lockPath), 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create sync lock file: %w", err)
+	}
+	// The file is never removed: a process waiting on it would end up
+	// holding a lock on a file that is gone.
+	f, err := os.OpenFile(s.lockPath, os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create sync lock file: %w", err)
+	}
+	for {
+		locked, err := lockFile(f)
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("failed to lock sync folder: %w", err)
+		}
+		if locked {
+			return func() { f.Close() }, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			f.Close()
+			return nil, fmt.Errorf("sync folder is locked by another process: %w", ctx.Err())
+		case <-time.After(lockRetryDelay):
+		}
+	}
+}
+
+// PutFile atomically writes the named file in the folder.
+func (s *LocalFolderSync) PutFile(ctx context.Context, name string, data []byte) error {
+	if err := checkFileName(name); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := os.MkdirAll(s.dir, 0o755); err != nil {
+		return fmt.Errorf("failed to create sync folder: %w", err)
+	}
+	unlock, err := s.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if err := writeFileAtomic(filepath.Join(s.dir, name), data); err != nil {
+		return fmt.Errorf("failed to write sync file: %w", err)
+	}
+	s.lastModified = time.Now()
+	return nil
+}
+
+// GetFile reads the named file from the folder.
+func (s *LocalFolderSync) GetFile(ctx context.Context, name string) ([]byte, error) {
+	if err := checkFileName(name); err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(filepath.Join(s.dir, name))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNoRemoteData
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read sync file: %w", err)
+	}
+	return data, nil
+}
+
+// ListFiles returns the names of the files in the folder that start with
+// prefix.
+func (s *LocalFolderSync) ListFiles(ctx context.Context, prefix string) ([]string, error) {
+	entries, err := os.ReadDir(s.dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read sync folder: %w", err)
+	}
+	var names []string
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasPrefix(entry.Name(), prefix) {
+			names = append(names, entry.Name())
+		}
+	}
+	return names, nil
+}
+
+// DeleteFile removes the named file from the folder.
+func (s *LocalFolderSync) DeleteFile(ctx context.Context, name string) error {
+	if err := checkFileName(name); err != nil {
+		return err
+	}
+	err := os.Remove(filepath.Join(s.dir, name))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to delete sync file: %w", err)
+	}
+	return nil
+}
+
+// LastSync returns the time of the newest file as of the last upload or
+// download, or the zero time if neither has happened.
+func (s *LocalFolderSync) LastSync() time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lastModified
+}
@@ -0,0 +1,126 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newLocalFolders returns a local-folder sync for each device, all sharing
+// one folder, with lock files in a temporary cache directory.
+func newLocalFolders(t *testing.T, devices ...string) (string, []*LocalFolderSync) {
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	t.Setenv("HOME", t.TempDir())
+	dir := filepath.Join(t.TempDir(), "sync")
+	syncs := make([]*LocalFolderSync, len(devices))
+	for i, device := range devices {
+		syncs[i] = NewLocalFolderSync(dir, device)
+	}
+	return dir, syncs
+}
+
+func TestLocalFolderUploadAndDownloadAll(t *testing.T) {
+	ctx := context.Background()
+	dir, s := newLocalFolders(t, "phone", "laptop")
+	if _, err := s[0].DownloadAll(ctx); !errors.Is(err, ErrNoRemoteData) {
+		t.Fatalf("DownloadAll of a missing folder: %v, want ErrNoRemoteData", err)
+	}
+	if err := s[0].Upload(ctx, []byte("from phone")); err != nil {
+		t.Fatal(err)
+	}
+	if err := s[1].Upload(ctx, []byte("from laptop")); err != nil {
+		t.Fatal(err)
+	}
+
+	all, err := s[0].DownloadAll(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, data := range all {
+		got = append(got, string(data))
+	}
+	sort.Strings(got)
+	if strings.Join(got, ",") != "from laptop,from phone" {
+		t.Fatalf("DownloadAll = %q", got)
+	}
+
+	// Only the device files are replicated; the lock lives elsewhere.
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	if strings.Join(names, ",") != "bookmarks-laptop.json,bookmarks-phone.json" {
+		t.Fatalf("sync folder holds %v, want only the device files", names)
+	}
+	if strings.HasPrefix(s[0].lockPath, dir) {
+		t.Fatalf("lock file %s is inside the sync folder", s[0].lockPath)
+	}
+}
+
+func TestLocalFolderLockSerializesProcesses(t *testing.T) {
+	ctx := context.Background()
+	_, s := newLocalFolders(t, "a", "b")
+	if s[0].lockPath != s[1].lockPath {
+		t.Fatalf("lock paths %s and %s differ for the same folder", s[0].lockPath, s[1].lockPath)
+	}
+
+	unlock, err := s[0].lock(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	short, cancel := context.WithTimeout(ctx, 3*lockRetryDelay)
+	defer cancel()
+	if _, err := s[1].lock(short); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("lock while held: %v, want a timeout", err)
+	}
+
+	// The waiter gets the lock once it is released.
+	done := make(chan error, 1)
+	go func() {
+		unlock, err := s[1].lock(ctx)
+		if err == nil {
+			unlock()
+		}
+		done <- err
+	}()
+	time.Sleep(2 * lockRetryDelay)
+	unlock()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(lockTimeout):
+		t.Fatal("lock was not handed over")
+	}
+}
+
+func TestLocalFolderLockFileLeftBehindDoesNotBlock(t *testing.T) {
+	ctx := context.Background()
+	_, s := newLocalFolders(t, "a")
+
+	// A process that crashed leaves the file but not the lock.
+	if err := os.MkdirAll(filepath.Dir(s[0].lockPath), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(s[0].lockPath, []byte("123 crashed\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	short, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	unlock, err := s[0].lock(short)
+	if err != nil {
+		t.Fatalf("lock with a leftover lock file: %v", err)
+	}
+	unlock()
+}
@@ -0,0 +1,569 @@
+package sync
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/goBookMarker/internal/models"
+)
+
+// Snapshot is the synced state of a library: bookmarks, tags, tag groups
+// and preferences, plus tombstones for deleted entities so deletions
+// propagate to other devices.
+type Snapshot struct {
+	Bookmarks   []models.Bookmark       `json:"bookmarks"`
+	Tags        []models.Tag            `json:"tags,omitempty"`
+	TagGroups   []models.TagGroup       `json:"tag_groups,omitempty"`
+	Preferences *models.UserPreferences `json:"preferences,omitempty"`
+	Tombstones  []Tombstone             `json:"tombstones,omitempty"`
+
+	// bookmarksOnly is set for snapshots decoded from a format that could
+	// not hold tags, tag groups or preferences. Merge treats those as
+	// unchanged on that side instead of deleted.
+	bookmarksOnly bool
+}
+
+// Entity kinds, used by tombstones and conflicts.
+const (
+	KindBookmark    = "bookmark"
+	KindTag         = "tag"
+	KindTagGroup    = "tag_group"
+	KindPreferences = "preferences"
+)
+
+// Tombstone records that an entity was deleted.
+type Tombstone struct {
+	Kind      string    `json:"kind"`
+	ID        string    `json:"id"`
+	DeletedAt time.Time `json:"deleted_at"`
+}
+
+// tombstoneTTL is how long tombstones are kept. A device that has not
+// synced for longer may bring a deleted entity back.
+const tombstoneTTL = 90 * 24 * time.Hour
+
+// Conflict records a change made on both sides since the last sync, and
+// how it was resolved, so the user can review it.
+type Conflict struct {
+	Kind       string    `json:"kind"`
+	ID         string    `json:"id"`
+	Title      string    `json:"title"`
+	Field      string    `json:"field"` // a field name, or "deleted"
+	Local      string    `json:"local"`
+	Remote     string    `json:"remote"`
+	Resolution string    `json:"resolution"` // "local" or "remote"
+	DetectedAt time.Time `json:"detected_at"`
+}
+
+const (
+	ResolvedLocal  = "local"
+	ResolvedRemote = "remote"
+)
+
+// MergeResult is the outcome of Merge.
+type MergeResult struct {
+	Snapshot  *Snapshot
+	Conflicts []Conflict
+}
+
+// Merge combines the local and remote snapshots using base, the result of
+// the last successful sync, as the common ancestor. Fields changed on one
+// side only take that side's value; fields changed on both sides go to the
+// side with the later UpdatedAt (remote on a tie, and always for tag
+// groups and preferences, which carry no timestamp) and are reported as
+// conflicts. An entity deleted on one side and modified on the other is
+// kept. Any snapshot may be nil; a nil base means nothing was synced yet.
+func Merge(base, local, remote *Snapshot, now time.Time) *MergeResult {
+	local = fillBookmarksOnly(local, remote)
+	remote = fillBookmarksOnly(remote, local)
+
+	m := &merger{now: now, tombs: make(map[string]Tombstone), result: &MergeResult{Snapshot: &Snapshot{}}}
+	for _, snap := range []*Snapshot{base, local, remote} {
+		if snap == nil {
+			continue
+		}
+		for _, t := range snap.Tombstones {
+			key := t.Kind + "\x00" + t.ID
+			if prev, ok := m.tombs[key]; !ok || t.DeletedAt.After(prev.DeletedAt) {
+				m.tombs[key] = t
+			}
+		}
+	}
+
+	out := m.result.Snapshot
+	out.Tags = mergeEntities(m, tagEntity, entities(base, (*Snapshot).tags), entities(local, (*Snapshot).tags), entities(remote, (*Snapshot).tags))
+	out.TagGroups = mergeEntities(m, tagGroupEntity, entities(base, (*Snapshot).tagGroups), entities(local, (*Snapshot).tagGroups), entities(remote, (*Snapshot).tagGroups))
+	out.Bookmarks = mergeEntities(m, bookmarkEntity, entities(base, (*Snapshot).bookmarks), entities(local, (*Snapshot).bookmarks), entities(remote, (*Snapshot).bookmarks))
+	out.Preferences = m.mergePreferences(prefs(base), prefs(local), prefs(remote))
+	dedupeTags(m, entities(base, (*Snapshot).tags))
+
+	sort.Slice(out.Tombstones, func(i, j int) bool {
+		if out.Tombstones[i].Kind != out.Tombstones[j].Kind {
+			return out.Tombstones[i].Kind < out.Tombstones[j].Kind
+		}
+		return out.Tombstones[i].ID < out.Tombstones[j].ID
+	})
+	conflicts := m.result.Conflicts
+	sort.Slice(conflicts, func(i, j int) bool {
+		a, b := conflicts[i], conflicts[j]
+		if a.Kind != b.Kind {
+			return a.Kind < b.Kind
+		}
+		if a.ID != b.ID {
+			return a.ID < b.ID
+		}
+		return a.Field < b.Field
+	})
+	return m.result
+}
+
+// fillBookmarksOnly returns s with the entities it cannot hold copied from
+// other, if s is a bookmarks-only snapshot.
+func fillBookmarksOnly(s, other *Snapshot) *Snapshot {
+	if s == nil || !s.bookmarksOnly || other == nil {
+		return s
+	}
+	filled := *s
+	filled.Tags = other.Tags
+	filled.TagGroups = other.TagGroups
+	filled.Preferences = other.Preferences
+	filled.bookmarksOnly = false
+	return &filled
+}
+
+type merger struct {
+	now    time.Time
+	tombs  map[string]Tombstone // by kind and ID
+	result *MergeResult
+}
+
+func (m *merger) conflict(c Conflict) {
+	c.DetectedAt = m.now
+	m.result.Conflicts = append(m.result.Conflicts, c)
+}
+
+// remove records the deletion of an entity, keeping the earliest known
+// tombstone unless it has expired.
+func (m *merger) remove(kind, id string) {
+	t, ok := m.tombs[kind+"\x00"+id]
+	if !ok {
+		t = Tombstone{Kind: kind, ID: id, DeletedAt: m.now}
+	}
+	if m.now.Sub(t.DeletedAt) < tombstoneTTL {
+		m.result.Snapshot.Tombstones = append(m.result.Snapshot.Tombstones, t)
+	}
+}
+
+func (s *Snapshot) bookmarks() []models.Bookmark { return s.Bookmarks }
+func (s *Snapshot) tags() []models.Tag           { return s.Tags }
+func (s *Snapshot) tagGroups() []models.TagGroup { return s.TagGroups }
+
+func entities[T any](s *Snapshot, get func(*Snapshot) []T) []T {
+	if s == nil {
+		return nil
+	}
+	return get(s)
+}
+
+func prefs(s *Snapshot) *models.UserPreferences {
+	if s == nil {
+		return nil
+	}
+	return s.Preferences
+}
+
+// field describes one mergeable field of an entity. Values are compared
+// and reported as strings. A field without set is merged by the entity's
+// finish function and never conflicts.
+type field[T any] struct {
+	name string
+	get  func(*T) string
+	set  func(dst, src *T)
+}
+
+// entity describes how to merge one kind of entity.
+type entity[T any] struct {
+	kind    string
+	id      func(*T) string
+	title   func(*T) string
+	updated func(*T) time.Time // zero when the kind has no timestamp
+	fields  []field[T]
+	// finish adjusts a merged entity for what fields cannot express, such
+	// as set-valued fields.
+	finish func(merged, base *T, local, remote T)
+}
+
+func mergeEntities[T any](m *merger, e entity[T], base, local, remote []T) []T {
+	index := func(list []T) map[string]T {
+		byID := make(map[string]T, len(list))
+		for i := range list {
+			byID[e.id(&list[i])] = list[i]
+		}
+		return byID
+	}
+	baseByID, localByID, remoteByID := index(base), index(local), index(remote)
+
+	ids := make(map[string]bool)
+	for _, byID := range []map[string]T{baseByID, localByID, remoteByID} {
+		for id := range byID {
+			ids[id] = true
+		}
+	}
+	for _, t := range m.tombs {
+		if t.Kind == e.kind {
+			ids[t.ID] = true
+		}
+	}
+
+	var merged []T
+	for id := range ids {
+		b, inBase := baseByID[id]
+		l, inLocal := localByID[id]
+		r, inRemote := remoteByID[id]
+		tomb, hasTomb := m.tombs[e.kind+"\x00"+id]
+
+		switch {
+		case inLocal && inRemote:
+			var basePtr *T
+			if inBase {
+				basePtr = &b
+			}
+			merged = append(merged, mergeEntity(m, e, basePtr, l, r))
+
+		case inLocal || inRemote:
+			present, side := l, ResolvedLocal
+			if inRemote {
+				present, side = r, ResolvedRemote
+			}
+			if !inBase && !hasTomb {
+				// Added on one side since the last sync.
+				merged = append(merged, present)
+				continue
+			}
+			// Deleted on the other side; keep the entity only if it was
+			// changed since the deletion could have seen it.
+			var modified bool
+			if inBase {
+				modified = changed(e, &b, &present)
+			} else {
+				modified = e.updated(&present).After(tomb.DeletedAt)
+			}
+			if !modified {
+				m.remove(e.kind, id)
+				continue
+			}
+			c := Conflict{Kind: e.kind, ID: id, Title: e.title(&present), Field: "deleted", Resolution: side}
+			if side == ResolvedLocal {
+				c.Local, c.Remote = "modified", "deleted"
+			} else {
+				c.Local, c.Remote = "deleted", "modified"
+			}
+			m.conflict(c)
+			merged = append(merged, present)
+
+		default:
+			m.remove(e.kind, id)
+		}
+	}
+
+	sort.Slice(merged, func(i, j int) bool {
+		return e.id(&merged[i]) < e.id(&merged[j])
+	})
+	return merged
+}
+
+func changed[T any](e entity[T], a, b *T) bool {
+	for _, f := range e.fields {
+		if f.get(a) != f.get(b) {
+			return true
+		}
+	}
+	return false
+}
+
+// mergeEntity merges two versions of an entity field by field.
+func mergeEntity[T any](m *merger, e entity[T], base *T, local, remote T) T {
+	remoteWins := !e.updated(&local).After(e.updated(&remote))
+	merged := local
+	if remoteWins {
+		merged = remote
+	}
+
+	var conflicts []Conflict
+	for _, f := range e.fields {
+		if f.set == nil {
+			continue
+		}
+		lv, rv := f.get(&local), f.get(&remote)
+		switch {
+		case lv == rv:
+			f.set(&merged, &local)
+		case base != nil && f.get(base) == rv:
+			f.set(&merged, &local)
+		case base != nil && f.get(base) == lv:
+			f.set(&merged, &remote)
+		default:
+			winner, resolution := &local, ResolvedLocal
+			if remoteWins {
+				winner, resolution = &remote, ResolvedRemote
+			}
+			f.set(&merged, winner)
+			conflicts = append(conflicts, Conflict{
+				Kind:       e.kind,
+				ID:         e.id(&local),
+				Field:      f.name,
+				Local:      lv,
+				Remote:     rv,
+				Resolution: resolution,
+			})
+		}
+	}
+	if e.finish != nil {
+		e.finish(&merged, base, local, remote)
+	}
+	for _, c := range conflicts {
+		c.Title = e.title(&merged)
+		m.conflict(c)
+	}
+	return merged
+}
+
+var bookmarkEntity = entity[models.Bookmark]{
+	kind:    KindBookmark,
+	id:      func(b *models.Bookmark) string { return b.ID },
+	title:   func(b *models.Bookmark) string { return b.Title },
+	updated: func(b *models.Bookmark) time.Time { return b.UpdatedAt },
+	fields: []field[models.Bookmark]{
+		{"url", func(b *models.Bookmark) string { return b.URL }, func(d, s *models.Bookmark) { d.URL = s.URL }},
+		{"title", func(b *models.Bookmark) string { return b.Title }, func(d, s *models.Bookmark) { d.Title = s.Title }},
+		{"description", func(b *models.Bookmark) string { return b.Description }, func(d, s *models.Bookmark) { d.Description = s.Description }},
+		{"image_url", func(b *models.Bookmark) string { return b.ImageURL }, func(d, s *models.Bookmark) { d.ImageURL = s.ImageURL }},
+		{"favicon_url", func(b *models.Bookmark) string { return b.FaviconURL }, func(d, s *models.Bookmark) { d.FaviconURL = s.FaviconURL }},
+		{"is_favorite", func(b *models.Bookmark) string { return fmt.Sprint(b.IsFavorite) }, func(d, s *models.Bookmark) { d.IsFavorite = s.IsFavorite }},
+		{"tags", func(b *models.Bookmark) string { return setKey(b.Tags) }, nil},
+	},
+	finish: func(merged, base *models.Bookmark, local, remote models.Bookmark) {
+		merged.UpdatedAt = later(local.UpdatedAt, remote.UpdatedAt)
+		merged.CreatedAt = earlier(local.CreatedAt, remote.CreatedAt)
+		// Tags merge as a set, so both sides' additions and removals apply
+		// without conflicting.
+		var baseTags []string
+		if base != nil {
+			baseTags = base.Tags
+		}
+		merged.Tags = mergeSet(baseTags, local.Tags, remote.Tags)
+	},
+}
+
+var tagEntity = entity[models.Tag]{
+	kind:    KindTag,
+	id:      func(t *models.Tag) string { return t.ID },
+	title:   func(t *models.Tag) string { return t.Name },
+	updated: func(t *models.Tag) time.Time { return t.UpdatedAt },
+	fields: []field[models.Tag]{
+		{"name", func(t *models.Tag) string { return t.Name }, func(d, s *models.Tag) { d.Name = s.Name }},
+		{"color", func(t *models.Tag) string { return t.Color }, func(d, s *models.Tag) { d.Color = s.Color }},
+		{"description", func(t *models.Tag) string { return t.Description }, func(d, s *models.Tag) { d.Description = s.Description }},
+		{"parent_id", func(t *models.Tag) string { return t.ParentID }, func(d, s *models.Tag) { d.ParentID = s.ParentID }},
+		{"order", func(t *models.Tag) string { return fmt.Sprint(t.Order) }, func(d, s *models.Tag) { d.Order = s.Order }},
+	},
+	finish: func(merged, base *models.Tag, local, remote models.Tag) {
+		merged.UpdatedAt = later(local.UpdatedAt, remote.UpdatedAt)
+		merged.CreatedAt = earlier(local.CreatedAt, remote.CreatedAt)
+	},
+}
+
+var tagGroupEntity = entity[models.TagGroup]{
+	kind:    KindTagGroup,
+	id:      func(g *models.TagGroup) string { return g.ID },
+	title:   func(g *models.TagGroup) string { return g.Name },
+	updated: func(g *models.TagGroup) time.Time { return time.Time{} },
+	fields: []field[models.TagGroup]{
+		{"name", func(g *models.TagGroup) string { return g.Name }, func(d, s *models.TagGroup) { d.Name = s.Name }},
+		{"tag_ids", func(g *models.TagGroup) string { return setKey(g.TagIDs) }, nil},
+		{"order", func(g *models.TagGroup) string { return fmt.Sprint(g.Order) }, func(d, s *models.TagGroup) { d.Order = s.Order }},
+		{"expanded", func(g *models.TagGroup) string { return fmt.Sprint(g.Expanded) }, func(d, s *models.TagGroup) { d.Expanded = s.Expanded }},
+	},
+	finish: func(merged, base *models.TagGroup, local, remote models.TagGroup) {
+		var baseIDs []string
+		if base != nil {
+			baseIDs = base.TagIDs
+		}
+		merged.TagIDs = mergeSet(baseIDs, local.TagIDs, remote.TagIDs)
+	},
+}
+
+// preferencesEntity merges the synced preferences. SyncEnabled stays
+// per-device: turning sync off on one device must not turn it off
+// everywhere.
+var preferencesEntity = entity[models.UserPreferences]{
+	kind:    KindPreferences,
+	id:      func(*models.UserPreferences) string { return KindPreferences },
+	title:   func(*models.UserPreferences) string { return "Preferences" },
+	updated: func(*models.UserPreferences) time.Time { return time.Time{} },
+	fields: []field[models.UserPreferences]{
+		{"nav_position", func(p *models.UserPreferences) string { return p.NavPosition }, func(d, s *models.UserPreferences) { d.NavPosition = s.NavPosition }},
+		{"nav_items", func(p *models.UserPreferences) string { return strings.Join(p.NavItems, "\n") }, func(d, s *models.UserPreferences) { d.NavItems = s.NavItems }},
+		{"theme", func(p *models.UserPreferences) string { return p.Theme }, func(d, s *models.UserPreferences) { d.Theme = s.Theme }},
+	},
+	finish: func(merged, base *models.UserPreferences, local, remote models.UserPreferences) {
+		merged.SyncEnabled = local.SyncEnabled
+	},
+}
+
+func (m *merger) mergePreferences(base, local, remote *models.UserPreferences) *models.UserPreferences {
+	switch {
+	case local == nil && remote == nil:
+		return nil
+	case local == nil:
+		p := *remote
+		return &p
+	case remote == nil:
+		p := *local
+		return &p
+	}
+	merged := mergeEntity(m, preferencesEntity, base, *local, *remote)
+	return &merged
+}
+
+// dedupeTags unifies tags that were created independently on two devices
+// with the same name and parent, which happens when both tag a bookmark
+// with a new name before syncing. The tag with the smaller ID is kept so
+// every device makes the same choice; references to the other one are
+// rewritten and it gets a tombstone.
+func dedupeTags(m *merger, base []models.Tag) {
+	out := m.result.Snapshot
+	inBase := make(map[string]bool, len(base))
+	for _, t := range base {
+		inBase[t.ID] = true
+	}
+
+	replaced := make(map[string]string)
+	keep := make(map[string]string) // name and parent -> kept ID
+	for {
+		changed := false
+		for _, t := range out.Tags {
+			if replaced[t.ID] != "" {
+				continue
+			}
+			parent := t.ParentID
+			if r := replaced[parent]; r != "" {
+				parent = r
+			}
+			key := parent + "\x00" + strings.ToLower(t.Name)
+			kept, ok := keep[key]
+			switch {
+			case !ok:
+				keep[key] = t.ID
+			case kept != t.ID && (!inBase[t.ID] || !inBase[kept]):
+				winner, loser := kept, t.ID
+				if t.ID < kept {
+					winner, loser = t.ID, kept
+				}
+				keep[key] = winner
+				replaced[loser] = winner
+				changed = true
+			}
+		}
+		if !changed {
+			break
+		}
+		// A replacement can make children collide, so go again.
+		keep = make(map[string]string)
+	}
+	if len(replaced) == 0 {
+		return
+	}
+
+	tags := out.Tags[:0]
+	for _, t := range out.Tags {
+		if replaced[t.ID] != "" {
+			m.remove(KindTag, t.ID)
+			continue
+		}
+		if r := replaced[t.ParentID]; r != "" {
+			t.ParentID = r
+		}
+		tags = append(tags, t)
+	}
+	out.Tags = tags
+
+	for i, g := range out.TagGroups {
+		ids := make([]string, 0, len(g.TagIDs))
+		for _, id := range g.TagIDs {
+			if r := replaced[id]; r != "" {
+				id = r
+			}
+			if !contains(ids, id) {
+				ids = append(ids, id)
+			}
+		}
+		out.TagGroups[i].TagIDs = ids
+	}
+}
+
+// mergeSet applies the additions and removals each side made to base,
+// keeping the local order. Items compare case-insensitively.
+func mergeSet(base, local, remote []string) []string {
+	inBase, inLocal, inRemote := foldSet(base), foldSet(local), foldSet(remote)
+
+	var merged []string
+	seen := make(map[string]bool)
+	for _, item := range append(append(append([]string(nil), local...), remote...), base...) {
+		key := strings.ToLower(item)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		removed := inBase[key] && (!inLocal[key] || !inRemote[key])
+		if (inLocal[key] || inRemote[key]) && !removed {
+			merged = append(merged, item)
+		}
+	}
+	return merged
+}
+
+func foldSet(items []string) map[string]bool {
+	set := make(map[string]bool, len(items))
+	for _, item := range items {
+		set[strings.ToLower(item)] = true
+	}
+	return set
+}
+
+// setKey is an order- and case-insensitive representation of items for
+// comparison.
+func setKey(items []string) string {
+	keys := make([]string, 0, len(items))
+	for key := range foldSet(items) {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return strings.Join(keys, "\n")
+}
+
+func contains(items []string, item string) bool {
+	for _, i := range items {
+		if i == item {
+			return true
+		}
+	}
+	return false
+}
+
+func later(a, b time.Time) time.Time {
+	if b.After(a) {
+		return b
+	}
+	return a
+}
+
+// earlier returns the earlier non-zero time.
+func earlier(a, b time.Time) time.Time {
+	if a.IsZero() || (!b.IsZero() && b.Before(a)) {
+		return b
+	}
+	return a
+}
@@ -0,0 +1,231 @@
+package sync
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/goBookMarker/internal/models"
+)
+
+var mergeTime = time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+// baseSnapshot is the last synced state the merge tests start from.
+func baseSnapshot() *Snapshot {
+	return &Snapshot{
+		Bookmarks: []models.Bookmark{{
+			ID:        "b1",
+			URL:       "https://go.dev/",
+			Title:     "Go",
+			Tags:      []string{"web", "news"},
+			CreatedAt: mergeTime.Add(-48 * time.Hour),
+			UpdatedAt: mergeTime.Add(-48 * time.Hour),
+		}},
+		Tags: []models.Tag{{ID: "t1", Name: "web", Color: "#ff0000", UpdatedAt: mergeTime.Add(-48 * time.Hour)}},
+	}
+}
+
+// mergedBookmark returns the bookmark with id in s, or nil.
+func mergedBookmark(s *Snapshot, id string) *models.Bookmark {
+	for i := range s.Bookmarks {
+		if s.Bookmarks[i].ID == id {
+			return &s.Bookmarks[i]
+		}
+	}
+	return nil
+}
+
+func TestMerge(t *testing.T) {
+	edit := func(title string, at time.Duration) func(*Snapshot) {
+		return func(s *Snapshot) {
+			s.Bookmarks[0].Title = title
+			s.Bookmarks[0].UpdatedAt = mergeTime.Add(at)
+		}
+	}
+	deleteBookmark := func(s *Snapshot) { s.Bookmarks = nil }
+	unchanged := func(*Snapshot) {}
+
+	tests := []struct {
+		name          string
+		local, remote func(*Snapshot)
+		// want is the merged title of b1, or "" if b1 is deleted.
+		want        string
+		description string
+		conflicts   string // kind/field/resolution of each conflict
+		tombstone   bool   // whether b1 has a tombstone
+	}{
+		{name: "unchanged", local: unchanged, remote: unchanged, want: "Go"},
+		{name: "local edit", local: edit("Local", -time.Hour), remote: unchanged, want: "Local"},
+		{name: "remote edit", local: unchanged, remote: edit("Remote", -time.Hour), want: "Remote"},
+		{name: "same edit on both sides", local: edit("Same", -2*time.Hour), remote: edit("Same", -time.Hour), want: "Same"},
+		{
+			name:  "edits to different fields",
+			local: edit("Local", -2*time.Hour),
+			remote: func(s *Snapshot) {
+				s.Bookmarks[0].Description = "The Go site"
+				s.Bookmarks[0].UpdatedAt = mergeTime.Add(-time.Hour)
+			},
+			want:        "Local",
+			description: "The Go site",
+		},
+		{
+			name:      "conflicting edits, local later",
+			local:     edit("Local", -time.Hour),
+			remote:    edit("Remote", -2*time.Hour),
+			want:      "Local",
+			conflicts: "bookmark/title/local",
+		},
+		{
+			name:      "conflicting edits, remote later",
+			local:     edit("Local", -2*time.Hour),
+			remote:    edit("Remote", -time.Hour),
+			want:      "Remote",
+			conflicts: "bookmark/title/remote",
+		},
+		{
+			name:      "conflicting edits at the same time",
+			local:     edit("Local", -time.Hour),
+			remote:    edit("Remote", -time.Hour),
+			want:      "Remote",
+			conflicts: "bookmark/title/remote",
+		},
+		{name: "local delete", local: deleteBookmark, remote: unchanged, tombstone: true},
+		{name: "remote delete", local: unchanged, remote: deleteBookmark, tombstone: true},
+		{name: "deleted on both sides", local: deleteBookmark, remote: deleteBookmark, tombstone: true},
+		{
+			name:      "local delete, remote edit",
+			local:     deleteBookmark,
+			remote:    edit("Remote", -time.Hour),
+			want:      "Remote",
+			conflicts: "bookmark/deleted/remote",
+		},
+		{
+			name:      "local edit, remote delete",
+			local:     edit("Local", -time.Hour),
+			remote:    deleteBookmark,
+			want:      "Local",
+			conflicts: "bookmark/deleted/local",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			local, remote := baseSnapshot(), baseSnapshot()
+			tt.local(local)
+			tt.remote(remote)
+			result := Merge(baseSnapshot(), local, remote, mergeTime)
+
+			b := mergedBookmark(result.Snapshot, "b1")
+			switch {
+			case tt.want == "" && b != nil:
+				t.Errorf("b1 = %+v, want deleted", b)
+			case tt.want != "" && (b == nil || b.Title != tt.want):
+				t.Errorf("b1 = %+v, want title %q", b, tt.want)
+			}
+			if b != nil && b.Description != tt.description {
+				t.Errorf("description = %q, want %q", b.Description, tt.description)
+			}
+
+			var conflicts []string
+			for _, c := range result.Conflicts {
+				conflicts = append(conflicts, c.Kind+"/"+c.Field+"/"+c.Resolution)
+			}
+			if got := strings.Join(conflicts, ","); got != tt.conflicts {
+				t.Errorf("conflicts = %q, want %q", got, tt.conflicts)
+			}
+
+			var tombstone bool
+			for _, ts := range result.Snapshot.Tombstones {
+				if ts.Kind == KindBookmark && ts.ID == "b1" {
+					tombstone = true
+				}
+			}
+			if tombstone != tt.tombstone {
+				t.Errorf("tombstone for b1 = %v, want %v", tombstone, tt.tombstone)
+			}
+		})
+	}
+}
+
+func TestMergeTags(t *testing.T) {
+	tests := []struct {
+		name          string
+		local, remote []string
+		want          string
+	}{
+		{"local addition", []string{"web", "news", "go"}, []string{"web", "news"}, "go,news,web"},
+		{"remote removal", []string{"web", "news"}, []string{"news"}, "news"},
+		{"addition and removal", []string{"web", "news", "go"}, []string{"news"}, "go,news"},
+		{"both add the same tag", []string{"web", "news", "go"}, []string{"web", "news", "Go"}, "go,news,web"},
+		{"both remove everything", nil, []string{}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			local, remote := baseSnapshot(), baseSnapshot()
+			local.Bookmarks[0].Tags = tt.local
+			remote.Bookmarks[0].Tags = tt.remote
+			result := Merge(baseSnapshot(), local, remote, mergeTime)
+
+			if got := strings.ReplaceAll(setKey(mergedBookmark(result.Snapshot, "b1").Tags), "\n", ","); got != tt.want {
+				t.Errorf("tags = %s, want %s", got, tt.want)
+			}
+			if len(result.Conflicts) != 0 {
+				t.Errorf("conflicts = %+v, want none", result.Conflicts)
+			}
+		})
+	}
+
+	// Tag entities merge like bookmarks.
+	local, remote := baseSnapshot(), baseSnapshot()
+	local.Tags[0].Color = "#00ff00"
+	local.Tags[0].UpdatedAt = mergeTime.Add(-time.Hour)
+	remote.Tags[0].Name = "internet"
+	remote.Tags[0].UpdatedAt = mergeTime.Add(-2 * time.Hour)
+	result := Merge(baseSnapshot(), local, remote, mergeTime)
+	if tags := result.Snapshot.Tags; len(tags) != 1 || tags[0].Name != "internet" || tags[0].Color != "#00ff00" || !tags[0].UpdatedAt.Equal(local.Tags[0].UpdatedAt) {
+		t.Errorf("tags = %+v, want the remote name and the local color", tags)
+	}
+}
+
+func TestMergeTombstones(t *testing.T) {
+	resurrected := baseSnapshot().Bookmarks[0]
+
+	tests := []struct {
+		name      string
+		deletedAt time.Time
+		// updatedAt is when the other side last changed the bookmark, or
+		// zero if it deleted it too.
+		updatedAt     time.Time
+		wantBookmark  bool
+		wantTombstone bool
+	}{
+		{name: "recent tombstone", deletedAt: mergeTime.Add(-24 * time.Hour), wantTombstone: true},
+		{name: "expired tombstone", deletedAt: mergeTime.Add(-tombstoneTTL - time.Hour)},
+		{name: "edited before the deletion", deletedAt: mergeTime.Add(-time.Hour), updatedAt: mergeTime.Add(-2 * time.Hour), wantTombstone: true},
+		{name: "edited after the deletion", deletedAt: mergeTime.Add(-2 * time.Hour), updatedAt: mergeTime.Add(-time.Hour), wantBookmark: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The base has not seen the bookmark, as on a device that
+			// never synced it; only the tombstone records the deletion.
+			local := &Snapshot{Tombstones: []Tombstone{{Kind: KindBookmark, ID: "b1", DeletedAt: tt.deletedAt}}}
+			remote := &Snapshot{}
+			if !tt.updatedAt.IsZero() {
+				b := resurrected
+				b.UpdatedAt = tt.updatedAt
+				remote.Bookmarks = []models.Bookmark{b}
+			}
+			result := Merge(nil, local, remote, mergeTime)
+
+			if got := mergedBookmark(result.Snapshot, "b1") != nil; got != tt.wantBookmark {
+				t.Errorf("bookmark kept = %v, want %v", got, tt.wantBookmark)
+			}
+			tombstones := result.Snapshot.Tombstones
+			if got := len(tombstones) == 1; got != tt.wantTombstone || len(tombstones) > 1 {
+				t.Fatalf("tombstones = %+v, want tombstone %v", tombstones, tt.wantTombstone)
+			}
+			if tt.wantTombstone && !tombstones[0].DeletedAt.Equal(tt.deletedAt) {
+				t.Errorf("tombstone deleted at %v, want the original %v", tombstones[0].DeletedAt, tt.deletedAt)
+			}
+		})
+	}
+}
@@ -0,0 +1,447 @@
+package sync
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	gosync "sync"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// DefaultGraphBaseURL is the root of the Microsoft Graph API.
+const DefaultGraphBaseURL = "https://graph.microsoft.com/v1.0"
+
+const (
+	// oneDriveFileName is the name of the sync file in the app folder.
+	oneDriveFileName = "bookmarks.json"
+
+	// maxSimpleUpload is the largest payload Graph accepts in a single PUT;
+	// anything bigger goes through an upload session.
+	maxSimpleUpload = 4 << 20
+
+	// uploadChunkSize must be a multiple of 320 KiB.
+	uploadChunkSize = 10 * 320 << 10
+)
+
+// OneDriveSync stores the sync data as a single file in the OneDrive app
+// folder (special/approot), which needs only Files.ReadWrite.AppFolder.
+type OneDriveSync struct {
+	client  *http.Client
+	baseURL string
+
+	// uploadClient sends upload session chunks. Session URLs are
+	// pre-authenticated and reject an Authorization header.
+	uploadClient *http.Client
+
+	mu           gosync.Mutex
+	itemID       string
+	eTag         string
+	cTag         string
+	lastModified time.Time
+}
+
+var _ FileStore = (*OneDriveSync)(nil)
+
+// driveItem is the subset of Graph driveItem metadata the sync uses.
+type driveItem struct {
+	ID                   string    `json:"id"`
+	Name                 string    `json:"name"`
+	ETag                 string    `json:"eTag"`
+	CTag                 string    `json:"cTag"`
+	Size                 int64     `json:"size"`
+	LastModifiedDateTime time.Time `json:"lastModifiedDateTime"`
+}
+
+// NewOneDriveSync creates a OneDrive sync that authorizes requests with tokens
+// from source, which should refresh them, such as the one returned by
+// auth.OAuthProvider.TokenSource.
+func NewOneDriveSync(source oauth2.TokenSource) *OneDriveSync {
+	return NewOneDriveSyncClient(newOAuthClient(source), DefaultGraphBaseURL)
+}
+
+// NewOneDriveSyncClient creates a OneDrive sync that sends Graph requests
+// to baseURL with client, which must add authorization itself.
+func NewOneDriveSyncClient(client *http.Client, baseURL string) *OneDriveSync {
+	return &OneDriveSync{
+		client:       client,
+		baseURL:      baseURL,
+		uploadClient: newHTTPClient(),
+	}
+}
+
+func (s *OneDriveSync) itemURL(suffix string) string {
+	return s.fileURL(oneDriveFileName, suffix)
+}
+
+// fileURL addresses the named file in the app folder by path.
+func (s *OneDriveSync) fileURL(name, suffix string) string {
+	return s.baseURL + "/me/drive/special/approot:/" + name + suffix
+}
+
+// Upload replaces the contents of the sync file, creating it on first use.
+// Payloads over 4 MB are sent in chunks through an upload session. Once
+// the file has been seen, the upload is conditional on its eTag, and
+// ErrRemoteChanged is returned if another device replaced it since.
+func (s *OneDriveSync) Upload(ctx context.Context, data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	item, err := s.upload(ctx, oneDriveFileName, data, s.eTag)
+	if isStatus(err, http.StatusNotFound) && s.eTag != "" {
+		// The file was deleted since it was last seen; create it again.
+		item, err = s.upload(ctx, oneDriveFileName, data, "")
+	}
+	if isStatus(err, http.StatusPreconditionFailed) {
+		return fmt.Errorf("failed to upload to OneDrive: %w", ErrRemoteChanged)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to upload to OneDrive: %w", err)
+	}
+	s.setItemLocked(item)
+	return nil
+}
+
+// upload replaces the contents of the named file in the app folder. With
+// an ifMatch eTag, Graph refuses the upload with 412 if the file changed.
+func (s *OneDriveSync) upload(ctx context.Context, name string, data []byte, ifMatch string) (*driveItem, error) {
+	if len(data) <= maxSimpleUpload {
+		return s.simpleUpload(ctx, name, data, ifMatch)
+	}
+	return s.sessionUpload(ctx, name, data, ifMatch)
+}
+
+func (s *OneDriveSync) simpleUpload(ctx context.Context, name string, data []byte, ifMatch string) (*driveItem, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, s.fileURL(name, ":/content"), bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if ifMatch != "" {
+		req.Header.Set("If-Match", ifMatch)
+	}
+	return s.doItem(s.client, req)
+}
+
+// sessionUpload creates an upload session and sends data in
+// uploadChunkSize pieces. The last chunk's response carries the item.
+func (s *OneDriveSync) sessionUpload(ctx context.Context, name string, data []byte, ifMatch string) (*driveItem, error) {
+	body, err := json.Marshal(map[string]interface{}{
+		"item": map[string]interface{}{
+			"@microsoft.graph.conflictBehavior": "replace",
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.fileURL(name, ":/createUploadSession"), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if ifMatch != "" {
+		req.Header.Set("If-Match", ifMatch)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if err := checkResponse(resp); err != nil {
+		return nil, fmt.Errorf("failed to create upload session: %w", err)
+	}
+	var session struct {
+		UploadURL string `json:"uploadUrl"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&session); err != nil {
+		return nil, fmt.Errorf("failed to decode upload session: %w", err)
+	}
+	if session.UploadURL == "" {
+		return nil, fmt.Errorf("upload session has no upload URL")
+	}
+
+	total := len(data)
+	for start := 0; start < total; start += uploadChunkSize {
+		end := start + uploadChunkSize
+		if end > total {
+			end = total
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodPut, session.UploadURL, bytes.NewReader(data[start:end]))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end-1, total))
+
+		if end < total {
+			if err := s.putChunk(req); err != nil {
+				s.cancelSession(session.UploadURL)
+				return nil, err
+			}
+			continue
+		}
+		item, err := s.doItem(s.uploadClient, req)
+		if err != nil {
+			s.cancelSession(session.UploadURL)
+			return nil, err
+		}
+		return item, nil
+	}
+	return nil, fmt.Errorf("nothing to upload")
+}
+
+// putChunk sends an intermediate chunk, which Graph acknowledges with 202.
+func (s *OneDriveSync) putChunk(req *http.Request) error {
+	resp, err := s.uploadClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err := checkResponse(resp); err != nil {
+		return err
+	}
+	io.Copy(io.Discard, resp.Body)
+	return nil
+}
+
+// cancelSession deletes an unfinished upload session. Errors are ignored
+// since sessions also expire on their own. It does not use the upload's
+// context, which may be what was cancelled.
+func (s *OneDriveSync) cancelSession(uploadURL string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, uploadURL, nil)
+	if err != nil {
+		return
+	}
+	if resp, err := s.uploadClient.Do(req); err == nil {
+		resp.Body.Close()
+	}
+}
+
+// doItem sends req and decodes a driveItem response.
+func (s *OneDriveSync) doItem(client *http.Client, req *http.Request) (*driveItem, error) {
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if err := checkResponse(resp); err != nil {
+		return nil, err
+	}
+	var item driveItem
+	if err := json.NewDecoder(resp.Body).Decode(&item); err != nil {
+		return nil, fmt.Errorf("failed to decode drive item: %w", err)
+	}
+	return &item, nil
+}
+
+// Download returns the contents of the sync file, or ErrNoRemoteData if it
+// does not exist yet.
+func (s *OneDriveSync) Download(ctx context.Context) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	item, err := s.metadataLocked(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if item == nil {
+		s.setItemLocked(nil)
+		return nil, ErrNoRemoteData
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.itemURL(":/content"), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download from OneDrive: %w", err)
+	}
+	defer resp.Body.Close()
+	err = checkResponse(resp)
+	if isStatus(err, http.StatusNotFound) {
+		s.setItemLocked(nil)
+		return nil, ErrNoRemoteData
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to download from OneDrive: %w", err)
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download from OneDrive: %w", err)
+	}
+	s.setItemLocked(item)
+	return data, nil
+}
+
+// Changed reports whether the sync file's content changed since the last
+// upload or download, by comparing its cTag. A file that appeared or
+// disappeared also counts as a change.
+func (s *OneDriveSync) Changed(ctx context.Context) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	item, err := s.metadataLocked(ctx)
+	if err != nil {
+		return false, err
+	}
+	if item == nil {
+		return s.cTag != "", nil
+	}
+	return item.CTag != s.cTag, nil
+}
+
+// metadataLocked fetches the sync file's metadata, returning nil if it
+// does not exist.
+func (s *OneDriveSync) metadataLocked(ctx context.Context) (*driveItem, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.itemURL(""), nil)
+	if err != nil {
+		return nil, err
+	}
+	item, err := s.doItem(s.client, req)
+	if isStatus(err, http.StatusNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up sync file: %w", err)
+	}
+	return item, nil
+}
+
+func (s *OneDriveSync) setItemLocked(item *driveItem) {
+	if item == nil {
+		s.itemID, s.eTag, s.cTag = "", "", ""
+		return
+	}
+	s.itemID = item.ID
+	s.eTag = item.ETag
+	s.cTag = item.CTag
+	s.lastModified = item.LastModifiedDateTime
+}
+
+// PutFile stores the named file in the app folder.
+func (s *OneDriveSync) PutFile(ctx context.Context, name string, data []byte) error {
+	if err := checkFileName(name); err != nil {
+		return err
+	}
+	if _, err := s.upload(ctx, name, data, ""); err != nil {
+		return fmt.Errorf("failed to upload to OneDrive: %w", err)
+	}
+	return nil
+}
+
+// GetFile returns the contents of the named file in the app folder.
+func (s *OneDriveSync) GetFile(ctx context.Context, name string) ([]byte, error) {
+	if err := checkFileName(name); err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.fileURL(name, ":/content"), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download from OneDrive: %w", err)
+	}
+	defer resp.Body.Close()
+	err = checkResponse(resp)
+	if isStatus(err, http.StatusNotFound) {
+		return nil, ErrNoRemoteData
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to download from OneDrive: %w", err)
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download from OneDrive: %w", err)
+	}
+	return data, nil
+}
+
+// ListFiles returns the names of the files in the app folder that start
+// with prefix.
+func (s *OneDriveSync) ListFiles(ctx context.Context, prefix string) ([]string, error) {
+	var names []string
+	next := s.baseURL + "/me/drive/special/approot/children?$select=name,file&$top=200"
+	for next != "" {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, next, nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := s.client.Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list OneDrive app folder: %w", err)
+		}
+		var page struct {
+			Value []struct {
+				Name string           `json:"name"`
+				File *json.RawMessage `json:"file"`
+			} `json:"value"`
+			NextLink string `json:"@odata.nextLink"`
+		}
+		err = checkResponse(resp)
+		if err == nil {
+			if err = json.NewDecoder(resp.Body).Decode(&page); err != nil {
+				err = fmt.Errorf("failed to decode file list: %w", err)
+			}
+		}
+		resp.Body.Close()
+		if isStatus(err, http.StatusNotFound) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to list OneDrive app folder: %w", err)
+		}
+		for _, item := range page.Value {
+			if item.File != nil && strings.HasPrefix(item.Name, prefix) {
+				names = append(names, item.Name)
+			}
+		}
+		next = page.NextLink
+	}
+	return names, nil
+}
+
+// DeleteFile removes the named file from the app folder.
+func (s *OneDriveSync) DeleteFile(ctx context.Context, name string) error {
+	if err := checkFileName(name); err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, s.fileURL(name, ""), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to delete from OneDrive: %w", err)
+	}
+	defer resp.Body.Close()
+	err = checkResponse(resp)
+	if err != nil && !isStatus(err, http.StatusNotFound) {
+		return fmt.Errorf("failed to delete from OneDrive: %w", err)
+	}
+	return nil
+}
+
+// LastSync returns the modification time of the sync file as of the last
+// upload or download, or the zero time if neither has happened.
+func (s *OneDriveSync) LastSync() time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lastModified
+}
+
+// CTag returns the content tag of the sync file as last seen, or "".
+func (s *OneDriveSync) CTag() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.cTag
+}
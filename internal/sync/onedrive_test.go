@@ -0,0 +1,448 @@
+package sync
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	gosync "sync"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// fakeGraph is an in-memory OneDrive app folder serving the parts of the
+// Graph API that OneDriveSync uses.
+type fakeGraph struct {
+	mu       gosync.Mutex
+	srv      *httptest.Server
+	items    map[string]*fakeGraphItem // by name
+	sessions map[string]*fakeGraphSession
+	nextID   int
+	clock    time.Time
+
+	simplePuts int
+	chunks     []string // Content-Range of each session chunk
+	authorized []bool   // whether each session chunk had an Authorization header
+}
+
+type fakeGraphItem struct {
+	id       string
+	name     string
+	data     []byte
+	version  int // bumped by any change
+	content  int // bumped by content changes
+	modified time.Time
+}
+
+func (it *fakeGraphItem) driveItem() driveItem {
+	return driveItem{
+		ID:                   it.id,
+		Name:                 it.name,
+		ETag:                 fmt.Sprintf(`"{%s},%d"`, it.id, it.version),
+		CTag:                 fmt.Sprintf(`"c:{%s},%d"`, it.id, it.content),
+		Size:                 int64(len(it.data)),
+		LastModifiedDateTime: it.modified,
+	}
+}
+
+type fakeGraphSession struct {
+	name string
+	data []byte
+}
+
+const graphAppRoot = "/me/drive/special/approot"
+
+// authTransport adds a bearer token, as the OAuth client would.
+type authTransport struct{}
+
+func (authTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", "Bearer token")
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func newFakeGraph(t *testing.T) (*fakeGraph, *OneDriveSync) {
+	g := &fakeGraph{
+		items:    make(map[string]*fakeGraphItem),
+		sessions: make(map[string]*fakeGraphSession),
+		clock:    time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+	g.srv = httptest.NewServer(g)
+	t.Cleanup(g.srv.Close)
+	return g, g.client()
+}
+
+// client returns a OneDriveSync for another device using the same folder.
+func (g *fakeGraph) client() *OneDriveSync {
+	return NewOneDriveSyncClient(&http.Client{Transport: authTransport{}}, g.srv.URL)
+}
+
+func (g *fakeGraph) item(name string) *fakeGraphItem {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.items[name]
+}
+
+func (g *fakeGraph) remove(name string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	delete(g.items, name)
+}
+
+// writeLocked stores content for the named item, honoring If-Match.
+func (g *fakeGraph) writeLocked(w http.ResponseWriter, name, ifMatch string, data []byte, status int) {
+	it := g.items[name]
+	if ifMatch != "" {
+		if it == nil {
+			http.Error(w, `{"error":{"code":"itemNotFound"}}`, http.StatusNotFound)
+			return
+		}
+		if ifMatch != it.driveItem().ETag {
+			http.Error(w, `{"error":{"code":"resourceModified"}}`, http.StatusPreconditionFailed)
+			return
+		}
+	}
+	if it == nil {
+		g.nextID++
+		it = &fakeGraphItem{id: fmt.Sprintf("item%d", g.nextID), name: name}
+		g.items[name] = it
+		status = http.StatusCreated
+	}
+	g.clock = g.clock.Add(time.Second)
+	it.data = data
+	it.version++
+	it.content++
+	it.modified = g.clock
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(it.driveItem())
+}
+
+func (g *fakeGraph) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if strings.HasPrefix(r.URL.Path, "/upload/") {
+		g.serveChunk(w, r)
+		return
+	}
+	if r.Header.Get("Authorization") == "" {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if r.URL.Path == graphAppRoot+"/children" {
+		var page struct {
+			Value []map[string]interface{} `json:"value"`
+		}
+		for name := range g.items {
+			page.Value = append(page.Value, map[string]interface{}{"name": name, "file": map[string]string{}})
+		}
+		page.Value = append(page.Value, map[string]interface{}{"name": "delta-folder"})
+		json.NewEncoder(w).Encode(page)
+		return
+	}
+
+	path, ok := strings.CutPrefix(r.URL.Path, graphAppRoot+":/")
+	if !ok {
+		http.Error(w, "unexpected request", http.StatusBadRequest)
+		return
+	}
+	name, action, _ := strings.Cut(path, ":/")
+	it := g.items[name]
+
+	switch {
+	case r.Method == http.MethodGet && action == "":
+		if it == nil {
+			http.Error(w, `{"error":{"code":"itemNotFound"}}`, http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(it.driveItem())
+	case r.Method == http.MethodGet && action == "content":
+		if it == nil {
+			http.Error(w, `{"error":{"code":"itemNotFound"}}`, http.StatusNotFound)
+			return
+		}
+		w.Write(it.data)
+	case r.Method == http.MethodPut && action == "content":
+		data, _ := io.ReadAll(r.Body)
+		if len(data) > maxSimpleUpload {
+			http.Error(w, "request entity too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		g.simplePuts++
+		g.writeLocked(w, name, r.Header.Get("If-Match"), data, http.StatusOK)
+	case r.Method == http.MethodPost && action == "createUploadSession":
+		if m := r.Header.Get("If-Match"); m != "" {
+			if it == nil {
+				http.Error(w, `{"error":{"code":"itemNotFound"}}`, http.StatusNotFound)
+				return
+			}
+			if m != it.driveItem().ETag {
+				http.Error(w, `{"error":{"code":"resourceModified"}}`, http.StatusPreconditionFailed)
+				return
+			}
+		}
+		id := fmt.Sprintf("session%d", len(g.sessions)+1)
+		g.sessions[id] = &fakeGraphSession{name: name}
+		json.NewEncoder(w).Encode(map[string]string{"uploadUrl": g.srv.URL + "/upload/" + id})
+	case r.Method == http.MethodDelete && action == "":
+		if it == nil {
+			http.Error(w, `{"error":{"code":"itemNotFound"}}`, http.StatusNotFound)
+			return
+		}
+		delete(g.items, name)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "unexpected request", http.StatusBadRequest)
+	}
+}
+
+// serveChunk accepts one chunk of an upload session. Chunks must arrive in
+// order and, except for the last, be multiples of 320 KiB.
+func (g *fakeGraph) serveChunk(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/upload/")
+	session := g.sessions[id]
+	if session == nil {
+		http.Error(w, "no such session", http.StatusNotFound)
+		return
+	}
+	if r.Method == http.MethodDelete {
+		delete(g.sessions, id)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	contentRange := r.Header.Get("Content-Range")
+	g.chunks = append(g.chunks, contentRange)
+	g.authorized = append(g.authorized, r.Header.Get("Authorization") != "")
+	var start, end, total int
+	if _, err := fmt.Sscanf(contentRange, "bytes %d-%d/%d", &start, &end, &total); err != nil {
+		http.Error(w, "bad Content-Range", http.StatusBadRequest)
+		return
+	}
+	data, _ := io.ReadAll(r.Body)
+	if start != len(session.data) || end-start+1 != len(data) {
+		http.Error(w, "unexpected range", http.StatusRequestedRangeNotSatisfiable)
+		return
+	}
+	if end+1 < total && len(data)%(320<<10) != 0 {
+		http.Error(w, "chunk is not a multiple of 320 KiB", http.StatusBadRequest)
+		return
+	}
+	session.data = append(session.data, data...)
+	if len(session.data) < total {
+		w.WriteHeader(http.StatusAccepted)
+		fmt.Fprintf(w, `{"nextExpectedRanges":["%d-"]}`, len(session.data))
+		return
+	}
+	delete(g.sessions, id)
+	g.writeLocked(w, session.name, "", session.data, http.StatusOK)
+}
+
+func TestOneDriveUploadAndDownload(t *testing.T) {
+	ctx := context.Background()
+	g, s := newFakeGraph(t)
+
+	if _, err := s.Download(ctx); !errors.Is(err, ErrNoRemoteData) {
+		t.Fatalf("Download before upload: %v, want ErrNoRemoteData", err)
+	}
+	if err := s.Upload(ctx, []byte("v1")); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Upload(ctx, []byte("v2")); err != nil {
+		t.Fatal(err)
+	}
+	if g.simplePuts != 2 || len(g.chunks) != 0 {
+		t.Fatalf("simple uploads = %d, chunks = %d; want small payloads in one PUT", g.simplePuts, len(g.chunks))
+	}
+	got, err := g.client().Download(ctx)
+	if err != nil || string(got) != "v2" {
+		t.Fatalf("Download = %q, %v, want v2", got, err)
+	}
+	if s.LastSync().IsZero() {
+		t.Error("LastSync is zero after upload")
+	}
+}
+
+func TestOneDriveUploadSessionForLargePayload(t *testing.T) {
+	ctx := context.Background()
+	g, s := newFakeGraph(t)
+
+	data := bytes.Repeat([]byte("0123456789abcdef"), maxSimpleUpload/16+1)
+	if err := s.Upload(ctx, data); err != nil {
+		t.Fatal(err)
+	}
+	if g.simplePuts != 0 {
+		t.Errorf("a payload over 4 MB was sent in a simple PUT")
+	}
+	want := (len(data) + uploadChunkSize - 1) / uploadChunkSize
+	if len(g.chunks) != want {
+		t.Fatalf("sent %d chunks %v, want %d", len(g.chunks), g.chunks, want)
+	}
+	for i, authorized := range g.authorized {
+		if authorized {
+			t.Errorf("chunk %d carried an Authorization header", i)
+		}
+	}
+	if it := g.item(oneDriveFileName); it == nil || !bytes.Equal(it.data, data) {
+		t.Fatal("uploaded content does not match")
+	}
+
+	// A session upload is conditional too.
+	other := g.client()
+	if _, err := other.Download(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Upload(ctx, data[:maxSimpleUpload/2]); err != nil {
+		t.Fatal(err)
+	}
+	if err := other.Upload(ctx, data); !errors.Is(err, ErrRemoteChanged) {
+		t.Fatalf("stale session upload: %v, want ErrRemoteChanged", err)
+	}
+}
+
+func TestOneDriveChangedByCTag(t *testing.T) {
+	ctx := context.Background()
+	g, s := newFakeGraph(t)
+
+	if changed, err := s.Changed(ctx); err != nil || changed {
+		t.Fatalf("Changed with no file = %v, %v, want false", changed, err)
+	}
+	if err := s.Upload(ctx, []byte("v1")); err != nil {
+		t.Fatal(err)
+	}
+	cTag := s.CTag()
+	if changed, err := s.Changed(ctx); err != nil || changed {
+		t.Fatalf("Changed after own upload = %v, %v, want false", changed, err)
+	}
+
+	// A metadata change, such as a rename elsewhere, keeps the cTag.
+	g.mu.Lock()
+	g.items[oneDriveFileName].version++
+	g.mu.Unlock()
+	if changed, err := s.Changed(ctx); err != nil || changed {
+		t.Fatalf("Changed after metadata change = %v, %v, want false", changed, err)
+	}
+
+	other := g.client()
+	if err := other.Upload(ctx, []byte("v2")); err != nil {
+		t.Fatal(err)
+	}
+	if changed, err := s.Changed(ctx); err != nil || !changed {
+		t.Fatalf("Changed after another upload = %v, %v, want true", changed, err)
+	}
+	if _, err := s.Download(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if s.CTag() == cTag {
+		t.Errorf("cTag %q did not change with the content", cTag)
+	}
+	if changed, err := s.Changed(ctx); err != nil || changed {
+		t.Fatalf("Changed after download = %v, %v, want false", changed, err)
+	}
+
+	g.remove(oneDriveFileName)
+	if changed, err := s.Changed(ctx); err != nil || !changed {
+		t.Fatalf("Changed after deletion = %v, %v, want true", changed, err)
+	}
+}
+
+func TestOneDriveUploadRefusesStaleETag(t *testing.T) {
+	ctx := context.Background()
+	g, s := newFakeGraph(t)
+	if err := s.Upload(ctx, []byte("v1")); err != nil {
+		t.Fatal(err)
+	}
+	other := g.client()
+	if _, err := other.Download(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := other.Upload(ctx, []byte("other")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Upload(ctx, []byte("v2")); !errors.Is(err, ErrRemoteChanged) {
+		t.Fatalf("Upload over another device's change: %v, want ErrRemoteChanged", err)
+	}
+	if it := g.item(oneDriveFileName); string(it.data) != "other" {
+		t.Fatalf("content = %q, the other device's upload was overwritten", it.data)
+	}
+
+	// Downloading again picks up the new eTag.
+	if _, err := s.Download(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Upload(ctx, []byte("v2")); err != nil {
+		t.Fatalf("Upload after download: %v", err)
+	}
+}
+
+func TestOneDriveRecreatesDeletedFile(t *testing.T) {
+	ctx := context.Background()
+	g, s := newFakeGraph(t)
+	if err := s.Upload(ctx, []byte("v1")); err != nil {
+		t.Fatal(err)
+	}
+
+	g.remove(oneDriveFileName)
+	if err := s.Upload(ctx, []byte("v2")); err != nil {
+		t.Fatalf("Upload after deletion: %v", err)
+	}
+	if it := g.item(oneDriveFileName); it == nil || string(it.data) != "v2" {
+		t.Fatal("file was not created again")
+	}
+
+	g.remove(oneDriveFileName)
+	if _, err := s.Download(ctx); !errors.Is(err, ErrNoRemoteData) {
+		t.Fatalf("Download after deletion: %v, want ErrNoRemoteData", err)
+	}
+	if err := s.Upload(ctx, []byte("v3")); err != nil {
+		t.Fatalf("Upload after downloading nothing: %v", err)
+	}
+}
+
+func TestOneDriveFiles(t *testing.T) {
+	ctx := context.Background()
+	_, s := newFakeGraph(t)
+	for _, name := range []string{"delta-a.json", "delta-b.json", "other.json"} {
+		if err := s.PutFile(ctx, name, []byte(name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	names, err := s.ListFiles(ctx, "delta-")
+	sort.Strings(names)
+	if err != nil || strings.Join(names, ",") != "delta-a.json,delta-b.json" {
+		t.Fatalf("ListFiles = %v, %v", names, err)
+	}
+	if got, err := s.GetFile(ctx, "delta-b.json"); err != nil || string(got) != "delta-b.json" {
+		t.Fatalf("GetFile = %q, %v", got, err)
+	}
+	if err := s.DeleteFile(ctx, "delta-b.json"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.GetFile(ctx, "delta-b.json"); !errors.Is(err, ErrNoRemoteData) {
+		t.Fatalf("GetFile after delete: %v, want ErrNoRemoteData", err)
+	}
+	if err := s.DeleteFile(ctx, "delta-b.json"); err != nil {
+		t.Fatalf("DeleteFile of a missing file: %v", err)
+	}
+}
+
+func TestNewOneDriveSyncHasTimeout(t *testing.T) {
+	s := NewOneDriveSync(oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "token"}))
+	if s.client.Timeout == 0 {
+		t.Error("Graph client has no timeout")
+	}
+	if s.uploadClient.Timeout == 0 || s.uploadClient == http.DefaultClient {
+		t.Error("upload client has no timeout")
+	}
+}
@@ -0,0 +1,93 @@
+package sync
+
+import "time"
+
+// State is what the sync manager is doing.
+type State int
+
+const (
+	StateIdle State = iota
+	StateSyncing
+	StateError
+)
+
+func (s State) String() string {
+	switch s {
+	case StateIdle:
+		return "idle"
+	case StateSyncing:
+		return "syncing"
+	case StateError:
+		return "error"
+	default:
+		return "unknown"
+	}
+}
+
+// Status describes the sync manager at one point in time.
+type Status struct {
+	State State
+
+	// Err is the error from the last sync while State is StateError.
+	Err error
+
+	// LastSuccess is when the last sync completed, or the zero time.
+	LastSuccess time.Time
+
+	// NextSync is when the background loop syncs next, or the zero time
+	// when it is not running.
+	NextSync time.Time
+
+	// Failures counts the syncs that failed since the last success.
+	Failures int
+
+	// Conflicts counts the recorded conflicts found by syncs since the
+	// manager was created or the conflicts were cleared.
+	Conflicts int
+}
+
+// Status returns the current status.
+func (sm *SyncManager) Status() Status {
+	sm.statusMu.Lock()
+	defer sm.statusMu.Unlock()
+	return sm.status
+}
+
+// Subscribe returns a channel that receives the status whenever it changes,
+// starting with the current one. Slow receivers only miss intermediate
+// updates: the channel always holds the latest status. The returned func
+// ends the subscription and closes the channel.
+func (sm *SyncManager) Subscribe() (<-chan Status, func()) {
+	sm.statusMu.Lock()
+	defer sm.statusMu.Unlock()
+
+	ch := make(chan Status, 1)
+	ch <- sm.status
+	sm.subscribers[ch] = struct{}{}
+
+	unsubscribe := func() {
+		sm.statusMu.Lock()
+		defer sm.statusMu.Unlock()
+		if _, ok := sm.subscribers[ch]; ok {
+			delete(sm.subscribers, ch)
+			close(ch)
+		}
+	}
+	return ch, unsubscribe
+}
+
+// updateStatus applies fn to the status and notifies subscribers.
+func (sm *SyncManager) updateStatus(fn func(*Status)) {
+	sm.statusMu.Lock()
+	defer sm.statusMu.Unlock()
+
+	fn(&sm.status)
+	for ch := range sm.subscribers {
+		// Replace an update the subscriber has not received yet.
+		select {
+		case <-ch:
+		default:
+		}
+		ch <- sm.status
+	}
+}
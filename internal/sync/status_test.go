@@ -0,0 +1,168 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	gosync "sync"
+	"testing"
+	"time"
+)
+
+// fakeClock is a clock that only moves when a test fires one of its
+// timers. Each timer the manager starts is sent on timers.
+type fakeClock struct {
+	mu     gosync.Mutex
+	now    time.Time
+	timers chan fakeTimer
+}
+
+type fakeTimer struct {
+	d time.Duration
+	c chan time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{
+		now:    time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		timers: make(chan fakeTimer, 1),
+	}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) NewTimer(d time.Duration) (<-chan time.Time, func() bool) {
+	timer := fakeTimer{d: d, c: make(chan time.Time, 1)}
+	c.timers <- timer
+	return timer.c, func() bool { return true }
+}
+
+// next returns the timer the manager started last.
+func (c *fakeClock) next(t *testing.T) fakeTimer {
+	t.Helper()
+	select {
+	case timer := <-c.timers:
+		return timer
+	case <-time.After(5 * time.Second):
+		t.Fatal("no timer started")
+		return fakeTimer{}
+	}
+}
+
+// fire moves the clock to the timer's deadline and fires it.
+func (c *fakeClock) fire(timer fakeTimer) {
+	c.mu.Lock()
+	c.now = c.now.Add(timer.d)
+	now := c.now
+	c.mu.Unlock()
+	timer.c <- now
+}
+
+// gatedCloud is a CloudSync whose downloads wait for the test. Each
+// download is announced on started and fails with the error received from
+// results, or finds no remote data when that is nil.
+type gatedCloud struct {
+	memCloud
+	started chan struct{}
+	results chan error
+}
+
+func (g *gatedCloud) Download(ctx context.Context) ([]byte, error) {
+	g.started <- struct{}{}
+	if err := <-g.results; err != nil {
+		return nil, err
+	}
+	return nil, ErrNoRemoteData
+}
+
+// awaitDownload waits for the next download to start.
+func (g *gatedCloud) awaitDownload(t *testing.T) {
+	t.Helper()
+	select {
+	case <-g.started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no sync started")
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	for failures, want := range map[int]time.Duration{
+		1:   15 * time.Second,
+		2:   30 * time.Second,
+		3:   time.Minute,
+		8:   32 * time.Minute,
+		9:   time.Hour,
+		100: time.Hour,
+	} {
+		if got := retryDelay(failures); got != want {
+			t.Errorf("retryDelay(%d) = %v, want %v", failures, got, want)
+		}
+	}
+}
+
+func TestSyncLoopBacksOffAndReportsStatus(t *testing.T) {
+	errOffline := errors.New("offline")
+	cloud := &gatedCloud{started: make(chan struct{}), results: make(chan error)}
+	clock := newFakeClock()
+	sm := NewSyncManager(cloud, make(memState), 30)
+	sm.clock = clock
+
+	if st := sm.Status(); st.State != StateIdle || !st.NextSync.IsZero() {
+		t.Fatalf("initial status = %+v, want idle", st)
+	}
+	sm.StartSync(context.Background(), &memSource{snapshot: &Snapshot{}})
+	defer sm.StopSync()
+	if timer := clock.next(t); timer.d != 0 {
+		t.Fatalf("first sync after %v, want at once", timer.d)
+	} else {
+		clock.fire(timer)
+	}
+
+	// Each failure doubles the delay before the next attempt.
+	for i, want := range []time.Duration{15 * time.Second, 30 * time.Second, time.Minute, 2 * time.Minute} {
+		cloud.awaitDownload(t)
+		if st := sm.Status(); st.State != StateSyncing || st.Failures != i {
+			t.Fatalf("status while syncing = %+v, want syncing after %d failures", st, i)
+		}
+		cloud.results <- errOffline
+
+		timer := clock.next(t)
+		if timer.d != want {
+			t.Fatalf("retry %d after %v, want %v", i+1, timer.d, want)
+		}
+		st := sm.Status()
+		if st.State != StateError || !errors.Is(st.Err, errOffline) || st.Failures != i+1 {
+			t.Fatalf("status after failure %d = %+v", i+1, st)
+		}
+		if !st.NextSync.Equal(clock.Now().Add(want)) {
+			t.Fatalf("NextSync = %v, want %v", st.NextSync, clock.Now().Add(want))
+		}
+		clock.fire(timer)
+	}
+
+	// A success resets the failures and goes back to the interval.
+	cloud.awaitDownload(t)
+	cloud.results <- nil
+	timer := clock.next(t)
+	if timer.d != 30*time.Minute {
+		t.Fatalf("next sync after %v, want the 30 minute interval", timer.d)
+	}
+	st := sm.Status()
+	if st.State != StateIdle || st.Err != nil || st.Failures != 0 || !st.LastSuccess.Equal(clock.Now()) {
+		t.Fatalf("status after success = %+v", st)
+	}
+
+	// SyncNow does not wait for the timer.
+	sm.SyncNow()
+	cloud.awaitDownload(t)
+	cloud.results <- nil
+	clock.next(t)
+
+	sm.StopSync()
+	if st := sm.Status(); st.State != StateIdle || !st.NextSync.IsZero() {
+		t.Fatalf("status after StopSync = %+v, want idle with no next sync", st)
+	}
+}
@@ -3,121 +3,414 @@ package sync
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"net/http"
+	gosync "sync"
 	"time"
-
-	"github.com/goBookMarker/internal/models"
-	"golang.org/x/oauth2"
 )
 
+// CloudSync stores the sync file with a cloud provider. Download returns
+// ErrNoRemoteData when nothing has been uploaded yet.
 type CloudSync interface {
-	Upload(data []byte) error
-	Download() ([]byte, error)
+	Upload(ctx context.Context, data []byte) error
+	Download(ctx context.Context) ([]byte, error)
 	LastSync() time.Time
 }
 
+// MultiDownloader is implemented by providers that keep one sync file per
+// device rather than a single shared one. DownloadAll returns the files of
+// all devices, oldest first, or ErrNoRemoteData if there are none, and
+// SyncManager combines them before merging.
+type MultiDownloader interface {
+	DownloadAll(ctx context.Context) ([][]byte, error)
+}
+
+// Source is the local library being synced. SyncSnapshot is called at the
+// start of every sync and ApplySyncSnapshot with the merged result once it
+// has been uploaded.
+type Source interface {
+	SyncSnapshot() (*Snapshot, error)
+	ApplySyncSnapshot(snapshot *Snapshot) error
+}
+
+// StateStore persists the sync engine's state between runs.
+// storage.SQLiteDB implements it.
+type StateStore interface {
+	GetSyncState(key string) ([]byte, error)
+	SaveSyncState(key string, data []byte) error
+}
+
+// stateKey is the StateStore key for syncState.
+const stateKey = "sync"
+
+// syncState is what the manager keeps locally: the merged snapshot from
+// the last successful sync, used as the merge base, and the conflicts that
+// have not been reviewed yet.
+type syncState struct {
+	Base      *Snapshot  `json:"base,omitempty"`
+	Conflicts []Conflict `json:"conflicts,omitempty"`
+
+	// DeviceID names this device's files in delta sync.
+	DeviceID string      `json:"device_id,omitempty"`
+	Delta    *deltaState `json:"delta,omitempty"`
+}
+
+// Retry delays after failed syncs. The delay doubles with every
+// consecutive failure, from minRetryDelay up to maxRetryDelay.
+const (
+	minRetryDelay = 15 * time.Second
+	maxRetryDelay = time.Hour
+)
+
+// clock is the time source of a SyncManager. Tests replace it to control
+// the retry timing.
+type clock interface {
+	Now() time.Time
+	// NewTimer returns a channel that receives once d has passed, and a
+	// func that stops the timer.
+	NewTimer(d time.Duration) (<-chan time.Time, func() bool)
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
+
+func (realClock) NewTimer(d time.Duration) (<-chan time.Time, func() bool) {
+	t := time.NewTimer(d)
+	return t.C, t.Stop
+}
+
+// maxSyncAttempts bounds how often Sync starts over when an upload fails
+// with ErrRemoteChanged.
+const maxSyncAttempts = 3
+
+// SyncManager syncs a Source with a cloud provider, either on demand with
+// Sync or periodically between StartSync and StopSync.
 type SyncManager struct {
 	provider CloudSync
+	state    StateStore
 	interval time.Duration
-	lastSync time.Time
-	running  bool
+	clock    clock
+
+	// mu serializes syncs so two merges never run against the same base.
+	mu gosync.Mutex
+
+	// lifecycle guards cancel and done, which are set while the
+	// background loop runs.
+	lifecycle gosync.Mutex
+	cancel    context.CancelFunc
+	done      chan struct{}
+	trigger   chan struct{}
+
+	statusMu    gosync.Mutex
+	status      Status
+	subscribers map[chan Status]struct{}
 }
 
-func NewSyncManager(provider CloudSync, intervalMinutes int) *SyncManager {
+func NewSyncManager(provider CloudSync, state StateStore, intervalMinutes int) *SyncManager {
 	return &SyncManager{
-		provider: provider,
-		interval: time.Duration(intervalMinutes) * time.Minute,
+		provider:    provider,
+		state:       state,
+		interval:    time.Duration(intervalMinutes) * time.Minute,
+		clock:       realClock{},
+		trigger:     make(chan struct{}, 1),
+		subscribers: make(map[chan Status]struct{}),
 	}
 }
 
-func (sm *SyncManager) StartSync(bookmarks []models.Bookmark) {
-	if sm.running {
+// StartSync syncs source right away and then every interval until ctx is
+// cancelled or StopSync is called. After a failure the next attempt is
+// made sooner, backing off exponentially up to an hour. Calling StartSync
+// while the loop is running has no effect.
+func (sm *SyncManager) StartSync(ctx context.Context, source Source) {
+	sm.lifecycle.Lock()
+	defer sm.lifecycle.Unlock()
+	if sm.done != nil {
 		return
 	}
 
-	sm.running = true
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	sm.cancel, sm.done = cancel, done
 	go func() {
-		for sm.running {
-			if err := sm.sync(bookmarks); err != nil {
-				// Handle error (maybe through a channel)
-				fmt.Printf("Sync error: %v\n", err)
-			}
-			time.Sleep(sm.interval)
+		defer close(done)
+		sm.run(ctx, source)
+		sm.lifecycle.Lock()
+		if sm.done == done {
+			sm.cancel, sm.done = nil, nil
 		}
+		sm.lifecycle.Unlock()
+		cancel()
 	}()
 }
 
+// StopSync stops the background loop, cancelling a sync in progress, and
+// waits for it to exit.
 func (sm *SyncManager) StopSync() {
-	sm.running = false
-}
+	sm.lifecycle.Lock()
+	cancel, done := sm.cancel, sm.done
+	sm.cancel, sm.done = nil, nil
+	sm.lifecycle.Unlock()
 
-func (sm *SyncManager) sync(bookmarks []models.Bookmark) error {
-	// Convert bookmarks to JSON
-	data, err := json.Marshal(bookmarks)
-	if err != nil {
-		return fmt.Errorf("failed to marshal bookmarks: %v", err)
+	if cancel != nil {
+		cancel()
+		<-done
 	}
+}
 
-	// Upload to cloud
-	if err := sm.provider.Upload(data); err != nil {
-		return fmt.Errorf("failed to upload: %v", err)
+// SyncNow asks the background loop to sync immediately instead of waiting
+// for the next interval. It does not wait for the sync and has no effect
+// when the loop is not running; use Sync for a blocking sync.
+func (sm *SyncManager) SyncNow() {
+	select {
+	case sm.trigger <- struct{}{}:
+	default:
+		// A sync is already pending.
 	}
+}
 
-	sm.lastSync = time.Now()
-	return nil
+func (sm *SyncManager) run(ctx context.Context, source Source) {
+	timer, stop := sm.clock.NewTimer(0)
+	defer func() { stop() }()
+	defer sm.updateStatus(func(st *Status) { st.NextSync = time.Time{} })
+
+	failures := 0
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer:
+		case <-sm.trigger:
+			stop()
+		}
+
+		_, err := sm.Sync(ctx, source)
+		if ctx.Err() != nil {
+			return
+		}
+
+		delay := sm.interval
+		if err != nil {
+			failures++
+			delay = retryDelay(failures)
+		} else {
+			failures = 0
+		}
+		sm.updateStatus(func(st *Status) { st.NextSync = sm.clock.Now().Add(delay) })
+		timer, stop = sm.clock.NewTimer(delay)
+	}
 }
 
-// Google Drive implementation
-type GoogleDriveSync struct {
-	client *http.Client
-	token  *oauth2.Token
+// retryDelay returns the delay before the next attempt after the given
+// number of consecutive failures.
+func retryDelay(failures int) time.Duration {
+	delay := minRetryDelay
+	for i := 1; i < failures && delay < maxRetryDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxRetryDelay {
+		delay = maxRetryDelay
+	}
+	return delay
 }
 
-func NewGoogleDriveSync(token *oauth2.Token) *GoogleDriveSync {
-	return &GoogleDriveSync{
-		token:  token,
-		client: oauth2.NewClient(context.Background(), oauth2.StaticTokenSource(token)),
+// Sync runs one three-way merge: it downloads the remote snapshot, merges
+// it with the local library against the base from the last sync, uploads
+// the result and applies it locally. The merged snapshot becomes the new
+// base only once it has been applied, so a failed sync is retried from the
+// same base. Conflicts are added to the list returned by Conflicts. If the
+// remote data changes while the merge runs, the sync starts over.
+//
+// With a provider that implements FileStore, only the changes are uploaded
+// and only files added by other devices since the last sync are
+// downloaded; see FileStore.
+func (sm *SyncManager) Sync(ctx context.Context, source Source) (*MergeResult, error) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	sm.updateStatus(func(st *Status) {
+		st.State = StateSyncing
+	})
+	var result *MergeResult
+	var err error
+	for attempt := 1; ; attempt++ {
+		result, err = sm.syncLocked(ctx, source)
+		if !errors.Is(err, ErrRemoteChanged) || attempt == maxSyncAttempts {
+			break
+		}
+		// Another device uploaded in the meantime; merge with its data.
 	}
+	sm.updateStatus(func(st *Status) {
+		if err != nil && ctx.Err() != nil {
+			// Cancelled, typically by StopSync; not a failure.
+			st.State = StateIdle
+			return
+		}
+		if err != nil {
+			st.State = StateError
+			st.Err = err
+			st.Failures++
+			return
+		}
+		st.State = StateIdle
+		st.Err = nil
+		st.Failures = 0
+		st.LastSuccess = sm.clock.Now()
+		st.Conflicts += len(result.Conflicts)
+	})
+	return result, err
 }
 
-func (s *GoogleDriveSync) Upload(data []byte) error {
-	// TODO: Implement Google Drive upload
-	return fmt.Errorf("not implemented")
+func (sm *SyncManager) syncLocked(ctx context.Context, source Source) (*MergeResult, error) {
+	if files, ok := sm.provider.(FileStore); ok {
+		result, err := sm.syncDelta(ctx, files, source)
+		if !errors.Is(err, ErrNotSupported) {
+			return result, err
+		}
+	}
+	return sm.syncFull(ctx, source)
 }
 
-func (s *GoogleDriveSync) Download() ([]byte, error) {
-	// TODO: Implement Google Drive download
-	return nil, fmt.Errorf("not implemented")
+// syncFull syncs by downloading and uploading the whole library.
+func (sm *SyncManager) syncFull(ctx context.Context, source Source) (*MergeResult, error) {
+	local, err := source.SyncSnapshot()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read local library: %w", err)
+	}
+
+	remote, err := sm.downloadRemote(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	state, err := sm.loadState()
+	if err != nil {
+		return nil, err
+	}
+
+	now := sm.clock.Now()
+	result := Merge(state.Base, local, remote, now)
+
+	data, err := EncodeEnvelope(result.Snapshot)
+	if err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if err := sm.provider.Upload(ctx, data); err != nil {
+		return nil, fmt.Errorf("failed to upload: %w", err)
+	}
+	if err := source.ApplySyncSnapshot(result.Snapshot); err != nil {
+		return nil, fmt.Errorf("failed to apply merged library: %w", err)
+	}
+
+	state.Base = result.Snapshot
+	state.Conflicts = append(state.Conflicts, result.Conflicts...)
+	if err := sm.saveState(state); err != nil {
+		return nil, err
+	}
+	return result, nil
 }
 
-func (s *GoogleDriveSync) LastSync() time.Time {
-	return time.Now() // TODO: Implement proper last sync tracking
+// downloadRemote fetches and decodes the remote snapshot, or returns nil if
+// there is none yet.
+func (sm *SyncManager) downloadRemote(ctx context.Context) (*Snapshot, error) {
+	var payloads [][]byte
+	var err error
+	if md, ok := sm.provider.(MultiDownloader); ok {
+		payloads, err = md.DownloadAll(ctx)
+	} else {
+		var data []byte
+		data, err = sm.provider.Download(ctx)
+		payloads = [][]byte{data}
+	}
+	if errors.Is(err, ErrNoRemoteData) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to download: %w", err)
+	}
+
+	return combineSnapshots(payloads)
 }
 
-// OneDrive implementation
-type OneDriveSync struct {
-	client *http.Client
-	token  *oauth2.Token
+// combineSnapshots decodes the payloads downloaded from a provider and
+// combines them into one snapshot. Each payload is a whole library as of
+// some device's last sync, so they are merged without a base: an entity
+// missing from one is only gone if another has its tombstone.
+func combineSnapshots(payloads [][]byte) (*Snapshot, error) {
+	var combined *Snapshot
+	for _, data := range payloads {
+		snapshot, err := DecodeEnvelope(data)
+		if err != nil {
+			return nil, err
+		}
+		if combined == nil {
+			combined = snapshot
+			continue
+		}
+		combined = Merge(nil, combined, snapshot, time.Now()).Snapshot
+	}
+	return combined, nil
 }
 
-func NewOneDriveSync(token *oauth2.Token) *OneDriveSync {
-	return &OneDriveSync{
-		token:  token,
-		client: oauth2.NewClient(context.Background(), oauth2.StaticTokenSource(token)),
+func (sm *SyncManager) loadState() (*syncState, error) {
+	data, err := sm.state.GetSyncState(stateKey)
+	if err != nil {
+		return nil, err
+	}
+	state := &syncState{}
+	if len(data) == 0 {
+		return state, nil
+	}
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, fmt.Errorf("failed to decode sync state: %w", err)
+	}
+	if state.Base != nil {
+		// Bases saved before tombstones had kinds only held bookmarks.
+		for i := range state.Base.Tombstones {
+			if state.Base.Tombstones[i].Kind == "" {
+				state.Base.Tombstones[i].Kind = KindBookmark
+			}
+		}
 	}
+	return state, nil
 }
 
-func (s *OneDriveSync) Upload(data []byte) error {
-	// TODO: Implement OneDrive upload
-	return fmt.Errorf("not implemented")
+func (sm *SyncManager) saveState(state *syncState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return fmt.Errorf("failed to marshal sync state: %w", err)
+	}
+	return sm.state.SaveSyncState(stateKey, data)
 }
 
-func (s *OneDriveSync) Download() ([]byte, error) {
-	// TODO: Implement OneDrive download
-	return nil, fmt.Errorf("not implemented")
+// Conflicts returns the conflicts recorded by past syncs that have not been
+// cleared, oldest first.
+func (sm *SyncManager) Conflicts() ([]Conflict, error) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	state, err := sm.loadState()
+	if err != nil {
+		return nil, err
+	}
+	return state.Conflicts, nil
 }
 
-func (s *OneDriveSync) LastSync() time.Time {
-	return time.Now() // TODO: Implement proper last sync tracking
+// ClearConflicts discards the recorded conflicts once the user has
+// reviewed them.
+func (sm *SyncManager) ClearConflicts() error {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	state, err := sm.loadState()
+	if err != nil {
+		return err
+	}
+	state.Conflicts = nil
+	if err := sm.saveState(state); err != nil {
+		return err
+	}
+	sm.updateStatus(func(st *Status) { st.Conflicts = 0 })
+	return nil
 }
@@ -0,0 +1,461 @@
+package sync
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	gosync "sync"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// webDAVFileName is the name of the sync file in the WebDAV collection.
+const webDAVFileName = "bookmarks.json"
+
+// WebDAVSync stores the sync data as a single file in a WebDAV collection,
+// such as a Nextcloud folder. Uploads are conditional on the ETag seen by
+// the last download, so a file changed by another device in between is
+// reported as ErrRemoteChanged instead of being overwritten.
+type WebDAVSync struct {
+	client        *http.Client
+	collectionURL string
+	username      string
+	password      string
+
+	mu           gosync.Mutex
+	etag         string
+	lastModified time.Time
+}
+
+var _ FileStore = (*WebDAVSync)(nil)
+
+// NewWebDAVSync creates a WebDAV sync that stores its file in the
+// collection at collectionURL, for Nextcloud something like
+// https://cloud.example.com/remote.php/dav/files/<user>/goBookMarker.
+// It authenticates with basic auth; for Nextcloud, password can be an app
+// password.
+func NewWebDAVSync(collectionURL, username, password string) *WebDAVSync {
+	s := NewWebDAVSyncClient(newHTTPClient(), collectionURL)
+	s.username = username
+	s.password = password
+	return s
+}
+
+// NewWebDAVSyncToken creates a WebDAV sync that authenticates with bearer
+// tokens from source.
+func NewWebDAVSyncToken(collectionURL string, source oauth2.TokenSource) *WebDAVSync {
+	return NewWebDAVSyncClient(newOAuthClient(source), collectionURL)
+}
+
+// NewWebDAVSyncClient creates a WebDAV sync that sends requests with
+// client, which must add authorization itself.
+func NewWebDAVSyncClient(client *http.Client, collectionURL string) *WebDAVSync {
+	return &WebDAVSync{
+		client:        client,
+		collectionURL: strings.TrimSuffix(collectionURL, "/"),
+	}
+}
+
+func (s *WebDAVSync) fileURL() string {
+	return s.collectionURL + "/" + webDAVFileName
+}
+
+func (s *WebDAVSync) newRequest(ctx context.Context, method, target string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, target, body)
+	if err != nil {
+		return nil, err
+	}
+	if s.username != "" || s.password != "" {
+		req.SetBasicAuth(s.username, s.password)
+	}
+	return req, nil
+}
+
+// Upload replaces the contents of the sync file, creating the file and its
+// collection on first use. It returns ErrRemoteChanged if the file was
+// changed, created or deleted since the last download.
+func (s *WebDAVSync) Upload(ctx context.Context, data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.putLocked(ctx, data)
+	if isStatus(err, http.StatusConflict) || isStatus(err, http.StatusNotFound) {
+		// The collection does not exist yet. RFC 4918 asks for 409 but
+		// some servers answer 404.
+		if err := s.mkcol(ctx, s.collectionURL); err != nil {
+			return fmt.Errorf("failed to create WebDAV collection: %w", err)
+		}
+		err = s.putLocked(ctx, data)
+	}
+	if isStatus(err, http.StatusPreconditionFailed) {
+		return ErrRemoteChanged
+	}
+	if err != nil {
+		return fmt.Errorf("failed to upload to WebDAV: %w", err)
+	}
+	return nil
+}
+
+func (s *WebDAVSync) putLocked(ctx context.Context, data []byte) error {
+	req, err := s.newRequest(ctx, http.MethodPut, s.fileURL(), bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if s.etag != "" {
+		req.Header.Set("If-Match", s.etag)
+	} else {
+		req.Header.Set("If-None-Match", "*")
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err := checkResponse(resp); err != nil {
+		return err
+	}
+	io.Copy(io.Discard, resp.Body)
+
+	// Servers are not required to return the new ETag from PUT.
+	s.etag = resp.Header.Get("ETag")
+	s.lastModified = time.Now()
+	if s.etag == "" {
+		props, err := s.propfind(ctx)
+		if err != nil {
+			return err
+		}
+		if props != nil {
+			s.etag, s.lastModified = props.etag, props.lastModified
+		}
+	}
+	return nil
+}
+
+// mkcol creates the collection at collectionURL, along with any missing
+// parent collections.
+func (s *WebDAVSync) mkcol(ctx context.Context, collectionURL string) error {
+	req, err := s.newRequest(ctx, "MKCOL", collectionURL+"/", nil)
+	if err != nil {
+		return err
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusMethodNotAllowed {
+		// It already exists.
+		return nil
+	}
+	err = checkResponse(resp)
+	if !isStatus(err, http.StatusConflict) {
+		return err
+	}
+
+	// The parent is missing too.
+	u, perr := url.Parse(collectionURL)
+	if perr != nil || path.Dir(u.Path) == u.Path || path.Dir(u.Path) == "/" {
+		return err
+	}
+	u.Path = path.Dir(u.Path)
+	if err := s.mkcol(ctx, u.String()); err != nil {
+		return err
+	}
+	return s.mkcol(ctx, collectionURL)
+}
+
+// Download returns the contents of the sync file, or ErrNoRemoteData if it
+// does not exist yet.
+func (s *WebDAVSync) Download(ctx context.Context) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	req, err := s.newRequest(ctx, http.MethodGet, s.fileURL(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download from WebDAV: %w", err)
+	}
+	defer resp.Body.Close()
+	err = checkResponse(resp)
+	if isStatus(err, http.StatusNotFound) {
+		s.etag = ""
+		return nil, ErrNoRemoteData
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to download from WebDAV: %w", err)
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download from WebDAV: %w", err)
+	}
+	s.etag = resp.Header.Get("ETag")
+	if t, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+		s.lastModified = t
+	}
+	return data, nil
+}
+
+// Changed reports whether the sync file changed since the last upload or
+// download, by comparing its ETag with a PROPFIND. A file that appeared or
+// disappeared also counts as a change.
+func (s *WebDAVSync) Changed(ctx context.Context) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	props, err := s.propfind(ctx)
+	if err != nil {
+		return false, err
+	}
+	if props == nil {
+		return s.etag != "", nil
+	}
+	return props.etag != s.etag, nil
+}
+
+// davProps are the properties of the sync file the sync uses.
+type davProps struct {
+	etag         string
+	lastModified time.Time
+}
+
+// propfind fetches the sync file's properties, returning nil if it does
+// not exist.
+func (s *WebDAVSync) propfind(ctx context.Context) (*davProps, error) {
+	const body = `<?xml version="1.0" encoding="utf-8"?>
+<d:propfind xmlns:d="DAV:"><d:prop><d:getetag/><d:getlastmodified/></d:prop></d:propfind>`
+
+	req, err := s.newRequest(ctx, "PROPFIND", s.fileURL(), strings.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/xml; charset=utf-8")
+	req.Header.Set("Depth", "0")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up sync file: %w", err)
+	}
+	defer resp.Body.Close()
+	err = checkResponse(resp)
+	if isStatus(err, http.StatusNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up sync file: %w", err)
+	}
+
+	var ms struct {
+		Responses []struct {
+			Propstats []struct {
+				Status string `xml:"status"`
+				Prop   struct {
+					ETag         string `xml:"getetag"`
+					LastModified string `xml:"getlastmodified"`
+				} `xml:"prop"`
+			} `xml:"propstat"`
+		} `xml:"response"`
+	}
+	if err := xml.NewDecoder(resp.Body).Decode(&ms); err != nil {
+		return nil, fmt.Errorf("failed to decode PROPFIND response: %w", err)
+	}
+
+	props := &davProps{}
+	for _, r := range ms.Responses {
+		for _, ps := range r.Propstats {
+			if !strings.Contains(ps.Status, " 200 ") {
+				continue
+			}
+			if ps.Prop.ETag != "" {
+				props.etag = ps.Prop.ETag
+			}
+			if t, err := http.ParseTime(ps.Prop.LastModified); err == nil {
+				props.lastModified = t
+			}
+		}
+	}
+	return props, nil
+}
+
+// LastSync returns the modification time of the sync file as of the last
+// upload or download, or the zero time if neither has happened.
+func (s *WebDAVSync) LastSync() time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lastModified
+}
+
+// PutFile stores the named file in the collection, creating the collection
+// on first use. Delta sync files are never rewritten, so unlike Upload it
+// does not use preconditions.
+func (s *WebDAVSync) PutFile(ctx context.Context, name string, data []byte) error {
+	if err := checkFileName(name); err != nil {
+		return err
+	}
+	target := s.collectionURL + "/" + name
+	put := func() error {
+		req, err := s.newRequest(ctx, http.MethodPut, target, bytes.NewReader(data))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := s.client.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if err := checkResponse(resp); err != nil {
+			return err
+		}
+		io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+
+	err := put()
+	if isStatus(err, http.StatusConflict) || isStatus(err, http.StatusNotFound) {
+		if err := s.mkcol(ctx, s.collectionURL); err != nil {
+			return fmt.Errorf("failed to create WebDAV collection: %w", err)
+		}
+		err = put()
+	}
+	if err != nil {
+		return fmt.Errorf("failed to upload to WebDAV: %w", err)
+	}
+	s.mu.Lock()
+	s.lastModified = time.Now()
+	s.mu.Unlock()
+	return nil
+}
+
+// GetFile returns the contents of the named file in the collection.
+func (s *WebDAVSync) GetFile(ctx context.Context, name string) ([]byte, error) {
+	if err := checkFileName(name); err != nil {
+		return nil, err
+	}
+	req, err := s.newRequest(ctx, http.MethodGet, s.collectionURL+"/"+name, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download from WebDAV: %w", err)
+	}
+	defer resp.Body.Close()
+	err = checkResponse(resp)
+	if isStatus(err, http.StatusNotFound) {
+		return nil, ErrNoRemoteData
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to download from WebDAV: %w", err)
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download from WebDAV: %w", err)
+	}
+	return data, nil
+}
+
+// ListFiles returns the names of the files in the collection that start
+// with prefix.
+func (s *WebDAVSync) ListFiles(ctx context.Context, prefix string) ([]string, error) {
+	const body = `<?xml version="1.0" encoding="utf-8"?>
+<d:propfind xmlns:d="DAV:"><d:prop><d:resourcetype/></d:prop></d:propfind>`
+
+	req, err := s.newRequest(ctx, "PROPFIND", s.collectionURL+"/", strings.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/xml; charset=utf-8")
+	req.Header.Set("Depth", "1")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list WebDAV collection: %w", err)
+	}
+	defer resp.Body.Close()
+	err = checkResponse(resp)
+	if isStatus(err, http.StatusNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list WebDAV collection: %w", err)
+	}
+
+	var ms struct {
+		Responses []struct {
+			Href      string `xml:"href"`
+			Propstats []struct {
+				Prop struct {
+					ResourceType struct {
+						Collection *struct{} `xml:"collection"`
+					} `xml:"resourcetype"`
+				} `xml:"prop"`
+			} `xml:"propstat"`
+		} `xml:"response"`
+	}
+	if err := xml.NewDecoder(resp.Body).Decode(&ms); err != nil {
+		return nil, fmt.Errorf("failed to decode PROPFIND response: %w", err)
+	}
+
+	var names []string
+	for _, r := range ms.Responses {
+		isCollection := false
+		for _, ps := range r.Propstats {
+			if ps.Prop.ResourceType.Collection != nil {
+				isCollection = true
+			}
+		}
+		if isCollection {
+			continue
+		}
+		href := r.Href
+		if u, err := url.Parse(href); err == nil {
+			href = u.Path
+		}
+		name := path.Base(href)
+		if strings.HasPrefix(name, prefix) {
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+// DeleteFile removes the named file from the collection.
+func (s *WebDAVSync) DeleteFile(ctx context.Context, name string) error {
+	if err := checkFileName(name); err != nil {
+		return err
+	}
+	req, err := s.newRequest(ctx, http.MethodDelete, s.collectionURL+"/"+name, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to delete from WebDAV: %w", err)
+	}
+	defer resp.Body.Close()
+	err = checkResponse(resp)
+	if err != nil && !isStatus(err, http.StatusNotFound) {
+		return fmt.Errorf("failed to delete from WebDAV: %w", err)
+	}
+	return nil
+}
+
+// ETag returns the entity tag of the sync file as last seen, or "".
+func (s *WebDAVSync) ETag() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.etag
+}
@@ -0,0 +1,274 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sort"
+	"strings"
+	gosync "sync"
+	"testing"
+
+	"golang.org/x/net/webdav"
+	"golang.org/x/oauth2"
+)
+
+// davServer is an in-memory WebDAV server. x/net/webdav ignores If-Match
+// and If-None-Match on PUT, so davServer checks them itself first, as
+// servers such as Nextcloud do.
+type davServer struct {
+	fs      webdav.FileSystem
+	handler *webdav.Handler
+
+	mu      gosync.Mutex
+	methods []string
+}
+
+func newDAVServer(t *testing.T) (*davServer, string) {
+	fs := webdav.NewMemFS()
+	d := &davServer{
+		fs:      fs,
+		handler: &webdav.Handler{FileSystem: fs, LockSystem: webdav.NewMemLS()},
+	}
+	srv := httptest.NewServer(d)
+	t.Cleanup(srv.Close)
+	return d, srv.URL
+}
+
+func (d *davServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.methods = append(d.methods, r.Method+" "+r.URL.Path)
+
+	if r.Method == http.MethodPut && !d.preconditionsMet(r) {
+		http.Error(w, "precondition failed", http.StatusPreconditionFailed)
+		return
+	}
+	d.handler.ServeHTTP(w, r)
+}
+
+// preconditionsMet evaluates If-Match and If-None-Match against the ETag
+// x/net/webdav gives the file.
+func (d *davServer) preconditionsMet(r *http.Request) bool {
+	etag := ""
+	if fi, err := d.fs.Stat(r.Context(), r.URL.Path); err == nil {
+		etag = fmt.Sprintf(`"%x%x"`, fi.ModTime().UnixNano(), fi.Size())
+	}
+	if m := r.Header.Get("If-Match"); m != "" && (etag == "" || (m != "*" && m != etag)) {
+		return false
+	}
+	if m := r.Header.Get("If-None-Match"); m != "" && etag != "" && (m == "*" || m == etag) {
+		return false
+	}
+	return true
+}
+
+// requests returns the requests served with the given method.
+func (d *davServer) requests(method string) []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var paths []string
+	for _, m := range d.methods {
+		if p, ok := strings.CutPrefix(m, method+" "); ok {
+			paths = append(paths, p)
+		}
+	}
+	return paths
+}
+
+func (d *davServer) read(t *testing.T, name string) string {
+	t.Helper()
+	f, err := d.fs.OpenFile(context.Background(), name, os.O_RDONLY, 0)
+	if err != nil {
+		t.Fatalf("open %s: %v", name, err)
+	}
+	defer f.Close()
+	data, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+const davCollection = "/remote.php/dav/files/alice/goBookMarker"
+
+func TestWebDAVUploadCreatesMissingCollections(t *testing.T) {
+	ctx := context.Background()
+	d, base := newDAVServer(t)
+	s := NewWebDAVSyncClient(http.DefaultClient, base+davCollection+"/")
+
+	if _, err := s.Download(ctx); !errors.Is(err, ErrNoRemoteData) {
+		t.Fatalf("Download before upload: %v, want ErrNoRemoteData", err)
+	}
+	if err := s.Upload(ctx, []byte("v1")); err != nil {
+		t.Fatal(err)
+	}
+	mkcols := d.requests("MKCOL")
+	want := []string{
+		davCollection + "/",
+		"/remote.php/dav/files/alice/",
+		"/remote.php/dav/files/",
+		"/remote.php/dav/",
+		"/remote.php/",
+		"/remote.php/dav/",
+		"/remote.php/dav/files/",
+		"/remote.php/dav/files/alice/",
+		davCollection + "/",
+	}
+	if strings.Join(mkcols, " ") != strings.Join(want, " ") {
+		t.Errorf("MKCOL requests = %v, want %v", mkcols, want)
+	}
+	if got := d.read(t, davCollection+"/"+webDAVFileName); got != "v1" {
+		t.Fatalf("server has %q, want v1", got)
+	}
+	if s.ETag() == "" {
+		t.Fatal("no ETag after upload")
+	}
+
+	// Existing collections are not created again.
+	if err := s.Upload(ctx, []byte("v2")); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(d.requests("MKCOL")); n != len(want) {
+		t.Errorf("%d MKCOL requests after the second upload, want %d", n, len(want))
+	}
+	got, err := NewWebDAVSyncClient(http.DefaultClient, base+davCollection).Download(ctx)
+	if err != nil || string(got) != "v2" {
+		t.Fatalf("Download = %q, %v, want v2", got, err)
+	}
+}
+
+func TestWebDAVUploadRefusesStaleETag(t *testing.T) {
+	ctx := context.Background()
+	d, base := newDAVServer(t)
+	a := NewWebDAVSyncClient(http.DefaultClient, base+davCollection)
+	b := NewWebDAVSyncClient(http.DefaultClient, base+davCollection)
+	if err := a.Upload(ctx, []byte("v1")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.Download(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Upload(ctx, []byte("v2 from a")); err != nil {
+		t.Fatal(err)
+	}
+
+	// b's If-Match carries the ETag of v1.
+	if err := b.Upload(ctx, []byte("v2 from b")); !errors.Is(err, ErrRemoteChanged) {
+		t.Fatalf("Upload with a stale ETag: %v, want ErrRemoteChanged", err)
+	}
+	if got := d.read(t, davCollection+"/"+webDAVFileName); got != "v2 from a" {
+		t.Fatalf("server has %q, want a's upload kept", got)
+	}
+
+	// After downloading again, b's upload goes through.
+	if got, err := b.Download(ctx); err != nil || string(got) != "v2 from a" {
+		t.Fatalf("Download = %q, %v", got, err)
+	}
+	if err := b.Upload(ctx, []byte("v3 from b")); err != nil {
+		t.Fatal(err)
+	}
+
+	// A client that never saw the file must not overwrite it.
+	c := NewWebDAVSyncClient(http.DefaultClient, base+davCollection)
+	if err := c.Upload(ctx, []byte("v1 from c")); !errors.Is(err, ErrRemoteChanged) {
+		t.Fatalf("Upload over an unseen file: %v, want ErrRemoteChanged", err)
+	}
+	if got := d.read(t, davCollection+"/"+webDAVFileName); got != "v3 from b" {
+		t.Fatalf("server has %q, want v3 from b", got)
+	}
+}
+
+func TestWebDAVChanged(t *testing.T) {
+	ctx := context.Background()
+	_, base := newDAVServer(t)
+	a := NewWebDAVSyncClient(http.DefaultClient, base+davCollection)
+	b := NewWebDAVSyncClient(http.DefaultClient, base+davCollection)
+
+	if changed, err := a.Changed(ctx); err != nil || changed {
+		t.Fatalf("Changed with no file = %v, %v, want false", changed, err)
+	}
+	if err := a.Upload(ctx, []byte("v1")); err != nil {
+		t.Fatal(err)
+	}
+	if changed, err := a.Changed(ctx); err != nil || changed {
+		t.Fatalf("Changed after own upload = %v, %v, want false", changed, err)
+	}
+	if changed, err := b.Changed(ctx); err != nil || !changed {
+		t.Fatalf("Changed for a new file = %v, %v, want true", changed, err)
+	}
+	if _, err := b.Download(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Upload(ctx, []byte("v2")); err != nil {
+		t.Fatal(err)
+	}
+	if changed, err := a.Changed(ctx); err != nil || !changed {
+		t.Fatalf("Changed after another client's upload = %v, %v, want true", changed, err)
+	}
+}
+
+func TestWebDAVFiles(t *testing.T) {
+	ctx := context.Background()
+	d, base := newDAVServer(t)
+	s := NewWebDAVSyncClient(http.DefaultClient, base+davCollection)
+
+	if names, err := s.ListFiles(ctx, "delta-"); err != nil || len(names) != 0 {
+		t.Fatalf("ListFiles of a missing collection = %v, %v", names, err)
+	}
+	// PutFile creates the collection on first use.
+	for _, name := range []string{"delta-a.json", "delta-b.json", "other.json"} {
+		if err := s.PutFile(ctx, name, []byte(name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.PutFile(ctx, "delta-a.json", []byte("replaced")); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.fs.Mkdir(ctx, davCollection+"/delta-dir", 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	names, err := s.ListFiles(ctx, "delta-")
+	sort.Strings(names)
+	if err != nil || strings.Join(names, ",") != "delta-a.json,delta-b.json" {
+		t.Fatalf("ListFiles = %v, %v", names, err)
+	}
+	if got := d.requests("PROPFIND"); len(got) == 0 || got[len(got)-1] != davCollection+"/" {
+		t.Errorf("PROPFIND requests = %v, want the collection listed", got)
+	}
+	if got, err := s.GetFile(ctx, "delta-a.json"); err != nil || string(got) != "replaced" {
+		t.Fatalf("GetFile = %q, %v, want replaced", got, err)
+	}
+
+	if err := s.DeleteFile(ctx, "delta-a.json"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.fs.Stat(ctx, davCollection+"/delta-a.json"); !os.IsNotExist(err) {
+		t.Fatalf("file still on the server after DeleteFile: %v", err)
+	}
+	if _, err := s.GetFile(ctx, "delta-a.json"); !errors.Is(err, ErrNoRemoteData) {
+		t.Fatalf("GetFile after delete: %v, want ErrNoRemoteData", err)
+	}
+	if err := s.DeleteFile(ctx, "delta-a.json"); err != nil {
+		t.Fatalf("DeleteFile of a missing file: %v", err)
+	}
+	if names, err := s.ListFiles(ctx, "delta-"); err != nil || strings.Join(names, ",") != "delta-b.json" {
+		t.Fatalf("ListFiles after delete = %v, %v", names, err)
+	}
+}
+
+func TestNewWebDAVSyncHasTimeout(t *testing.T) {
+	for name, s := range map[string]*WebDAVSync{
+		"password": NewWebDAVSync(davCollection, "alice", "secret"),
+		"token":    NewWebDAVSyncToken(davCollection, oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "token"})),
+	} {
+		if s.client.Timeout == 0 || s.client == http.DefaultClient {
+			t.Errorf("%s client has no timeout", name)
+		}
+	}
+}
@@ -61,17 +61,29 @@ func (p *BookmarksPage) getBookmarkActions(id string) *BookmarkActions {
 }
 
 func (p *BookmarksPage) Layout(gtx layout.Context) layout.Dimensions {
-	if p.searchBar.Submit {
-		p.state.Search(p.searchBar.Text())
-		p.searchBar.Submit = false
+	for {
+		ev, ok := p.searchBar.Update(gtx)
+		if !ok {
+			break
+		}
+		if _, ok := ev.(widget.SubmitEvent); ok {
+			p.state.Search(p.searchBar.Text())
+		}
 	}
 
 	return layout.Flex{Axis: layout.Vertical}.Layout(gtx,
 		layout.Rigid(func(gtx layout.Context) layout.Dimensions {
 			return layout.UniformInset(unit.Dp(16)).Layout(gtx,
 				func(gtx layout.Context) layout.Dimensions {
-					ed := material.Editor(p.theme, &p.searchBar, "Search bookmarks...")
-					return ed.Layout(gtx)
+					return layout.Flex{Axis: layout.Vertical}.Layout(gtx,
+						layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+							ed := material.Editor(p.theme, &p.searchBar, "Search bookmarks...")
+							return ed.Layout(gtx)
+						}),
+						layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+							return layoutSearchError(gtx, p.theme, p.state.SearchError())
+						}),
+					)
 				},
 			)
 		}),
@@ -82,6 +94,17 @@ func (p *BookmarksPage) Layout(gtx layout.Context) layout.Dimensions {
 }
 
 func (p *BookmarksPage) layoutBookmarks(gtx layout.Context) layout.Dimensions {
+	if p.state.SearchQuery() != "" {
+		results := p.state.SearchResults()
+		if len(results) == 0 {
+			return p.layoutEmptyState(gtx)
+		}
+		return p.list.List.Layout(gtx, len(results),
+			func(gtx layout.Context, index int) layout.Dimensions {
+				return p.layoutBookmarkItem(gtx, &results[index].Bookmark, &results[index])
+			})
+	}
+
 	bookmarks := p.state.GetBookmarks()
 	if len(bookmarks) == 0 {
 		return p.layoutEmptyState(gtx)
@@ -89,7 +112,7 @@ func (p *BookmarksPage) layoutBookmarks(gtx layout.Context) layout.Dimensions {
 
 	return p.list.List.Layout(gtx, len(bookmarks),
 		func(gtx layout.Context, index int) layout.Dimensions {
-			return p.layoutBookmarkItem(gtx, &bookmarks[index])
+			return p.layoutBookmarkItem(gtx, &bookmarks[index], nil)
 		})
 }
 
@@ -114,7 +137,9 @@ func (p *BookmarksPage) layoutEmptyState(gtx layout.Context) layout.Dimensions {
 	)
 }
 
-func (p *BookmarksPage) layoutBookmarkItem(gtx layout.Context, bookmark *models.Bookmark) layout.Dimensions {
+// layoutBookmarkItem draws a bookmark card. When result is not nil the
+// matched parts of the title and a snippet are highlighted.
+func (p *BookmarksPage) layoutBookmarkItem(gtx layout.Context, bookmark *models.Bookmark, result *models.SearchResult) layout.Dimensions {
 	actions := p.getBookmarkActions(bookmark.ID)
 
 	// Handle actions
@@ -159,6 +184,9 @@ func (p *BookmarksPage) layoutBookmarkItem(gtx layout.Context, bookmark *models.
 								layout.Rigid(func(gtx layout.Context) layout.Dimensions {
 									return layout.Flex{Alignment: layout.Middle}.Layout(gtx,
 										layout.Flexed(1, func(gtx layout.Context) layout.Dimensions {
+											if result != nil {
+												return highlightedText(p.theme, p.theme.TextSize*1.25, bookmark.Title, result.TitleHighlights, p.theme.Fg).Layout(gtx, nil)
+											}
 											title := material.H6(p.theme, bookmark.Title)
 											title.Color = p.theme.Fg
 											return title.Layout(gtx)
@@ -174,6 +202,14 @@ func (p *BookmarksPage) layoutBookmarkItem(gtx layout.Context, bookmark *models.
 									url.Color = color.NRGBA{R: 128, G: 128, B: 128, A: 255}
 									return url.Layout(gtx)
 								}),
+								layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+									if result == nil || result.Snippet == "" {
+										return layout.Dimensions{}
+									}
+									return layout.Inset{Top: unit.Dp(4)}.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+										return highlightedText(p.theme, p.theme.TextSize*0.875, result.Snippet, result.SnippetHighlights, p.theme.Fg).Layout(gtx, nil)
+									})
+								}),
 								layout.Rigid(layout.Spacer{Height: unit.Dp(8)}.Layout),
 								layout.Rigid(func(gtx layout.Context) layout.Dimensions {
 									return p.layoutTags(gtx, bookmark.Tags)
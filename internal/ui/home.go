@@ -3,6 +3,7 @@ package ui
 import (
 	"image"
 	"image/color"
+	"strings"
 
 	"gioui.org/layout"
 	"gioui.org/op/clip"
@@ -12,6 +13,7 @@ import (
 	"gioui.org/widget/material"
 
 	"github.com/goBookMarker/internal/app"
+	"github.com/goBookMarker/internal/attachment"
 	"github.com/goBookMarker/internal/models"
 	"github.com/goBookMarker/internal/ui/icons"
 )
@@ -22,13 +24,25 @@ type HomePage struct {
 	searchBar widget.Editor
 	addButton *widget.Clickable
 	list      widget.List
+
+	// Incoming shares
+	shareButtons map[string]*shareButtons
+	shareStatus  string
+}
+
+// shareButtons are the actions on one queued share.
+type shareButtons struct {
+	save    widget.Clickable
+	retry   widget.Clickable
+	dismiss widget.Clickable
 }
 
 func NewHomePage(th *material.Theme, state *app.AppState) *HomePage {
 	return &HomePage{
-		theme:     th,
-		state:     state,
-		addButton: new(widget.Clickable),
+		theme:        th,
+		state:        state,
+		addButton:    new(widget.Clickable),
+		shareButtons: make(map[string]*shareButtons),
 		searchBar: widget.Editor{
 			SingleLine: true,
 			Submit:     true,
@@ -40,15 +54,55 @@ func NewHomePage(th *material.Theme, state *app.AppState) *HomePage {
 }
 
 func (h *HomePage) Layout(gtx layout.Context) layout.Dimensions {
-	if h.searchBar.Submit {
-		h.state.Search(h.searchBar.Text())
-		h.searchBar.Submit = false
+	for {
+		ev, ok := h.searchBar.Update(gtx)
+		if !ok {
+			break
+		}
+		if _, ok := ev.(widget.SubmitEvent); ok {
+			h.state.Search(h.searchBar.Text())
+		}
 	}
 
 	if h.addButton.Clicked(gtx) {
 		h.state.ShowAddBookmark()
 	}
 
+	// Handle incoming share actions
+	shares, err := h.state.QueuedShares()
+	if err != nil {
+		h.shareStatus = "Could not load shares: " + err.Error()
+	}
+	queuedIDs := make(map[string]bool, len(shares))
+	for _, queued := range shares {
+		queuedIDs[queued.ID] = true
+	}
+	for id := range h.shareButtons {
+		if !queuedIDs[id] {
+			delete(h.shareButtons, id)
+		}
+	}
+	for _, queued := range shares {
+		buttons := h.buttonsFor(queued.ID)
+		if buttons.save.Clicked(gtx) {
+			if _, err := h.state.SaveQueuedShare(queued.ID); err != nil {
+				h.shareStatus = "Could not save share: " + err.Error()
+			} else {
+				h.shareStatus = ""
+			}
+		}
+		if buttons.retry.Clicked(gtx) {
+			if err := h.state.RetryShare(queued.ID); err != nil {
+				h.shareStatus = "Could not retry share: " + err.Error()
+			}
+		}
+		if buttons.dismiss.Clicked(gtx) {
+			if err := h.state.DismissShare(queued.ID); err != nil {
+				h.shareStatus = "Could not dismiss share: " + err.Error()
+			}
+		}
+	}
+
 	return layout.Flex{Axis: layout.Vertical}.Layout(gtx,
 		layout.Rigid(func(gtx layout.Context) layout.Dimensions {
 			return layout.UniformInset(unit.Dp(16)).Layout(gtx,
@@ -70,14 +124,140 @@ func (h *HomePage) Layout(gtx layout.Context) layout.Dimensions {
 				},
 			)
 		}),
+		layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+			return layout.Inset{Left: unit.Dp(16), Right: unit.Dp(16)}.Layout(gtx,
+				func(gtx layout.Context) layout.Dimensions {
+					return layoutSearchError(gtx, h.theme, h.state.SearchError())
+				},
+			)
+		}),
 		layout.Rigid(layout.Spacer{Height: unit.Dp(16)}.Layout),
+		layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+			return h.layoutIncomingShares(gtx, shares)
+		}),
 		layout.Flexed(1, func(gtx layout.Context) layout.Dimensions {
 			return h.layoutRecentBookmarks(gtx)
 		}),
 	)
 }
 
+func (h *HomePage) buttonsFor(shareID string) *shareButtons {
+	buttons, ok := h.shareButtons[shareID]
+	if !ok {
+		buttons = &shareButtons{}
+		h.shareButtons[shareID] = buttons
+	}
+	return buttons
+}
+
+// layoutIncomingShares lists the shares waiting to be saved: those whose
+// pages are still being fetched, those ready to save and those that
+// failed, which can be retried or saved by their links alone.
+func (h *HomePage) layoutIncomingShares(gtx layout.Context, shares []models.QueuedShare) layout.Dimensions {
+	if len(shares) == 0 && h.shareStatus == "" {
+		return layout.Dimensions{}
+	}
+	grey := color.NRGBA{R: 128, G: 128, B: 128, A: 255}
+	children := []layout.FlexChild{
+		layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+			return material.Subtitle2(h.theme, "Incoming shares").Layout(gtx)
+		}),
+	}
+	for _, queued := range shares {
+		queued := queued
+		buttons := h.buttonsFor(queued.ID)
+		children = append(children, layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+			return layout.Inset{Top: unit.Dp(8)}.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+				return layout.Flex{Alignment: layout.Middle}.Layout(gtx,
+					layout.Flexed(1, func(gtx layout.Context) layout.Dimensions {
+						return layout.Flex{Axis: layout.Vertical}.Layout(gtx,
+							layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+								label := material.Body1(h.theme, shareLabel(queued))
+								label.MaxLines = 1
+								return label.Layout(gtx)
+							}),
+							layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+								label := material.Caption(h.theme, shareStatusText(queued))
+								label.Color = grey
+								label.MaxLines = 2
+								return label.Layout(gtx)
+							}),
+						)
+					}),
+					layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+						if queued.Status == models.SharePending {
+							return layout.Dimensions{}
+						}
+						return layout.Inset{Left: unit.Dp(8)}.Layout(gtx,
+							material.Button(h.theme, &buttons.save, "Save").Layout)
+					}),
+					layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+						if queued.Status != models.ShareFailed {
+							return layout.Dimensions{}
+						}
+						return layout.Inset{Left: unit.Dp(8)}.Layout(gtx,
+							material.Button(h.theme, &buttons.retry, "Retry").Layout)
+					}),
+					layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+						return layout.Inset{Left: unit.Dp(8)}.Layout(gtx,
+							material.Button(h.theme, &buttons.dismiss, "Dismiss").Layout)
+					}),
+				)
+			})
+		}))
+	}
+	if h.shareStatus != "" {
+		children = append(children, layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+			label := material.Caption(h.theme, h.shareStatus)
+			label.Color = grey
+			return layout.Inset{Top: unit.Dp(8)}.Layout(gtx, label.Layout)
+		}))
+	}
+	return layout.Inset{Left: unit.Dp(16), Right: unit.Dp(16), Bottom: unit.Dp(16)}.Layout(gtx,
+		func(gtx layout.Context) layout.Dimensions {
+			return layout.Flex{Axis: layout.Vertical}.Layout(gtx, children...)
+		},
+	)
+}
+
+// shareLabel returns what was shared, shortened to a line.
+func shareLabel(queued models.QueuedShare) string {
+	if _, stored := attachment.ParseURL(queued.Content); stored || strings.HasPrefix(queued.Content, "data:") {
+		return "Shared image"
+	}
+	label, _, _ := strings.Cut(queued.Content, "\n")
+	if len(label) > 80 {
+		label = strings.ToValidUTF8(label[:80], "") + "…"
+	}
+	return label
+}
+
+// shareStatusText describes where a queued share is in being processed.
+func shareStatusText(queued models.QueuedShare) string {
+	switch queued.Status {
+	case models.ShareReady:
+		return "Ready to save"
+	case models.ShareFailed:
+		return "Could not fetch details: " + queued.LastError
+	}
+	if queued.Attempts > 0 {
+		return "Waiting to try again: " + queued.LastError
+	}
+	return "Fetching details…"
+}
+
 func (h *HomePage) layoutRecentBookmarks(gtx layout.Context) layout.Dimensions {
+	if h.state.SearchQuery() != "" {
+		results := h.state.SearchResults()
+		if len(results) == 0 {
+			return h.layoutEmptyState(gtx)
+		}
+		return h.list.List.Layout(gtx, len(results),
+			func(gtx layout.Context, index int) layout.Dimensions {
+				return h.layoutBookmarkItem(gtx, &results[index].Bookmark, results[index].TitleHighlights)
+			})
+	}
+
 	bookmarks := h.state.GetBookmarks()
 	if len(bookmarks) == 0 {
 		return h.layoutEmptyState(gtx)
@@ -85,7 +265,7 @@ func (h *HomePage) layoutRecentBookmarks(gtx layout.Context) layout.Dimensions {
 
 	return h.list.List.Layout(gtx, len(bookmarks),
 		func(gtx layout.Context, index int) layout.Dimensions {
-			return h.layoutBookmarkItem(gtx, &bookmarks[index])
+			return h.layoutBookmarkItem(gtx, &bookmarks[index], nil)
 		})
 }
 
@@ -110,7 +290,7 @@ func (h *HomePage) layoutEmptyState(gtx layout.Context) layout.Dimensions {
 	)
 }
 
-func (h *HomePage) layoutBookmarkItem(gtx layout.Context, bookmark *models.Bookmark) layout.Dimensions {
+func (h *HomePage) layoutBookmarkItem(gtx layout.Context, bookmark *models.Bookmark, highlights []models.TextRange) layout.Dimensions {
 	return layout.UniformInset(unit.Dp(16)).Layout(gtx,
 		func(gtx layout.Context) layout.Dimensions {
 			return layout.Stack{}.Layout(gtx,
@@ -139,6 +319,9 @@ func (h *HomePage) layoutBookmarkItem(gtx layout.Context, bookmark *models.Bookm
 						func(gtx layout.Context) layout.Dimensions {
 							return layout.Flex{Axis: layout.Vertical}.Layout(gtx,
 								layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+									if len(highlights) > 0 {
+										return highlightedText(h.theme, h.theme.TextSize*1.25, bookmark.Title, highlights, h.theme.Fg).Layout(gtx, nil)
+									}
 									title := material.H6(h.theme, bookmark.Title)
 									title.Color = h.theme.Fg
 									return title.Layout(gtx)
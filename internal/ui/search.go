@@ -0,0 +1,55 @@
+package ui
+
+import (
+	"image/color"
+
+	"gioui.org/font"
+	"gioui.org/layout"
+	"gioui.org/unit"
+	"gioui.org/widget/material"
+	"gioui.org/x/styledtext"
+
+	"github.com/goBookMarker/internal/models"
+)
+
+// highlightedText renders content with the given ranges emphasized, as
+// returned by search results.
+func highlightedText(th *material.Theme, size unit.Sp, content string, ranges []models.TextRange, fg color.NRGBA) styledtext.TextStyle {
+	var spans []styledtext.SpanStyle
+	plain := func(s string) {
+		if s != "" {
+			spans = append(spans, styledtext.SpanStyle{Size: size, Color: fg, Content: s})
+		}
+	}
+
+	pos := 0
+	for _, r := range ranges {
+		if r.Start < pos || r.End > len(content) || r.Start >= r.End {
+			continue
+		}
+		plain(content[pos:r.Start])
+		spans = append(spans, styledtext.SpanStyle{
+			Font:    font.Font{Weight: font.Bold},
+			Size:    size,
+			Color:   th.ContrastBg,
+			Content: content[r.Start:r.End],
+		})
+		pos = r.End
+	}
+	plain(content[pos:])
+
+	return styledtext.Text(th.Shaper, spans...)
+}
+
+// layoutSearchError shows why the last search query could not be run,
+// below a search bar. It draws nothing when err is nil.
+func layoutSearchError(gtx layout.Context, th *material.Theme, err error) layout.Dimensions {
+	if err == nil {
+		return layout.Dimensions{}
+	}
+	return layout.Inset{Top: unit.Dp(4)}.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+		label := material.Caption(th, err.Error())
+		label.Color = color.NRGBA{R: 255, G: 0, B: 0, A: 255}
+		return label.Layout(gtx)
+	})
+}
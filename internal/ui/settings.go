@@ -1,8 +1,10 @@
 package ui
 
 import (
+	"fmt"
 	"image"
 	"image/color"
+	"strings"
 
 	"gioui.org/layout"
 	"gioui.org/op/clip"
@@ -12,6 +14,7 @@ import (
 	"gioui.org/widget/material"
 
 	"github.com/goBookMarker/internal/app"
+	"github.com/goBookMarker/internal/models"
 )
 
 type SettingsPage struct {
@@ -23,15 +26,33 @@ type SettingsPage struct {
 	saveButton          widget.Clickable
 	logoutButton        widget.Clickable
 	previousSyncEnabled bool
+
+	// Linked accounts, keyed by provider
+	accountButtons map[string]*accountButtons
+	accountStatus  string
+
+	// Bookmark import/export
+	importPath   widget.Editor
+	importButton widget.Clickable
+	exportButton widget.Clickable
+	dataStatus   string
+}
+
+// accountButtons are the actions on one linked account.
+type accountButtons struct {
+	useForSync widget.Clickable
+	unlink     widget.Clickable
 }
 
 func NewSettingsPage(th *material.Theme, state *app.AppState) *SettingsPage {
 	return &SettingsPage{
-		theme: th,
-		state: state,
+		theme:          th,
+		state:          state,
+		accountButtons: make(map[string]*accountButtons),
 		list: widget.List{
 			List: layout.List{Axis: layout.Vertical},
 		},
+		importPath: widget.Editor{SingleLine: true, Submit: true},
 	}
 }
 
@@ -68,6 +89,34 @@ func (p *SettingsPage) Layout(gtx layout.Context) layout.Dimensions {
 		p.state.Logout()
 	}
 
+	// Handle linked account actions
+	accounts := p.state.LinkedAccounts()
+	for _, account := range accounts {
+		buttons := p.buttonsFor(account.Provider)
+		if buttons.useForSync.Clicked(gtx) {
+			if err := p.state.SetSyncAccount(account.Provider); err != nil {
+				p.accountStatus = "Could not change sync account: " + err.Error()
+			} else {
+				p.accountStatus = ""
+			}
+		}
+		if buttons.unlink.Clicked(gtx) {
+			if err := p.state.UnlinkAccount(account.Provider); err != nil {
+				p.accountStatus = "Could not unlink account: " + err.Error()
+			} else {
+				p.accountStatus = providerLabel(account.Provider) + " account unlinked. Your bookmarks were kept."
+			}
+		}
+	}
+
+	// Handle bookmark import/export
+	if p.importButton.Clicked(gtx) {
+		p.handleImport(strings.TrimSpace(p.importPath.Text()))
+	}
+	if p.exportButton.Clicked(gtx) {
+		p.handleExport()
+	}
+
 	return layout.UniformInset(unit.Dp(16)).Layout(gtx,
 		func(gtx layout.Context) layout.Dimensions {
 			return p.list.List.Layout(gtx, 1,
@@ -94,6 +143,10 @@ func (p *SettingsPage) Layout(gtx layout.Context) layout.Dimensions {
 											}),
 										)
 									}),
+									layout.Rigid(layout.Spacer{Height: unit.Dp(16)}.Layout),
+									layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+										return p.layoutLinkedAccounts(gtx, accounts)
+									}),
 								)
 							})
 						}),
@@ -115,6 +168,10 @@ func (p *SettingsPage) Layout(gtx layout.Context) layout.Dimensions {
 								)
 							})
 						}),
+						layout.Rigid(layout.Spacer{Height: unit.Dp(16)}.Layout),
+						layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+							return p.layoutSection(gtx, "Bookmarks", p.layoutBookmarkData)
+						}),
 						layout.Rigid(layout.Spacer{Height: unit.Dp(24)}.Layout),
 						layout.Rigid(func(gtx layout.Context) layout.Dimensions {
 							return layout.Flex{}.Layout(gtx,
@@ -137,6 +194,142 @@ func (p *SettingsPage) Layout(gtx layout.Context) layout.Dimensions {
 	)
 }
 
+func (p *SettingsPage) buttonsFor(provider string) *accountButtons {
+	buttons, ok := p.accountButtons[provider]
+	if !ok {
+		buttons = &accountButtons{}
+		p.accountButtons[provider] = buttons
+	}
+	return buttons
+}
+
+func (p *SettingsPage) layoutLinkedAccounts(gtx layout.Context, accounts []models.LinkedAccount) layout.Dimensions {
+	grey := color.NRGBA{R: 128, G: 128, B: 128, A: 255}
+	children := []layout.FlexChild{
+		layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+			return material.Subtitle2(p.theme, "Linked accounts").Layout(gtx)
+		}),
+	}
+	if len(accounts) == 0 {
+		children = append(children, layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+			label := material.Caption(p.theme, "No accounts linked")
+			label.Color = grey
+			return layout.Inset{Top: unit.Dp(8)}.Layout(gtx, label.Layout)
+		}))
+	}
+	for _, account := range accounts {
+		account := account
+		buttons := p.buttonsFor(account.Provider)
+		children = append(children, layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+			return layout.Inset{Top: unit.Dp(8)}.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+				return layout.Flex{Alignment: layout.Middle}.Layout(gtx,
+					layout.Flexed(1, func(gtx layout.Context) layout.Dimensions {
+						return layout.Flex{Axis: layout.Vertical}.Layout(gtx,
+							layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+								return material.Body1(p.theme, providerLabel(account.Provider)).Layout(gtx)
+							}),
+							layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+								label := material.Caption(p.theme, account.Email)
+								label.Color = grey
+								return label.Layout(gtx)
+							}),
+						)
+					}),
+					layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+						if account.IsSyncAccount {
+							label := material.Caption(p.theme, "Used for sync")
+							label.Color = grey
+							return label.Layout(gtx)
+						}
+						return material.Button(p.theme, &buttons.useForSync, "Use for sync").Layout(gtx)
+					}),
+					layout.Rigid(layout.Spacer{Width: unit.Dp(8)}.Layout),
+					layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+						return material.Button(p.theme, &buttons.unlink, "Unlink").Layout(gtx)
+					}),
+				)
+			})
+		}))
+	}
+	if p.accountStatus != "" {
+		children = append(children, layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+			label := material.Caption(p.theme, p.accountStatus)
+			label.Color = grey
+			return layout.Inset{Top: unit.Dp(8)}.Layout(gtx, label.Layout)
+		}))
+	}
+	return layout.Flex{Axis: layout.Vertical}.Layout(gtx, children...)
+}
+
+// providerLabel returns the display name of an OAuth provider.
+func providerLabel(provider string) string {
+	switch provider {
+	case "google":
+		return "Google"
+	case "microsoft":
+		return "Microsoft"
+	case "":
+		return ""
+	}
+	return strings.ToUpper(provider[:1]) + provider[1:]
+}
+
+func (p *SettingsPage) layoutBookmarkData(gtx layout.Context) layout.Dimensions {
+	return layout.Flex{Axis: layout.Vertical}.Layout(gtx,
+		layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+			editor := material.Editor(p.theme, &p.importPath, "Path to bookmarks.html")
+			return editor.Layout(gtx)
+		}),
+		layout.Rigid(layout.Spacer{Height: unit.Dp(16)}.Layout),
+		layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+			return layout.Flex{}.Layout(gtx,
+				layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+					btn := material.Button(p.theme, &p.importButton, "Import HTML")
+					return btn.Layout(gtx)
+				}),
+				layout.Rigid(layout.Spacer{Width: unit.Dp(16)}.Layout),
+				layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+					btn := material.Button(p.theme, &p.exportButton, "Export HTML")
+					return btn.Layout(gtx)
+				}),
+			)
+		}),
+		layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+			if p.dataStatus == "" {
+				return layout.Dimensions{}
+			}
+			return layout.Inset{Top: unit.Dp(8)}.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+				label := material.Caption(p.theme, p.dataStatus)
+				label.Color = color.NRGBA{R: 128, G: 128, B: 128, A: 255}
+				return label.Layout(gtx)
+			})
+		}),
+	)
+}
+
+func (p *SettingsPage) handleImport(path string) {
+	if path == "" {
+		p.dataStatus = "Enter the path of a bookmarks.html file to import"
+		return
+	}
+	report, err := p.state.ImportBookmarksFile(path)
+	if err != nil {
+		p.dataStatus = "Import failed: " + err.Error()
+		return
+	}
+	p.dataStatus = fmt.Sprintf("Imported %d bookmarks, %d duplicates, %d skipped, %d new tags",
+		report.Created, report.Duplicates, report.Skipped, report.TagsCreated)
+}
+
+func (p *SettingsPage) handleExport() {
+	exportPath, err := p.state.ExportBookmarks()
+	if err != nil {
+		p.dataStatus = "Export failed: " + err.Error()
+		return
+	}
+	p.dataStatus = "Bookmarks exported to " + exportPath
+}
+
 func (p *SettingsPage) layoutSection(gtx layout.Context, title string, content func(gtx layout.Context) layout.Dimensions) layout.Dimensions {
 	return layout.Stack{}.Layout(gtx,
 		layout.Expanded(func(gtx layout.Context) layout.Dimensions {
@@ -0,0 +1,134 @@
+// Package urlnorm reduces URLs to a canonical form so that the same page
+// saved from different places is recognized as one bookmark. The
+// normalized form is a comparison key; bookmarks keep the URL they were
+// saved with.
+package urlnorm
+
+import (
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// defaultPorts maps schemes to the port that is implied when none is
+// given.
+var defaultPorts = map[string]string{
+	"http":  "80",
+	"https": "443",
+	"ftp":   "21",
+}
+
+// trackingParams lists query parameters that identify a campaign or a
+// click rather than the page. Parameters starting with utm_ are removed
+// too.
+var trackingParams = map[string]bool{
+	"fbclid":      true,
+	"gclid":       true,
+	"gclsrc":      true,
+	"dclid":       true,
+	"gbraid":      true,
+	"wbraid":      true,
+	"msclkid":     true,
+	"yclid":       true,
+	"twclid":      true,
+	"ttclid":      true,
+	"igshid":      true,
+	"li_fat_id":   true,
+	"mc_cid":      true,
+	"mc_eid":      true,
+	"_hsenc":      true,
+	"_hsmi":       true,
+	"mkt_tok":     true,
+	"vero_id":     true,
+	"oly_anon_id": true,
+	"oly_enc_id":  true,
+	"rb_clickid":  true,
+	"s_cid":       true,
+	"wickedid":    true,
+	"ref_src":     true,
+	"ref_url":     true,
+	"spm":         true,
+}
+
+// IsTrackingParam reports whether the query parameter name only tracks how
+// the link was reached.
+func IsTrackingParam(name string) bool {
+	name = strings.ToLower(name)
+	return strings.HasPrefix(name, "utm_") || trackingParams[name]
+}
+
+// Normalize returns the canonical form of rawURL: the scheme and host are
+// lower case, a default port and a trailing dot on the host are dropped,
+// an empty path becomes "/", tracking parameters are removed and the rest
+// sorted by name, and the fragment is dropped unless it looks like a
+// client-side route ("#!" or "#/"). A string that does not parse as an
+// absolute URL is returned trimmed.
+func Normalize(rawURL string) string {
+	rawURL = strings.TrimSpace(rawURL)
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Scheme == "" || u.Opaque != "" {
+		return rawURL
+	}
+
+	u.Scheme = strings.ToLower(u.Scheme)
+	host := strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+	if port := u.Port(); port != "" && port != defaultPorts[u.Scheme] {
+		host = joinHostPort(host, port)
+	} else if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+	u.Host = host
+
+	if u.Path == "" && u.Host != "" {
+		u.Path = "/"
+		u.RawPath = ""
+	}
+
+	u.RawQuery = normalizeQuery(u.RawQuery)
+	u.ForceQuery = false
+
+	if !strings.HasPrefix(u.Fragment, "!") && !strings.HasPrefix(u.Fragment, "/") {
+		u.Fragment = ""
+		u.RawFragment = ""
+	}
+	return u.String()
+}
+
+// normalizeQuery removes tracking parameters from a raw query and sorts the
+// rest by name, keeping the order of repeated names. Parameters are kept
+// as written, so encodings the server may distinguish are left alone.
+func normalizeQuery(rawQuery string) string {
+	if rawQuery == "" {
+		return ""
+	}
+	type param struct{ name, raw string }
+	var params []param
+	for _, part := range strings.Split(rawQuery, "&") {
+		if part == "" {
+			continue
+		}
+		name, _, _ := strings.Cut(part, "=")
+		if unescaped, err := url.QueryUnescape(name); err == nil {
+			name = unescaped
+		}
+		if IsTrackingParam(name) {
+			continue
+		}
+		params = append(params, param{name: name, raw: part})
+	}
+	sort.SliceStable(params, func(i, j int) bool {
+		return params[i].name < params[j].name
+	})
+	parts := make([]string, len(params))
+	for i, p := range params {
+		parts[i] = p.raw
+	}
+	return strings.Join(parts, "&")
+}
+
+func joinHostPort(host, port string) string {
+	if strings.Contains(host, ":") {
+		return "[" + host + "]:" + port
+	}
+	return host + ":" + port
+}